@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/BurntSushi/toml"
 )
@@ -22,10 +23,24 @@ type LoggingConfig struct {
 	Format string `toml:"format"`
 }
 
+// FeedConfig holds the bearer tokens accepted by the change feed
+// endpoints. Without tokens the feed is disabled.
+type FeedConfig struct {
+	Tokens []string `toml:"tokens"`
+}
+
+// CaptureConfig holds the directory that capture and replay paths given
+// to the job API must stay within. Without a root the API rejects them.
+type CaptureConfig struct {
+	Root string `toml:"root"`
+}
+
 type Config struct {
 	Server   ServerConfig   `toml:"server"`
 	Database DatabaseConfig `toml:"database"`
 	Logging  LoggingConfig  `toml:"logging"`
+	Feed     FeedConfig     `toml:"feed"`
+	Capture  CaptureConfig  `toml:"capture"`
 }
 
 func Defaults() Config {
@@ -96,4 +111,10 @@ func applyEnv(cfg *Config) {
 	if v := os.Getenv("PGMANAGER_LOG_FORMAT"); v != "" {
 		cfg.Logging.Format = v
 	}
+	if v := os.Getenv("PGMANAGER_FEED_TOKENS"); v != "" {
+		cfg.Feed.Tokens = strings.Split(v, ",")
+	}
+	if v := os.Getenv("PGMANAGER_CAPTURE_ROOT"); v != "" {
+		cfg.Capture.Root = v
+	}
 }
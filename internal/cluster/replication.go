@@ -0,0 +1,256 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ReplicationSlot is a logical or physical slot on a node.
+type ReplicationSlot struct {
+	Name          string `json:"name"`
+	Type          string `json:"type"`
+	Plugin        string `json:"plugin,omitempty"`
+	Database      string `json:"database,omitempty"`
+	Temporary     bool   `json:"temporary"`
+	Active        bool   `json:"active"`
+	ActivePID     *int32 `json:"active_pid,omitempty"`
+	WALStatus     string `json:"wal_status,omitempty"`
+	RetainedBytes int64  `json:"retained_bytes"`
+	RetainedSize  string `json:"retained_size"`
+	Owner         string `json:"owner,omitempty"`
+	Orphan        bool   `json:"orphan"`
+}
+
+// Publication is a publication in one database of a node.
+type Publication struct {
+	Name      string `json:"name"`
+	Database  string `json:"database"`
+	AllTables bool   `json:"all_tables"`
+	Tables    int    `json:"tables"`
+	Owner     string `json:"owner,omitempty"`
+}
+
+// Subscription is a subscription defined on a node.
+type Subscription struct {
+	Name     string `json:"name"`
+	Database string `json:"database"`
+	Enabled  bool   `json:"enabled"`
+	SlotName string `json:"slot_name,omitempty"`
+}
+
+// ReplicationOrigin is a replication origin on a node.
+type ReplicationOrigin struct {
+	Name      string `json:"name"`
+	RemoteLSN string `json:"remote_lsn,omitempty"`
+	Owner     string `json:"owner,omitempty"`
+}
+
+// NodeReplication is the replication inventory of one node. Error is set
+// when the node could not be inspected.
+type NodeReplication struct {
+	NodeID        string              `json:"node_id"`
+	NodeName      string              `json:"node_name"`
+	Role          NodeRole            `json:"role"`
+	InRecovery    bool                `json:"in_recovery"`
+	Slots         []ReplicationSlot   `json:"slots"`
+	Publications  []Publication       `json:"publications"`
+	Subscriptions []Subscription      `json:"subscriptions"`
+	Origins       []ReplicationOrigin `json:"origins"`
+	Error         string              `json:"error,omitempty"`
+}
+
+// ReplicationInventory lists the replication objects on every node of a
+// cluster.
+type ReplicationInventory struct {
+	ClusterID string            `json:"cluster_id"`
+	Nodes     []NodeReplication `json:"nodes"`
+}
+
+// Replication inspects every node of c. Unreachable nodes are reported
+// with Error set.
+func Replication(ctx context.Context, c Cluster) ReplicationInventory {
+	inv := ReplicationInventory{ClusterID: c.ID, Nodes: []NodeReplication{}}
+	for _, n := range c.Nodes {
+		nr, err := NodeReplicationInfo(ctx, n)
+		if err != nil {
+			nr.Error = err.Error()
+		}
+		inv.Nodes = append(inv.Nodes, nr)
+	}
+	return inv
+}
+
+// NodeReplicationInfo lists the slots, publications, subscriptions and
+// origins on a node. Publications are per database, so every non-template
+// database is visited.
+func NodeReplicationInfo(ctx context.Context, n Node) (NodeReplication, error) {
+	nr := NodeReplication{
+		NodeID:        n.ID,
+		NodeName:      n.Name,
+		Role:          n.Role,
+		Slots:         []ReplicationSlot{},
+		Publications:  []Publication{},
+		Subscriptions: []Subscription{},
+		Origins:       []ReplicationOrigin{},
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	dsn := n.DSN()
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		return nr, fmt.Errorf("connect: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	if err := conn.QueryRow(ctx, "SELECT pg_is_in_recovery()").Scan(&nr.InRecovery); err != nil {
+		return nr, fmt.Errorf("check recovery: %w", err)
+	}
+	if nr.Slots, err = querySlots(ctx, conn); err != nil {
+		return nr, fmt.Errorf("list slots: %w", err)
+	}
+	if nr.Subscriptions, err = querySubscriptions(ctx, conn); err != nil {
+		return nr, fmt.Errorf("list subscriptions: %w", err)
+	}
+	if nr.Origins, err = queryOrigins(ctx, conn); err != nil {
+		return nr, fmt.Errorf("list replication origins: %w", err)
+	}
+
+	dbs, err := queryDatabases(ctx, conn)
+	if err != nil {
+		return nr, fmt.Errorf("list databases: %w", err)
+	}
+	for _, db := range dbs {
+		pubs, err := databasePublications(ctx, replaceDSNDatabase(dsn, db.Name), db.Name)
+		if err != nil {
+			return nr, fmt.Errorf("list publications in %s: %w", db.Name, err)
+		}
+		nr.Publications = append(nr.Publications, pubs...)
+	}
+	return nr, nil
+}
+
+func querySlots(ctx context.Context, conn *pgx.Conn) ([]ReplicationSlot, error) {
+	rows, err := conn.Query(ctx, `
+		SELECT s.slot_name, s.slot_type, COALESCE(s.plugin, ''), COALESCE(s.database, ''),
+		       s.temporary, s.active, s.active_pid,
+		       COALESCE(to_jsonb(s)->>'wal_status', ''),
+		       COALESCE(pg_wal_lsn_diff(
+		           CASE WHEN pg_is_in_recovery() THEN pg_last_wal_replay_lsn() ELSE pg_current_wal_lsn() END,
+		           s.restart_lsn), 0)::bigint
+		FROM pg_replication_slots s
+		ORDER BY s.slot_name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	slots := []ReplicationSlot{}
+	for rows.Next() {
+		var s ReplicationSlot
+		if err := rows.Scan(&s.Name, &s.Type, &s.Plugin, &s.Database, &s.Temporary,
+			&s.Active, &s.ActivePID, &s.WALStatus, &s.RetainedBytes); err != nil {
+			return nil, err
+		}
+		s.RetainedSize = formatBytes(s.RetainedBytes)
+		slots = append(slots, s)
+	}
+	return slots, rows.Err()
+}
+
+func querySubscriptions(ctx context.Context, conn *pgx.Conn) ([]Subscription, error) {
+	rows, err := conn.Query(ctx, `
+		SELECT s.subname, d.datname, s.subenabled, COALESCE(s.subslotname, '')
+		FROM pg_subscription s
+		JOIN pg_database d ON d.oid = s.subdbid
+		ORDER BY d.datname, s.subname`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subs := []Subscription{}
+	for rows.Next() {
+		var s Subscription
+		if err := rows.Scan(&s.Name, &s.Database, &s.Enabled, &s.SlotName); err != nil {
+			return nil, err
+		}
+		subs = append(subs, s)
+	}
+	return subs, rows.Err()
+}
+
+func queryOrigins(ctx context.Context, conn *pgx.Conn) ([]ReplicationOrigin, error) {
+	rows, err := conn.Query(ctx, `
+		SELECT o.roname, COALESCE(st.remote_lsn::text, '')
+		FROM pg_replication_origin o
+		LEFT JOIN pg_replication_origin_status st ON st.local_id = o.roident
+		ORDER BY o.roname`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	origins := []ReplicationOrigin{}
+	for rows.Next() {
+		var o ReplicationOrigin
+		if err := rows.Scan(&o.Name, &o.RemoteLSN); err != nil {
+			return nil, err
+		}
+		origins = append(origins, o)
+	}
+	return origins, rows.Err()
+}
+
+func databasePublications(ctx context.Context, dsn, dbname string) ([]Publication, error) {
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close(ctx)
+
+	rows, err := conn.Query(ctx, `
+		SELECT p.pubname, p.puballtables,
+		       (SELECT count(*) FROM pg_publication_tables t WHERE t.pubname = p.pubname)::int
+		FROM pg_publication p
+		ORDER BY p.pubname`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pubs []Publication
+	for rows.Next() {
+		p := Publication{Database: dbname}
+		if err := rows.Scan(&p.Name, &p.AllTables, &p.Tables); err != nil {
+			return nil, err
+		}
+		pubs = append(pubs, p)
+	}
+	return pubs, rows.Err()
+}
+
+// DropInactiveSlot drops a slot on n only if it exists and is not in use.
+func DropInactiveSlot(ctx context.Context, n Node, slot string) error {
+	conn, err := pgx.Connect(ctx, n.DSN())
+	if err != nil {
+		return fmt.Errorf("connect: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	tag, err := conn.Exec(ctx, `
+		SELECT pg_drop_replication_slot(slot_name)
+		FROM pg_replication_slots
+		WHERE slot_name = $1 AND NOT active`, slot)
+	if err != nil {
+		return fmt.Errorf("drop slot %q: %w", slot, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("slot %q does not exist or is active", slot)
+	}
+	return nil
+}
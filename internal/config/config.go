@@ -4,8 +4,11 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // DatabaseConfig holds connection parameters for a PostgreSQL instance.
@@ -82,11 +85,238 @@ type ReplicationConfig struct {
 	Publication  string
 	OutputPlugin string
 	OriginID     string
+
+	// AllowMissingIdentity lets the pipeline create a FOR ALL TABLES
+	// publication even though some tables have no usable replica identity.
+	// UPDATE and DELETE on those tables will fail on the source.
+	AllowMissingIdentity bool
+
+	// Slot health guard thresholds, in bytes; zero disables a threshold.
+	// SlotWarnBytes warns when the slot retains more WAL than this, and
+	// SlotWarnSafeBytes when safe_wal_size drops below it. SlotMaxBytes is
+	// the hard limit: with SlotDropOnLimit the slot is dropped and the
+	// migration fails, otherwise the guard only logs.
+	SlotWarnBytes     int64
+	SlotWarnSafeBytes int64
+	SlotMaxBytes      int64
+	SlotDropOnLimit   bool
+	// SlotCheckInterval is how often the guard polls the slot (default 10s).
+	SlotCheckInterval time.Duration
 }
 
 // SnapshotConfig holds settings for the initial data copy.
 type SnapshotConfig struct {
 	Workers int
+
+	// ReindexCollations rebuilds, after the copy, destination indexes whose
+	// collation differs in definition or library version from the source.
+	ReindexCollations bool
+}
+
+// RolesConfig holds settings for the optional role and privilege phase.
+type RolesConfig struct {
+	// Enabled copies roles, memberships, grants, default privileges and
+	// ownership from source to destination around the schema phase.
+	Enabled bool
+}
+
+// CaptureConfig holds settings for recording the decoded change stream.
+type CaptureConfig struct {
+	// Dir enables capture into rotating segment files in this directory.
+	Dir string
+	// SegmentBytes is the size at which a segment rotates (default 64 MiB).
+	SegmentBytes int64
+	// MaxSegments keeps only the newest segments; 0 keeps all of them.
+	MaxSegments int
+}
+
+// SpoolConfig holds settings for the durable spool between decoder and
+// applier.
+type SpoolConfig struct {
+	// Dir enables the spool in this directory. The source slot then
+	// advances once changes are on local disk instead of once applied.
+	Dir string
+	// MaxBytes pauses decoding while this much is waiting to be applied
+	// (default 1 GiB).
+	MaxBytes int64
+	// SegmentBytes is the spool segment size (default 16 MiB).
+	SegmentBytes int64
+}
+
+// SinkConfig sends the change stream to a consumer other than the Dest
+// database.
+type SinkConfig struct {
+	// Type selects the sink; empty applies changes to Dest. Supported:
+	// "ndjson", "debezium", "webhook".
+	Type string
+	// Dir is the output directory of file sinks.
+	Dir string
+	// Path is the output file of stream sinks; empty or "-" is stdout.
+	Path string
+	// ServerName is the Debezium logical server name used in schema names
+	// and source.name (default "pgmanager").
+	ServerName string
+	// URL is the endpoint of the webhook sink.
+	URL string
+	// BatchSize caps the changes in one webhook request (default 500).
+	BatchSize int
+	// Linger is how long the webhook sink waits for more transactions
+	// before sending a batch (default 200ms).
+	Linger time.Duration
+	// MaxRetries caps the retries of one webhook request before the
+	// pipeline fails (default 20).
+	MaxRetries int
+	// SegmentBytes is the file size at which file sinks rotate.
+	SegmentBytes int64
+	// MaxSegments keeps only the newest files; 0 keeps all of them.
+	MaxSegments int
+}
+
+// Enabled reports whether a sink replaces the Dest database.
+func (s SinkConfig) Enabled() bool {
+	return s.Type != ""
+}
+
+// DefaultMemoryBudget is the default MemoryConfig.BudgetBytes.
+const DefaultMemoryBudget = 256 << 20
+
+// MemoryConfig bounds the memory used by in-flight changes.
+type MemoryConfig struct {
+	// BudgetBytes caps the tuple bytes buffered between the decoder and
+	// the applier or spool; decoding pauses at the limit (default 256 MiB).
+	BudgetBytes int64
+}
+
+// FeedConfig keeps recent committed transactions for the live change
+// feed API.
+type FeedConfig struct {
+	Enabled bool
+	// MaxTransactions and MaxBytes bound the retained window that clients
+	// can resume from (defaults 10000 and 64 MiB).
+	MaxTransactions int
+	MaxBytes        int64
+}
+
+// FanoutConfig replicates the one slot into more destinations besides
+// Dest, each with its own applier.
+type FanoutConfig struct {
+	Destinations []DestinationConfig
+	// Policy is what a destination that cannot keep up does: "block"
+	// (default) pauses decoding until it catches up; "spool" gives every
+	// destination its own spool under Spool.Dir, so a stuck one only
+	// grows its spool.
+	Policy string
+}
+
+// DestinationConfig is an extra fan-out destination.
+type DestinationConfig struct {
+	// Name identifies the destination in logs, metrics and, under the
+	// spool policy, its spool directory.
+	Name string
+	DB   DatabaseConfig
+}
+
+// Enabled reports whether changes fan out to more than Dest.
+func (f FanoutConfig) Enabled() bool {
+	return len(f.Destinations) > 0
+}
+
+// DestName is the fan-out name of Dest.
+const DestName = "dest"
+
+var destNameRe = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// ShardingConfig splits rows between the fan-out destinations instead of
+// copying every row to each of them. The shards are Dest, shard 0, then
+// Fanout.Destinations in order.
+type ShardingConfig struct {
+	// Tables are the sharded tables. Every other table is a reference
+	// table, copied to all shards.
+	Tables []ShardTableConfig
+}
+
+// ShardTableConfig declares how one table is sharded.
+type ShardTableConfig struct {
+	// Table is "schema.table"; a bare name is in public.
+	Table string
+	// Key is the shard key column. It must be part of the table's
+	// replica identity.
+	Key string
+	// Method is "hash" (default) or "range".
+	Method string
+	// Bounds are the ascending integer split points of the range method,
+	// one fewer than the shards: shard i holds keys below Bounds[i] and
+	// the last shard the rest.
+	Bounds []int64
+}
+
+// Enabled reports whether rows are sharded between the destinations.
+func (s ShardingConfig) Enabled() bool {
+	return len(s.Tables) > 0
+}
+
+// ConsolidationConfig merges several source databases into Dest, each
+// through its own slot and decoder. Source is unused when it is set.
+type ConsolidationConfig struct {
+	Sources []SourceConfig
+	// Column, when set, keeps the source table names and adds this text
+	// column, holding the source name, to every table. Otherwise every
+	// source gets its own destination schema.
+	Column string
+}
+
+// SourceConfig is one consolidated source.
+type SourceConfig struct {
+	// Name identifies the source in logs and metrics, suffixes its slot
+	// name and is its discriminator value.
+	Name string
+	DB   DatabaseConfig
+	// Schema is the destination schema of the source's public schema
+	// (default Name); its other schemas s go to Schema_s. Unused with a
+	// discriminator column.
+	Schema string
+}
+
+// Enabled reports whether several sources are consolidated.
+func (c ConsolidationConfig) Enabled() bool {
+	return len(c.Sources) > 0
+}
+
+var sourceNameRe = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// RemapConfig renames schemas, tables and columns between the source and
+// the destination. Table rules take precedence over schema rules.
+type RemapConfig struct {
+	Schemas []SchemaMapConfig
+	Tables  []TableMapConfig
+	Columns []ColumnMapConfig
+}
+
+// SchemaMapConfig moves the tables of source schema From into To.
+type SchemaMapConfig struct {
+	From string
+	To   string
+}
+
+// TableMapConfig renames one table. From is "schema.table"; a bare name is
+// in public. To is "schema.table", or a bare name kept in the schema the
+// table is mapped to otherwise.
+type TableMapConfig struct {
+	From string
+	To   string
+}
+
+// ColumnMapConfig renames column From of source table Table to To.
+type ColumnMapConfig struct {
+	// Table is the source "schema.table"; a bare name is in public.
+	Table string
+	From  string
+	To    string
+}
+
+// Enabled reports whether any name is remapped.
+func (r RemapConfig) Enabled() bool {
+	return len(r.Schemas) > 0 || len(r.Tables) > 0 || len(r.Columns) > 0
 }
 
 // LoggingConfig holds settings for structured logging.
@@ -97,28 +327,86 @@ type LoggingConfig struct {
 
 // Config is the top-level configuration for pgmanager.
 type Config struct {
-	Source      DatabaseConfig
-	Dest        DatabaseConfig
-	Replication ReplicationConfig
-	Snapshot    SnapshotConfig
-	Logging     LoggingConfig
+	Source        DatabaseConfig
+	Dest          DatabaseConfig
+	Replication   ReplicationConfig
+	Snapshot      SnapshotConfig
+	Roles         RolesConfig
+	Capture       CaptureConfig
+	Spool         SpoolConfig
+	Memory        MemoryConfig
+	Sink          SinkConfig
+	Feed          FeedConfig
+	Fanout        FanoutConfig
+	Sharding      ShardingConfig
+	Consolidation ConsolidationConfig
+	Remap         RemapConfig
+	Logging       LoggingConfig
+
+	// SourcePrimary is the source's primary when Source is a hot standby
+	// used as the read source (PG16+). Publications are created here, and
+	// the standby's slot needs WAL activity from it to become consistent.
+	// Left empty when Source is the primary.
+	SourcePrimary DatabaseConfig
+}
+
+// ReadsFromStandby reports whether the source is a standby with a
+// separate primary.
+func (c *Config) ReadsFromStandby() bool {
+	return c.SourcePrimary.Host != ""
+}
+
+// Primary returns the source primary: SourcePrimary when reading from a
+// standby, Source otherwise. Writes to the source go here.
+func (c *Config) Primary() DatabaseConfig {
+	if c.ReadsFromStandby() {
+		return c.SourcePrimary
+	}
+	return c.Source
+}
+
+// ForSource returns the configuration of one consolidated source's own
+// pipeline: Source is s.DB, the slot name is suffixed with s.Name, and the
+// capture and spool directories and memory budget are split between the
+// sources.
+func (c *Config) ForSource(s SourceConfig) *Config {
+	out := *c
+	out.Source = s.DB
+	out.Consolidation = ConsolidationConfig{}
+	out.Replication.SlotName = c.Replication.SlotName + "_" + s.Name
+	if c.Capture.Dir != "" {
+		out.Capture.Dir = filepath.Join(c.Capture.Dir, s.Name)
+	}
+	if c.Spool.Dir != "" {
+		out.Spool.Dir = filepath.Join(c.Spool.Dir, s.Name)
+	}
+	if n := int64(len(c.Consolidation.Sources)); n > 0 {
+		out.Memory.BudgetBytes = c.Memory.BudgetBytes / n
+	}
+	return &out
 }
 
 // Validate checks that required fields are present and values are sane.
 func (c *Config) Validate() error {
 	var errs []error
 
-	if c.Source.Host == "" {
-		errs = append(errs, errors.New("source host is required"))
-	}
-	if c.Source.DBName == "" {
-		errs = append(errs, errors.New("source database name is required"))
-	}
-	if c.Dest.Host == "" {
-		errs = append(errs, errors.New("destination host is required"))
+	if c.Consolidation.Enabled() {
+		errs = append(errs, c.validateConsolidation()...)
+	} else {
+		if c.Source.Host == "" {
+			errs = append(errs, errors.New("source host is required"))
+		}
+		if c.Source.DBName == "" {
+			errs = append(errs, errors.New("source database name is required"))
+		}
 	}
-	if c.Dest.DBName == "" {
-		errs = append(errs, errors.New("destination database name is required"))
+	if !c.Sink.Enabled() {
+		if c.Dest.Host == "" {
+			errs = append(errs, errors.New("destination host is required"))
+		}
+		if c.Dest.DBName == "" {
+			errs = append(errs, errors.New("destination database name is required"))
+		}
 	}
 	if c.Replication.SlotName == "" {
 		errs = append(errs, errors.New("replication slot name is required"))
@@ -126,12 +414,262 @@ func (c *Config) Validate() error {
 	if c.Replication.Publication == "" {
 		errs = append(errs, errors.New("publication name is required"))
 	}
-	if c.Replication.OutputPlugin == "" {
+	switch c.Replication.OutputPlugin {
+	case "":
 		c.Replication.OutputPlugin = "pgoutput"
+	case "pgoutput", "wal2json", "test_decoding":
+	default:
+		errs = append(errs, fmt.Errorf("unsupported output plugin %q (pgoutput, wal2json or test_decoding)", c.Replication.OutputPlugin))
+	}
+	if c.Replication.OriginID != "" && c.Replication.OutputPlugin != "pgoutput" {
+		errs = append(errs, errors.New("origin filtering requires the pgoutput plugin"))
 	}
 	if c.Snapshot.Workers < 1 {
 		c.Snapshot.Workers = 4
 	}
+	if c.Replication.SlotCheckInterval <= 0 {
+		c.Replication.SlotCheckInterval = 10 * time.Second
+	}
+	if c.Replication.SlotDropOnLimit && c.Replication.SlotMaxBytes <= 0 {
+		errs = append(errs, errors.New("slot drop on limit requires a slot max bytes limit"))
+	}
+	if c.Capture.SegmentBytes < 0 || c.Capture.MaxSegments < 0 {
+		errs = append(errs, errors.New("capture segment bytes and max segments must not be negative"))
+	}
+	if c.Spool.MaxBytes < 0 || c.Spool.SegmentBytes < 0 {
+		errs = append(errs, errors.New("spool max bytes and segment bytes must not be negative"))
+	}
+	switch c.Sink.Type {
+	case "":
+	case "ndjson":
+		if c.Sink.Dir == "" {
+			errs = append(errs, errors.New("ndjson sink requires a directory"))
+		}
+	case "debezium":
+		if c.Sink.ServerName == "" {
+			c.Sink.ServerName = "pgmanager"
+		}
+	case "webhook":
+		if u, err := url.Parse(c.Sink.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, errors.New("webhook sink requires an http or https URL"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unsupported sink type %q (ndjson, debezium, webhook)", c.Sink.Type))
+	}
+	if c.Sink.Enabled() && (c.Snapshot.ReindexCollations || c.Roles.Enabled) {
+		errs = append(errs, errors.New("collation reindexing and role migration need a PostgreSQL destination"))
+	}
+	if c.Sink.SegmentBytes < 0 || c.Sink.MaxSegments < 0 {
+		errs = append(errs, errors.New("sink segment bytes and max segments must not be negative"))
+	}
+	if c.Sink.BatchSize < 0 || c.Sink.Linger < 0 || c.Sink.MaxRetries < 0 {
+		errs = append(errs, errors.New("sink batch size, linger and max retries must not be negative"))
+	}
+	if c.Feed.MaxTransactions < 0 || c.Feed.MaxBytes < 0 {
+		errs = append(errs, errors.New("feed max transactions and max bytes must not be negative"))
+	}
+	if c.Fanout.Enabled() {
+		errs = append(errs, c.validateFanout()...)
+	}
+	if c.Sharding.Enabled() {
+		errs = append(errs, c.validateSharding()...)
+	}
+	if c.Remap.Enabled() {
+		errs = append(errs, c.validateRemap()...)
+	}
+	switch {
+	case c.Memory.BudgetBytes < 0:
+		errs = append(errs, errors.New("memory budget bytes must not be negative"))
+	case c.Memory.BudgetBytes == 0:
+		c.Memory.BudgetBytes = DefaultMemoryBudget
+	}
 
 	return errors.Join(errs...)
 }
+
+func (c *Config) validateConsolidation() []error {
+	var errs []error
+	if len(c.Consolidation.Sources) < 2 {
+		errs = append(errs, errors.New("consolidation requires at least two sources"))
+	}
+	switch {
+	case c.Sink.Enabled(), c.Fanout.Enabled():
+		errs = append(errs, errors.New("consolidation cannot be combined with a sink or fan-out destinations"))
+	case c.Feed.Enabled, c.ReadsFromStandby(), c.Replication.OriginID != "", c.Snapshot.ReindexCollations:
+		errs = append(errs, errors.New("consolidation does not support the change feed, standby sources, origin filtering or collation reindexing"))
+	}
+	names := make(map[string]bool)
+	schemas := make(map[string]bool)
+	for i := range c.Consolidation.Sources {
+		s := &c.Consolidation.Sources[i]
+		switch {
+		case !sourceNameRe.MatchString(s.Name):
+			errs = append(errs, fmt.Errorf("source name %q must be lowercase letters, digits or _", s.Name))
+		case names[s.Name]:
+			errs = append(errs, fmt.Errorf("duplicate source %q", s.Name))
+		}
+		names[s.Name] = true
+		if s.DB.Host == "" || s.DB.DBName == "" {
+			errs = append(errs, fmt.Errorf("source %q requires a host and database name", s.Name))
+		}
+		if c.Consolidation.Column != "" {
+			continue
+		}
+		if s.Schema == "" {
+			s.Schema = s.Name
+		}
+		if schemas[s.Schema] {
+			errs = append(errs, fmt.Errorf("sources share destination schema %q", s.Schema))
+		}
+		schemas[s.Schema] = true
+	}
+	return errs
+}
+
+// validateRemap checks the rules and qualifies their table names: From and
+// Table become "schema.table" and a bare table To gets its mapped schema.
+func (c *Config) validateRemap() []error {
+	var errs []error
+	if c.Sink.Enabled() || c.Consolidation.Enabled() {
+		errs = append(errs, errors.New("remapping cannot be combined with a sink or consolidation"))
+	}
+	schemas := make(map[string]string)
+	for _, s := range c.Remap.Schemas {
+		switch {
+		case s.From == "" || s.To == "":
+			errs = append(errs, errors.New("every schema mapping requires a source and destination schema"))
+		case schemas[s.From] != "":
+			errs = append(errs, fmt.Errorf("schema %s is mapped twice", s.From))
+		default:
+			schemas[s.From] = s.To
+		}
+	}
+	qualify := func(name string) string {
+		if !strings.Contains(name, ".") {
+			return "public." + name
+		}
+		return name
+	}
+
+	from := make(map[string]bool)
+	to := make(map[string]bool)
+	for i := range c.Remap.Tables {
+		t := &c.Remap.Tables[i]
+		if t.From == "" || t.To == "" {
+			errs = append(errs, errors.New("every table mapping requires a source and destination table"))
+			continue
+		}
+		t.From = qualify(t.From)
+		if !strings.Contains(t.To, ".") {
+			schema, _, _ := strings.Cut(t.From, ".")
+			if mapped := schemas[schema]; mapped != "" {
+				schema = mapped
+			}
+			t.To = schema + "." + t.To
+		}
+		if from[t.From] {
+			errs = append(errs, fmt.Errorf("table %s is mapped twice", t.From))
+		}
+		if to[t.To] {
+			errs = append(errs, fmt.Errorf("several tables are mapped to %s", t.To))
+		}
+		from[t.From], to[t.To] = true, true
+	}
+
+	colFrom := make(map[[2]string]bool)
+	colTo := make(map[[2]string]bool)
+	for i := range c.Remap.Columns {
+		col := &c.Remap.Columns[i]
+		if col.Table == "" || col.From == "" || col.To == "" {
+			errs = append(errs, errors.New("every column mapping requires a table, source and destination column"))
+			continue
+		}
+		col.Table = qualify(col.Table)
+		if colFrom[[2]string{col.Table, col.From}] {
+			errs = append(errs, fmt.Errorf("column %s of %s is mapped twice", col.From, col.Table))
+		}
+		if colTo[[2]string{col.Table, col.To}] {
+			errs = append(errs, fmt.Errorf("several columns of %s are mapped to %s", col.Table, col.To))
+		}
+		colFrom[[2]string{col.Table, col.From}] = true
+		colTo[[2]string{col.Table, col.To}] = true
+	}
+	return errs
+}
+
+func (c *Config) validateFanout() []error {
+	var errs []error
+	switch c.Fanout.Policy {
+	case "":
+		c.Fanout.Policy = "block"
+	case "block":
+	case "spool":
+		if c.Spool.Dir == "" {
+			errs = append(errs, errors.New("the fan-out spool policy requires a spool directory"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unsupported fan-out policy %q (block, spool)", c.Fanout.Policy))
+	}
+	if c.Sink.Enabled() {
+		errs = append(errs, errors.New("fan-out destinations cannot be combined with a sink"))
+	}
+	seen := map[string]bool{DestName: true}
+	for _, d := range c.Fanout.Destinations {
+		switch {
+		case !destNameRe.MatchString(d.Name):
+			errs = append(errs, fmt.Errorf("fan-out destination name %q must be lowercase letters, digits, _ or -", d.Name))
+		case seen[d.Name]:
+			errs = append(errs, fmt.Errorf("duplicate fan-out destination %q", d.Name))
+		}
+		seen[d.Name] = true
+		if d.DB.Host == "" || d.DB.DBName == "" {
+			errs = append(errs, fmt.Errorf("fan-out destination %q requires a host and database name", d.Name))
+		}
+	}
+	return errs
+}
+
+func (c *Config) validateSharding() []error {
+	if !c.Fanout.Enabled() {
+		return []error{errors.New("sharding requires fan-out destinations as the shards besides Dest")}
+	}
+	shards := len(c.Fanout.Destinations) + 1
+	var errs []error
+	seen := make(map[string]bool)
+	for i := range c.Sharding.Tables {
+		t := &c.Sharding.Tables[i]
+		if t.Table == "" || t.Key == "" {
+			errs = append(errs, errors.New("every sharded table requires a table name and shard key"))
+			continue
+		}
+		if !strings.Contains(t.Table, ".") {
+			t.Table = "public." + t.Table
+		}
+		if seen[t.Table] {
+			errs = append(errs, fmt.Errorf("table %s is sharded twice", t.Table))
+		}
+		seen[t.Table] = true
+		switch t.Method {
+		case "":
+			t.Method = "hash"
+			fallthrough
+		case "hash":
+			if len(t.Bounds) > 0 {
+				errs = append(errs, fmt.Errorf("table %s: bounds only apply to the range method", t.Table))
+			}
+		case "range":
+			if len(t.Bounds) != shards-1 {
+				errs = append(errs, fmt.Errorf("table %s: range sharding over %d shards requires %d bounds", t.Table, shards, shards-1))
+			}
+			for j := 1; j < len(t.Bounds); j++ {
+				if t.Bounds[j] <= t.Bounds[j-1] {
+					errs = append(errs, fmt.Errorf("table %s: range bounds must be ascending", t.Table))
+					break
+				}
+			}
+		default:
+			errs = append(errs, fmt.Errorf("table %s: unsupported shard method %q (hash, range)", t.Table, t.Method))
+		}
+	}
+	return errs
+}
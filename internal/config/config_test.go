@@ -3,6 +3,7 @@ package config
 import (
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestDSN(t *testing.T) {
@@ -89,6 +90,162 @@ func TestValidate_MissingFields(t *testing.T) {
 	}
 }
 
+func TestValidate_OutputPlugin(t *testing.T) {
+	base := Config{
+		Source:      DatabaseConfig{Host: "src", DBName: "srcdb"},
+		Dest:        DatabaseConfig{Host: "dst", DBName: "dstdb"},
+		Replication: ReplicationConfig{SlotName: "slot", Publication: "pub"},
+	}
+
+	for _, plugin := range []string{"pgoutput", "wal2json", "test_decoding"} {
+		cfg := base
+		cfg.Replication.OutputPlugin = plugin
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("plugin %s: unexpected error: %v", plugin, err)
+		}
+	}
+
+	cfg := base
+	cfg.Replication.OutputPlugin = "decoderbufs"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "unsupported output plugin") {
+		t.Errorf("expected unsupported plugin error, got %v", err)
+	}
+
+	cfg = base
+	cfg.Replication.OutputPlugin = "wal2json"
+	cfg.Replication.OriginID = "node_a"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "requires the pgoutput plugin") {
+		t.Errorf("expected origin filtering error, got %v", err)
+	}
+}
+
+func TestValidate_Sink(t *testing.T) {
+	base := Config{
+		Source:      DatabaseConfig{Host: "src", DBName: "srcdb"},
+		Replication: ReplicationConfig{SlotName: "slot", Publication: "pub"},
+		Sink:        SinkConfig{Type: "ndjson", Dir: "/tmp/changes"},
+	}
+
+	cfg := base
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("sink without destination: unexpected error: %v", err)
+	}
+
+	cfg = base
+	cfg.Sink.Dir = ""
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "requires a directory") {
+		t.Errorf("expected missing directory error, got %v", err)
+	}
+
+	cfg = base
+	cfg.Sink.Type = "parquet"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "unsupported sink type") {
+		t.Errorf("expected unsupported sink error, got %v", err)
+	}
+
+	cfg = base
+	cfg.Sink = SinkConfig{Type: "debezium"}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("debezium sink: unexpected error: %v", err)
+	}
+	if cfg.Sink.ServerName != "pgmanager" {
+		t.Errorf("expected default server name, got %q", cfg.Sink.ServerName)
+	}
+
+	cfg = base
+	cfg.Sink = SinkConfig{Type: "webhook", URL: "ftp://example.com"}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "http or https URL") {
+		t.Errorf("expected webhook URL error, got %v", err)
+	}
+	cfg.Sink.URL = "https://example.com/hook"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("webhook sink: unexpected error: %v", err)
+	}
+
+	cfg = base
+	cfg.Roles.Enabled = true
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "need a PostgreSQL destination") {
+		t.Errorf("expected destination error, got %v", err)
+	}
+}
+
+func TestValidate_Fanout(t *testing.T) {
+	base := Config{
+		Source:      DatabaseConfig{Host: "src", DBName: "srcdb"},
+		Dest:        DatabaseConfig{Host: "dst", DBName: "dstdb"},
+		Replication: ReplicationConfig{SlotName: "slot", Publication: "pub"},
+		Fanout: FanoutConfig{Destinations: []DestinationConfig{
+			{Name: "analytics", DB: DatabaseConfig{Host: "olap", DBName: "app"}},
+		}},
+	}
+	cfg := base
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() unexpected error: %v", err)
+	}
+	if cfg.Fanout.Policy != "block" {
+		t.Errorf("expected default policy block, got %q", cfg.Fanout.Policy)
+	}
+
+	cfg = base
+	cfg.Fanout.Policy = "spool"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "spool directory") {
+		t.Errorf("expected spool directory error, got %v", err)
+	}
+
+	cfg = base
+	cfg.Fanout.Destinations = []DestinationConfig{
+		{Name: "dest", DB: DatabaseConfig{Host: "a", DBName: "app"}},
+		{Name: "Bad Name", DB: DatabaseConfig{Host: "b", DBName: "app"}},
+	}
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), `duplicate fan-out destination "dest"`) || !strings.Contains(err.Error(), `"Bad Name"`) {
+		t.Errorf("expected name errors, got %v", err)
+	}
+}
+
+func TestValidate_Sharding(t *testing.T) {
+	base := Config{
+		Source:      DatabaseConfig{Host: "src", DBName: "srcdb"},
+		Dest:        DatabaseConfig{Host: "dst", DBName: "dstdb"},
+		Replication: ReplicationConfig{SlotName: "slot", Publication: "pub"},
+		Fanout: FanoutConfig{Destinations: []DestinationConfig{
+			{Name: "shard1", DB: DatabaseConfig{Host: "s1", DBName: "app"}},
+			{Name: "shard2", DB: DatabaseConfig{Host: "s2", DBName: "app"}},
+		}},
+	}
+	cfg := base
+	cfg.Sharding.Tables = []ShardTableConfig{
+		{Table: "orders", Key: "customer_id"},
+		{Table: "billing.invoices", Key: "customer_id", Method: "range", Bounds: []int64{1000, 2000}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() unexpected error: %v", err)
+	}
+	if got := cfg.Sharding.Tables[0]; got.Table != "public.orders" || got.Method != "hash" {
+		t.Errorf("expected public.orders by hash, got %+v", got)
+	}
+
+	cfg = base
+	cfg.Sharding.Tables = []ShardTableConfig{
+		{Table: "orders", Key: "customer_id", Method: "range", Bounds: []int64{2000, 1000}},
+		{Table: "public.orders", Key: "id", Bounds: []int64{5}},
+		{Table: "items"},
+	}
+	err := cfg.Validate()
+	for _, want := range []string{"ascending", "sharded twice", "only apply to the range method", "shard key"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q error, got %v", want, err)
+		}
+	}
+
+	cfg = base
+	cfg.Fanout.Destinations = nil
+	cfg.Sharding.Tables = []ShardTableConfig{{Table: "orders", Key: "customer_id"}}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "requires fan-out destinations") {
+		t.Errorf("expected fan-out error, got %v", err)
+	}
+}
+
 func TestValidate_DefaultsApplied(t *testing.T) {
 	cfg := Config{
 		Source:      DatabaseConfig{Host: "src", DBName: "srcdb"},
@@ -103,6 +260,24 @@ func TestValidate_DefaultsApplied(t *testing.T) {
 	if cfg.Snapshot.Workers != 4 {
 		t.Errorf("expected default workers 4, got %d", cfg.Snapshot.Workers)
 	}
+	if cfg.Replication.SlotCheckInterval != 10*time.Second {
+		t.Errorf("expected default slot check interval 10s, got %s", cfg.Replication.SlotCheckInterval)
+	}
+}
+
+func TestValidate_SlotDropRequiresLimit(t *testing.T) {
+	cfg := Config{
+		Source:      DatabaseConfig{Host: "src", DBName: "srcdb"},
+		Dest:        DatabaseConfig{Host: "dst", DBName: "dstdb"},
+		Replication: ReplicationConfig{SlotName: "slot", Publication: "pub", SlotDropOnLimit: true},
+	}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("Validate() expected error for drop on limit without a limit")
+	}
+	cfg.Replication.SlotMaxBytes = 1 << 30
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() unexpected error: %v", err)
+	}
 }
 
 func TestValidate_PartialMissing(t *testing.T) {
@@ -216,3 +391,112 @@ func TestParseURI_ExplicitFlagOverride(t *testing.T) {
 		t.Errorf("expected dbname from URI, got %q", d.DBName)
 	}
 }
+
+func TestPrimary(t *testing.T) {
+	cfg := Config{Source: DatabaseConfig{Host: "standby"}}
+	if cfg.ReadsFromStandby() || cfg.Primary().Host != "standby" {
+		t.Errorf("without SourcePrimary, Primary() = %q, want source", cfg.Primary().Host)
+	}
+	cfg.SourcePrimary = DatabaseConfig{Host: "primary"}
+	if !cfg.ReadsFromStandby() || cfg.Primary().Host != "primary" {
+		t.Errorf("with SourcePrimary, Primary() = %q, want primary", cfg.Primary().Host)
+	}
+}
+
+func TestValidate_Consolidation(t *testing.T) {
+	base := Config{
+		Dest:        DatabaseConfig{Host: "dst", DBName: "dstdb"},
+		Replication: ReplicationConfig{SlotName: "slot", Publication: "pub"},
+	}
+	cfg := base
+	cfg.Consolidation.Sources = []SourceConfig{
+		{Name: "acme", DB: DatabaseConfig{Host: "a", DBName: "app"}},
+		{Name: "globex", DB: DatabaseConfig{Host: "g", DBName: "app"}, Schema: "tenant_globex"},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() unexpected error: %v", err)
+	}
+	if got := cfg.Consolidation.Sources[0].Schema; got != "acme" {
+		t.Errorf("expected schema to default to the source name, got %q", got)
+	}
+	sub := cfg.ForSource(cfg.Consolidation.Sources[1])
+	if sub.Source.Host != "g" || sub.Replication.SlotName != "slot_globex" || sub.Consolidation.Enabled() {
+		t.Errorf("unexpected source config %+v", sub)
+	}
+
+	cfg = base
+	cfg.Consolidation.Sources = []SourceConfig{
+		{Name: "Acme", DB: DatabaseConfig{Host: "a", DBName: "app"}},
+		{Name: "globex", DB: DatabaseConfig{Host: "g"}, Schema: "Acme"},
+	}
+	err := cfg.Validate()
+	for _, want := range []string{"lowercase", "requires a host and database name", "share destination schema"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q error, got %v", want, err)
+		}
+	}
+
+	cfg = base
+	cfg.Consolidation.Sources = []SourceConfig{{Name: "acme", DB: DatabaseConfig{Host: "a", DBName: "app"}}}
+	cfg.Fanout.Destinations = []DestinationConfig{{Name: "eu", DB: DatabaseConfig{Host: "eu", DBName: "app"}}}
+	err = cfg.Validate()
+	for _, want := range []string{"at least two sources", "cannot be combined"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q error, got %v", want, err)
+		}
+	}
+}
+
+func TestValidate_Remap(t *testing.T) {
+	base := Config{
+		Source:      DatabaseConfig{Host: "src", DBName: "srcdb"},
+		Dest:        DatabaseConfig{Host: "dst", DBName: "dstdb"},
+		Replication: ReplicationConfig{SlotName: "slot", Publication: "pub"},
+	}
+	cfg := base
+	cfg.Remap = RemapConfig{
+		Schemas: []SchemaMapConfig{{From: "public", To: "app"}},
+		Tables: []TableMapConfig{
+			{From: "orders", To: "orders_v2"},
+			{From: "billing.invoices", To: "archive.invoices"},
+		},
+		Columns: []ColumnMapConfig{{Table: "orders", From: "total", To: "amount"}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() unexpected error: %v", err)
+	}
+	if got := cfg.Remap.Tables[0]; got.From != "public.orders" || got.To != "app.orders_v2" {
+		t.Errorf("expected public.orders to app.orders_v2, got %+v", got)
+	}
+	if got := cfg.Remap.Columns[0].Table; got != "public.orders" {
+		t.Errorf("expected column table public.orders, got %s", got)
+	}
+
+	cfg = base
+	cfg.Remap = RemapConfig{
+		Schemas: []SchemaMapConfig{{From: "public", To: "app"}, {From: "public", To: "other"}},
+		Tables: []TableMapConfig{
+			{From: "orders", To: "app.orders"},
+			{From: "public.orders", To: "app.orders"},
+		},
+		Columns: []ColumnMapConfig{
+			{Table: "orders", From: "a", To: "c"},
+			{Table: "orders", From: "b", To: "c"},
+			{Table: "orders", From: "a"},
+		},
+	}
+	err := cfg.Validate()
+	for _, want := range []string{"schema public is mapped twice", "table public.orders is mapped twice",
+		"several tables are mapped to app.orders", "mapped to c", "requires a table, source and destination column"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q error, got %v", want, err)
+		}
+	}
+
+	cfg = base
+	cfg.Sink.Type = "debezium"
+	cfg.Remap.Schemas = []SchemaMapConfig{{From: "public", To: "app"}}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "cannot be combined") {
+		t.Errorf("expected sink error, got %v", err)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/jfoltran/pgmanager/internal/metrics"
+	"github.com/jfoltran/pgmanager/internal/migration/preflight"
 )
 
 // Client talks to the daemon's HTTP API.
@@ -86,6 +87,11 @@ func (c *Client) SubmitSwitchover(payload SwitchoverPayload) (*JobResponse, erro
 	return c.postJob("/api/v1/jobs/switchover", payload)
 }
 
+// SubmitReplay submits a capture replay job to the daemon.
+func (c *Client) SubmitReplay(payload ReplayPayload) (*JobResponse, error) {
+	return c.postJob("/api/v1/jobs/replay", payload)
+}
+
 // StopJob requests the daemon to stop the current job.
 func (c *Client) StopJob() (*JobResponse, error) {
 	return c.postJob("/api/v1/jobs/stop", nil)
@@ -109,6 +115,31 @@ func (c *Client) JobStatus() (map[string]any, error) {
 	return result, nil
 }
 
+// Preflight runs the migration preflight checks for a source/destination pair.
+func (c *Client) Preflight(payload PreflightPayload) (*preflight.Report, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Post(c.baseURL+"/api/v1/preflight", "application/json", bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("cannot reach daemon at %s: %w", c.baseURL, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("preflight: %s", bytes.TrimSpace(body))
+	}
+	var report preflight.Report
+	if err := json.Unmarshal(body, &report); err != nil {
+		return nil, fmt.Errorf("unexpected response: %s", string(body))
+	}
+	return &report, nil
+}
+
 func (c *Client) postJob(path string, payload any) (*JobResponse, error) {
 	var body io.Reader
 	if payload != nil {
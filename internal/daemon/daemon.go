@@ -206,6 +206,88 @@ type ClonePayload struct {
 	SlotName    string `json:"slot_name,omitempty"`
 	Publication string `json:"publication,omitempty"`
 	Workers     int    `json:"workers,omitempty"`
+	Roles       bool   `json:"roles,omitempty"`
+
+	AllowMissingIdentity bool `json:"allow_missing_identity,omitempty"`
+	ReindexCollations    bool `json:"reindex_collations,omitempty"`
+
+	SlotWarnBytes     int64 `json:"slot_warn_bytes,omitempty"`
+	SlotWarnSafeBytes int64 `json:"slot_warn_safe_bytes,omitempty"`
+	SlotMaxBytes      int64 `json:"slot_max_bytes,omitempty"`
+	SlotDropOnLimit   bool  `json:"slot_drop_on_limit,omitempty"`
+
+	// SourcePrimaryURI is the primary when SourceURI is a standby.
+	SourcePrimaryURI string `json:"source_primary_uri,omitempty"`
+
+	// OutputPlugin is the logical decoding plugin; empty means pgoutput.
+	OutputPlugin string `json:"output_plugin,omitempty"`
+
+	// CaptureDir records the decoded change stream into this directory.
+	CaptureDir          string `json:"capture_dir,omitempty"`
+	CaptureSegmentBytes int64  `json:"capture_segment_bytes,omitempty"`
+	CaptureMaxSegments  int    `json:"capture_max_segments,omitempty"`
+	// SpoolDir spools decoded transactions to disk before applying them.
+	SpoolDir          string `json:"spool_dir,omitempty"`
+	SpoolMaxBytes     int64  `json:"spool_max_bytes,omitempty"`
+	SpoolSegmentBytes int64  `json:"spool_segment_bytes,omitempty"`
+	// MemoryBudgetBytes caps decoded change data held in memory.
+	MemoryBudgetBytes int64 `json:"memory_budget_bytes,omitempty"`
+	// SinkType sends changes to a sink instead of DestURI.
+	SinkType         string `json:"sink_type,omitempty"`
+	SinkDir          string `json:"sink_dir,omitempty"`
+	SinkSegmentBytes int64  `json:"sink_segment_bytes,omitempty"`
+	SinkMaxSegments  int    `json:"sink_max_segments,omitempty"`
+	SinkPath         string `json:"sink_path,omitempty"`
+	SinkServerName   string `json:"sink_server_name,omitempty"`
+	SinkURL          string `json:"sink_url,omitempty"`
+	SinkBatchSize    int    `json:"sink_batch_size,omitempty"`
+	SinkLingerMs     int    `json:"sink_linger_ms,omitempty"`
+	SinkMaxRetries   int    `json:"sink_max_retries,omitempty"`
+	// Feed serves committed transactions on the change feed endpoints.
+	Feed                bool  `json:"feed,omitempty"`
+	FeedMaxTransactions int   `json:"feed_max_transactions,omitempty"`
+	FeedMaxBytes        int64 `json:"feed_max_bytes,omitempty"`
+	// Destinations are fed from the same slot alongside DestURI.
+	Destinations []DestinationPayload `json:"destinations,omitempty"`
+	FanoutPolicy string               `json:"fanout_policy,omitempty"`
+	// ShardTables split rows between DestURI and Destinations as shards.
+	ShardTables []ShardTablePayload `json:"shard_tables,omitempty"`
+	// Remap renames schemas, tables and columns on the destination.
+	Remap *RemapPayload `json:"remap,omitempty"`
+}
+
+// DestinationPayload is an extra fan-out destination.
+type DestinationPayload struct {
+	Name string `json:"name"`
+	URI  string `json:"uri"`
+}
+
+// ShardTablePayload declares the shard key of one table.
+type ShardTablePayload struct {
+	Table  string  `json:"table"`
+	Key    string  `json:"key"`
+	Method string  `json:"method,omitempty"`
+	Bounds []int64 `json:"bounds,omitempty"`
+}
+
+// RemapPayload holds the schema, table and column rename rules.
+type RemapPayload struct {
+	Schemas []MapPayload       `json:"schemas,omitempty"`
+	Tables  []MapPayload       `json:"tables,omitempty"`
+	Columns []ColumnMapPayload `json:"columns,omitempty"`
+}
+
+// MapPayload renames a schema or a table.
+type MapPayload struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// ColumnMapPayload renames a column of a source table.
+type ColumnMapPayload struct {
+	Table string `json:"table"`
+	From  string `json:"from"`
+	To    string `json:"to"`
 }
 
 // FollowPayload holds parameters for a follow job.
@@ -215,6 +297,52 @@ type FollowPayload struct {
 	StartLSN    string `json:"start_lsn,omitempty"`
 	SlotName    string `json:"slot_name,omitempty"`
 	Publication string `json:"publication,omitempty"`
+
+	AllowMissingIdentity bool `json:"allow_missing_identity,omitempty"`
+
+	SlotWarnBytes     int64 `json:"slot_warn_bytes,omitempty"`
+	SlotWarnSafeBytes int64 `json:"slot_warn_safe_bytes,omitempty"`
+	SlotMaxBytes      int64 `json:"slot_max_bytes,omitempty"`
+	SlotDropOnLimit   bool  `json:"slot_drop_on_limit,omitempty"`
+
+	// SourcePrimaryURI is the primary when SourceURI is a standby.
+	SourcePrimaryURI string `json:"source_primary_uri,omitempty"`
+
+	// OutputPlugin is the logical decoding plugin; empty means pgoutput.
+	OutputPlugin string `json:"output_plugin,omitempty"`
+
+	// CaptureDir records the decoded change stream into this directory.
+	CaptureDir          string `json:"capture_dir,omitempty"`
+	CaptureSegmentBytes int64  `json:"capture_segment_bytes,omitempty"`
+	CaptureMaxSegments  int    `json:"capture_max_segments,omitempty"`
+	// SpoolDir spools decoded transactions to disk before applying them.
+	SpoolDir          string `json:"spool_dir,omitempty"`
+	SpoolMaxBytes     int64  `json:"spool_max_bytes,omitempty"`
+	SpoolSegmentBytes int64  `json:"spool_segment_bytes,omitempty"`
+	// MemoryBudgetBytes caps decoded change data held in memory.
+	MemoryBudgetBytes int64 `json:"memory_budget_bytes,omitempty"`
+	// SinkType sends changes to a sink instead of DestURI.
+	SinkType         string `json:"sink_type,omitempty"`
+	SinkDir          string `json:"sink_dir,omitempty"`
+	SinkSegmentBytes int64  `json:"sink_segment_bytes,omitempty"`
+	SinkMaxSegments  int    `json:"sink_max_segments,omitempty"`
+	SinkPath         string `json:"sink_path,omitempty"`
+	SinkServerName   string `json:"sink_server_name,omitempty"`
+	SinkURL          string `json:"sink_url,omitempty"`
+	SinkBatchSize    int    `json:"sink_batch_size,omitempty"`
+	SinkLingerMs     int    `json:"sink_linger_ms,omitempty"`
+	SinkMaxRetries   int    `json:"sink_max_retries,omitempty"`
+	// Feed serves committed transactions on the change feed endpoints.
+	Feed                bool  `json:"feed,omitempty"`
+	FeedMaxTransactions int   `json:"feed_max_transactions,omitempty"`
+	FeedMaxBytes        int64 `json:"feed_max_bytes,omitempty"`
+	// Destinations are fed from the same slot alongside DestURI.
+	Destinations []DestinationPayload `json:"destinations,omitempty"`
+	FanoutPolicy string               `json:"fanout_policy,omitempty"`
+	// ShardTables split rows between DestURI and Destinations as shards.
+	ShardTables []ShardTablePayload `json:"shard_tables,omitempty"`
+	// Remap renames schemas, tables and columns on the destination.
+	Remap *RemapPayload `json:"remap,omitempty"`
 }
 
 // SwitchoverPayload holds parameters for a switchover job.
@@ -226,6 +354,25 @@ type SwitchoverPayload struct {
 	TimeoutSec  int    `json:"timeout_sec,omitempty"`
 }
 
+// ReplayPayload holds parameters for replaying a captured change stream.
+type ReplayPayload struct {
+	// CapturePath is a capture directory or a single segment file.
+	CapturePath string `json:"capture_path"`
+	DestURI     string `json:"dest_uri"`
+	// FromLSN skips transactions that commit before it.
+	FromLSN string `json:"from_lsn,omitempty"`
+	// ToLSN stops after the last transaction committing at or before it.
+	ToLSN string `json:"to_lsn,omitempty"`
+}
+
+// PreflightPayload holds the source/destination pair to check.
+type PreflightPayload struct {
+	SourceURI string `json:"source_uri"`
+	DestURI   string `json:"dest_uri"`
+	// Remap rules are checked against the source and destination.
+	Remap *RemapPayload `json:"remap,omitempty"`
+}
+
 // JobResponse is returned after submitting a job.
 type JobResponse struct {
 	OK      bool   `json:"ok"`
@@ -7,10 +7,12 @@ import (
 	"time"
 
 	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/rs/zerolog"
 
 	"github.com/jfoltran/pgmanager/internal/config"
 	"github.com/jfoltran/pgmanager/internal/metrics"
+	"github.com/jfoltran/pgmanager/internal/migration/capture"
 	"github.com/jfoltran/pgmanager/internal/migration/pipeline"
 )
 
@@ -25,6 +27,8 @@ type JobManager struct {
 	cancel   context.CancelFunc
 	jobErr   error
 	running  bool
+
+	lastReplay *capture.ReplayResult
 }
 
 // NewJobManager creates a new JobManager.
@@ -230,6 +234,59 @@ func (jm *JobManager) RunSwitchover(parentCtx context.Context, cfg *config.Confi
 	return nil
 }
 
+// RunReplay starts replaying a capture into dest in the background.
+func (jm *JobManager) RunReplay(parentCtx context.Context, dest config.DatabaseConfig, path string, opts capture.ReplayOptions) error {
+	jm.mu.Lock()
+	if jm.running {
+		jm.mu.Unlock()
+		return fmt.Errorf("a job is already running")
+	}
+	jm.running = true
+	jm.jobErr = nil
+	jm.lastReplay = nil
+	ctx, cancel := context.WithCancel(parentCtx)
+	jm.cancel = cancel
+	jm.mu.Unlock()
+
+	logWriter := metrics.NewLogWriter(jm.collector)
+	replayLogger := zerolog.New(zerolog.MultiLevelWriter(jm.logger, logWriter)).
+		With().Timestamp().Logger().Level(jm.logger.GetLevel())
+
+	go func() {
+		var res *capture.ReplayResult
+		pool, err := pgxpool.New(ctx, dest.DSN())
+		if err == nil {
+			res, err = capture.Replay(ctx, path, pool, opts, replayLogger)
+			pool.Close()
+		} else {
+			err = fmt.Errorf("connect to destination: %w", err)
+		}
+
+		jm.mu.Lock()
+		jm.running = false
+		jm.jobErr = err
+		jm.lastReplay = res
+		jm.cancel = nil
+		jm.mu.Unlock()
+		cancel()
+
+		if err != nil && err != context.Canceled {
+			jm.logger.Err(err).Msg("replay finished with error")
+		} else {
+			jm.logger.Info().Msg("replay finished successfully")
+		}
+	}()
+
+	return nil
+}
+
+// LastReplay returns the result of the last replay job, if any.
+func (jm *JobManager) LastReplay() *capture.ReplayResult {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+	return jm.lastReplay
+}
+
 // Collector returns the shared metrics collector.
 func (jm *JobManager) Collector() *metrics.Collector {
 	return jm.collector
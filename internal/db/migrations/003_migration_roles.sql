@@ -0,0 +1 @@
+ALTER TABLE migrations ADD COLUMN migrate_roles BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1 @@
+ALTER TABLE migrations ADD COLUMN allow_missing_identity BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1 @@
+ALTER TABLE migrations ADD COLUMN reindex_collations BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1 @@
+ALTER TABLE migrations ADD COLUMN upgrade_advice JSONB;
@@ -0,0 +1,4 @@
+ALTER TABLE migrations ADD COLUMN slot_warn_bytes BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE migrations ADD COLUMN slot_warn_safe_bytes BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE migrations ADD COLUMN slot_max_bytes BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE migrations ADD COLUMN slot_drop_on_limit BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1 @@
+ALTER TABLE migrations ADD COLUMN read_node_id TEXT NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE migrations ADD COLUMN output_plugin TEXT NOT NULL DEFAULT 'pgoutput';
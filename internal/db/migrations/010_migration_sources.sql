@@ -0,0 +1,2 @@
+ALTER TABLE migrations ADD COLUMN sources JSONB;
+ALTER TABLE migrations ADD COLUMN consolidate_column TEXT NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE migrations ADD COLUMN feed BOOLEAN NOT NULL DEFAULT false;
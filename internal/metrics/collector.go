@@ -61,6 +61,80 @@ type Snapshot struct {
 	// Errors
 	ErrorCount   int             `json:"error_count"`
 	LastError    string          `json:"last_error,omitempty"`
+
+	// Replication slot health, once the slot guard has polled.
+	Slot         *SlotHealth     `json:"slot,omitempty"`
+
+	// Durable spool backlog, when the spool is enabled.
+	Spool        *SpoolDepth     `json:"spool,omitempty"`
+
+	// In-memory change buffer against the pipeline's memory budget.
+	Buffer       *BufferUsage    `json:"buffer,omitempty"`
+
+	// Per-destination progress when changes fan out to several
+	// destinations. AppliedLSN above is then the slowest one.
+	Destinations []DestinationStatus `json:"destinations,omitempty"`
+
+	// Per-source progress when several sources are consolidated. The
+	// LSNs above are then empty, as each source has its own.
+	Sources []SourceStatus `json:"sources,omitempty"`
+}
+
+// SourceStatus is the progress of one consolidated source.
+type SourceStatus struct {
+	Name         string `json:"name"`
+	Phase        string `json:"phase"`
+	AppliedLSN   string `json:"applied_lsn"`
+	ConfirmedLSN string `json:"confirmed_lsn"`
+	LagBytes     uint64 `json:"lag_bytes"`
+	LagFormatted string `json:"lag_formatted"`
+	TablesTotal  int    `json:"tables_total"`
+	TablesCopied int    `json:"tables_copied"`
+	LastError    string `json:"last_error,omitempty"`
+}
+
+// DestinationStatus is the progress of one fan-out destination.
+type DestinationStatus struct {
+	Name         string      `json:"name"`
+	AppliedLSN   string      `json:"applied_lsn"`
+	LagBytes     uint64      `json:"lag_bytes"`
+	LagFormatted string      `json:"lag_formatted"`
+	Transactions int64       `json:"transactions"`
+	Changes      int64       `json:"changes"`
+	RowsCopied   int64       `json:"rows_copied,omitempty"` // sharded copy only
+	Queued       int         `json:"queued,omitempty"`
+	Spool        *SpoolDepth `json:"spool,omitempty"`
+	LastError    string      `json:"last_error,omitempty"`
+}
+
+// BufferUsage is the decoded change data held in memory.
+type BufferUsage struct {
+	Bytes      int64 `json:"bytes"`
+	LimitBytes int64 `json:"limit_bytes"`
+	Blocked    bool  `json:"blocked"` // decoding is waiting for the budget
+}
+
+// SpoolDepth is the backlog waiting in the durable spool.
+type SpoolDepth struct {
+	Bytes        int64  `json:"bytes"`
+	MaxBytes     int64  `json:"max_bytes"`
+	Transactions int64  `json:"transactions"`
+	Segments     int    `json:"segments"`
+	SpooledLSN   string `json:"spooled_lsn"`
+	AppliedLSN   string `json:"applied_lsn"`
+	Blocked      bool   `json:"blocked"`
+}
+
+// SlotHealth is the last observed state of the replication slot.
+type SlotHealth struct {
+	Name          string    `json:"name"`
+	Active        bool      `json:"active"`
+	WALStatus     string    `json:"wal_status,omitempty"`    // reserved, extended, unreserved, lost (PG13+)
+	SafeWALSize   *int64    `json:"safe_wal_size,omitempty"` // bytes before the slot is invalidated; nil when unlimited
+	RetainedBytes int64     `json:"retained_bytes"`
+	State         string    `json:"state"` // ok, warn, limit, lost
+	Message       string    `json:"message,omitempty"`
+	CheckedAt     time.Time `json:"checked_at"`
 }
 
 // LogEntry represents a log line captured for the UI.
@@ -92,6 +166,12 @@ type Collector struct {
 	errorCount atomic.Int64
 	lastError  atomic.Value // string
 
+	slot  *SlotHealth
+	spool  *SpoolDepth
+	buffer *BufferUsage
+
+	destinations []DestinationStatus
+
 	// Throughput tracking (sliding window).
 	rowWindow   *slidingWindow
 	byteWindow  *slidingWindow
@@ -233,6 +313,35 @@ func (c *Collector) RecordLatestLSN(lsn pglogrepl.LSN) {
 	c.latestLSN = lsn
 }
 
+// RecordSlotHealth stores the latest replication slot health.
+func (c *Collector) RecordSlotHealth(h SlotHealth) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.slot = &h
+}
+
+// RecordSpoolDepth stores the latest spool backlog.
+func (c *Collector) RecordSpoolDepth(d SpoolDepth) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.spool = &d
+}
+
+// RecordBufferUsage stores the latest in-memory buffer usage.
+func (c *Collector) RecordBufferUsage(u BufferUsage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.buffer = &u
+}
+
+// RecordDestinations stores the latest per-destination progress. Lag is
+// computed from each AppliedLSN when a snapshot is taken.
+func (c *Collector) RecordDestinations(ds []DestinationStatus) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.destinations = append([]DestinationStatus(nil), ds...)
+}
+
 // RecordError increments the error count and stores the last error message.
 func (c *Collector) RecordError(err error) {
 	c.errorCount.Add(1)
@@ -291,6 +400,32 @@ func (c *Collector) Snapshot() Snapshot {
 		lastErr = v.(string)
 	}
 
+	var slot *SlotHealth
+	if c.slot != nil {
+		h := *c.slot
+		slot = &h
+	}
+
+	var spool *SpoolDepth
+	if c.spool != nil {
+		d := *c.spool
+		spool = &d
+	}
+
+	var buffer *BufferUsage
+	if c.buffer != nil {
+		u := *c.buffer
+		buffer = &u
+	}
+
+	var destinations []DestinationStatus
+	for _, d := range c.destinations {
+		applied, _ := pglogrepl.ParseLSN(d.AppliedLSN)
+		d.LagBytes = lsn.Lag(applied, c.latestLSN)
+		d.LagFormatted = lsn.FormatLag(d.LagBytes, 0)
+		destinations = append(destinations, d)
+	}
+
 	return Snapshot{
 		Timestamp:    now,
 		Phase:        c.phase,
@@ -308,6 +443,10 @@ func (c *Collector) Snapshot() Snapshot {
 		TotalBytes:   c.totalBytes.Load(),
 		ErrorCount:   int(c.errorCount.Load()),
 		LastError:    lastErr,
+		Slot:         slot,
+		Spool:        spool,
+		Buffer:       buffer,
+		Destinations: destinations,
 	}
 }
 
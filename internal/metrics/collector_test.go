@@ -100,6 +100,25 @@ func TestCollector_LSNTracking(t *testing.T) {
 	}
 }
 
+func TestCollector_DestinationLag(t *testing.T) {
+	c := NewCollector(zerolog.Nop())
+	defer c.Close()
+
+	c.RecordLatestLSN(pglogrepl.LSN(200))
+	c.RecordDestinations([]DestinationStatus{
+		{Name: "dest", AppliedLSN: pglogrepl.LSN(200).String()},
+		{Name: "analytics", AppliedLSN: pglogrepl.LSN(100).String()},
+	})
+
+	snap := c.Snapshot()
+	if len(snap.Destinations) != 2 {
+		t.Fatalf("Destinations = %d, want 2", len(snap.Destinations))
+	}
+	if snap.Destinations[0].LagBytes != 0 || snap.Destinations[1].LagBytes != 100 {
+		t.Errorf("lag = %d, %d; want 0, 100", snap.Destinations[0].LagBytes, snap.Destinations[1].LagBytes)
+	}
+}
+
 func TestCollector_ErrorTracking(t *testing.T) {
 	c := NewCollector(zerolog.Nop())
 	defer c.Close()
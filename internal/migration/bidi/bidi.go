@@ -2,17 +2,38 @@ package bidi
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/rs/zerolog"
 
+	"github.com/jfoltran/pgmanager/internal/config"
+	"github.com/jfoltran/pgmanager/internal/metrics"
+	"github.com/jfoltran/pgmanager/internal/migration/conflict"
+	"github.com/jfoltran/pgmanager/internal/migration/identity"
+	"github.com/jfoltran/pgmanager/internal/migration/pgwire"
+	"github.com/jfoltran/pgmanager/internal/migration/replay"
 	"github.com/jfoltran/pgmanager/internal/migration/stream"
 )
 
 // Filter drops messages that originated from a specific replication origin,
-// preventing infinite loops in bidirectional replication.
+// preventing infinite loops in bidirectional replication. The Begin and
+// Commit of a looped transaction are still forwarded, as an empty
+// transaction, so that the consumer confirms it and the slot moves past it.
 type Filter struct {
 	originID string
+	budget   *stream.Budget
 	logger   zerolog.Logger
+
+	mu     sync.Mutex
+	looped []pglogrepl.LSN // commit LSNs of emptied transactions, in order
 }
 
 // NewFilter creates a Filter that drops messages matching the given origin ID.
@@ -23,8 +44,15 @@ func NewFilter(originID string, logger zerolog.Logger) *Filter {
 	}
 }
 
-// Run reads messages from the input channel, drops any whose OriginID matches
-// the filter's origin, and forwards the rest to the returned output channel.
+// SetBudget makes the filter release the size of each dropped message to
+// b, for inputs from a budgeted source.
+func (f *Filter) SetBudget(b *stream.Budget) {
+	f.budget = b
+}
+
+// Run reads messages from the input channel, drops the changes whose
+// OriginID matches the filter's origin, and forwards the rest to the
+// returned output channel.
 func (f *Filter) Run(ctx context.Context, in <-chan stream.Message) <-chan stream.Message {
 	out := make(chan stream.Message, cap(in))
 
@@ -39,11 +67,20 @@ func (f *Filter) Run(ctx context.Context, in <-chan stream.Message) <-chan strea
 					return
 				}
 				if msg.OriginID() == f.originID && f.originID != "" {
-					f.logger.Debug().
-						Str("origin", msg.OriginID()).
-						Stringer("lsn", msg.LSN()).
-						Msg("dropped looped message")
-					continue
+					switch m := msg.(type) {
+					case *stream.BeginMessage:
+					case *stream.CommitMessage:
+						f.mu.Lock()
+						f.looped = append(f.looped, m.CommitLSN)
+						f.mu.Unlock()
+					default:
+						f.logger.Debug().
+							Str("origin", msg.OriginID()).
+							Stringer("lsn", msg.LSN()).
+							Msg("dropped looped message")
+						f.budget.Release(stream.MessageSize(msg))
+						continue
+					}
 				}
 				select {
 				case out <- msg:
@@ -57,31 +94,365 @@ func (f *Filter) Run(ctx context.Context, in <-chan stream.Message) <-chan strea
 	return out
 }
 
-// Manager sets up bidirectional replication by wiring two decoder→filter→applier
-// pipelines (one per direction).
+// Looped reports whether the transaction committed at lsn was a looped
+// one that the filter emptied. It forgets every commit up to lsn, so it is
+// meant to be called as commits are applied.
+func (f *Filter) Looped(lsn pglogrepl.LSN) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	looped := false
+	for len(f.looped) > 0 && f.looped[0] <= lsn {
+		looped = f.looped[0] == lsn
+		f.looped = f.looped[1:]
+	}
+	return looped
+}
+
+// Manager runs active-active replication between two nodes by wiring two
+// decoder→filter→applier chains, one per direction. Node A is cfg.Source
+// and node B is cfg.Dest. Both nodes must already hold the same schema and
+// data; only changes made after Start are replicated.
 type Manager struct {
 	OriginA string
 	OriginB string
-	logger  zerolog.Logger
+
+	// Tables limits both publications to the given schema.table names.
+	// Empty publishes all tables.
+	Tables []string
+	// AllowMissingIdentity creates the publications even when a published
+	// table has no replica identity, which makes UPDATE and DELETE on it
+	// fail on that node.
+	AllowMissingIdentity bool
+
+	// PriorityA and PriorityB break last-writer-wins ties between rows
+	// committed at the same instant; the higher priority wins. When equal,
+	// node A wins.
+	PriorityA int
+	PriorityB int
+	// ConflictTable is the schema.table on each node where losing changes
+	// are recorded. Empty uses conflict.DefaultAuditTable.
+	ConflictTable string
+
+	// AtoB streams from A to B; its applier writes to B under OriginA.
+	AtoB *Direction
+	// BtoA streams from B to A; its applier writes to A under OriginB.
+	BtoA *Direction
+
+	logger zerolog.Logger
 }
 
-// NewManager creates a bidirectional replication Manager.
-func NewManager(originA, originB string, logger zerolog.Logger) *Manager {
+// NewManager creates a bidirectional replication Manager. Slot and
+// publication names are derived from cfg.Replication with an "_a" suffix on
+// node A and "_b" on node B.
+func NewManager(cfg *config.Config, originA, originB string, logger zerolog.Logger) *Manager {
+	logger = logger.With().Str("component", "bidi-manager").Logger()
+	slot := strings.ReplaceAll(cfg.Replication.SlotName, "-", "_")
+	pub := cfg.Replication.Publication
 	return &Manager{
-		OriginA: originA,
-		OriginB: originB,
-		logger:  logger.With().Str("component", "bidi-manager").Logger(),
+		OriginA:              originA,
+		OriginB:              originB,
+		AllowMissingIdentity: cfg.Replication.AllowMissingIdentity,
+		AtoB:                 newDirection("a_to_b", cfg.Source, cfg.Dest, slot+"_a", pub+"_a", originA, originB, logger),
+		BtoA:                 newDirection("b_to_a", cfg.Dest, cfg.Source, slot+"_b", pub+"_b", originB, originA, logger),
+		logger:               logger,
 	}
 }
 
-// Start sets up the bidirectional pipeline. In a full implementation this
-// would wire two decoder→filter→applier chains. Currently a placeholder
-// that logs the configuration.
+// Start connects both directions and streams until ctx is cancelled or
+// either direction fails. The first error stops the other direction.
 func (m *Manager) Start(ctx context.Context) error {
+	if m.OriginA == "" || m.OriginB == "" || m.OriginA == m.OriginB {
+		return fmt.Errorf("bidirectional replication needs two distinct origins (got %q and %q)", m.OriginA, m.OriginB)
+	}
+	defer m.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	prioA, prioB := m.PriorityA, m.PriorityB
+	if prioA == prioB {
+		prioA = prioB + 1
+	}
+	m.AtoB.resolver = conflict.NewResolver(prioB, prioA, m.ConflictTable, m.AtoB.logger)
+	m.BtoA.resolver = conflict.NewResolver(prioA, prioB, m.ConflictTable, m.BtoA.logger)
+
+	for _, d := range []*Direction{m.AtoB, m.BtoA} {
+		if err := d.open(ctx, m.Tables, m.AllowMissingIdentity); err != nil {
+			return fmt.Errorf("%s: %w", d.Name, err)
+		}
+	}
+
 	m.logger.Info().
 		Str("origin_a", m.OriginA).
 		Str("origin_b", m.OriginB).
-		Msg("bidirectional replication manager started")
-	<-ctx.Done()
+		Msg("bidirectional replication started")
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	for _, d := range []*Direction{m.AtoB, m.BtoA} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := d.run(ctx); err != nil {
+				once.Do(func() {
+					firstErr = fmt.Errorf("%s: %w", d.Name, err)
+					cancel()
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil && !errors.Is(firstErr, context.Canceled) {
+		return firstErr
+	}
 	return ctx.Err()
 }
+
+// Close releases the connections of both directions.
+func (m *Manager) Close() {
+	m.AtoB.close()
+	m.BtoA.close()
+}
+
+// Direction is one decoder→filter→applier chain.
+type Direction struct {
+	Name        string
+	SlotName    string
+	Publication string
+	// Origin tags every write the applier makes on the destination.
+	Origin string
+	// Skip is the origin dropped from the source stream: the other
+	// direction's writes, which would otherwise be sent back.
+	Skip    string
+	Metrics *metrics.Collector
+
+	source   config.DatabaseConfig
+	dest     config.DatabaseConfig
+	resolver *conflict.Resolver
+	logger   zerolog.Logger
+
+	replConn *pgconn.PgConn
+	srcPool  *pgxpool.Pool
+	dstPool  *pgxpool.Pool
+	decoder  stream.Source
+	applier  *replay.Applier
+	filter   *Filter
+	messages <-chan stream.Message
+}
+
+func newDirection(name string, source, dest config.DatabaseConfig, slot, pub, origin, skip string, logger zerolog.Logger) *Direction {
+	logger = logger.With().Str("direction", name).Logger()
+	return &Direction{
+		Name:        name,
+		SlotName:    slot,
+		Publication: pub,
+		Origin:      origin,
+		Skip:        skip,
+		Metrics:     metrics.NewCollector(logger),
+		source:      source,
+		dest:        dest,
+		logger:      logger,
+	}
+}
+
+// open connects to both ends, makes sure the publication and slot exist on
+// the source, and starts streaming. An existing slot is resumed from its
+// confirmed position.
+func (d *Direction) open(ctx context.Context, tables []string, allowMissingIdentity bool) error {
+	d.Metrics.SetPhase("connecting")
+
+	replConn, err := pgconn.Connect(ctx, d.source.ReplicationDSN())
+	if err != nil {
+		return fmt.Errorf("replication connection to %s:%d/%s: %w", d.source.Host, d.source.Port, d.source.DBName, err)
+	}
+	d.replConn = replConn
+
+	srcPool, err := pgxpool.New(ctx, d.source.DSN())
+	if err != nil {
+		return fmt.Errorf("source pool: %w", err)
+	}
+	d.srcPool = srcPool
+
+	// One connection: a replication origin can only be active in one
+	// session at a time, and the applier runs one transaction at a time.
+	dstCfg, err := pgxpool.ParseConfig(d.dest.DSN())
+	if err != nil {
+		return fmt.Errorf("parse dest pool config: %w", err)
+	}
+	dstCfg.MaxConns = 1
+	dstCfg.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+		if _, err := conn.Exec(ctx, "SET session_replication_role = 'replica'"); err != nil {
+			return err
+		}
+		return pgwire.NewConn(conn.PgConn(), d.logger).SetReplicationOrigin(ctx, d.Origin)
+	}
+	dstPool, err := pgxpool.NewWithConfig(ctx, dstCfg)
+	if err != nil {
+		return fmt.Errorf("dest pool: %w", err)
+	}
+	d.dstPool = dstPool
+	if err := dstPool.Ping(ctx); err != nil {
+		return fmt.Errorf("dest ping %s:%d/%s: %w", d.dest.Host, d.dest.Port, d.dest.DBName, err)
+	}
+
+	d.applier = replay.NewApplier(dstPool, d.logger)
+	if d.resolver != nil {
+		if err := d.resolver.Prepare(ctx, dstPool); err != nil {
+			return err
+		}
+		d.applier.SetResolver(d.resolver)
+	}
+
+	if err := d.ensurePublication(ctx, tables, allowMissingIdentity); err != nil {
+		return err
+	}
+	startLSN, err := d.slotPosition(ctx)
+	if err != nil {
+		return err
+	}
+
+	d.decoder = stream.NewDecoder(replConn, d.SlotName, d.Publication, d.logger)
+	if _, err := d.decoder.CreateSlot(ctx, startLSN); err != nil {
+		return fmt.Errorf("create slot: %w", err)
+	}
+	msgCh, err := d.decoder.StartStreaming(ctx)
+	if err != nil {
+		return fmt.Errorf("start streaming: %w", err)
+	}
+	d.filter = NewFilter(d.Skip, d.logger)
+	d.messages = d.filter.Run(ctx, msgCh)
+	d.Metrics.SetPhase("streaming")
+	return nil
+}
+
+// ensurePublication creates the publication on the source unless it
+// exists. A published table without a replica identity would reject
+// UPDATE and DELETE on the source, so such tables make it refuse unless
+// allowMissingIdentity is set.
+func (d *Direction) ensurePublication(ctx context.Context, tables []string, allowMissingIdentity bool) error {
+	var exists bool
+	err := d.srcPool.QueryRow(ctx,
+		"SELECT EXISTS(SELECT 1 FROM pg_publication WHERE pubname = $1)", d.Publication).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("check publication: %w", err)
+	}
+	if exists {
+		return nil
+	}
+
+	findings, err := identity.NewAuditor(d.srcPool, d.logger).Audit(ctx)
+	if err != nil {
+		return err
+	}
+	if findings = identity.Filter(findings, tables); len(findings) > 0 {
+		names := make([]string, len(findings))
+		for i, f := range findings {
+			names[i] = f.Schema + "." + f.Table
+		}
+		if !allowMissingIdentity {
+			return fmt.Errorf("refusing to create publication: %d table(s) have no replica identity and would reject UPDATE/DELETE on %s: %s (fix them or allow missing replica identity)",
+				len(findings), d.source.Host, strings.Join(names, ", "))
+		}
+		d.logger.Warn().Strs("tables", names).Msg("creating publication with tables lacking replica identity (override enabled)")
+	}
+
+	target := "ALL TABLES"
+	if len(tables) > 0 {
+		quoted := make([]string, len(tables))
+		for i, t := range tables {
+			quoted[i] = quoteQualified(t)
+		}
+		target = "TABLE " + strings.Join(quoted, ", ")
+	}
+	if _, err := d.srcPool.Exec(ctx, fmt.Sprintf("CREATE PUBLICATION %s FOR %s", quoteIdent(d.Publication), target)); err != nil {
+		return fmt.Errorf("create publication: %w", err)
+	}
+	d.logger.Info().Str("publication", d.Publication).Msg("created publication")
+	return nil
+}
+
+// slotPosition returns the confirmed position of an existing slot, or 0 when
+// the slot does not exist yet.
+func (d *Direction) slotPosition(ctx context.Context) (pglogrepl.LSN, error) {
+	var confirmed *string
+	err := d.srcPool.QueryRow(ctx,
+		"SELECT confirmed_flush_lsn::text FROM pg_replication_slots WHERE slot_name = $1", d.SlotName).Scan(&confirmed)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("check slot: %w", err)
+	}
+	if confirmed == nil {
+		return 0, fmt.Errorf("slot %q has no confirmed position", d.SlotName)
+	}
+	lsn, err := pglogrepl.ParseLSN(*confirmed)
+	if err != nil {
+		return 0, fmt.Errorf("parse confirmed_flush_lsn: %w", err)
+	}
+	d.logger.Info().Str("slot", d.SlotName).Stringer("lsn", lsn).Msg("resuming existing slot")
+	return lsn, nil
+}
+
+func (d *Direction) run(ctx context.Context) error {
+	err := d.applier.Start(ctx, d.messages, func(lsn pglogrepl.LSN) {
+		// Looped transactions arrive empty; confirm them so the slot does
+		// not retain WAL, but do not count them as applied.
+		d.decoder.ConfirmLSN(lsn)
+		var txns int64 = 1
+		if d.filter.Looped(lsn) {
+			txns = 0
+		}
+		d.Metrics.RecordApplied(lsn, txns, 0)
+		d.Metrics.RecordConfirmedLSN(lsn)
+	}, nil)
+	if err != nil {
+		d.Metrics.RecordError(err)
+		return err
+	}
+	if err := d.decoder.Err(); err != nil {
+		d.Metrics.RecordError(err)
+		return fmt.Errorf("decoder: %w", err)
+	}
+	return ctx.Err()
+}
+
+func (d *Direction) close() {
+	if d.decoder != nil {
+		d.decoder.Close()
+	}
+	if d.applier != nil {
+		d.applier.Close()
+	}
+	if d.replConn != nil {
+		closeCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		d.replConn.Close(closeCtx) //nolint:errcheck
+		cancel()
+		d.replConn = nil
+	}
+	if d.srcPool != nil {
+		d.srcPool.Close()
+		d.srcPool = nil
+	}
+	if d.dstPool != nil {
+		d.dstPool.Close()
+		d.dstPool = nil
+	}
+	d.Metrics.Close()
+}
+
+func quoteIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// quoteQualified quotes a schema.table name; a bare name is left unqualified.
+func quoteQualified(name string) string {
+	if schema, table, ok := strings.Cut(name, "."); ok {
+		return quoteIdent(schema) + "." + quoteIdent(table)
+	}
+	return quoteIdent(name)
+}
@@ -0,0 +1,435 @@
+//go:build integration
+
+package bidi_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog"
+
+	"github.com/jfoltran/pgmanager/internal/config"
+	"github.com/jfoltran/pgmanager/internal/migration/bidi"
+	"github.com/jfoltran/pgmanager/internal/testutil"
+)
+
+func TestMain(m *testing.M) {
+	if testutil.ContainerRuntime() == "" {
+		fmt.Fprintln(os.Stderr, "SKIP: no container runtime found (docker or podman)")
+		os.Exit(0)
+	}
+
+	alreadyRunning := testutil.TryPing(testutil.SourceDSN()) && testutil.TryPing(testutil.DestDSN())
+	if !alreadyRunning {
+		if err := testutil.RunCompose("up", "-d", "--wait"); err != nil {
+			fmt.Fprintf(os.Stderr, "compose up failed: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	code := m.Run()
+
+	if !alreadyRunning {
+		_ = testutil.RunCompose("down", "-v")
+	}
+	os.Exit(code)
+}
+
+func testConfig(slotName, pubName string) *config.Config {
+	return &config.Config{
+		Source: config.DatabaseConfig{
+			Host: "localhost", Port: 55432, User: "postgres", Password: "source", DBName: "source",
+		},
+		Dest: config.DatabaseConfig{
+			Host: "localhost", Port: 55433, User: "postgres", Password: "dest", DBName: "dest",
+		},
+		Replication: config.ReplicationConfig{
+			SlotName:    slotName,
+			Publication: pubName,
+		},
+	}
+}
+
+func uniqueName(prefix string) string {
+	return fmt.Sprintf("%s_%d", prefix, time.Now().UnixNano()%1_000_000)
+}
+
+func TestManager_ConvergesWithoutLoop(t *testing.T) {
+	poolA := testutil.MustConnectPool(t, testutil.SourceDSN())
+	poolB := testutil.MustConnectPool(t, testutil.DestDSN())
+
+	table := uniqueName("test_bidi")
+	audit := "public." + uniqueName("conflicts")
+	slot := uniqueName("slot_bidi")
+	pub := uniqueName("pub_bidi")
+	originA := uniqueName("origin_a")
+	originB := uniqueName("origin_b")
+
+	testutil.CreateTestTable(t, poolA, "public", table, 0)
+	testutil.CreateTestTable(t, poolB, "public", table, 0)
+	t.Cleanup(func() {
+		testutil.DropTestTable(t, poolA, "public", table)
+		testutil.DropTestTable(t, poolB, "public", table)
+		mustExec(t, poolA, "DROP TABLE IF EXISTS "+audit)
+		mustExec(t, poolB, "DROP TABLE IF EXISTS "+audit)
+		testutil.CleanupReplication(t, poolA, slot+"_a", pub+"_a")
+		testutil.CleanupReplication(t, poolB, slot+"_b", pub+"_b")
+		dropOrigin(poolB, originA)
+		dropOrigin(poolA, originB)
+	})
+
+	logger := zerolog.New(zerolog.NewTestWriter(t)).With().Timestamp().Logger()
+	m := bidi.NewManager(testConfig(slot, pub), originA, originB, logger)
+	m.Tables = []string{"public." + table}
+	m.ConflictTable = audit
+
+	ctx, cancel := context.WithTimeout(context.Background(), 90*time.Second)
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- m.Start(ctx) }()
+
+	// Wait for both slots so that the writes below are captured.
+	waitFor(t, 30*time.Second, func() bool {
+		return slotExists(poolA, slot+"_a") && slotExists(poolB, slot+"_b")
+	})
+
+	// Disjoint ids on each node; SERIAL would collide.
+	for i := 1; i <= 10; i++ {
+		mustExec(t, poolA, fmt.Sprintf("INSERT INTO %q (id, name, value) VALUES ($1, $2, $3)", table), i, fmt.Sprintf("a-%d", i), i)
+		mustExec(t, poolB, fmt.Sprintf("INSERT INTO %q (id, name, value) VALUES ($1, $2, $3)", table), 100+i, fmt.Sprintf("b-%d", i), i)
+	}
+	waitFor(t, 30*time.Second, func() bool {
+		return testutil.TableRowCount(t, poolA, "public", table) == 20 &&
+			testutil.TableRowCount(t, poolB, "public", table) == 20
+	})
+
+	// Each node now changes a row that arrived from the other one.
+	mustExec(t, poolA, fmt.Sprintf("UPDATE %q SET value = 999 WHERE id = 101", table))
+	mustExec(t, poolB, fmt.Sprintf("DELETE FROM %q WHERE id = 5", table))
+
+	waitFor(t, 30*time.Second, func() bool {
+		return tableDigest(t, poolA, table) == tableDigest(t, poolB, table) &&
+			testutil.TableRowCount(t, poolA, "public", table) == 19
+	})
+
+	var value int
+	if err := poolB.QueryRow(ctx, fmt.Sprintf("SELECT value FROM %q WHERE id = 101", table)).Scan(&value); err != nil {
+		t.Fatalf("read updated row: %v", err)
+	}
+	if value != 999 {
+		t.Errorf("update from A not applied on B: value = %d", value)
+	}
+
+	// No loop: once converged, neither direction keeps applying.
+	time.Sleep(2 * time.Second)
+	appliedAB := m.AtoB.Metrics.Snapshot().TotalRows
+	appliedBA := m.BtoA.Metrics.Snapshot().TotalRows
+	time.Sleep(3 * time.Second)
+	if got := m.AtoB.Metrics.Snapshot().TotalRows; got != appliedAB {
+		t.Errorf("a_to_b kept applying after convergence: %d -> %d", appliedAB, got)
+	}
+	if got := m.BtoA.Metrics.Snapshot().TotalRows; got != appliedBA {
+		t.Errorf("b_to_a kept applying after convergence: %d -> %d", appliedBA, got)
+	}
+	// 10 inserts + 1 update from A, 10 inserts + 1 delete from B, one
+	// transaction each; echoed transactions must not be counted.
+	if appliedAB != 11 || appliedBA != 11 {
+		t.Errorf("applied transactions a_to_b=%d b_to_a=%d, want 11 each", appliedAB, appliedBA)
+	}
+
+	cancel()
+	select {
+	case err := <-errCh:
+		if err != nil && !errors.Is(err, context.Canceled) {
+			t.Errorf("Start returned %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("manager did not stop after cancellation")
+	}
+}
+
+func TestManager_ConfirmsLoopedTransactions(t *testing.T) {
+	poolA := testutil.MustConnectPool(t, testutil.SourceDSN())
+	poolB := testutil.MustConnectPool(t, testutil.DestDSN())
+
+	table := uniqueName("test_bidi_echo")
+	audit := "public." + uniqueName("conflicts")
+	slot := uniqueName("slot_echo")
+	pub := uniqueName("pub_echo")
+	originA := uniqueName("origin_a")
+	originB := uniqueName("origin_b")
+
+	testutil.CreateTestTable(t, poolA, "public", table, 0)
+	testutil.CreateTestTable(t, poolB, "public", table, 0)
+	t.Cleanup(func() {
+		testutil.DropTestTable(t, poolA, "public", table)
+		testutil.DropTestTable(t, poolB, "public", table)
+		mustExec(t, poolA, "DROP TABLE IF EXISTS "+audit)
+		mustExec(t, poolB, "DROP TABLE IF EXISTS "+audit)
+		testutil.CleanupReplication(t, poolA, slot+"_a", pub+"_a")
+		testutil.CleanupReplication(t, poolB, slot+"_b", pub+"_b")
+		dropOrigin(poolB, originA)
+		dropOrigin(poolA, originB)
+	})
+
+	logger := zerolog.New(zerolog.NewTestWriter(t)).With().Timestamp().Logger()
+	m := bidi.NewManager(testConfig(slot, pub), originA, originB, logger)
+	m.Tables = []string{"public." + table}
+	m.ConflictTable = audit
+
+	ctx, cancel := context.WithTimeout(context.Background(), 90*time.Second)
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- m.Start(ctx) }()
+
+	waitFor(t, 30*time.Second, func() bool {
+		return slotExists(poolA, slot+"_a") && slotExists(poolB, slot+"_b")
+	})
+	before := confirmedFlush(t, poolB, slot+"_b")
+
+	// Writes only on A: everything B's slot decodes is an echo of them.
+	for i := 1; i <= 5; i++ {
+		mustExec(t, poolA, fmt.Sprintf("INSERT INTO %q (id, name, value) VALUES ($1, $2, $3)", table), i, fmt.Sprintf("a-%d", i), i)
+	}
+	waitFor(t, 30*time.Second, func() bool {
+		return testutil.TableRowCount(t, poolB, "public", table) == 5
+	})
+
+	// Without confirming the echoes, B's slot would stay where it started.
+	waitFor(t, 30*time.Second, func() bool {
+		return confirmedFlush(t, poolB, slot+"_b") > before
+	})
+	// The echoes are confirmed but not counted as applied on A.
+	if got := m.BtoA.Metrics.Snapshot().TotalRows; got != 0 {
+		t.Errorf("b_to_a applied %d transactions, want 0", got)
+	}
+	if got := testutil.TableRowCount(t, poolA, "public", table); got != 5 {
+		t.Errorf("rows on A = %d, want 5", got)
+	}
+
+	cancel()
+	select {
+	case err := <-errCh:
+		if err != nil && !errors.Is(err, context.Canceled) {
+			t.Errorf("Start returned %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("manager did not stop after cancellation")
+	}
+}
+
+func TestManager_RefusesTablesWithoutIdentity(t *testing.T) {
+	poolA := testutil.MustConnectPool(t, testutil.SourceDSN())
+	poolB := testutil.MustConnectPool(t, testutil.DestDSN())
+
+	table := uniqueName("test_bidi_noid")
+	slot := uniqueName("slot_noid")
+	pub := uniqueName("pub_noid")
+	for _, pool := range []*pgxpool.Pool{poolA, poolB} {
+		mustExec(t, pool, fmt.Sprintf("CREATE TABLE %q (id int, name text)", table))
+	}
+	t.Cleanup(func() {
+		testutil.DropTestTable(t, poolA, "public", table)
+		testutil.DropTestTable(t, poolB, "public", table)
+		testutil.CleanupReplication(t, poolA, slot+"_a", pub+"_a")
+		testutil.CleanupReplication(t, poolB, slot+"_b", pub+"_b")
+	})
+
+	logger := zerolog.New(zerolog.NewTestWriter(t)).With().Timestamp().Logger()
+	m := bidi.NewManager(testConfig(slot, pub), uniqueName("origin_a"), uniqueName("origin_b"), logger)
+	m.Tables = []string{"public." + table}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	err := m.Start(ctx)
+	if err == nil || !strings.Contains(err.Error(), "no replica identity") {
+		t.Fatalf("Start = %v, want a replica identity refusal", err)
+	}
+	var exists bool
+	if err := poolA.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM pg_publication WHERE pubname = $1)", pub+"_a").Scan(&exists); err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Error("publication created despite the missing replica identity")
+	}
+}
+
+func confirmedFlush(t *testing.T, pool *pgxpool.Pool, slot string) pglogrepl.LSN {
+	t.Helper()
+	var lsn string
+	if err := pool.QueryRow(context.Background(),
+		"SELECT COALESCE(confirmed_flush_lsn, '0/0')::text FROM pg_replication_slots WHERE slot_name = $1", slot).Scan(&lsn); err != nil {
+		t.Fatalf("read slot %s: %v", slot, err)
+	}
+	parsed, err := pglogrepl.ParseLSN(lsn)
+	if err != nil {
+		t.Fatalf("parse %s: %v", lsn, err)
+	}
+	return parsed
+}
+
+func mustExec(t *testing.T, pool *pgxpool.Pool, sql string, args ...any) {
+	t.Helper()
+	if _, err := pool.Exec(context.Background(), sql, args...); err != nil {
+		t.Fatalf("exec %q: %v", sql, err)
+	}
+}
+
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	t.Fatalf("condition not met within %s", timeout)
+}
+
+func slotExists(pool *pgxpool.Pool, name string) bool {
+	var ok bool
+	_ = pool.QueryRow(context.Background(),
+		"SELECT EXISTS(SELECT 1 FROM pg_replication_slots WHERE slot_name = $1)", name).Scan(&ok)
+	return ok
+}
+
+func tableDigest(t *testing.T, pool *pgxpool.Pool, table string) string {
+	t.Helper()
+	var digest string
+	err := pool.QueryRow(context.Background(), fmt.Sprintf(
+		"SELECT COALESCE(md5(string_agg(id || ':' || name || ':' || value, ',' ORDER BY id)), '') FROM %q", table)).Scan(&digest)
+	if err != nil {
+		t.Fatalf("digest %s: %v", table, err)
+	}
+	return digest
+}
+
+func dropOrigin(pool *pgxpool.Pool, name string) {
+	_, _ = pool.Exec(context.Background(),
+		"SELECT pg_replication_origin_drop(roname) FROM pg_replication_origin WHERE roname = $1", name)
+}
+
+func TestManager_LastWriterWins(t *testing.T) {
+	poolA := testutil.MustConnectPool(t, testutil.SourceDSN())
+	poolB := testutil.MustConnectPool(t, testutil.DestDSN())
+
+	table := uniqueName("test_lww")
+	audit := "public." + uniqueName("conflicts")
+	slot := uniqueName("slot_lww")
+	pub := uniqueName("pub_lww")
+	originA := uniqueName("origin_a")
+	originB := uniqueName("origin_b")
+
+	testutil.CreateTestTable(t, poolA, "public", table, 3)
+	testutil.CreateTestTable(t, poolB, "public", table, 3)
+	t.Cleanup(func() {
+		testutil.DropTestTable(t, poolA, "public", table)
+		testutil.DropTestTable(t, poolB, "public", table)
+		mustExec(t, poolA, "DROP TABLE IF EXISTS "+audit)
+		mustExec(t, poolB, "DROP TABLE IF EXISTS "+audit)
+		testutil.CleanupReplication(t, poolA, slot+"_a", pub+"_a")
+		testutil.CleanupReplication(t, poolB, slot+"_b", pub+"_b")
+		dropOrigin(poolB, originA)
+		dropOrigin(poolA, originB)
+	})
+
+	logger := zerolog.New(zerolog.NewTestWriter(t)).With().Timestamp().Logger()
+	run := func() (context.CancelFunc, chan error) {
+		m := bidi.NewManager(testConfig(slot, pub), originA, originB, logger)
+		m.Tables = []string{"public." + table}
+		m.ConflictTable = audit
+		ctx, cancel := context.WithTimeout(context.Background(), 90*time.Second)
+		errCh := make(chan error, 1)
+		go func() { errCh <- m.Start(ctx) }()
+		return cancel, errCh
+	}
+	stop := func(cancel context.CancelFunc, errCh chan error) {
+		cancel()
+		select {
+		case <-errCh:
+		case <-time.After(10 * time.Second):
+			t.Fatal("manager did not stop")
+		}
+	}
+
+	// Create the slots, then stop so that both nodes change the same rows
+	// while disconnected.
+	cancel, errCh := run()
+	waitFor(t, 30*time.Second, func() bool {
+		return slotExists(poolA, slot+"_a") && slotExists(poolB, slot+"_b")
+	})
+	stop(cancel, errCh)
+
+	// A changes first.
+	mustExec(t, poolA, fmt.Sprintf("UPDATE %q SET value = 1 WHERE id = 1", table))
+	mustExec(t, poolA, fmt.Sprintf("DELETE FROM %q WHERE id = 2", table))
+	time.Sleep(50 * time.Millisecond)
+	// B changes the same rows later: update/update (B wins), delete/update
+	// (deletes always win), and an insert that A then overwrites.
+	mustExec(t, poolB, fmt.Sprintf("UPDATE %q SET value = 2 WHERE id = 2", table))
+	mustExec(t, poolB, fmt.Sprintf("UPDATE %q SET value = 2 WHERE id = 1", table))
+	mustExec(t, poolB, fmt.Sprintf("INSERT INTO %q (id, name, value) VALUES (50, 'b', 2)", table))
+	time.Sleep(50 * time.Millisecond)
+	// insert/insert: A inserts last and wins.
+	mustExec(t, poolA, fmt.Sprintf("INSERT INTO %q (id, name, value) VALUES (50, 'a', 1)", table))
+
+	cancel, errCh = run()
+	defer stop(cancel, errCh)
+
+	waitFor(t, 30*time.Second, func() bool {
+		return tableDigest(t, poolA, table) == tableDigest(t, poolB, table) &&
+			testutil.TableRowCount(t, poolA, "public", table) == 3
+	})
+
+	for _, pool := range []*pgxpool.Pool{poolA, poolB} {
+		var v1, v50 int
+		var name50 string
+		if err := pool.QueryRow(context.Background(), fmt.Sprintf(
+			"SELECT (SELECT value FROM %[1]q WHERE id = 1), (SELECT value FROM %[1]q WHERE id = 50), (SELECT name FROM %[1]q WHERE id = 50)",
+			table)).Scan(&v1, &v50, &name50); err != nil {
+			t.Fatalf("read rows: %v", err)
+		}
+		if v1 != 2 || v50 != 1 || name50 != "a" {
+			t.Errorf("rows = (%d, %d, %s), want (2, 1, a)", v1, v50, name50)
+		}
+	}
+
+	kinds := func(pool *pgxpool.Pool) map[string]string {
+		rows, err := pool.Query(context.Background(), "SELECT kind, winner FROM "+audit)
+		if err != nil {
+			t.Fatalf("read audit: %v", err)
+		}
+		defer rows.Close()
+		out := map[string]string{}
+		for rows.Next() {
+			var kind, winner string
+			if err := rows.Scan(&kind, &winner); err != nil {
+				t.Fatal(err)
+			}
+			out[kind] = winner
+		}
+		return out
+	}
+	waitFor(t, 10*time.Second, func() bool { return len(kinds(poolA)) == 2 && len(kinds(poolB)) == 3 })
+
+	// A receives B's update of the row A deleted (discarded), B's newer
+	// update of row 1 (applied, no conflict) and B's older insert (discarded).
+	if got := kinds(poolA); got["update_delete"] != "local" || got["insert_insert"] != "local" {
+		t.Errorf("audit on A = %v", got)
+	}
+	// B receives A's older update of row 1 (discarded), A's delete of a row
+	// B updated later (deletes win anyway) and A's newer insert (applied).
+	if got := kinds(poolB); got["update_update"] != "local" || got["delete_update"] != "remote" || got["insert_insert"] != "remote" {
+		t.Errorf("audit on B = %v", got)
+	}
+}
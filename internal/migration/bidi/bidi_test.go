@@ -2,6 +2,8 @@ package bidi
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -104,3 +106,58 @@ func TestFilter_ContextCancellation(t *testing.T) {
 		t.Error("output channel did not close after context cancellation")
 	}
 }
+
+func TestFilter_EmptiesLoopedTransaction(t *testing.T) {
+	f := NewFilter("pgmanager-a", zerolog.Nop())
+
+	in := make(chan stream.Message, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	out := f.Run(ctx, in)
+
+	in <- &stream.BeginMessage{TxnLSN: 100, Origin: "pgmanager-a"}
+	in <- &stream.ChangeMessage{Op: stream.OpInsert, MsgLSN: 100, Origin: "pgmanager-a"}
+	in <- &stream.CommitMessage{CommitLSN: 100, Origin: "pgmanager-a"}
+	in <- &stream.BeginMessage{TxnLSN: 200}
+	in <- &stream.ChangeMessage{Op: stream.OpInsert, MsgLSN: 200}
+	in <- &stream.CommitMessage{CommitLSN: 200}
+	in <- &stream.BeginMessage{TxnLSN: 300, Origin: "pgmanager-a"}
+	in <- &stream.CommitMessage{CommitLSN: 300, Origin: "pgmanager-a"}
+	close(in)
+
+	// The looped transactions arrive as Begin and Commit only, so that the
+	// consumer still confirms them.
+	var got []string
+	for m := range out {
+		got = append(got, fmt.Sprintf("%s@%d", m.Kind(), m.LSN()))
+	}
+	want := []string{"Begin@100", "Commit@100", "Begin@200", "Change@200", "Commit@200", "Begin@300", "Commit@300"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	if !f.Looped(100) {
+		t.Error("commit 100 not reported as looped")
+	}
+	if f.Looped(200) {
+		t.Error("local commit 200 reported as looped")
+	}
+	// Confirming past a looped commit forgets it.
+	if f.Looped(400) || f.Looped(300) {
+		t.Error("commit 300 still remembered after confirming 400")
+	}
+}
+
+func TestQuoteQualified(t *testing.T) {
+	tests := map[string]string{
+		"public.t":   `"public"."t"`,
+		"orders":     `"orders"`,
+		`app.we"ird`: `"app"."we""ird"`,
+	}
+	for in, want := range tests {
+		if got := quoteQualified(in); got != want {
+			t.Errorf("quoteQualified(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
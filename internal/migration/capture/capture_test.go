@@ -0,0 +1,326 @@
+package capture
+
+import (
+	"context"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/rs/zerolog"
+
+	"github.com/jfoltran/pgmanager/internal/migration/sentinel"
+	"github.com/jfoltran/pgmanager/internal/migration/stream"
+)
+
+var testRelation = &stream.RelationMessage{
+	RelationID: 16384,
+	Namespace:  "public",
+	Name:       "users",
+	Columns: []stream.Column{
+		{Name: "id", DataType: 23, Key: true},
+		{Name: "name", DataType: 25},
+	},
+	MsgLSN: 100,
+}
+
+// txn returns the messages of a one-row insert transaction committing at lsn.
+func txn(lsn pglogrepl.LSN, id string) []stream.Message {
+	return []stream.Message{
+		&stream.BeginMessage{TxnLSN: lsn, XID: uint32(lsn)},
+		&stream.ChangeMessage{
+			Op: stream.OpInsert, RelationID: 16384, Namespace: "public", Table: "users",
+			NewTuple: &stream.TupleData{Columns: []stream.Column{
+				{Name: "id", DataType: 23, Value: []byte(id), Key: true},
+				{Name: "name", DataType: 25},
+			}},
+			MsgLSN: lsn,
+		},
+		&stream.CommitMessage{CommitLSN: lsn},
+	}
+}
+
+func writeAll(t *testing.T, w *Writer, msgs ...stream.Message) {
+	t.Helper()
+	for _, m := range msgs {
+		if err := w.Write(m); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+}
+
+func readAll(t *testing.T, r *Reader) []stream.Message {
+	t.Helper()
+	var msgs []stream.Message
+	for {
+		m, err := r.Next()
+		if err == io.EOF {
+			return msgs
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		msgs = append(msgs, m)
+	}
+}
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	ts := time.Unix(1700000000, 123456789)
+	msgs := []stream.Message{
+		&stream.BeginMessage{TxnLSN: 0x16B3748, TxnTime: ts, XID: 742, Origin: "pgmanager_m1"},
+		testRelation,
+		&stream.ChangeMessage{
+			Op: stream.OpUpdate, RelationID: 16384, Namespace: "public", Table: "users",
+			OldTuple: &stream.TupleData{Columns: []stream.Column{{Name: "id", DataType: 23, Value: []byte("1"), Key: true}}},
+			NewTuple: &stream.TupleData{Columns: []stream.Column{
+				{Name: "id", DataType: 23, Value: []byte("2"), Key: true},
+				{Name: "name", DataType: 25, Value: []byte{}},
+				{Name: "bio", DataType: 25},
+			}},
+			MsgLSN: 0x16B3750, MsgTime: ts, Origin: "x",
+		},
+		&stream.ChangeMessage{Op: stream.OpDelete, RelationID: 1, OldTuple: &stream.TupleData{}},
+		&stream.CommitMessage{CommitLSN: 0x16B3760, TxnTime: ts},
+	}
+	for _, m := range msgs {
+		payload, ok := encodeMessage(nil, m)
+		if !ok {
+			t.Fatalf("%s not encoded", m.Kind())
+		}
+		got, err := decodeMessage(payload)
+		if err != nil {
+			t.Fatalf("decode %s: %v", m.Kind(), err)
+		}
+		if !reflect.DeepEqual(got, m) {
+			t.Errorf("%s round trip:\n got %#v\nwant %#v", m.Kind(), got, m)
+		}
+	}
+}
+
+func TestEncodeSkipsSentinels(t *testing.T) {
+	if _, ok := encodeMessage(nil, &sentinel.SentinelMessage{ID: "s"}); ok {
+		t.Error("sentinel should not be recorded")
+	}
+}
+
+func TestDecodeTruncatedPayload(t *testing.T) {
+	payload, _ := encodeMessage(nil, txn(10, "1")[1])
+	for i := 0; i < len(payload); i++ {
+		if _, err := decodeMessage(payload[:i]); err == nil {
+			t.Fatalf("decode of %d/%d bytes succeeded", i, len(payload))
+		}
+	}
+}
+
+func TestWriterRotatesAtCommit(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(dir, Options{SegmentBytes: 1}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeAll(t, w, testRelation)
+	writeAll(t, w, txn(10, "1")...)
+	writeAll(t, w, txn(20, "2")...)
+	writeAll(t, w, &sentinel.SentinelMessage{ID: "s"})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	segs, err := Segments(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segs) != 2 {
+		t.Fatalf("segments = %d, want 2", len(segs))
+	}
+	if segs[0].After != 0 || segs[1].After != 10 {
+		t.Errorf("After = %s, %s, want 0/0, 0/A", segs[0].After, segs[1].After)
+	}
+
+	// The second segment repeats the relation so it replays on its own.
+	r, err := Open(segs[1].Path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	got := readAll(t, r)
+	if len(got) != 4 || got[0].Kind() != stream.KindRelation || got[3].LSN() != 20 {
+		t.Errorf("second segment = %v", kinds(got))
+	}
+}
+
+func TestWriterMaxSegments(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(dir, Options{SegmentBytes: 1, MaxSegments: 2}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for lsn := pglogrepl.LSN(10); lsn <= 50; lsn += 10 {
+		writeAll(t, w, txn(lsn, "1")...)
+	}
+	w.Close()
+	segs, _ := Segments(dir)
+	if len(segs) != 2 || segs[0].Seq != 4 {
+		t.Fatalf("segments = %+v, want the last two", segs)
+	}
+}
+
+func TestWriterContinuesAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	w, _ := NewWriter(dir, Options{}, zerolog.Nop())
+	writeAll(t, w, txn(10, "1")...)
+	writeAll(t, w, txn(30, "3")...)
+	w.Close()
+
+	w, err := NewWriter(dir, Options{}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeAll(t, w, txn(40, "4")...)
+	w.Close()
+
+	segs, _ := Segments(dir)
+	if len(segs) != 2 || segs[1].Seq != 2 || segs[1].After != 30 {
+		t.Fatalf("segments = %+v", segs)
+	}
+}
+
+func TestOpenSkipsSegmentsBeforeFrom(t *testing.T) {
+	dir := t.TempDir()
+	w, _ := NewWriter(dir, Options{SegmentBytes: 1}, zerolog.Nop())
+	writeAll(t, w, testRelation)
+	for lsn := pglogrepl.LSN(10); lsn <= 40; lsn += 10 {
+		writeAll(t, w, txn(lsn, "1")...)
+	}
+	w.Close()
+
+	r, err := Open(dir, 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	got := readAll(t, r)
+	if got[0].Kind() != stream.KindRelation {
+		t.Errorf("first message = %s, want Relation", got[0].Kind())
+	}
+	if first := got[1].LSN(); first != 30 {
+		t.Errorf("first transaction = %s, want 0/1E", first)
+	}
+}
+
+func TestReaderTornTail(t *testing.T) {
+	dir := t.TempDir()
+	w, _ := NewWriter(dir, Options{}, zerolog.Nop())
+	writeAll(t, w, txn(10, "1")...)
+	writeAll(t, w, txn(20, "2")...)
+	w.Close()
+
+	segs, _ := Segments(dir)
+	info, _ := os.Stat(segs[0].Path)
+	if err := os.Truncate(segs[0].Path, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	r, _ := Open(dir, 0)
+	defer r.Close()
+	got := readAll(t, r)
+	if len(got) != 5 {
+		t.Errorf("read %d messages, want 5", len(got))
+	}
+	if len(r.TornSegments()) != 1 {
+		t.Errorf("torn segments = %v", r.TornSegments())
+	}
+}
+
+func TestFeedWindow(t *testing.T) {
+	dir := t.TempDir()
+	w, _ := NewWriter(dir, Options{}, zerolog.Nop())
+	writeAll(t, w, testRelation)
+	for lsn := pglogrepl.LSN(10); lsn <= 50; lsn += 10 {
+		writeAll(t, w, txn(lsn, "1")...)
+	}
+	w.Close()
+
+	r, _ := Open(dir, 20)
+	defer r.Close()
+	out := make(chan stream.Message, 100)
+	res, err := Feed(context.Background(), r, ReplayOptions{FromLSN: 20, ToLSN: 40}, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(out)
+
+	var commits []pglogrepl.LSN
+	for m := range out {
+		if m.Kind() == stream.KindCommit {
+			commits = append(commits, m.LSN())
+		}
+	}
+	if !reflect.DeepEqual(commits, []pglogrepl.LSN{20, 30, 40}) {
+		t.Errorf("commits = %v", commits)
+	}
+	if res.Transactions != 3 || res.Skipped != 1 || res.LastLSN != 40 {
+		t.Errorf("result = %+v", res)
+	}
+}
+
+func TestFeedDropsDuplicatesAndPartialTransactions(t *testing.T) {
+	dir := t.TempDir()
+	w, _ := NewWriter(dir, Options{}, zerolog.Nop())
+	writeAll(t, w, txn(10, "1")...)
+	// The decoder died mid-transaction and resent from 0/A after reconnecting.
+	writeAll(t, w, txn(20, "2")[:2]...)
+	writeAll(t, w, txn(10, "1")...)
+	writeAll(t, w, txn(20, "2")...)
+	w.Close()
+
+	r, _ := Open(dir, 0)
+	defer r.Close()
+	out := make(chan stream.Message, 100)
+	res, err := Feed(context.Background(), r, ReplayOptions{}, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Transactions != 2 || res.Skipped != 1 || res.Incomplete != 1 {
+		t.Errorf("result = %+v", res)
+	}
+	if len(out) != 6 {
+		t.Errorf("sent %d messages, want 6", len(out))
+	}
+}
+
+func TestTap(t *testing.T) {
+	dir := t.TempDir()
+	w, _ := NewWriter(dir, Options{}, zerolog.Nop())
+	in := make(chan stream.Message, 10)
+	for _, m := range txn(10, "1") {
+		in <- m
+	}
+	in <- &sentinel.SentinelMessage{ID: "s"}
+	close(in)
+
+	var n int
+	for range Tap(context.Background(), in, w) {
+		n++
+	}
+	if n != 4 {
+		t.Errorf("tapped %d messages, want 4", n)
+	}
+	w.Close()
+
+	r, _ := Open(dir, 0)
+	defer r.Close()
+	if got := readAll(t, r); len(got) != 3 {
+		t.Errorf("recorded %v, want Begin/Change/Commit", kinds(got))
+	}
+}
+
+func kinds(msgs []stream.Message) []string {
+	out := make([]string, len(msgs))
+	for i, m := range msgs {
+		out[i] = m.Kind().String()
+	}
+	return out
+}
@@ -0,0 +1,391 @@
+// Package capture records the decoded change stream to rotating segment
+// files and replays recorded streams into a destination.
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+
+	"github.com/jfoltran/pgmanager/internal/migration/stream"
+)
+
+// segmentMagic opens every segment file; the last byte is the format version.
+var segmentMagic = []byte("PGMCAP\x00\x01")
+
+// HeaderSize is the length of the header at the start of every segment.
+var HeaderSize = int64(len(segmentMagic))
+
+// WriteHeader writes the segment header.
+func WriteHeader(w io.Writer) error {
+	_, err := w.Write(segmentMagic)
+	return err
+}
+
+// CheckHeader reads and verifies the segment header.
+func CheckHeader(r io.Reader) error {
+	header := make([]byte, len(segmentMagic))
+	if _, err := io.ReadFull(r, header); err != nil || !bytes.Equal(header, segmentMagic) {
+		return errors.New("not a capture segment")
+	}
+	return nil
+}
+
+// maxRecordBytes bounds a single record so a corrupt length prefix cannot
+// trigger a huge allocation.
+const maxRecordBytes = 1 << 30
+
+var errCorrupt = errors.New("corrupt capture record")
+
+// ErrDamaged is returned for a record that is cut short or fails its
+// checksum, as left at the end of a segment by a crash.
+var ErrDamaged = errors.New("damaged capture record")
+
+// AppendRecord encodes msg and appends it to buf as a framed record. It
+// reports false, leaving buf unchanged, for message kinds that are not
+// recorded, such as sentinels.
+func AppendRecord(buf []byte, msg stream.Message) ([]byte, bool) {
+	payload, ok := encodeMessage(nil, msg)
+	if !ok {
+		return buf, false
+	}
+	return appendRecord(buf, payload), true
+}
+
+// RecordReader decodes framed records and tracks the offset they end at.
+type RecordReader struct {
+	br  *bufio.Reader
+	buf []byte
+	// Offset is the position just past the last record returned, counted
+	// from the offset given to NewRecordReader.
+	Offset int64
+}
+
+// NewRecordReader reads records from r, which is positioned at offset.
+func NewRecordReader(r io.Reader, offset int64) *RecordReader {
+	return &RecordReader{br: bufio.NewReaderSize(r, 256<<10), Offset: offset}
+}
+
+// Next returns the next message. It returns io.EOF at a clean end and
+// ErrDamaged for a partial or corrupt record.
+func (rr *RecordReader) Next() (stream.Message, error) {
+	payload, n, err := rr.readPayload()
+	if err != nil {
+		return nil, err
+	}
+	msg, err := decodeMessage(payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDamaged, err)
+	}
+	rr.Offset += n
+	return msg, nil
+}
+
+func (rr *RecordReader) readPayload() ([]byte, int64, error) {
+	n, err := binary.ReadUvarint(rr.br)
+	if err == io.EOF {
+		return nil, 0, io.EOF
+	}
+	if err != nil {
+		return nil, 0, ErrDamaged
+	}
+	if n > maxRecordBytes {
+		return nil, 0, ErrDamaged
+	}
+	if uint64(cap(rr.buf)) < n+4 {
+		rr.buf = make([]byte, n+4)
+	}
+	buf := rr.buf[:n+4]
+	if _, err := io.ReadFull(rr.br, buf); err != nil {
+		return nil, 0, ErrDamaged
+	}
+	payload := buf[:n]
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(buf[n:]) {
+		return nil, 0, ErrDamaged
+	}
+	return payload, int64(uvarintLen(n)) + int64(n) + 4, nil
+}
+
+func uvarintLen(v uint64) int {
+	var b [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(b[:], v)
+}
+
+// appendRecord frames an encoded message as
+// uvarint(len) | payload | crc32(payload).
+func appendRecord(buf, payload []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(payload)))
+	buf = append(buf, payload...)
+	return binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(payload))
+}
+
+// encodeMessage encodes a message payload. It reports false for message
+// kinds that are not recorded, such as sentinels.
+func encodeMessage(buf []byte, msg stream.Message) ([]byte, bool) {
+	switch m := msg.(type) {
+	case *stream.BeginMessage:
+		buf = append(buf, byte(stream.KindBegin))
+		buf = binary.AppendUvarint(buf, uint64(m.TxnLSN))
+		buf = appendTime(buf, m.TxnTime)
+		buf = binary.AppendUvarint(buf, uint64(m.XID))
+		buf = appendString(buf, m.Origin)
+	case *stream.CommitMessage:
+		buf = append(buf, byte(stream.KindCommit))
+		buf = binary.AppendUvarint(buf, uint64(m.CommitLSN))
+		buf = appendTime(buf, m.TxnTime)
+		buf = appendString(buf, m.Origin)
+	case *stream.RelationMessage:
+		buf = append(buf, byte(stream.KindRelation))
+		buf = binary.AppendUvarint(buf, uint64(m.RelationID))
+		buf = binary.AppendUvarint(buf, uint64(m.MsgLSN))
+		buf = appendTime(buf, m.MsgTime)
+		buf = appendString(buf, m.Namespace)
+		buf = appendString(buf, m.Name)
+		buf = appendColumns(buf, m.Columns)
+	case *stream.ChangeMessage:
+		buf = append(buf, byte(stream.KindChange), byte(m.Op))
+		buf = binary.AppendUvarint(buf, uint64(m.RelationID))
+		buf = binary.AppendUvarint(buf, uint64(m.MsgLSN))
+		buf = appendTime(buf, m.MsgTime)
+		buf = appendString(buf, m.Origin)
+		buf = appendString(buf, m.Namespace)
+		buf = appendString(buf, m.Table)
+		buf = appendTuple(buf, m.OldTuple)
+		buf = appendTuple(buf, m.NewTuple)
+	default:
+		return buf, false
+	}
+	return buf, true
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func appendBytes(buf, b []byte) []byte {
+	if b == nil {
+		return append(buf, 0)
+	}
+	buf = append(buf, 1)
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+func appendBool(buf []byte, v bool) []byte {
+	if v {
+		return append(buf, 1)
+	}
+	return append(buf, 0)
+}
+
+// appendTime stores Unix nanoseconds, with 0 for the zero time.
+func appendTime(buf []byte, t time.Time) []byte {
+	if t.IsZero() {
+		return binary.AppendVarint(buf, 0)
+	}
+	return binary.AppendVarint(buf, t.UnixNano())
+}
+
+// appendColumns encodes column metadata without values.
+func appendColumns(buf []byte, cols []stream.Column) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(cols)))
+	for _, c := range cols {
+		buf = appendString(buf, c.Name)
+		buf = binary.AppendUvarint(buf, uint64(c.DataType))
+		buf = appendBool(buf, c.Key)
+	}
+	return buf
+}
+
+func appendTuple(buf []byte, td *stream.TupleData) []byte {
+	if td == nil {
+		return append(buf, 0)
+	}
+	buf = append(buf, 1)
+	buf = binary.AppendUvarint(buf, uint64(len(td.Columns)))
+	for _, c := range td.Columns {
+		buf = appendString(buf, c.Name)
+		buf = binary.AppendUvarint(buf, uint64(c.DataType))
+		buf = appendBool(buf, c.Key)
+		buf = appendBytes(buf, c.Value)
+	}
+	return buf
+}
+
+// decoder reads fields from a record payload. The first error sticks and
+// later reads return zero values.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil || len(d.b) == 0 {
+		d.err = errCorrupt
+		return 0
+	}
+	v := d.b[0]
+	d.b = d.b[1:]
+	return v
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.err = errCorrupt
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) time() time.Time {
+	if d.err != nil {
+		return time.Time{}
+	}
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.err = errCorrupt
+		return time.Time{}
+	}
+	d.b = d.b[n:]
+	if v == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, v)
+}
+
+func (d *decoder) raw(n uint64) []byte {
+	if d.err != nil || n > uint64(len(d.b)) {
+		d.err = errCorrupt
+		return nil
+	}
+	v := d.b[:n:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) string() string {
+	return string(d.raw(d.uvarint()))
+}
+
+func (d *decoder) bytes() []byte {
+	if d.byte() == 0 {
+		return nil
+	}
+	return append([]byte{}, d.raw(d.uvarint())...)
+}
+
+func (d *decoder) lsn() pglogrepl.LSN {
+	return pglogrepl.LSN(d.uvarint())
+}
+
+// count reads a length and checks it against the remaining payload, at
+// least one byte per element.
+func (d *decoder) count() int {
+	n := d.uvarint()
+	if n > uint64(len(d.b)) {
+		d.err = errCorrupt
+		return 0
+	}
+	return int(n)
+}
+
+// makeColumns reads a column count; zero columns decode as nil, as the
+// decoder produces them.
+func (d *decoder) makeColumns() []stream.Column {
+	n := d.count()
+	if n == 0 {
+		return nil
+	}
+	return make([]stream.Column, n)
+}
+
+func (d *decoder) columns() []stream.Column {
+	cols := d.makeColumns()
+	for i := range cols {
+		cols[i].Name = d.string()
+		cols[i].DataType = uint32(d.uvarint())
+		cols[i].Key = d.byte() == 1
+	}
+	return cols
+}
+
+func (d *decoder) tuple() *stream.TupleData {
+	if d.byte() == 0 {
+		return nil
+	}
+	td := &stream.TupleData{Columns: d.makeColumns()}
+	for i := range td.Columns {
+		c := &td.Columns[i]
+		c.Name = d.string()
+		c.DataType = uint32(d.uvarint())
+		c.Key = d.byte() == 1
+		c.Value = d.bytes()
+	}
+	return td
+}
+
+// decodeMessage decodes a record payload produced by encodeMessage.
+func decodeMessage(payload []byte) (stream.Message, error) {
+	d := &decoder{b: payload}
+	var msg stream.Message
+	switch kind := stream.MessageKind(d.byte()); kind {
+	case stream.KindBegin:
+		msg = &stream.BeginMessage{
+			TxnLSN:  d.lsn(),
+			TxnTime: d.time(),
+			XID:     uint32(d.uvarint()),
+			Origin:  d.string(),
+		}
+	case stream.KindCommit:
+		msg = &stream.CommitMessage{
+			CommitLSN: d.lsn(),
+			TxnTime:   d.time(),
+			Origin:    d.string(),
+		}
+	case stream.KindRelation:
+		msg = &stream.RelationMessage{
+			RelationID: uint32(d.uvarint()),
+			MsgLSN:     d.lsn(),
+			MsgTime:    d.time(),
+			Namespace:  d.string(),
+			Name:       d.string(),
+			Columns:    d.columns(),
+		}
+	case stream.KindChange:
+		msg = &stream.ChangeMessage{
+			Op:         stream.ChangeOp(d.byte()),
+			RelationID: uint32(d.uvarint()),
+			MsgLSN:     d.lsn(),
+			MsgTime:    d.time(),
+			Origin:     d.string(),
+			Namespace:  d.string(),
+			Table:      d.string(),
+			OldTuple:   d.tuple(),
+			NewTuple:   d.tuple(),
+		}
+	default:
+		if d.err == nil {
+			return nil, fmt.Errorf("%w: unknown message kind %d", errCorrupt, kind)
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if len(d.b) != 0 {
+		return nil, fmt.Errorf("%w: %d trailing bytes", errCorrupt, len(d.b))
+	}
+	return msg, nil
+}
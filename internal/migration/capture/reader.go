@@ -0,0 +1,171 @@
+package capture
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pglogrepl"
+
+	"github.com/jfoltran/pgmanager/internal/migration/stream"
+)
+
+const segmentExt = ".pgcap"
+
+// Segment is one capture file.
+type Segment struct {
+	Path string
+	Seq  int
+	// After is the last commit LSN recorded before this segment; every
+	// transaction in the segment commits after it.
+	After pglogrepl.LSN
+}
+
+func segmentName(seq int, after pglogrepl.LSN) string {
+	return fmt.Sprintf("%08d-%016X%s", seq, uint64(after), segmentExt)
+}
+
+func parseSegmentName(name string) (int, pglogrepl.LSN, bool) {
+	base, ok := strings.CutSuffix(name, segmentExt)
+	if !ok {
+		return 0, 0, false
+	}
+	seqPart, lsnPart, ok := strings.Cut(base, "-")
+	if !ok {
+		return 0, 0, false
+	}
+	seq, err := strconv.Atoi(seqPart)
+	if err != nil {
+		return 0, 0, false
+	}
+	after, err := strconv.ParseUint(lsnPart, 16, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return seq, pglogrepl.LSN(after), true
+}
+
+// Segments lists the capture segments in dir in recording order.
+func Segments(dir string) ([]Segment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read capture dir: %w", err)
+	}
+	var segs []Segment
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		seq, after, ok := parseSegmentName(e.Name())
+		if !ok {
+			continue
+		}
+		segs = append(segs, Segment{Path: filepath.Join(dir, e.Name()), Seq: seq, After: after})
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i].Seq < segs[j].Seq })
+	return segs, nil
+}
+
+// Reader reads messages back from a capture, across segments.
+type Reader struct {
+	segments []Segment
+	next     int
+
+	f       *os.File
+	rr      *RecordReader
+	current string
+	torn    []string
+}
+
+// Open opens a capture directory, or a single segment file. Segments
+// whose transactions all commit before from are skipped without being
+// read; pass 0 to read everything.
+func Open(path string, from pglogrepl.LSN) (*Reader, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("open capture: %w", err)
+	}
+	if !info.IsDir() {
+		return &Reader{segments: []Segment{{Path: path}}}, nil
+	}
+
+	segs, err := Segments(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(segs) == 0 {
+		return nil, fmt.Errorf("no capture segments in %s", path)
+	}
+	// Segment i only holds commits up to segs[i+1].After.
+	start := 0
+	for start+1 < len(segs) && segs[start+1].After < from {
+		start++
+	}
+	return &Reader{segments: segs[start:]}, nil
+}
+
+// Next returns the next recorded message, or io.EOF after the last one.
+// A segment that ends in a partial or corrupt record, as left by a crash,
+// is read up to that record; TornSegments lists such segments.
+func (r *Reader) Next() (stream.Message, error) {
+	for {
+		if r.rr == nil {
+			if r.next >= len(r.segments) {
+				return nil, io.EOF
+			}
+			if err := r.openNext(); err != nil {
+				return nil, err
+			}
+		}
+
+		msg, err := r.rr.Next()
+		if err == io.EOF {
+			r.closeCurrent()
+			continue
+		}
+		if err != nil {
+			r.torn = append(r.torn, r.current)
+			r.closeCurrent()
+			continue
+		}
+		return msg, nil
+	}
+}
+
+// TornSegments returns the segments that ended in a partial or corrupt
+// record so far.
+func (r *Reader) TornSegments() []string {
+	return r.torn
+}
+
+// Close releases the open segment.
+func (r *Reader) Close() error {
+	r.closeCurrent()
+	return nil
+}
+
+func (r *Reader) openNext() error {
+	seg := r.segments[r.next]
+	r.next++
+	f, err := os.Open(seg.Path)
+	if err != nil {
+		return fmt.Errorf("open capture segment: %w", err)
+	}
+	if err := CheckHeader(f); err != nil {
+		f.Close()
+		return fmt.Errorf("%s: %w", seg.Path, err)
+	}
+	r.f, r.rr, r.current = f, NewRecordReader(f, HeaderSize), seg.Path
+	return nil
+}
+
+func (r *Reader) closeCurrent() {
+	if r.f != nil {
+		r.f.Close()
+	}
+	r.f, r.rr, r.current = nil, nil, ""
+}
@@ -0,0 +1,156 @@
+package capture
+
+import (
+	"context"
+	"io"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog"
+
+	"github.com/jfoltran/pgmanager/internal/migration/replay"
+	"github.com/jfoltran/pgmanager/internal/migration/stream"
+)
+
+// ReplayOptions selects the window of transactions to replay by commit LSN.
+type ReplayOptions struct {
+	// FromLSN skips transactions that commit before it.
+	FromLSN pglogrepl.LSN
+	// ToLSN stops the replay after the last transaction that commits at or
+	// before it; 0 replays to the end of the capture.
+	ToLSN pglogrepl.LSN
+}
+
+// ReplayResult summarizes a replay.
+type ReplayResult struct {
+	// Transactions is the number of transactions sent to the destination.
+	Transactions int64 `json:"transactions"`
+	// Skipped counts transactions outside the window or already replayed,
+	// as recorded twice when the decoder reconnected.
+	Skipped int64 `json:"skipped"`
+	// Incomplete counts transactions without a commit, cut off by a
+	// decoder restart or a crash.
+	Incomplete int64 `json:"incomplete"`
+	// LastLSN is the commit LSN of the last transaction sent.
+	LastLSN pglogrepl.LSN `json:"last_lsn"`
+	// TornSegments lists segments that ended in a damaged record.
+	TornSegments []string `json:"torn_segments,omitempty"`
+}
+
+// Feed reads r and sends relations, plus every complete transaction whose
+// commit LSN falls in the window, to out. Transactions are buffered until
+// their commit so the window is decided on the commit LSN, which orders
+// transactions for every output plugin.
+func Feed(ctx context.Context, r *Reader, opts ReplayOptions, out chan<- stream.Message) (*ReplayResult, error) {
+	res := &ReplayResult{}
+	send := func(msg stream.Message) error {
+		select {
+		case out <- msg:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	var txn []stream.Message
+	var lastSeen pglogrepl.LSN
+	for {
+		msg, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return res, err
+		}
+
+		switch msg.Kind() {
+		case stream.KindRelation:
+			if err := send(msg); err != nil {
+				return res, err
+			}
+		case stream.KindBegin:
+			if txn != nil {
+				res.Incomplete++
+			}
+			txn = append(txn[:0], msg)
+		case stream.KindChange:
+			if txn != nil {
+				txn = append(txn, msg)
+			}
+		case stream.KindCommit:
+			if txn == nil {
+				continue
+			}
+			lsn := msg.LSN()
+			if opts.ToLSN != 0 && lsn > opts.ToLSN {
+				res.TornSegments = r.TornSegments()
+				return res, nil
+			}
+			if lsn < opts.FromLSN || lsn <= lastSeen {
+				res.Skipped++
+				txn = nil
+				continue
+			}
+			lastSeen = lsn
+			for _, m := range append(txn, msg) {
+				if err := send(m); err != nil {
+					return res, err
+				}
+			}
+			txn = nil
+			res.Transactions++
+			res.LastLSN = lsn
+		}
+	}
+	if txn != nil {
+		res.Incomplete++
+	}
+	res.TornSegments = r.TornSegments()
+	return res, nil
+}
+
+// Replay applies the transactions of a capture directory or segment file
+// to the destination pool within the LSN window.
+func Replay(ctx context.Context, path string, pool *pgxpool.Pool, opts ReplayOptions, logger zerolog.Logger) (*ReplayResult, error) {
+	logger = logger.With().Str("component", "capture-replay").Logger()
+	r, err := Open(path, opts.FromLSN)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ch := make(chan stream.Message, 256)
+	var res *ReplayResult
+	var feedErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer close(ch)
+		res, feedErr = Feed(ctx, r, opts, ch)
+	}()
+
+	applier := replay.NewApplier(pool, logger)
+	defer applier.Close()
+	applyErr := applier.Start(ctx, ch, nil, nil)
+	cancel()
+	<-done
+
+	if applyErr != nil {
+		return res, applyErr
+	}
+	if feedErr != nil {
+		return res, feedErr
+	}
+	for _, seg := range res.TornSegments {
+		logger.Warn().Str("segment", seg).Msg("capture segment ends in a damaged record")
+	}
+	logger.Info().
+		Int64("transactions", res.Transactions).
+		Int64("skipped", res.Skipped).
+		Stringer("last_lsn", res.LastLSN).
+		Msg("replay finished")
+	return res, nil
+}
@@ -0,0 +1,265 @@
+package capture
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/rs/zerolog"
+
+	"github.com/jfoltran/pgmanager/internal/migration/stream"
+)
+
+// DefaultSegmentBytes is the segment size at which the writer rotates.
+const DefaultSegmentBytes = 64 << 20
+
+// Options controls segment rotation and retention.
+type Options struct {
+	// SegmentBytes rotates to a new segment after the first commit that
+	// takes the current one past this size (default 64 MiB).
+	SegmentBytes int64
+	// MaxSegments deletes the oldest segments beyond this count; 0 keeps
+	// every segment.
+	MaxSegments int
+}
+
+// Writer records stream messages into numbered segment files in a
+// directory. Segments only rotate at commit boundaries, and each new
+// segment starts with the relations seen so far, so every segment can be
+// replayed on its own. Sentinels are not recorded.
+type Writer struct {
+	dir    string
+	opts   Options
+	logger zerolog.Logger
+
+	mu         sync.Mutex
+	f          *os.File
+	bw         *bufio.Writer
+	size       int64
+	seq        int
+	relations  map[uint32]*stream.RelationMessage
+	lastCommit pglogrepl.LSN
+	buf        []byte
+	rec        []byte
+}
+
+// NewWriter creates dir if needed and prepares a writer. Existing segments
+// are kept; recording continues in a new segment after them.
+func NewWriter(dir string, opts Options, logger zerolog.Logger) (*Writer, error) {
+	if opts.SegmentBytes <= 0 {
+		opts.SegmentBytes = DefaultSegmentBytes
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create capture dir: %w", err)
+	}
+	segs, err := Segments(dir)
+	if err != nil {
+		return nil, err
+	}
+	w := &Writer{
+		dir:       dir,
+		opts:      opts,
+		logger:    logger.With().Str("component", "capture").Logger(),
+		relations: make(map[uint32]*stream.RelationMessage),
+	}
+	if len(segs) > 0 {
+		last := segs[len(segs)-1]
+		w.seq = last.Seq
+		w.lastCommit, err = lastCommit(last)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// lastCommit returns the highest commit LSN recorded in seg, so the next
+// segment's name keeps the After ordering that Open relies on.
+func lastCommit(seg Segment) (pglogrepl.LSN, error) {
+	r := &Reader{segments: []Segment{seg}}
+	defer r.Close()
+	lsn := seg.After
+	for {
+		msg, err := r.Next()
+		if err == io.EOF {
+			return lsn, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if msg.Kind() == stream.KindCommit && msg.LSN() > lsn {
+			lsn = msg.LSN()
+		}
+	}
+}
+
+// Write records msg. A segment is opened lazily, so a writer that sees no
+// changes leaves no files behind.
+func (w *Writer) Write(msg stream.Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var ok bool
+	w.buf, ok = encodeMessage(w.buf[:0], msg)
+	if !ok {
+		return nil
+	}
+	if rel, isRel := msg.(*stream.RelationMessage); isRel {
+		w.relations[rel.RelationID] = rel
+	}
+
+	if w.f == nil {
+		if err := w.openSegment(); err != nil {
+			return err
+		}
+		if msg.Kind() == stream.KindRelation {
+			// openSegment already wrote it with the other relations.
+			return nil
+		}
+	}
+	if err := w.writeRecord(w.buf); err != nil {
+		return err
+	}
+
+	if msg.Kind() == stream.KindCommit {
+		if msg.LSN() > w.lastCommit {
+			w.lastCommit = msg.LSN()
+		}
+		if err := w.bw.Flush(); err != nil {
+			return fmt.Errorf("flush capture segment: %w", err)
+		}
+		if w.size >= w.opts.SegmentBytes {
+			return w.rotate()
+		}
+	}
+	return nil
+}
+
+// Close flushes and closes the current segment.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.closeSegment()
+}
+
+func (w *Writer) writeRecord(payload []byte) error {
+	w.rec = appendRecord(w.rec[:0], payload)
+	n, err := w.bw.Write(w.rec)
+	w.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("write capture record: %w", err)
+	}
+	return nil
+}
+
+// openSegment starts the next segment, named after the last commit LSN
+// recorded before it, and writes the known relations into it.
+func (w *Writer) openSegment() error {
+	w.seq++
+	path := filepath.Join(w.dir, segmentName(w.seq, w.lastCommit))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("create capture segment: %w", err)
+	}
+	w.f = f
+	w.bw = bufio.NewWriterSize(f, 256<<10)
+
+	if err := WriteHeader(w.bw); err != nil {
+		return fmt.Errorf("write capture header: %w", err)
+	}
+	w.size = HeaderSize
+
+	ids := make([]uint32, 0, len(w.relations))
+	for id := range w.relations {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	var payload []byte
+	for _, id := range ids {
+		payload, _ = encodeMessage(payload[:0], w.relations[id])
+		if err := w.writeRecord(payload); err != nil {
+			return err
+		}
+	}
+	w.logger.Debug().Str("segment", path).Int("relations", len(ids)).Msg("opened capture segment")
+	return nil
+}
+
+func (w *Writer) closeSegment() error {
+	if w.f == nil {
+		return nil
+	}
+	f := w.f
+	w.f = nil
+	if err := w.bw.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("flush capture segment: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("sync capture segment: %w", err)
+	}
+	return f.Close()
+}
+
+func (w *Writer) rotate() error {
+	if err := w.closeSegment(); err != nil {
+		return err
+	}
+	if w.opts.MaxSegments <= 0 {
+		return nil
+	}
+	segs, err := Segments(w.dir)
+	if err != nil {
+		return err
+	}
+	for len(segs) > w.opts.MaxSegments {
+		if err := os.Remove(segs[0].Path); err != nil {
+			return fmt.Errorf("remove old capture segment: %w", err)
+		}
+		w.logger.Debug().Str("segment", segs[0].Path).Msg("removed old capture segment")
+		segs = segs[1:]
+	}
+	return nil
+}
+
+// Tap returns a channel that carries everything from in while recording
+// it with w. A write error is logged and stops recording; the stream
+// itself is never interrupted by the capture.
+func Tap(ctx context.Context, in <-chan stream.Message, w *Writer) <-chan stream.Message {
+	out := make(chan stream.Message, cap(in))
+	go func() {
+		defer close(out)
+		recording := true
+		for {
+			var msg stream.Message
+			select {
+			case m, ok := <-in:
+				if !ok {
+					return
+				}
+				msg = m
+			case <-ctx.Done():
+				return
+			}
+			if recording {
+				if err := w.Write(msg); err != nil {
+					w.logger.Err(err).Msg("capture write failed, recording stopped")
+					recording = false
+				}
+			}
+			select {
+			case out <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
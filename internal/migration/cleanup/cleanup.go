@@ -0,0 +1,262 @@
+package cleanup
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog"
+)
+
+// Kind is the type of a replication artifact.
+type Kind string
+
+const (
+	KindSlot        Kind = "slot"
+	KindPublication Kind = "publication"
+	KindOrigin      Kind = "origin"
+)
+
+// Sides of a migration an artifact can live on.
+const (
+	SideSource        = "source"
+	SideSourceStandby = "source_standby"
+	SideDest          = "dest"
+)
+
+// Artifact is a replication object left on one side of a migration.
+type Artifact struct {
+	Side      string `json:"side"`
+	Kind      Kind   `json:"kind"`
+	Name      string `json:"name"`
+	Active    bool   `json:"active"`
+	ActivePID *int32 `json:"active_pid,omitempty"`
+	Dropped   bool   `json:"dropped,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Names lists the objects to look for on one database.
+type Names struct {
+	Slots        []string
+	Publications []string
+	Origins      []string
+}
+
+// Target is one database to clean up.
+type Target struct {
+	Side  string
+	Pool  *pgxpool.Pool
+	Names Names
+}
+
+// MigrationNames returns what a migration with the given slot and
+// publication may have created. The source holds the forward slot and
+// publication; a fallback switchover adds the _reverse pair on the
+// destination. Origins named after either slot are looked up on both sides.
+// Slot names are also checked with dashes replaced, as the decoder creates
+// them.
+func MigrationNames(slot, publication string) (source, dest Names) {
+	slots := variants(slot)
+	reverse := variants(slot + "_reverse")
+	origins := append(append([]string{}, slots...), reverse...)
+
+	source = Names{
+		Slots:        slots,
+		Publications: []string{publication},
+		Origins:      origins,
+	}
+	dest = Names{
+		Slots:        reverse,
+		Publications: []string{publication + "_reverse"},
+		Origins:      origins,
+	}
+	return source, dest
+}
+
+// FanoutNames returns what a migration with the given slot may have
+// created on a fan-out destination: the origin its applier records its
+// progress in.
+func FanoutNames(slot string) Names {
+	return Names{Origins: variants(slot)}
+}
+
+// FanoutSide is the side of the fan-out destination with the given name.
+func FanoutSide(name string) string {
+	return SideDest + ":" + name
+}
+
+func variants(name string) []string {
+	normalized := strings.ReplaceAll(name, "-", "_")
+	if normalized == name {
+		return []string{name}
+	}
+	return []string{name, normalized}
+}
+
+// Cleaner lists and drops replication artifacts on a set of databases.
+type Cleaner struct {
+	targets []Target
+	logger  zerolog.Logger
+}
+
+// NewCleaner creates a Cleaner for the given targets.
+func NewCleaner(targets []Target, logger zerolog.Logger) *Cleaner {
+	return &Cleaner{
+		targets: targets,
+		logger:  logger.With().Str("component", "cleanup").Logger(),
+	}
+}
+
+// List returns every artifact that still exists, source side first.
+func (c *Cleaner) List(ctx context.Context) ([]Artifact, error) {
+	artifacts := []Artifact{}
+	for _, t := range c.targets {
+		found, err := list(ctx, t)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", t.Side, err)
+		}
+		artifacts = append(artifacts, found...)
+	}
+	return artifacts, nil
+}
+
+func list(ctx context.Context, t Target) ([]Artifact, error) {
+	var out []Artifact
+
+	rows, err := t.Pool.Query(ctx, `
+		SELECT slot_name, active, active_pid FROM pg_replication_slots
+		WHERE slot_name = ANY($1) ORDER BY slot_name`, t.Names.Slots)
+	if err != nil {
+		return nil, fmt.Errorf("list slots: %w", err)
+	}
+	for rows.Next() {
+		a := Artifact{Side: t.Side, Kind: KindSlot}
+		if err := rows.Scan(&a.Name, &a.Active, &a.ActivePID); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan slot: %w", err)
+		}
+		out = append(out, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list slots: %w", err)
+	}
+
+	names, err := queryNames(ctx, t.Pool,
+		"SELECT pubname FROM pg_publication WHERE pubname = ANY($1) ORDER BY pubname", t.Names.Publications)
+	if err != nil {
+		return nil, fmt.Errorf("list publications: %w", err)
+	}
+	for _, n := range names {
+		out = append(out, Artifact{Side: t.Side, Kind: KindPublication, Name: n})
+	}
+
+	names, err = queryNames(ctx, t.Pool,
+		"SELECT roname FROM pg_replication_origin WHERE roname = ANY($1) ORDER BY roname", t.Names.Origins)
+	if err != nil {
+		return nil, fmt.Errorf("list replication origins: %w", err)
+	}
+	for _, n := range names {
+		out = append(out, Artifact{Side: t.Side, Kind: KindOrigin, Name: n})
+	}
+	return out, nil
+}
+
+func queryNames(ctx context.Context, pool *pgxpool.Pool, query string, arg []string) ([]string, error) {
+	rows, err := pool.Query(ctx, query, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var n string
+		if err := rows.Scan(&n); err != nil {
+			return nil, err
+		}
+		names = append(names, n)
+	}
+	return names, rows.Err()
+}
+
+// Drop drops every listed artifact. An active slot is skipped unless
+// terminate is set, in which case its walsender is terminated first. Drop
+// errors are recorded on the artifact and do not stop the others.
+func (c *Cleaner) Drop(ctx context.Context, terminate bool) ([]Artifact, error) {
+	artifacts, err := c.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pools := make(map[string]*pgxpool.Pool, len(c.targets))
+	for _, t := range c.targets {
+		pools[t.Side] = t.Pool
+	}
+
+	for i := range artifacts {
+		a := &artifacts[i]
+		if err := drop(ctx, pools[a.Side], *a, terminate); err != nil {
+			a.Error = err.Error()
+			c.logger.Warn().Err(err).Str("side", a.Side).Str("kind", string(a.Kind)).Str("name", a.Name).Msg("cleanup failed")
+			continue
+		}
+		a.Dropped = true
+		c.logger.Info().Str("side", a.Side).Str("kind", string(a.Kind)).Str("name", a.Name).Msg("dropped")
+	}
+	return artifacts, nil
+}
+
+func drop(ctx context.Context, pool *pgxpool.Pool, a Artifact, terminate bool) error {
+	switch a.Kind {
+	case KindSlot:
+		if a.Active {
+			if !terminate {
+				return fmt.Errorf("slot is active (pid %d)", derefPID(a.ActivePID))
+			}
+			if a.ActivePID != nil {
+				if _, err := pool.Exec(ctx, "SELECT pg_terminate_backend($1)", *a.ActivePID); err != nil {
+					return fmt.Errorf("terminate walsender: %w", err)
+				}
+			}
+		}
+		return dropSlot(ctx, pool, a.Name)
+	case KindPublication:
+		_, err := pool.Exec(ctx, "DROP PUBLICATION IF EXISTS "+quoteIdent(a.Name))
+		return err
+	case KindOrigin:
+		_, err := pool.Exec(ctx, "SELECT pg_replication_origin_drop($1)", a.Name)
+		return err
+	}
+	return fmt.Errorf("unknown artifact kind %q", a.Kind)
+}
+
+// dropSlot drops a slot, retrying briefly while a terminated walsender
+// releases it.
+func dropSlot(ctx context.Context, pool *pgxpool.Pool, name string) error {
+	var err error
+	for attempt := 0; attempt < 10; attempt++ {
+		_, err = pool.Exec(ctx,
+			"SELECT pg_drop_replication_slot(slot_name) FROM pg_replication_slots WHERE slot_name = $1", name)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+	return err
+}
+
+func derefPID(pid *int32) int32 {
+	if pid == nil {
+		return 0
+	}
+	return *pid
+}
+
+func quoteIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
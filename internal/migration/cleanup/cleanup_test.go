@@ -0,0 +1,53 @@
+package cleanup
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMigrationNames(t *testing.T) {
+	source, dest := MigrationNames("pgmanager_m1", "pgmanager_pub_m1")
+
+	if !reflect.DeepEqual(source.Slots, []string{"pgmanager_m1"}) {
+		t.Errorf("source slots = %v", source.Slots)
+	}
+	if !reflect.DeepEqual(source.Publications, []string{"pgmanager_pub_m1"}) {
+		t.Errorf("source publications = %v", source.Publications)
+	}
+	if !reflect.DeepEqual(dest.Slots, []string{"pgmanager_m1_reverse"}) {
+		t.Errorf("dest slots = %v", dest.Slots)
+	}
+	if !reflect.DeepEqual(dest.Publications, []string{"pgmanager_pub_m1_reverse"}) {
+		t.Errorf("dest publications = %v", dest.Publications)
+	}
+	wantOrigins := []string{"pgmanager_m1", "pgmanager_m1_reverse"}
+	if !reflect.DeepEqual(source.Origins, wantOrigins) || !reflect.DeepEqual(dest.Origins, wantOrigins) {
+		t.Errorf("origins = %v / %v, want %v", source.Origins, dest.Origins, wantOrigins)
+	}
+}
+
+func TestMigrationNames_DashedSlot(t *testing.T) {
+	source, dest := MigrationNames("mig-1", "pub")
+	if !reflect.DeepEqual(source.Slots, []string{"mig-1", "mig_1"}) {
+		t.Errorf("source slots = %v, want both spellings", source.Slots)
+	}
+	if !reflect.DeepEqual(dest.Slots, []string{"mig-1_reverse", "mig_1_reverse"}) {
+		t.Errorf("dest slots = %v, want both spellings", dest.Slots)
+	}
+}
+
+func TestFanoutNames(t *testing.T) {
+	names := FanoutNames("mig-1")
+	if len(names.Slots) != 0 || len(names.Publications) != 0 || !reflect.DeepEqual(names.Origins, []string{"mig-1", "mig_1"}) {
+		t.Errorf("fan-out names = %+v, want only the origin", names)
+	}
+	if side := FanoutSide("analytics"); side != "dest:analytics" {
+		t.Errorf("side = %s", side)
+	}
+}
+
+func TestQuoteIdent(t *testing.T) {
+	if got := quoteIdent(`pub"x`); got != `"pub""x"` {
+		t.Errorf("quoteIdent = %s", got)
+	}
+}
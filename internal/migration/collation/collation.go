@@ -0,0 +1,423 @@
+package collation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog"
+)
+
+// defaultCollationOID is the OID of the "default" collation, which resolves
+// to the database's datcollate (or ICU locale).
+const defaultCollationOID = 100
+
+// Locale is the encoding and default collation of a database.
+type Locale struct {
+	ServerVersion  int    `json:"server_version"`
+	ServerEncoding string `json:"server_encoding"`
+	Encoding       string `json:"encoding"`
+	Provider       string `json:"provider"` // libc, icu, builtin
+	Collate        string `json:"collate"`
+	Ctype          string `json:"ctype"`
+	ICULocale      string `json:"icu_locale,omitempty"`
+	// Version is the collation library version the database currently
+	// links against and RecordedVersion the one stored at creation; both
+	// are empty before PostgreSQL 15.
+	Version         string `json:"version,omitempty"`
+	RecordedVersion string `json:"recorded_version,omitempty"`
+}
+
+// Collation is a collation referenced by at least one index.
+type Collation struct {
+	Schema   string `json:"schema"`
+	Name     string `json:"name"`
+	Provider string `json:"provider"`
+	Locale   string `json:"locale"`
+	// Recorded is the version stored in the catalog when the collation
+	// (or database) was created; Actual is what the library reports now.
+	Recorded string `json:"recorded_version,omitempty"`
+	Actual   string `json:"actual_version,omitempty"`
+}
+
+// QualifiedName returns schema.name, or "default" for the database default.
+func (c Collation) QualifiedName() string {
+	if c.Schema == "" {
+		return c.Name
+	}
+	return c.Schema + "." + c.Name
+}
+
+// CollationDiff compares one collation between source and destination.
+type CollationDiff struct {
+	Name          string `json:"name"`
+	Provider      string `json:"provider"`
+	SourceVersion string `json:"source_version,omitempty"`
+	DestVersion   string `json:"dest_version,omitempty"`
+	// DestRecorded is the version recorded on the destination, which
+	// differs from DestVersion when the destination itself needs a REINDEX.
+	DestRecorded string `json:"dest_recorded_version,omitempty"`
+	Missing      bool   `json:"missing,omitempty"`
+	Changed      bool   `json:"changed"`
+	Reason       string `json:"reason,omitempty"`
+}
+
+// Index is an index that depends on a collation.
+type Index struct {
+	Schema     string   `json:"schema"`
+	Table      string   `json:"table"`
+	Name       string   `json:"name"`
+	Collations []string `json:"collations"`
+}
+
+// QualifiedName returns the quoted schema.index name.
+func (i Index) QualifiedName() string {
+	return quoteIdent(i.Schema) + "." + quoteIdent(i.Name)
+}
+
+// Report is the result of a compatibility analysis.
+type Report struct {
+	Source         Locale          `json:"source"`
+	Dest           Locale          `json:"dest"`
+	EncodingMatch  bool            `json:"encoding_match"`
+	LocaleMatch    bool            `json:"locale_match"`
+	Collations     []CollationDiff `json:"collations"`
+	ReindexNeeded  []Index         `json:"reindex_needed"`
+	IndexesChecked int             `json:"indexes_checked"`
+}
+
+// Analyzer compares encoding and collation support between two databases.
+type Analyzer struct {
+	source *pgxpool.Pool
+	dest   *pgxpool.Pool
+	logger zerolog.Logger
+}
+
+// NewAnalyzer creates an Analyzer.
+func NewAnalyzer(source, dest *pgxpool.Pool, logger zerolog.Logger) *Analyzer {
+	return &Analyzer{
+		source: source,
+		dest:   dest,
+		logger: logger.With().Str("component", "collation").Logger(),
+	}
+}
+
+// Analyze compares encoding, default locale and the collations used by
+// source indexes, and lists the indexes that need a REINDEX on the
+// destination after the copy.
+func (a *Analyzer) Analyze(ctx context.Context) (*Report, error) {
+	src, err := readLocale(ctx, a.source)
+	if err != nil {
+		return nil, fmt.Errorf("source locale: %w", err)
+	}
+	dst, err := readLocale(ctx, a.dest)
+	if err != nil {
+		return nil, fmt.Errorf("dest locale: %w", err)
+	}
+
+	indexes, err := indexCollations(ctx, a.source)
+	if err != nil {
+		return nil, fmt.Errorf("list index collations: %w", err)
+	}
+
+	used := make(map[string]bool)
+	for _, idx := range indexes {
+		for _, c := range idx.Collations {
+			used[c] = true
+		}
+	}
+	names := make([]string, 0, len(used))
+	for n := range used {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	srcColls, err := lookupCollations(ctx, a.source, names, src)
+	if err != nil {
+		return nil, fmt.Errorf("source collations: %w", err)
+	}
+	dstColls, err := lookupCollations(ctx, a.dest, names, dst)
+	if err != nil {
+		return nil, fmt.Errorf("dest collations: %w", err)
+	}
+
+	report := Compare(src, dst, names, srcColls, dstColls, indexes)
+	a.logger.Info().
+		Bool("encoding_match", report.EncodingMatch).
+		Bool("locale_match", report.LocaleMatch).
+		Int("collations", len(report.Collations)).
+		Int("reindex", len(report.ReindexNeeded)).
+		Msg("collation analysis complete")
+	return report, nil
+}
+
+// Reindex rebuilds the given indexes on the destination pool, then records
+// the current library version of the collations they use so the
+// destination stops reporting them as stale. A failed refresh is logged:
+// it needs ownership, and the indexes are rebuilt either way.
+func (a *Analyzer) Reindex(ctx context.Context, indexes []Index) error {
+	for _, idx := range indexes {
+		a.logger.Info().Str("index", idx.Schema+"."+idx.Name).Strs("collations", idx.Collations).Msg("reindexing")
+		if _, err := a.dest.Exec(ctx, "REINDEX INDEX "+idx.QualifiedName()); err != nil {
+			return fmt.Errorf("reindex %s.%s: %w", idx.Schema, idx.Name, err)
+		}
+	}
+	if len(indexes) == 0 {
+		return nil
+	}
+
+	var version int
+	var database string
+	if err := a.dest.QueryRow(ctx,
+		"SELECT current_setting('server_version_num')::int, current_database()",
+	).Scan(&version, &database); err != nil {
+		return fmt.Errorf("read destination version: %w", err)
+	}
+	for _, stmt := range refreshStatements(indexes, database, version) {
+		if _, err := a.dest.Exec(ctx, stmt); err != nil {
+			a.logger.Warn().Err(err).Str("statement", stmt).Msg("could not refresh collation version")
+		}
+	}
+	return nil
+}
+
+// refreshStatements returns the statements that record the current version
+// of each collation the indexes use. The database default can only be
+// refreshed from PostgreSQL 15.
+func refreshStatements(indexes []Index, database string, serverVersion int) []string {
+	used := make(map[string]bool)
+	for _, idx := range indexes {
+		for _, c := range idx.Collations {
+			used[c] = true
+		}
+	}
+	names := make([]string, 0, len(used))
+	for n := range used {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var out []string
+	for _, name := range names {
+		if name == "default" {
+			if serverVersion >= 150000 {
+				out = append(out, "ALTER DATABASE "+quoteIdent(database)+" REFRESH COLLATION VERSION")
+			}
+			continue
+		}
+		schema, coll, _ := strings.Cut(name, ".")
+		out = append(out, "ALTER COLLATION "+quoteIdent(schema)+"."+quoteIdent(coll)+" REFRESH VERSION")
+	}
+	return out
+}
+
+// Compare builds a report from already-collected catalog data. It is split
+// out of Analyze so the decision logic can be tested without a database.
+func Compare(src, dst Locale, names []string, srcColls, dstColls map[string]Collation, indexes []Index) *Report {
+	report := &Report{
+		Source:         src,
+		Dest:           dst,
+		EncodingMatch:  src.Encoding == dst.Encoding && src.ServerEncoding == dst.ServerEncoding,
+		LocaleMatch:    src.Provider == dst.Provider && src.Collate == dst.Collate && src.Ctype == dst.Ctype && src.ICULocale == dst.ICULocale,
+		IndexesChecked: len(indexes),
+		Collations:     []CollationDiff{},
+		ReindexNeeded:  []Index{},
+	}
+
+	changed := make(map[string]bool)
+	for _, name := range names {
+		s := srcColls[name]
+		d, ok := dstColls[name]
+		diff := CollationDiff{Name: name, Provider: s.Provider, SourceVersion: s.Actual}
+		switch {
+		case !ok:
+			diff.Missing = true
+			diff.Changed = true
+			diff.Reason = "collation does not exist on the destination"
+		default:
+			diff.DestVersion = d.Actual
+			diff.DestRecorded = d.Recorded
+			switch {
+			case s.Provider != d.Provider || s.Locale != d.Locale:
+				diff.Changed = true
+				diff.Reason = fmt.Sprintf("definition differs: source %s/%s, destination %s/%s", s.Provider, s.Locale, d.Provider, d.Locale)
+			case s.Actual != "" && d.Actual != "" && s.Actual != d.Actual:
+				diff.Changed = true
+				diff.Reason = fmt.Sprintf("library version differs: source %s, destination %s", s.Actual, d.Actual)
+			case d.Recorded != "" && d.Actual != "" && d.Recorded != d.Actual:
+				diff.Changed = true
+				diff.Reason = fmt.Sprintf("destination recorded version %s does not match its library version %s", d.Recorded, d.Actual)
+			}
+		}
+		if diff.Changed {
+			changed[name] = true
+		}
+		report.Collations = append(report.Collations, diff)
+	}
+
+	for _, idx := range indexes {
+		for _, c := range idx.Collations {
+			if changed[c] {
+				report.ReindexNeeded = append(report.ReindexNeeded, idx)
+				break
+			}
+		}
+	}
+	return report
+}
+
+func readLocale(ctx context.Context, pool *pgxpool.Pool) (Locale, error) {
+	var l Locale
+	err := pool.QueryRow(ctx, `
+		SELECT current_setting('server_version_num')::int, current_setting('server_encoding'),
+		       pg_encoding_to_char(encoding), datcollate, datctype
+		FROM pg_database WHERE datname = current_database()`,
+	).Scan(&l.ServerVersion, &l.ServerEncoding, &l.Encoding, &l.Collate, &l.Ctype)
+	if err != nil {
+		return l, err
+	}
+
+	// datlocprovider and pg_database_collation_actual_version are PG15+;
+	// daticulocale was renamed to datlocale in PG17. Reading the row through
+	// to_jsonb keeps one query working across versions.
+	var provider, iculocale, version, recorded *string
+	err = pool.QueryRow(ctx, `
+		SELECT to_jsonb(d)->>'datlocprovider',
+		       COALESCE(to_jsonb(d)->>'datlocale', to_jsonb(d)->>'daticulocale'),
+		       to_jsonb(d)->>'datcollversion',
+		       CASE WHEN current_setting('server_version_num')::int >= 150000
+		            THEN pg_database_collation_actual_version(d.oid) END
+		FROM pg_database d WHERE d.datname = current_database()`,
+	).Scan(&provider, &iculocale, &recorded, &version)
+	if err != nil {
+		return l, err
+	}
+	l.Provider = "libc"
+	if provider != nil {
+		l.Provider = providerName(*provider)
+	}
+	if iculocale != nil {
+		l.ICULocale = *iculocale
+	}
+	if version != nil {
+		l.Version = *version
+	}
+	if recorded != nil {
+		l.RecordedVersion = *recorded
+	}
+	return l, nil
+}
+
+// indexCollations lists source indexes together with the non-C collations
+// their key columns use. The "default" collation is reported as "default".
+func indexCollations(ctx context.Context, pool *pgxpool.Pool) ([]Index, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT n.nspname, t.relname, ic.relname,
+		       array_agg(DISTINCT CASE WHEN coll.oid = $1 THEN 'default'
+		                               ELSE cn.nspname || '.' || coll.collname END)
+		FROM pg_index i
+		JOIN pg_class ic ON ic.oid = i.indexrelid
+		JOIN pg_class t ON t.oid = i.indrelid
+		JOIN pg_namespace n ON n.oid = t.relnamespace
+		CROSS JOIN LATERAL unnest(i.indcollation::oid[]) AS u(colloid)
+		JOIN pg_collation coll ON coll.oid = u.colloid
+		JOIN pg_namespace cn ON cn.oid = coll.collnamespace
+		WHERE n.nspname NOT IN ('pg_catalog', 'information_schema')
+		  AND n.nspname !~ '^pg_toast'
+		  AND coll.collname NOT IN ('C', 'POSIX', 'ucs_basic')
+		GROUP BY n.nspname, t.relname, ic.relname
+		ORDER BY 1, 2, 3`, defaultCollationOID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Index
+	for rows.Next() {
+		var idx Index
+		if err := rows.Scan(&idx.Schema, &idx.Table, &idx.Name, &idx.Collations); err != nil {
+			return nil, err
+		}
+		out = append(out, idx)
+	}
+	return out, rows.Err()
+}
+
+// lookupCollations resolves collation names (as produced by
+// indexCollations) to their definition and versions on one side.
+func lookupCollations(ctx context.Context, pool *pgxpool.Pool, names []string, db Locale) (map[string]Collation, error) {
+	out := make(map[string]Collation, len(names))
+	for _, name := range names {
+		if name == "default" {
+			out[name] = Collation{
+				Name:     "default",
+				Provider: db.Provider,
+				Locale:   defaultLocale(db),
+				Recorded: db.RecordedVersion,
+				Actual:   db.Version,
+			}
+			continue
+		}
+
+		schema, coll, _ := strings.Cut(name, ".")
+		var c Collation
+		var provider string
+		var recorded, actual *string
+		err := pool.QueryRow(ctx, `
+			SELECT n.nspname, c.collname, c.collprovider::text,
+			       COALESCE(to_jsonb(c)->>'colllocale', to_jsonb(c)->>'colliculocale', c.collcollate, ''),
+			       c.collversion,
+			       pg_collation_actual_version(c.oid)
+			FROM pg_collation c
+			JOIN pg_namespace n ON n.oid = c.collnamespace
+			WHERE n.nspname = $1 AND c.collname = $2
+			  AND c.collencoding IN (-1, (SELECT encoding FROM pg_database WHERE datname = current_database()))
+			LIMIT 1`, schema, coll,
+		).Scan(&c.Schema, &c.Name, &provider, &c.Locale, &recorded, &actual)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				continue
+			}
+			return nil, fmt.Errorf("collation %s: %w", name, err)
+		}
+		c.Provider = providerName(provider)
+		if recorded != nil {
+			c.Recorded = *recorded
+		}
+		if actual != nil {
+			c.Actual = *actual
+		}
+		out[name] = c
+	}
+	return out, nil
+}
+
+func defaultLocale(db Locale) string {
+	if db.Provider != "libc" && db.ICULocale != "" {
+		return db.ICULocale
+	}
+	return db.Collate
+}
+
+func providerName(code string) string {
+	switch code {
+	case "c":
+		return "libc"
+	case "i":
+		return "icu"
+	case "b":
+		return "builtin"
+	case "d":
+		return "default"
+	default:
+		return code
+	}
+}
+
+func quoteIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
@@ -0,0 +1,112 @@
+package collation
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	src := Locale{ServerEncoding: "UTF8", Encoding: "UTF8", Provider: "libc", Collate: "en_US.UTF-8", Ctype: "en_US.UTF-8", Version: "2.31"}
+	indexes := []Index{
+		{Schema: "public", Table: "users", Name: "users_email_idx", Collations: []string{"default"}},
+		{Schema: "public", Table: "docs", Name: "docs_title_icu", Collations: []string{"public.de_icu"}},
+		{Schema: "public", Table: "docs", Name: "docs_slug_idx", Collations: []string{"pg_catalog.en-x-icu"}},
+	}
+	names := []string{"default", "pg_catalog.en-x-icu", "public.de_icu"}
+	srcColls := map[string]Collation{
+		"default":             {Name: "default", Provider: "libc", Locale: "en_US.UTF-8", Actual: "2.31"},
+		"pg_catalog.en-x-icu": {Schema: "pg_catalog", Name: "en-x-icu", Provider: "icu", Locale: "en", Actual: "153.14"},
+		"public.de_icu":       {Schema: "public", Name: "de_icu", Provider: "icu", Locale: "de", Actual: "153.14"},
+	}
+
+	t.Run("identical", func(t *testing.T) {
+		r := Compare(src, src, names, srcColls, srcColls, indexes)
+		if !r.EncodingMatch || !r.LocaleMatch {
+			t.Errorf("match = %v/%v", r.EncodingMatch, r.LocaleMatch)
+		}
+		if len(r.ReindexNeeded) != 0 {
+			t.Errorf("ReindexNeeded = %+v", r.ReindexNeeded)
+		}
+		if r.IndexesChecked != 3 {
+			t.Errorf("IndexesChecked = %d", r.IndexesChecked)
+		}
+	})
+
+	t.Run("glibc upgrade", func(t *testing.T) {
+		dst := src
+		dst.Version = "2.36"
+		dstColls := map[string]Collation{
+			"default":             {Name: "default", Provider: "libc", Locale: "en_US.UTF-8", Actual: "2.36"},
+			"pg_catalog.en-x-icu": srcColls["pg_catalog.en-x-icu"],
+			"public.de_icu":       srcColls["public.de_icu"],
+		}
+		r := Compare(src, dst, names, srcColls, dstColls, indexes)
+		if !r.LocaleMatch {
+			t.Error("locale names are identical, only the version changed")
+		}
+		if len(r.ReindexNeeded) != 1 || r.ReindexNeeded[0].Name != "users_email_idx" {
+			t.Errorf("ReindexNeeded = %+v", r.ReindexNeeded)
+		}
+	})
+
+	t.Run("missing and stale", func(t *testing.T) {
+		// de_icu does not exist on the destination, and en-x-icu was
+		// recorded under an older ICU than the one now linked.
+		dstColls := map[string]Collation{
+			"default":             srcColls["default"],
+			"pg_catalog.en-x-icu": {Schema: "pg_catalog", Name: "en-x-icu", Provider: "icu", Locale: "en", Recorded: "72.1", Actual: "153.14"},
+		}
+		r := Compare(src, src, names, srcColls, dstColls, indexes)
+		if len(r.ReindexNeeded) != 2 {
+			t.Fatalf("ReindexNeeded = %+v", r.ReindexNeeded)
+		}
+		var missing int
+		for _, c := range r.Collations {
+			if c.Missing {
+				missing++
+				if c.Name != "public.de_icu" {
+					t.Errorf("unexpected missing collation %s", c.Name)
+				}
+			}
+		}
+		if missing != 1 {
+			t.Errorf("missing = %d", missing)
+		}
+	})
+
+	t.Run("encoding differs", func(t *testing.T) {
+		dst := src
+		dst.Encoding = "LATIN1"
+		dst.ServerEncoding = "LATIN1"
+		if r := Compare(src, dst, nil, nil, nil, nil); r.EncodingMatch {
+			t.Error("EncodingMatch should be false")
+		}
+	})
+}
+
+func TestRefreshStatements(t *testing.T) {
+	indexes := []Index{
+		{Schema: "public", Name: "users_email_idx", Collations: []string{"default"}},
+		{Schema: "public", Name: "docs_slug_idx", Collations: []string{"pg_catalog.en-x-icu", "default"}},
+	}
+	got := refreshStatements(indexes, `app"db`, 160000)
+	want := []string{
+		`ALTER DATABASE "app""db" REFRESH COLLATION VERSION`,
+		`ALTER COLLATION "pg_catalog"."en-x-icu" REFRESH VERSION`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("PG16 statements = %q, want %q", got, want)
+	}
+	// The database default has no recorded version before PostgreSQL 15.
+	if got := refreshStatements(indexes, "app", 140000); len(got) != 1 || got[0] != want[1] {
+		t.Errorf("PG14 statements = %q", got)
+	}
+}
+
+func TestProviderName(t *testing.T) {
+	for code, want := range map[string]string{"c": "libc", "i": "icu", "b": "builtin", "d": "default", "x": "x"} {
+		if got := providerName(code); got != want {
+			t.Errorf("providerName(%q) = %q, want %q", code, got, want)
+		}
+	}
+}
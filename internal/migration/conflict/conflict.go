@@ -0,0 +1,174 @@
+package conflict
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog"
+)
+
+// DefaultAuditTable is where losing changes are recorded on each node.
+const DefaultAuditTable = "public.pgmanager_conflicts"
+
+// Kind classifies a conflict between a remote change and the local row.
+type Kind string
+
+const (
+	// KindInsertInsert is a remote INSERT for a key that already exists locally.
+	KindInsertInsert Kind = "insert_insert"
+	// KindUpdateUpdate is a remote UPDATE of a row changed more recently here.
+	KindUpdateUpdate Kind = "update_update"
+	// KindUpdateDelete is a remote UPDATE of a row already deleted here.
+	KindUpdateDelete Kind = "update_delete"
+	// KindDeleteUpdate is a remote DELETE of a row changed more recently
+	// here. Deletes win in both update/delete cases: no delete time is kept,
+	// so this is the only choice on which both nodes agree.
+	KindDeleteUpdate Kind = "delete_update"
+)
+
+// Winner names the side whose version of the row was kept.
+type Winner string
+
+const (
+	WinnerRemote Winner = "remote"
+	WinnerLocal  Winner = "local"
+)
+
+// Conflict is one resolved conflict, as written to the audit table.
+type Conflict struct {
+	Kind       Kind              `json:"kind"`
+	Schema     string            `json:"schema"`
+	Table      string            `json:"table"`
+	Key        map[string]string `json:"key"`
+	Winner     Winner            `json:"winner"`
+	RemoteTime time.Time         `json:"remote_commit_ts"`
+	LocalTime  *time.Time        `json:"local_commit_ts,omitempty"`
+	// Discarded is the losing version of the row: the local row when the
+	// remote side won, or the remote change when the local side won.
+	Discarded json.RawMessage `json:"discarded,omitempty"`
+}
+
+// Resolver decides conflicts by last-writer-wins on commit timestamps. The
+// remote commit time comes from the replicated transaction; the local one
+// from pg_xact_commit_timestamp, which needs track_commit_timestamp = on.
+// Equal timestamps are broken by node priority, higher wins.
+type Resolver struct {
+	localPriority  int
+	remotePriority int
+	auditTable     string
+	commitTS       bool
+	logger         zerolog.Logger
+}
+
+// NewResolver creates a Resolver for an applier writing to the local node.
+// The two priorities must differ, or both nodes would keep their own row on
+// a tie. auditTable is a schema.table name; empty uses DefaultAuditTable.
+func NewResolver(localPriority, remotePriority int, auditTable string, logger zerolog.Logger) *Resolver {
+	if auditTable == "" {
+		auditTable = DefaultAuditTable
+	}
+	return &Resolver{
+		localPriority:  localPriority,
+		remotePriority: remotePriority,
+		auditTable:     auditTable,
+		logger:         logger.With().Str("component", "conflict-resolver").Logger(),
+	}
+}
+
+// Prepare checks track_commit_timestamp on the local node and creates the
+// audit table. Without commit timestamps every remote change wins.
+func (r *Resolver) Prepare(ctx context.Context, pool *pgxpool.Pool) error {
+	var setting string
+	if err := pool.QueryRow(ctx, "SHOW track_commit_timestamp").Scan(&setting); err != nil {
+		return fmt.Errorf("check track_commit_timestamp: %w", err)
+	}
+	r.commitTS = setting == "on"
+	if !r.commitTS {
+		r.logger.Warn().Msg("track_commit_timestamp is off: local commit times are unknown, remote changes always win")
+	}
+
+	_, err := pool.Exec(ctx, fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			id               bigserial PRIMARY KEY,
+			detected_at      timestamptz NOT NULL DEFAULT now(),
+			kind             text NOT NULL,
+			schema_name      text NOT NULL,
+			table_name       text NOT NULL,
+			key              jsonb NOT NULL,
+			winner           text NOT NULL,
+			remote_commit_ts timestamptz NOT NULL,
+			local_commit_ts  timestamptz,
+			discarded        jsonb
+		)`, quoteQualified(r.auditTable)))
+	if err != nil {
+		return fmt.Errorf("create conflict audit table: %w", err)
+	}
+	return nil
+}
+
+// CommitTimestamps reports whether local commit timestamps are available.
+func (r *Resolver) CommitTimestamps() bool {
+	return r.commitTS
+}
+
+// RemoteWins reports whether a remote change committed at remote replaces
+// a local row committed at local. A nil local time (unknown, or written by
+// the current transaction) lets the remote change win.
+func (r *Resolver) RemoteWins(remote time.Time, local *time.Time) bool {
+	if local == nil {
+		return true
+	}
+	switch {
+	case remote.After(*local):
+		return true
+	case remote.Before(*local):
+		return false
+	default:
+		return r.remotePriority > r.localPriority
+	}
+}
+
+// Record writes a conflict to the audit table inside tx, so the record is
+// committed together with its resolution.
+func (r *Resolver) Record(ctx context.Context, tx pgx.Tx, c Conflict) error {
+	key, err := json.Marshal(c.Key)
+	if err != nil {
+		return fmt.Errorf("encode conflict key: %w", err)
+	}
+	var discarded any
+	if len(c.Discarded) > 0 {
+		discarded = string(c.Discarded)
+	}
+	_, err = tx.Exec(ctx, fmt.Sprintf(`
+		INSERT INTO %s (kind, schema_name, table_name, key, winner, remote_commit_ts, local_commit_ts, discarded)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`, quoteQualified(r.auditTable)),
+		string(c.Kind), c.Schema, c.Table, string(key), string(c.Winner), c.RemoteTime, c.LocalTime, discarded)
+	if err != nil {
+		return fmt.Errorf("record conflict: %w", err)
+	}
+
+	r.logger.Warn().
+		Str("kind", string(c.Kind)).
+		Str("table", c.Schema+"."+c.Table).
+		Interface("key", c.Key).
+		Str("winner", string(c.Winner)).
+		Msg("conflict resolved")
+	return nil
+}
+
+func quoteIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// quoteQualified quotes a schema.table name; a bare name is left unqualified.
+func quoteQualified(name string) string {
+	if schema, table, ok := strings.Cut(name, "."); ok {
+		return quoteIdent(schema) + "." + quoteIdent(table)
+	}
+	return quoteIdent(name)
+}
@@ -0,0 +1,56 @@
+package conflict
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestRemoteWins(t *testing.T) {
+	t0 := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	earlier, later := t0.Add(-time.Second), t0.Add(time.Second)
+
+	high := NewResolver(1, 2, "", zerolog.Nop()) // remote has higher priority
+	low := NewResolver(2, 1, "", zerolog.Nop())
+
+	tests := []struct {
+		name   string
+		r      *Resolver
+		remote time.Time
+		local  *time.Time
+		want   bool
+	}{
+		{"unknown local", low, t0, nil, true},
+		{"remote newer", low, later, &t0, true},
+		{"local newer", high, earlier, &t0, false},
+		{"tie, remote priority higher", high, t0, &t0, true},
+		{"tie, local priority higher", low, t0, &t0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.r.RemoteWins(tt.remote, tt.local); got != tt.want {
+				t.Errorf("RemoteWins = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemoteWins_TieIsSymmetric(t *testing.T) {
+	// Both nodes must pick the same winner on a tie.
+	t0 := time.Now()
+	onA := NewResolver(5, 3, "", zerolog.Nop()) // local A=5, remote B=3
+	onB := NewResolver(3, 5, "", zerolog.Nop()) // local B=3, remote A=5
+	if onA.RemoteWins(t0, &t0) || !onB.RemoteWins(t0, &t0) {
+		t.Error("node A's row should win the tie on both nodes")
+	}
+}
+
+func TestQuoteQualified(t *testing.T) {
+	if got := quoteQualified(DefaultAuditTable); got != `"public"."pgmanager_conflicts"` {
+		t.Errorf("got %s", got)
+	}
+	if got := quoteQualified("conflicts"); got != `"conflicts"` {
+		t.Errorf("got %s", got)
+	}
+}
@@ -0,0 +1,228 @@
+package failover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog"
+
+	"github.com/jfoltran/pgmanager/internal/cluster"
+	"github.com/jfoltran/pgmanager/internal/migration/slotguard"
+)
+
+// MinVersion is the first server_version_num with failover slots: logical
+// slots created with FAILOVER are synchronized to standbys running with
+// sync_replication_slots = on.
+const MinVersion = 170000
+
+// ErrNoPrimary means no registered node left recovery within the wait.
+var ErrNoPrimary = errors.New("no primary found among cluster nodes")
+
+// Standby is the slot synchronization setup of one standby node.
+type Standby struct {
+	NodeID               string `json:"node_id"`
+	Host                 string `json:"host"`
+	Reachable            bool   `json:"reachable"`
+	InRecovery           bool   `json:"in_recovery"`
+	SyncReplicationSlots bool   `json:"sync_replication_slots"`
+	HotStandbyFeedback   bool   `json:"hot_standby_feedback"`
+	PrimarySlotName      string `json:"primary_slot_name,omitempty"`
+	Error                string `json:"error,omitempty"`
+}
+
+// Readiness describes whether the source's slot survives a primary failover.
+type Readiness struct {
+	ServerVersion            int       `json:"server_version"`
+	Supported                bool      `json:"supported"`
+	SynchronizedStandbySlots string    `json:"synchronized_standby_slots,omitempty"`
+	Standbys                 []Standby `json:"standbys,omitempty"`
+	Warnings                 []string  `json:"warnings,omitempty"`
+}
+
+// ServerVersion returns server_version_num of the database behind pool.
+func ServerVersion(ctx context.Context, pool *pgxpool.Pool) (int, error) {
+	var version int
+	if err := pool.QueryRow(ctx, "SELECT current_setting('server_version_num')::int").Scan(&version); err != nil {
+		return 0, fmt.Errorf("query server version: %w", err)
+	}
+	return version, nil
+}
+
+// SlotFailover reports whether an existing slot was created with FAILOVER.
+// Servers before PG17 have no such column and report false.
+func SlotFailover(ctx context.Context, pool *pgxpool.Pool, slotName string) (bool, error) {
+	var enabled bool
+	err := pool.QueryRow(ctx, `
+		SELECT COALESCE((to_jsonb(s)->>'failover')::bool, false)
+		FROM pg_replication_slots s
+		WHERE s.slot_name = $1`, slotName).Scan(&enabled)
+	if err != nil {
+		return false, fmt.Errorf("query slot %q: %w", slotName, err)
+	}
+	return enabled, nil
+}
+
+// Check inspects the primary behind pool and every other node of the
+// source cluster. Unreachable standbys are reported, not returned as errors.
+func Check(ctx context.Context, pool *pgxpool.Pool, primaryID string, nodes []cluster.Node) (*Readiness, error) {
+	version, err := ServerVersion(ctx, pool)
+	if err != nil {
+		return nil, err
+	}
+	r := &Readiness{ServerVersion: version, Supported: version >= MinVersion}
+	if r.Supported {
+		if err := pool.QueryRow(ctx, "SELECT current_setting('synchronized_standby_slots', true)").Scan(&r.SynchronizedStandbySlots); err != nil {
+			return nil, fmt.Errorf("query synchronized_standby_slots: %w", err)
+		}
+		for _, n := range nodes {
+			if n.ID == primaryID {
+				continue
+			}
+			r.Standbys = append(r.Standbys, checkStandby(ctx, n))
+		}
+	}
+	r.Warnings = evaluate(r)
+	return r, nil
+}
+
+func checkStandby(ctx context.Context, n cluster.Node) Standby {
+	s := Standby{NodeID: n.ID, Host: n.Host}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	conn, err := pgx.Connect(ctx, n.DSN())
+	if err != nil {
+		s.Error = err.Error()
+		return s
+	}
+	defer conn.Close(ctx)
+	s.Reachable = true
+
+	var sync, feedback string
+	err = conn.QueryRow(ctx, `
+		SELECT pg_is_in_recovery(),
+		       COALESCE(current_setting('sync_replication_slots', true), 'off'),
+		       current_setting('hot_standby_feedback'),
+		       current_setting('primary_slot_name')`).Scan(&s.InRecovery, &sync, &feedback, &s.PrimarySlotName)
+	if err != nil {
+		s.Error = err.Error()
+		return s
+	}
+	s.SyncReplicationSlots = sync == "on"
+	s.HotStandbyFeedback = feedback == "on"
+	return s
+}
+
+// evaluate lists what prevents the slot from surviving a failover.
+func evaluate(r *Readiness) []string {
+	var warnings []string
+	if !r.Supported {
+		return append(warnings, fmt.Sprintf(
+			"source server version %d is older than PostgreSQL 17: the replication slot is not synchronized to standbys, so a primary failover loses it and requires a re-clone",
+			r.ServerVersion))
+	}
+	if r.SynchronizedStandbySlots == "" {
+		warnings = append(warnings, "synchronized_standby_slots is empty on the primary: changes may be decoded before a standby has them, and a failover can lose data already applied to the destination")
+	}
+
+	synced := 0
+	for _, s := range r.Standbys {
+		switch {
+		case !s.Reachable:
+			warnings = append(warnings, fmt.Sprintf("standby %s is unreachable: %s", s.NodeID, s.Error))
+		case !s.InRecovery:
+			warnings = append(warnings, fmt.Sprintf("node %s is not in recovery and cannot take over as a standby", s.NodeID))
+		case !s.SyncReplicationSlots:
+			warnings = append(warnings, fmt.Sprintf("standby %s has sync_replication_slots = off", s.NodeID))
+		case !s.HotStandbyFeedback || s.PrimarySlotName == "":
+			warnings = append(warnings, fmt.Sprintf("standby %s needs hot_standby_feedback = on and a primary_slot_name to synchronize slots", s.NodeID))
+		default:
+			synced++
+		}
+	}
+	if synced == 0 {
+		warnings = append(warnings, "no registered standby synchronizes replication slots: a primary failover is not survivable")
+	}
+	return warnings
+}
+
+// FindPrimary polls nodes until one reports pg_is_in_recovery() = false or
+// wait elapses. Unreachable nodes are skipped; a promotion usually leaves
+// the old primary down and the new one briefly still in recovery.
+func FindPrimary(ctx context.Context, nodes []cluster.Node, wait time.Duration, logger zerolog.Logger) (*cluster.Node, error) {
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		for i := range nodes {
+			primary, err := isPrimary(ctx, nodes[i])
+			if err != nil {
+				logger.Debug().Err(err).Str("node", nodes[i].ID).Msg("node not reachable while looking for primary")
+				continue
+			}
+			if primary {
+				return &nodes[i], nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w within %s", ErrNoPrimary, wait)
+		case <-ticker.C:
+		}
+	}
+}
+
+func isPrimary(ctx context.Context, n cluster.Node) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	conn, err := pgx.Connect(ctx, n.DSN())
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close(ctx)
+
+	var inRecovery bool
+	if err := conn.QueryRow(ctx, "SELECT pg_is_in_recovery()").Scan(&inRecovery); err != nil {
+		return false, err
+	}
+	return !inRecovery, nil
+}
+
+// SlotPosition returns the confirmed_flush_lsn of slotName on the node
+// behind dsn. A missing or invalidated slot wraps slotguard.ErrSlotLost:
+// it was not synchronized before the failover and the WAL is gone.
+func SlotPosition(ctx context.Context, dsn, slotName string) (pglogrepl.LSN, error) {
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		return 0, fmt.Errorf("connect to new primary: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	var confirmed *string
+	var walStatus string
+	err = conn.QueryRow(ctx, `
+		SELECT confirmed_flush_lsn::text, COALESCE(to_jsonb(s)->>'wal_status', '')
+		FROM pg_replication_slots s
+		WHERE s.slot_name = $1`, slotName).Scan(&confirmed, &walStatus)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, fmt.Errorf("%w: slot %q does not exist on the new primary", slotguard.ErrSlotLost, slotName)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("query slot %q on new primary: %w", slotName, err)
+	}
+	if walStatus == "lost" || confirmed == nil {
+		return 0, fmt.Errorf("%w: slot %q on the new primary is invalidated", slotguard.ErrSlotLost, slotName)
+	}
+	lsn, err := pglogrepl.ParseLSN(*confirmed)
+	if err != nil {
+		return 0, fmt.Errorf("parse confirmed_flush_lsn: %w", err)
+	}
+	return lsn, nil
+}
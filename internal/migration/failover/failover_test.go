@@ -0,0 +1,55 @@
+package failover
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEvaluate_OldVersion(t *testing.T) {
+	w := evaluate(&Readiness{ServerVersion: 160004})
+	if len(w) != 1 || !strings.Contains(w[0], "re-clone") {
+		t.Fatalf("warnings = %v, want one re-clone warning", w)
+	}
+}
+
+func TestEvaluate_Ready(t *testing.T) {
+	r := &Readiness{
+		ServerVersion:            170002,
+		Supported:                true,
+		SynchronizedStandbySlots: "standby1_slot",
+		Standbys: []Standby{{
+			NodeID: "standby1", Reachable: true, InRecovery: true,
+			SyncReplicationSlots: true, HotStandbyFeedback: true, PrimarySlotName: "standby1_slot",
+		}},
+	}
+	if w := evaluate(r); len(w) != 0 {
+		t.Errorf("warnings = %v, want none", w)
+	}
+}
+
+func TestEvaluate_Misconfigured(t *testing.T) {
+	r := &Readiness{
+		ServerVersion: 170002,
+		Supported:     true,
+		Standbys: []Standby{
+			{NodeID: "a", Error: "connection refused"},
+			{NodeID: "b", Reachable: true, InRecovery: true, HotStandbyFeedback: true, PrimarySlotName: "b"},
+			{NodeID: "c", Reachable: true, InRecovery: true, SyncReplicationSlots: true},
+			{NodeID: "d", Reachable: true},
+		},
+	}
+	w := evaluate(r)
+	joined := strings.Join(w, "\n")
+	for _, want := range []string{
+		"synchronized_standby_slots is empty",
+		"standby a is unreachable",
+		"standby b has sync_replication_slots = off",
+		"standby c needs hot_standby_feedback",
+		"node d is not in recovery",
+		"no registered standby synchronizes",
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("missing warning %q in:\n%s", want, joined)
+		}
+	}
+}
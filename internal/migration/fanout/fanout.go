@@ -0,0 +1,654 @@
+// Package fanout applies one decoded change stream to several destinations,
+// each through its own applier, so a single slot and decoder replicate the
+// source into all of them.
+package fanout
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/rs/zerolog"
+
+	"github.com/jfoltran/pgmanager/internal/migration/replay"
+	"github.com/jfoltran/pgmanager/internal/migration/shard"
+	"github.com/jfoltran/pgmanager/internal/migration/spool"
+	"github.com/jfoltran/pgmanager/internal/migration/stream"
+)
+
+// Policy decides what a destination that cannot keep up does to the others.
+type Policy string
+
+const (
+	// PolicyBlock feeds every destination from memory. The slowest one
+	// paces decoding, and an apply error stops the stream.
+	PolicyBlock Policy = "block"
+	// PolicySpool gives every destination its own spool. Destinations that
+	// keep up apply from memory; one that falls behind moves to its spool
+	// at the next transaction, so decoding no longer waits for it, and
+	// returns to memory once caught up. A stuck one only grows its spool,
+	// retrying with backoff, while the others and the slot move on, until
+	// maxApplyRetries attempts in a row fail.
+	PolicySpool Policy = "spool"
+)
+
+const (
+	// laneQueue is the number of messages queued per destination in
+	// memory. They are released from the memory budget once queued to
+	// every destination, so this also bounds the memory outside it. Under
+	// PolicySpool a destination whose queue is half full at the start of
+	// a transaction moves to its spool, leaving room for the transaction.
+	laneQueue = 64
+
+	// stallWarnInterval is how often a destination that blocks decoding
+	// is logged.
+	stallWarnInterval = 30 * time.Second
+
+	initialRetryDelay = 2 * time.Second
+	maxRetryDelay     = 30 * time.Second
+	// maxApplyRetries is how many times in a row a spooled destination
+	// may fail without progress before the fanout fails.
+	maxApplyRetries = 20
+)
+
+// Applier is the per-destination consumer, such as replay.Applier.
+type Applier interface {
+	Start(ctx context.Context, messages <-chan stream.Message, onApplied replay.OnApplied, onSentinel replay.OnSentinel) error
+	Close()
+}
+
+// Target is one destination of a Fanout.
+type Target struct {
+	Name    string
+	Applier Applier
+	// Spool is the destination's spool, required under PolicySpool.
+	Spool *spool.Spool
+	// Progress, if set, returns the last commit the destination recorded
+	// as applied. The spool skips up to it before each apply attempt.
+	Progress func(ctx context.Context) (pglogrepl.LSN, error)
+}
+
+// Status is the progress of one destination.
+type Status struct {
+	Name         string
+	AppliedLSN   pglogrepl.LSN
+	Transactions int64
+	// Changes is the number of row changes sent to the destination.
+	Changes int64
+	// Queued is the number of messages waiting in memory.
+	Queued int
+	// Spooling is set while the destination applies from its spool
+	// (PolicySpool).
+	Spooling bool
+	// Spool is the destination's backlog (PolicySpool).
+	Spool     *spool.Depth
+	LastError string
+}
+
+type lane struct {
+	name     string
+	applier  Applier
+	spool    *spool.Spool
+	progress func(ctx context.Context) (pglogrepl.LSN, error)
+	changes  atomic.Int64
+
+	// Under PolicySpool, phases hands the lane what to apply next: a
+	// memory queue, or its spool until drain is closed. Sent to by the
+	// dispatcher only.
+	phases chan phase
+	drain  chan struct{}
+
+	// Guarded by Fanout.mu; queue and spooling are written by the
+	// dispatcher only.
+	queue   chan stream.Message
+	applied pglogrepl.LSN
+	txns    int64
+	lastErr error
+	// spooling is set while new transactions go to the spool. backlog is
+	// set while messages queued before the switch are not all applied.
+	spooling bool
+	backlog  bool
+	// spooled is the last commit the spool holds, and skipped is set once
+	// the spool dropped what the destination had already applied, up to
+	// floor.
+	spooled pglogrepl.LSN
+	skipped bool
+	floor   pglogrepl.LSN
+}
+
+// phase is a stretch of a lane's input: queue, or the spool when nil.
+type phase struct {
+	queue chan stream.Message
+	drain chan struct{}
+}
+
+// Fanout replicates a message stream to several destinations. It has the
+// same Start contract as replay.Applier, so the pipeline drives it like a
+// single applier. The LSN passed to onApplied is the one every destination
+// has applied, or under PolicySpool durably spooled, so the slot is never
+// confirmed past the slowest destination. LastLSN is the one every
+// destination has applied.
+type Fanout struct {
+	policy Policy
+	logger zerolog.Logger
+	lanes  []*lane
+	budget *stream.Budget
+	router *shard.Router
+
+	mu         sync.Mutex
+	confirmed  pglogrepl.LSN
+	dispatched pglogrepl.LSN
+	sentinels  map[string]int
+	onApplied  replay.OnApplied
+	onSentinel replay.OnSentinel
+}
+
+// New creates a Fanout over targets. Under PolicySpool every target needs
+// a spool.
+func New(targets []Target, policy Policy, logger zerolog.Logger) (*Fanout, error) {
+	if policy == "" {
+		policy = PolicyBlock
+	}
+	if policy != PolicyBlock && policy != PolicySpool {
+		return nil, fmt.Errorf("unknown fan-out policy %q", policy)
+	}
+	f := &Fanout{
+		policy:    policy,
+		logger:    logger.With().Str("component", "fanout").Logger(),
+		sentinels: make(map[string]int),
+	}
+	for _, t := range targets {
+		if policy == PolicySpool && t.Spool == nil {
+			return nil, fmt.Errorf("destination %s has no spool", t.Name)
+		}
+		l := &lane{name: t.Name, applier: t.Applier}
+		if policy == PolicySpool {
+			l.spool, l.progress = t.Spool, t.Progress
+		}
+		f.lanes = append(f.lanes, l)
+	}
+	return f, nil
+}
+
+// SetBudget makes the fanout release each message's size to b once every
+// destination has it. Call before Start.
+func (f *Fanout) SetBudget(b *stream.Budget) {
+	f.budget = b
+}
+
+// SetRouter makes the fanout send each row change only to the shards r
+// picks, the lanes being the shards in order. Relations, transaction
+// boundaries and sentinels still go to every destination, so each one
+// confirms every transaction. Call before Start.
+func (f *Fanout) SetRouter(r *shard.Router) {
+	f.router = r
+}
+
+// Start dispatches messages to every destination until the channel closes
+// or ctx ends. It returns once each destination has applied what it was
+// given from memory, or with the first apply error. Under PolicySpool
+// every destination first catches up from its spool, and whatever is
+// spooled but not yet applied is applied by the next Start; an error while
+// applying from memory loses what the destination had taken from its
+// queue, so the stream must be restarted from the confirmed LSN.
+func (f *Fanout) Start(ctx context.Context, messages <-chan stream.Message, onApplied replay.OnApplied, onSentinel replay.OnSentinel) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// Spooled destinations stop reading when the input ends; live ones
+	// drain their queue first.
+	spoolCtx, stopSpooled := context.WithCancel(ctx)
+	defer stopSpooled()
+
+	f.mu.Lock()
+	f.onApplied, f.onSentinel = onApplied, onSentinel
+	for _, l := range f.lanes {
+		if l.spool == nil {
+			l.queue = make(chan stream.Message, laneQueue)
+			continue
+		}
+		l.queue = nil
+		l.spooling, l.backlog, l.skipped = true, false, false
+		l.spooled = l.spool.LastCommit()
+		l.drain = make(chan struct{})
+		l.phases = make(chan phase, 1)
+		l.phases <- phase{drain: l.drain}
+	}
+	f.mu.Unlock()
+
+	var wg sync.WaitGroup
+	var failOnce sync.Once
+	var laneErr error
+	for _, l := range f.lanes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var err error
+			if l.spool != nil {
+				err = f.runPhases(ctx, spoolCtx, l)
+			} else {
+				err = l.applier.Start(ctx, l.queue, func(lsn pglogrepl.LSN) { f.applied(l, lsn) }, f.sentinel)
+			}
+			if err != nil {
+				f.setErr(l, err)
+				failOnce.Do(func() {
+					laneErr = fmt.Errorf("destination %s: %w", l.name, err)
+					cancel()
+				})
+			}
+		}()
+	}
+
+	err := f.dispatch(ctx, messages)
+	for _, l := range f.lanes {
+		if l.queue != nil {
+			close(l.queue)
+		}
+		if l.phases != nil {
+			close(l.phases)
+		}
+	}
+	stopSpooled()
+	wg.Wait()
+	if laneErr != nil {
+		return laneErr
+	}
+	return err
+}
+
+// Restartable reports whether an error from Start leaves every destination
+// able to resume from a restarted stream, as under PolicySpool.
+func (f *Fanout) Restartable() bool {
+	return f.policy == PolicySpool
+}
+
+// dispatch hands every message to each destination, in order.
+func (f *Fanout) dispatch(ctx context.Context, messages <-chan stream.Message) error {
+	for {
+		var msg stream.Message
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case m, ok := <-messages:
+			if !ok {
+				return nil
+			}
+			msg = m
+		}
+
+		if err := f.deliver(ctx, msg); err != nil {
+			return err
+		}
+		f.budget.Release(stream.MessageSize(msg))
+	}
+}
+
+// send queues msg for l, logging while l keeps decoding waiting.
+func (f *Fanout) send(ctx context.Context, l *lane, msg stream.Message) error {
+	select {
+	case l.queue <- msg:
+		return nil
+	default:
+	}
+	t := time.NewTimer(stallWarnInterval)
+	defer t.Stop()
+	for {
+		select {
+		case l.queue <- msg:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+			f.mu.Lock()
+			applied := l.applied
+			f.mu.Unlock()
+			f.logger.Warn().Str("destination", l.name).Stringer("applied_lsn", applied).
+				Msg("destination is not keeping up, decoding waits for it")
+			t.Reset(stallWarnInterval)
+		}
+	}
+}
+
+// deliver hands msg to every destination, or a routed change to its
+// shards only. Under PolicySpool, a commit may move the confirmable LSN of
+// the destinations applying from their spool.
+func (f *Fanout) deliver(ctx context.Context, msg stream.Message) error {
+	if c, ok := msg.(*stream.ChangeMessage); ok && f.router != nil {
+		routed, err := f.router.Route(c)
+		if err != nil {
+			return fmt.Errorf("route change: %w", err)
+		}
+		for _, r := range routed {
+			if err := f.put(ctx, f.lanes[r.Shard], r.Change); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, l := range f.lanes {
+		if err := f.put(ctx, l, msg); err != nil {
+			return err
+		}
+	}
+
+	c, ok := msg.(*stream.CommitMessage)
+	if !ok || f.policy != PolicySpool {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.dispatched = c.CommitLSN
+	for _, l := range f.lanes {
+		if l.spooling {
+			// A spool that already held the commit, as after a restart,
+			// dropped it instead of spooling it again.
+			l.spooled = l.spool.LastCommit()
+		}
+	}
+	f.advance()
+	return nil
+}
+
+// put queues msg for l, or appends it to l's spool while l is spooling.
+// Under PolicySpool a transaction start may first switch l.
+func (f *Fanout) put(ctx context.Context, l *lane, msg stream.Message) error {
+	if _, ok := msg.(*stream.ChangeMessage); ok {
+		l.changes.Add(1)
+	}
+	if l.spool == nil {
+		return f.send(ctx, l, msg)
+	}
+	switch msg.(type) {
+	case *stream.BeginMessage:
+		if err := f.switchLane(ctx, l); err != nil {
+			return err
+		}
+	case *stream.RelationMessage:
+		// The spool knows every relation, in case l moves to it later.
+		if !l.spooling {
+			if _, _, err := l.spool.Append(ctx, msg); err != nil {
+				return fmt.Errorf("spool for %s: %w", l.name, err)
+			}
+		}
+	}
+	if !l.spooling {
+		return f.send(ctx, l, msg)
+	}
+	if _, _, err := l.spool.Append(ctx, msg); err != nil {
+		return fmt.Errorf("spool for %s: %w", l.name, err)
+	}
+	return nil
+}
+
+// switchLane moves l to its spool when its queue is half full, or when it is
+// still failing to finish its spool and has not taken up its queue, and
+// back to memory once it has applied its spool without error and the
+// stream has passed what it had applied before.
+func (f *Fanout) switchLane(ctx context.Context, l *lane) error {
+	if !l.spooling {
+		return f.toSpool(ctx, l)
+	}
+	if len(l.phases) > 0 {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !l.skipped || l.lastErr != nil || f.dispatched < l.floor || l.spool.Pending() {
+		return nil
+	}
+	close(l.drain)
+	l.queue = make(chan stream.Message, laneQueue)
+	l.spooling = false
+	l.phases <- phase{queue: l.queue}
+	f.logger.Info().Str("destination", l.name).Stringer("applied_lsn", l.applied).
+		Msg("destination caught up, applying from memory")
+	return nil
+}
+
+// toSpool moves l from memory to its spool if it lags. A queue l has not
+// taken up yet is moved into the spool; otherwise l first applies it.
+func (f *Fanout) toSpool(ctx context.Context, l *lane) error {
+	f.mu.Lock()
+	failing := l.lastErr != nil
+	f.mu.Unlock()
+	if len(l.queue) < cap(l.queue)/2 && !(failing && len(l.phases) > 0) {
+		return nil
+	}
+
+	queue := l.queue
+	taken := true
+	select {
+	case <-l.phases:
+		taken = false
+	default:
+	}
+	f.mu.Lock()
+	close(queue)
+	l.queue = nil
+	l.spooling, l.backlog = true, taken
+	l.drain = make(chan struct{})
+	f.mu.Unlock()
+
+	if !taken {
+		for msg := range queue {
+			if _, _, err := l.spool.Append(ctx, msg); err != nil {
+				return fmt.Errorf("spool for %s: %w", l.name, err)
+			}
+		}
+	}
+	f.mu.Lock()
+	l.spooled = l.spool.LastCommit()
+	f.advance()
+	f.mu.Unlock()
+	l.phases <- phase{drain: l.drain}
+	f.logger.Info().Str("destination", l.name).Msg("destination is not keeping up, spooling its transactions")
+	return nil
+}
+
+// runPhases applies l's phases in order until they end. An error while
+// applying from memory is returned; spool errors are retried.
+func (f *Fanout) runPhases(ctx, spoolCtx context.Context, l *lane) error {
+	for ph := range l.phases {
+		if ph.queue == nil {
+			if err := f.applySpooled(spoolCtx, l, ph.drain); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := l.applier.Start(ctx, ph.queue, func(lsn pglogrepl.LSN) { f.applied(l, lsn) }, f.sentinel); err != nil {
+			return err
+		}
+		f.mu.Lock()
+		l.backlog = false
+		f.advance()
+		f.mu.Unlock()
+	}
+	return nil
+}
+
+// applySpooled applies l's spool until ctx ends or, once drain is closed,
+// the spool is applied. Each attempt first skips what the destination
+// recorded as applied. Apply errors are retried with backoff from there,
+// up to maxApplyRetries times in a row without progress.
+func (f *Fanout) applySpooled(ctx context.Context, l *lane, drain chan struct{}) error {
+	delay := initialRetryDelay
+	retries := 0
+	for {
+		err := f.skipApplied(ctx, l)
+		if err == nil {
+			actx, acancel := context.WithCancel(ctx)
+			err = l.applier.Start(actx, l.spool.MessagesUntil(actx, drain), func(lsn pglogrepl.LSN) {
+				if err := l.spool.Ack(lsn); err != nil {
+					f.logger.Err(err).Str("destination", l.name).Msg("spool ack failed")
+				}
+				f.applied(l, lsn)
+				delay = initialRetryDelay
+				retries = 0
+			}, f.sentinel)
+			acancel()
+		}
+		// The spool channel only ends with ctx, once drained or once the
+		// spool is closed.
+		if err == nil || ctx.Err() != nil {
+			return nil
+		}
+
+		f.setErr(l, err)
+		if retries++; retries > maxApplyRetries {
+			return fmt.Errorf("gave up after %d retries: %w", maxApplyRetries, err)
+		}
+		f.logger.Warn().Err(err).Str("destination", l.name).Dur("delay", delay).Int("retry", retries).Msg("apply from spool failed, retrying")
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxRetryDelay)
+	}
+}
+
+// skipApplied moves l's spool past what the destination recorded as
+// applied, and makes it drop those transactions if they are sent again.
+func (f *Fanout) skipApplied(ctx context.Context, l *lane) error {
+	var lsn pglogrepl.LSN
+	if l.progress != nil {
+		var err error
+		if lsn, err = l.progress(ctx); err != nil {
+			return err
+		}
+	}
+	l.spool.Advance(lsn)
+	n, err := l.spool.Skip(lsn)
+	if err != nil {
+		return fmt.Errorf("skip applied transactions: %w", err)
+	}
+	if n > 0 {
+		f.logger.Info().Str("destination", l.name).Int("transactions", n).Stringer("lsn", lsn).
+			Msg("skipped spooled transactions already applied")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	l.skipped = true
+	l.floor = max(l.floor, lsn)
+	return nil
+}
+
+func (f *Fanout) applied(l *lane, lsn pglogrepl.LSN) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	l.applied = lsn
+	l.txns++
+	l.lastErr = nil
+	f.advance()
+}
+
+// advance reports the confirmable LSN when it moves: the lowest position
+// of any destination. The callback runs with mu held, so LSNs are
+// reported in order. Called with mu held.
+func (f *Fanout) advance() {
+	var lsn pglogrepl.LSN
+	for i, l := range f.lanes {
+		if p := l.position(); i == 0 || p < lsn {
+			lsn = p
+		}
+	}
+	if lsn <= f.confirmed {
+		return
+	}
+	f.confirmed = lsn
+	if f.onApplied != nil {
+		f.onApplied(lsn)
+	}
+}
+
+// position is how far the slot may be confirmed for l: what it applied,
+// or, once what it had in memory is applied, what its spool holds. Called
+// with mu held.
+func (l *lane) position() pglogrepl.LSN {
+	if l.spooling && !l.backlog {
+		return max(l.applied, l.spooled)
+	}
+	return l.applied
+}
+
+// minApplied is the lowest applied LSN. Called with mu held.
+func (f *Fanout) minApplied() pglogrepl.LSN {
+	var lsn pglogrepl.LSN
+	for i, l := range f.lanes {
+		if i == 0 || l.applied < lsn {
+			lsn = l.applied
+		}
+	}
+	return lsn
+}
+
+// sentinel confirms a sentinel once every destination has reached it.
+func (f *Fanout) sentinel(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sentinels[id]++
+	if f.sentinels[id] < len(f.lanes) {
+		return
+	}
+	delete(f.sentinels, id)
+	if f.onSentinel != nil {
+		f.onSentinel(id)
+	}
+}
+
+func (f *Fanout) setErr(l *lane, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	l.lastErr = err
+}
+
+// LastLSN returns the lowest LSN applied by every destination.
+func (f *Fanout) LastLSN() pglogrepl.LSN {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.minApplied()
+}
+
+// Pending reports whether a destination's spool holds transactions not
+// yet applied.
+func (f *Fanout) Pending() bool {
+	for _, l := range f.lanes {
+		if l.spool != nil && l.spool.Pending() {
+			return true
+		}
+	}
+	return false
+}
+
+// Status returns the progress of each destination.
+func (f *Fanout) Status() []Status {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make([]Status, len(f.lanes))
+	for i, l := range f.lanes {
+		s := Status{Name: l.name, AppliedLSN: l.applied, Transactions: l.txns, Changes: l.changes.Load(), Queued: len(l.queue), Spooling: l.spooling}
+		if l.spool != nil {
+			d := l.spool.Depth()
+			s.Spool = &d
+		}
+		if l.lastErr != nil {
+			s.LastError = l.lastErr.Error()
+		}
+		out[i] = s
+	}
+	return out
+}
+
+// Close closes every applier and spool.
+func (f *Fanout) Close() {
+	for _, l := range f.lanes {
+		l.applier.Close()
+		if l.spool != nil {
+			if err := l.spool.Close(); err != nil {
+				f.logger.Err(err).Str("destination", l.name).Msg("close spool")
+			}
+		}
+	}
+}
@@ -0,0 +1,386 @@
+package fanout
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/rs/zerolog"
+
+	"github.com/jfoltran/pgmanager/internal/config"
+	"github.com/jfoltran/pgmanager/internal/migration/replay"
+	"github.com/jfoltran/pgmanager/internal/migration/sentinel"
+	"github.com/jfoltran/pgmanager/internal/migration/shard"
+	"github.com/jfoltran/pgmanager/internal/migration/spool"
+	"github.com/jfoltran/pgmanager/internal/migration/stream"
+)
+
+// fakeApplier records applied commits. With gate set, each commit waits
+// for a value from it; with fail set, every message fails.
+type fakeApplier struct {
+	gate chan struct{}
+	fail atomic.Bool
+
+	mu      sync.Mutex
+	commits []pglogrepl.LSN
+	changes []*stream.ChangeMessage
+}
+
+func (a *fakeApplier) Start(ctx context.Context, messages <-chan stream.Message, onApplied replay.OnApplied, onSentinel replay.OnSentinel) error {
+	for {
+		var msg stream.Message
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case m, ok := <-messages:
+			if !ok {
+				return nil
+			}
+			msg = m
+		}
+		if a.fail.Load() {
+			return errors.New("destination down")
+		}
+		switch m := msg.(type) {
+		case *stream.ChangeMessage:
+			a.mu.Lock()
+			a.changes = append(a.changes, m)
+			a.mu.Unlock()
+		case *stream.CommitMessage:
+			if a.gate != nil {
+				select {
+				case <-a.gate:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			a.mu.Lock()
+			a.commits = append(a.commits, m.CommitLSN)
+			a.mu.Unlock()
+			onApplied(m.CommitLSN)
+		case *sentinel.SentinelMessage:
+			onSentinel(m.ID)
+		}
+	}
+}
+
+func (a *fakeApplier) Close() {}
+
+func (a *fakeApplier) applied() []pglogrepl.LSN {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]pglogrepl.LSN(nil), a.commits...)
+}
+
+func txn(lsn pglogrepl.LSN) []stream.Message {
+	return []stream.Message{
+		&stream.RelationMessage{RelationID: 1, Namespace: "public", Name: "users",
+			Columns: []stream.Column{{Name: "id", DataType: 23, Key: true}}},
+		&stream.BeginMessage{TxnLSN: lsn},
+		&stream.ChangeMessage{Op: stream.OpInsert, RelationID: 1, Namespace: "public", Table: "users", MsgLSN: lsn,
+			NewTuple: &stream.TupleData{Columns: []stream.Column{{Name: "id", DataType: 23, Value: []byte("1"), Key: true}}}},
+		&stream.CommitMessage{CommitLSN: lsn},
+	}
+}
+
+// confirmations collects the LSNs passed to onApplied.
+type confirmations struct {
+	mu   sync.Mutex
+	lsns []pglogrepl.LSN
+}
+
+func (c *confirmations) add(lsn pglogrepl.LSN) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lsns = append(c.lsns, lsn)
+}
+
+func (c *confirmations) last() pglogrepl.LSN {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.lsns) == 0 {
+		return 0
+	}
+	return c.lsns[len(c.lsns)-1]
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestFanoutBlockConfirmsSlowest(t *testing.T) {
+	fast := &fakeApplier{}
+	slow := &fakeApplier{gate: make(chan struct{})}
+	f, err := New([]Target{{Name: "dest", Applier: fast}, {Name: "analytics", Applier: slow}}, PolicyBlock, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := make(chan stream.Message, 16)
+	for _, lsn := range []pglogrepl.LSN{10, 20} {
+		for _, m := range txn(lsn) {
+			in <- m
+		}
+	}
+	in <- &sentinel.SentinelMessage{ID: "s1"}
+	close(in)
+
+	var confirmed confirmations
+	var sentinels atomic.Int32
+	done := make(chan error, 1)
+	go func() {
+		done <- f.Start(context.Background(), in, confirmed.add, func(string) { sentinels.Add(1) })
+	}()
+
+	waitFor(t, "fast destination", func() bool { return len(fast.applied()) == 2 })
+	if got := confirmed.last(); got != 0 {
+		t.Errorf("confirmed %s before the slow destination applied anything", got)
+	}
+	if sentinels.Load() != 0 {
+		t.Error("sentinel confirmed before every destination reached it")
+	}
+
+	slow.gate <- struct{}{}
+	waitFor(t, "first confirmation", func() bool { return confirmed.last() == 10 })
+	if got := f.LastLSN(); got != 10 {
+		t.Errorf("LastLSN = %s, want 0/A", got)
+	}
+	slow.gate <- struct{}{}
+
+	if err := <-done; err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if got := confirmed.last(); got != 20 {
+		t.Errorf("confirmed %s, want 0/14", got)
+	}
+	if sentinels.Load() != 1 {
+		t.Errorf("sentinel confirmed %d times, want 1", sentinels.Load())
+	}
+	st := f.Status()
+	if st[1].Name != "analytics" || st[1].AppliedLSN != 20 || st[1].Transactions != 2 {
+		t.Errorf("status = %+v", st[1])
+	}
+}
+
+func TestFanoutBlockApplyError(t *testing.T) {
+	bad := &fakeApplier{}
+	bad.fail.Store(true)
+	f, _ := New([]Target{{Name: "dest", Applier: &fakeApplier{}}, {Name: "analytics", Applier: bad}}, PolicyBlock, zerolog.Nop())
+
+	in := make(chan stream.Message, 8)
+	for _, m := range txn(10) {
+		in <- m
+	}
+	err := f.Start(context.Background(), in, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "destination analytics") {
+		t.Errorf("Start = %v, want an error naming the destination", err)
+	}
+}
+
+func TestFanoutSpoolKeepsStuckDestination(t *testing.T) {
+	open := func() *spool.Spool {
+		s, err := spool.Open(t.TempDir(), spool.Options{}, zerolog.Nop())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	}
+	good := &fakeApplier{}
+	stuck := &fakeApplier{}
+	stuck.fail.Store(true)
+	f, err := New([]Target{
+		{Name: "dest", Applier: good, Spool: open()},
+		{Name: "analytics", Applier: stuck, Spool: open()},
+	}, PolicySpool, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New([]Target{{Name: "dest", Applier: good}}, PolicySpool, zerolog.Nop()); err == nil {
+		t.Error("expected an error for a target without a spool")
+	}
+
+	in := make(chan stream.Message, 16)
+	for _, m := range txn(10) {
+		in <- m
+	}
+	var confirmed confirmations
+	done := make(chan error, 1)
+	go func() { done <- f.Start(context.Background(), in, confirmed.add, nil) }()
+	waitFor(t, "stuck destination error", func() bool { return f.Status()[1].LastError != "" })
+	for _, m := range txn(20) {
+		in <- m
+	}
+
+	// The failing destination moves to its spool, so the slot moves on
+	// while it is down; the healthy one keeps applying from memory.
+	waitFor(t, "healthy destination", func() bool { return len(good.applied()) == 2 })
+	waitFor(t, "confirmation", func() bool { return confirmed.last() == 20 })
+	if st := f.Status(); st[0].Spooling || !st[1].Spooling || st[1].AppliedLSN != 0 ||
+		st[1].Spool == nil || st[1].Spool.Transactions != 2 {
+		t.Errorf("status = %+v", st)
+	}
+	if got := f.LastLSN(); got != 0 {
+		t.Errorf("LastLSN = %s, want the stuck destination's 0/0", got)
+	}
+
+	// Once the destination recovers, it catches up from its spool.
+	stuck.fail.Store(false)
+	waitFor(t, "recovered destination", func() bool { return len(stuck.applied()) == 2 })
+	close(in)
+	if err := <-done; err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if st := f.Status()[1]; st.LastError != "" || st.AppliedLSN != 20 {
+		t.Errorf("recovered status = %+v", st)
+	}
+}
+
+func TestFanoutSpoolsLaggingDestination(t *testing.T) {
+	open := func() *spool.Spool {
+		s, err := spool.Open(t.TempDir(), spool.Options{}, zerolog.Nop())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	}
+	fast := &fakeApplier{}
+	slow := &fakeApplier{gate: make(chan struct{})}
+	f, err := New([]Target{
+		{Name: "dest", Applier: fast, Spool: open()},
+		{Name: "analytics", Applier: slow, Spool: open()},
+	}, PolicySpool, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 40
+	in := make(chan stream.Message, 4*n)
+	for _, m := range txn(10) {
+		in <- m
+	}
+	var confirmed confirmations
+	done := make(chan error, 1)
+	go func() { done <- f.Start(context.Background(), in, confirmed.add, nil) }()
+
+	// Both destinations start from their spools; the fast one keeps up, so
+	// it applies the rest from memory.
+	waitFor(t, "first transaction", func() bool { return f.Status()[0].Transactions == 1 })
+	for i := 2; i <= n; i++ {
+		for _, m := range txn(pglogrepl.LSN(i * 10)) {
+			in <- m
+		}
+		waitFor(t, "fast destination", func() bool { return f.Status()[0].Transactions == int64(i) })
+	}
+	if st := f.Status(); st[0].Spooling || !st[1].Spooling {
+		t.Errorf("status = %+v", st)
+	}
+	if got := f.LastLSN(); got != 0 {
+		t.Errorf("LastLSN = %s, want the slow destination's 0/0", got)
+	}
+
+	close(slow.gate)
+	waitFor(t, "slow destination", func() bool { return len(slow.applied()) == n })
+	waitFor(t, "confirmation", func() bool { return confirmed.last() == n*10 })
+	close(in)
+	if err := <-done; err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	for i, lsn := range slow.applied() {
+		if lsn != pglogrepl.LSN((i+1)*10) {
+			t.Fatalf("slow destination applied %v, want each transaction once in order", slow.applied())
+		}
+	}
+	if got := f.LastLSN(); got != n*10 {
+		t.Errorf("LastLSN = %s, want %s", got, pglogrepl.LSN(n*10))
+	}
+}
+
+func TestFanoutSpoolSkipsApplied(t *testing.T) {
+	sp, err := spool.Open(t.TempDir(), spool.Options{}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sp.Close() })
+	a := &fakeApplier{}
+	// The destination committed 0/A before a crash the spool did not see.
+	progress := func(context.Context) (pglogrepl.LSN, error) { return 10, nil }
+	f, err := New([]Target{{Name: "dest", Applier: a, Spool: sp, Progress: progress}}, PolicySpool, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, lsn := range []pglogrepl.LSN{10, 20} {
+		for _, m := range txn(lsn) {
+			if _, _, err := sp.Append(context.Background(), m); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	in := make(chan stream.Message)
+	done := make(chan error, 1)
+	go func() { done <- f.Start(context.Background(), in, nil, nil) }()
+	waitFor(t, "spooled transaction", func() bool { return len(a.applied()) == 1 })
+	close(in)
+	if err := <-done; err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if got := a.applied(); len(got) != 1 || got[0] != 20 {
+		t.Errorf("applied = %v, want [0/14]", got)
+	}
+}
+
+func TestFanoutRoutesShards(t *testing.T) {
+	shards := []*fakeApplier{{}, {}}
+	f, _ := New([]Target{{Name: "dest", Applier: shards[0]}, {Name: "shard1", Applier: shards[1]}}, PolicyBlock, zerolog.Nop())
+	r, err := shard.New([]config.ShardTableConfig{{Table: "public.orders", Key: "customer_id", Method: "range", Bounds: []int64{100}}}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.SetRouter(r)
+
+	change := func(table, customer string) *stream.ChangeMessage {
+		return &stream.ChangeMessage{Op: stream.OpInsert, Namespace: "public", Table: table,
+			NewTuple: &stream.TupleData{Columns: []stream.Column{{Name: "customer_id", Value: []byte(customer), Key: true}}}}
+	}
+	in := make(chan stream.Message, 8)
+	in <- &stream.BeginMessage{TxnLSN: 10}
+	in <- change("orders", "50")
+	in <- change("orders", "150")
+	in <- change("countries", "1")
+	in <- &stream.CommitMessage{CommitLSN: 10}
+	close(in)
+
+	var confirmed confirmations
+	if err := f.Start(context.Background(), in, confirmed.add, nil); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	for i, want := range []string{"50", "150"} {
+		got := shards[i].changes
+		if len(got) != 2 || string(got[0].NewTuple.Columns[0].Value) != want || got[1].Table != "countries" {
+			t.Errorf("shard %d got %d changes", i, len(got))
+		}
+		if len(shards[i].applied()) != 1 {
+			t.Errorf("shard %d did not apply the commit", i)
+		}
+	}
+	if confirmed.last() != 10 {
+		t.Errorf("confirmed %s, want 0/A", confirmed.last())
+	}
+	if st := f.Status(); st[0].Changes != 2 || st[1].Changes != 2 {
+		t.Errorf("status = %+v", st)
+	}
+}
@@ -0,0 +1,371 @@
+// Package feed keeps a window of recently committed transactions from the
+// change stream that clients can subscribe to, filtered by table and
+// operation, and resume from a commit LSN inside the window.
+package feed
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/rs/zerolog"
+
+	"github.com/jfoltran/pgmanager/internal/migration/sink"
+	"github.com/jfoltran/pgmanager/internal/migration/stream"
+)
+
+// Retention defaults.
+const (
+	DefaultMaxTransactions = 10000
+	DefaultMaxBytes        = 64 << 20
+)
+
+var (
+	// ErrExpired means the requested LSN has left the retained window.
+	ErrExpired = errors.New("resume LSN is older than the retained window")
+	// ErrSlow means a subscriber fell a whole window behind and was
+	// dropped.
+	ErrSlow = errors.New("subscriber fell too far behind")
+	// ErrClosed means the feed was closed, as when its pipeline stops.
+	ErrClosed = errors.New("change feed closed")
+)
+
+// Options bounds the retained window. Whichever limit is reached first
+// evicts the oldest transactions.
+type Options struct {
+	MaxTransactions int
+	MaxBytes        int64
+}
+
+// Transaction is one committed transaction.
+type Transaction struct {
+	XID        uint32
+	CommitLSN  pglogrepl.LSN
+	CommitTime time.Time
+	Origin     string
+	Changes    []*stream.ChangeMessage
+
+	size int64
+}
+
+// MarshalJSON encodes the transaction in the webhook sink's format, with
+// changes as sink events.
+func (t *Transaction) MarshalJSON() ([]byte, error) {
+	changes := make([]*sink.Event, len(t.Changes))
+	for i, c := range t.Changes {
+		changes[i] = sink.ChangeEvent(c, false)
+	}
+	v := struct {
+		XID        uint32        `json:"xid"`
+		CommitLSN  string        `json:"commit_lsn"`
+		CommitTime *time.Time    `json:"commit_time,omitempty"`
+		Origin     string        `json:"origin,omitempty"`
+		Changes    []*sink.Event `json:"changes"`
+	}{XID: t.XID, CommitLSN: t.CommitLSN.String(), Origin: t.Origin, Changes: changes}
+	if !t.CommitTime.IsZero() {
+		v.CommitTime = &t.CommitTime
+	}
+	return json.Marshal(v)
+}
+
+// Filter selects changes by table and operation. Empty sets match
+// everything.
+type Filter struct {
+	// Tables holds qualified "schema.table" names.
+	Tables map[string]bool
+	Ops    map[stream.ChangeOp]bool
+}
+
+// ParseFilter parses comma-separated table names and operations (insert,
+// update, delete). A table without a schema is taken to be in public.
+func ParseFilter(tables, ops string) (Filter, error) {
+	var f Filter
+	for _, t := range strings.Split(tables, ",") {
+		if t = strings.TrimSpace(t); t == "" {
+			continue
+		}
+		if !strings.Contains(t, ".") {
+			t = "public." + t
+		}
+		if f.Tables == nil {
+			f.Tables = make(map[string]bool)
+		}
+		f.Tables[t] = true
+	}
+	for _, o := range strings.Split(ops, ",") {
+		var op stream.ChangeOp
+		switch strings.ToLower(strings.TrimSpace(o)) {
+		case "":
+			continue
+		case "insert":
+			op = stream.OpInsert
+		case "update":
+			op = stream.OpUpdate
+		case "delete":
+			op = stream.OpDelete
+		default:
+			return Filter{}, fmt.Errorf("unknown operation %q (insert, update, delete)", o)
+		}
+		if f.Ops == nil {
+			f.Ops = make(map[stream.ChangeOp]bool)
+		}
+		f.Ops[op] = true
+	}
+	return f, nil
+}
+
+// Match reports whether the filter selects m.
+func (f Filter) Match(m *stream.ChangeMessage) bool {
+	if len(f.Ops) > 0 && !f.Ops[m.Op] {
+		return false
+	}
+	return len(f.Tables) == 0 || f.Tables[m.Namespace+"."+m.Table]
+}
+
+// apply returns txn with only the selected changes, or nil if none are.
+func (f Filter) apply(txn *Transaction) *Transaction {
+	if len(f.Tables) == 0 && len(f.Ops) == 0 {
+		return txn
+	}
+	var changes []*stream.ChangeMessage
+	for _, c := range txn.Changes {
+		if f.Match(c) {
+			changes = append(changes, c)
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	out := *txn
+	out.Changes = changes
+	return &out
+}
+
+// Feed retains recent transactions and fans them out to subscribers.
+type Feed struct {
+	opts   Options
+	logger zerolog.Logger
+
+	mu    sync.Mutex
+	txns  []*Transaction // oldest first
+	bytes int64
+	// last is the newest published commit; evicted the newest commit that
+	// has left the window.
+	last    pglogrepl.LSN
+	evicted pglogrepl.LSN
+	subs    map[*Subscription]struct{}
+	closed  bool
+}
+
+// New creates an empty feed.
+func New(opts Options, logger zerolog.Logger) *Feed {
+	if opts.MaxTransactions <= 0 {
+		opts.MaxTransactions = DefaultMaxTransactions
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultMaxBytes
+	}
+	return &Feed{
+		opts:   opts,
+		logger: logger.With().Str("component", "change-feed").Logger(),
+		subs:   make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish adds a committed transaction. Transactions at or below the last
+// published commit, as sent again after a decoder reconnect, are ignored.
+func (f *Feed) Publish(txn *Transaction) {
+	txn.size = 0
+	for _, c := range txn.Changes {
+		txn.size += stream.MessageSize(c)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed || txn.CommitLSN <= f.last {
+		return
+	}
+	f.last = txn.CommitLSN
+	f.txns = append(f.txns, txn)
+	f.bytes += txn.size
+	for len(f.txns) > 1 && (len(f.txns) > f.opts.MaxTransactions || f.bytes > f.opts.MaxBytes) {
+		old := f.txns[0]
+		f.txns[0] = nil
+		f.txns = f.txns[1:]
+		f.bytes -= old.size
+		f.evicted = old.CommitLSN
+	}
+
+	// A subscriber whose oldest queued transaction has left the window
+	// is a whole window behind.
+	for s := range f.subs {
+		s.push(txn)
+		if len(s.queue) > 0 && s.queue[0].CommitLSN <= f.evicted {
+			s.fail(ErrSlow)
+		}
+	}
+}
+
+// Subscribe returns a subscription to transactions committed after from.
+// A zero from starts with the next transaction. ErrExpired is returned if
+// transactions after from have already been evicted.
+func (f *Feed) Subscribe(from pglogrepl.LSN, filter Filter) (*Subscription, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil, ErrClosed
+	}
+	if from != 0 && from < f.evicted {
+		return nil, fmt.Errorf("%w: %s is before %s", ErrExpired, from, f.evicted)
+	}
+	s := &Subscription{
+		feed:   f,
+		filter: filter,
+		notify: make(chan struct{}, 1),
+	}
+	if from != 0 {
+		for _, txn := range f.txns {
+			if txn.CommitLSN > from {
+				s.push(txn)
+			}
+		}
+	}
+	f.subs[s] = struct{}{}
+	f.logger.Debug().Stringer("from", from).Int("subscribers", len(f.subs)).Msg("feed subscriber added")
+	return s, nil
+}
+
+// Close ends every subscription with ErrClosed.
+func (f *Feed) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	for s := range f.subs {
+		s.fail(ErrClosed)
+	}
+}
+
+// Subscription receives transactions from a Feed.
+type Subscription struct {
+	feed   *Feed
+	filter Filter
+
+	// queue and err are guarded by feed.mu.
+	queue  []*Transaction
+	err    error
+	notify chan struct{}
+}
+
+// push queues txn if the filter selects any of its changes. Called with
+// feed.mu held.
+func (s *Subscription) push(txn *Transaction) {
+	if s.err != nil {
+		return
+	}
+	if txn = s.filter.apply(txn); txn == nil {
+		return
+	}
+	s.queue = append(s.queue, txn)
+	s.wake()
+}
+
+// fail ends the subscription. Called with feed.mu held.
+func (s *Subscription) fail(err error) {
+	s.err = err
+	s.queue = nil
+	delete(s.feed.subs, s)
+	s.wake()
+}
+
+func (s *Subscription) wake() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Next returns the next transaction, waiting until one arrives, ctx ends
+// or the subscription fails.
+func (s *Subscription) Next(ctx context.Context) (*Transaction, error) {
+	for {
+		s.feed.mu.Lock()
+		if len(s.queue) > 0 {
+			txn := s.queue[0]
+			s.queue[0] = nil
+			s.queue = s.queue[1:]
+			s.feed.mu.Unlock()
+			return txn, nil
+		}
+		err := s.err
+		s.feed.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case <-s.notify:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Close removes the subscription from the feed.
+func (s *Subscription) Close() {
+	s.feed.mu.Lock()
+	defer s.feed.mu.Unlock()
+	if s.err == nil {
+		s.err = ErrClosed
+		s.queue = nil
+		delete(s.feed.subs, s)
+	}
+}
+
+// Tap returns a channel that carries everything from in while publishing
+// each committed transaction to f.
+func Tap(ctx context.Context, in <-chan stream.Message, f *Feed) <-chan stream.Message {
+	out := make(chan stream.Message, cap(in))
+	go func() {
+		defer close(out)
+		var cur *Transaction
+		for {
+			var msg stream.Message
+			select {
+			case m, ok := <-in:
+				if !ok {
+					return
+				}
+				msg = m
+			case <-ctx.Done():
+				return
+			}
+			switch m := msg.(type) {
+			case *stream.BeginMessage:
+				cur = &Transaction{XID: m.XID, Origin: m.Origin, CommitTime: m.TxnTime}
+			case *stream.ChangeMessage:
+				if cur != nil {
+					cur.Changes = append(cur.Changes, m)
+				}
+			case *stream.CommitMessage:
+				if cur != nil {
+					cur.CommitLSN = m.CommitLSN
+					if !m.TxnTime.IsZero() {
+						cur.CommitTime = m.TxnTime
+					}
+					f.Publish(cur)
+					cur = nil
+				}
+			}
+			select {
+			case out <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
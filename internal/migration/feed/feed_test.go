@@ -0,0 +1,133 @@
+package feed
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/rs/zerolog"
+
+	"github.com/jfoltran/pgmanager/internal/migration/stream"
+)
+
+func change(op stream.ChangeOp, table string) *stream.ChangeMessage {
+	return &stream.ChangeMessage{Op: op, Namespace: "public", Table: table,
+		NewTuple: &stream.TupleData{Columns: []stream.Column{{Name: "id", Value: []byte("1")}}}}
+}
+
+func txn(lsn pglogrepl.LSN, changes ...*stream.ChangeMessage) *Transaction {
+	return &Transaction{XID: uint32(lsn), CommitLSN: lsn, Changes: changes}
+}
+
+func next(t *testing.T, s *Subscription) *Transaction {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	got, err := s.Next(ctx)
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	return got
+}
+
+func TestFeedLiveAndResume(t *testing.T) {
+	f := New(Options{MaxTransactions: 3}, zerolog.Nop())
+
+	live, err := f.Subscribe(0, Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer live.Close()
+
+	for lsn := pglogrepl.LSN(10); lsn <= 50; lsn += 10 {
+		f.Publish(txn(lsn, change(stream.OpInsert, "users")))
+		f.Publish(txn(lsn)) // replayed after a reconnect
+		if got := next(t, live); got.CommitLSN != lsn {
+			t.Fatalf("live got %s, want %s", got.CommitLSN, lsn)
+		}
+	}
+
+	// The window holds 30, 40 and 50; 20 was the last evicted.
+	resumed, err := f.Subscribe(30, Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := next(t, resumed); got.CommitLSN != 40 {
+		t.Errorf("resumed at %s, want 0/28", got.CommitLSN)
+	}
+	if _, err := f.Subscribe(20, Filter{}); err != nil {
+		t.Errorf("resume at the last evicted commit: %v", err)
+	}
+	if _, err := f.Subscribe(10, Filter{}); !errors.Is(err, ErrExpired) {
+		t.Errorf("Subscribe(10) = %v, want ErrExpired", err)
+	}
+
+	f.Close()
+	if _, err := resumed.Next(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Errorf("Next after Close = %v, want ErrClosed", err)
+	}
+}
+
+func TestFeedFilter(t *testing.T) {
+	filter, err := ParseFilter("users, sales.orders", "insert,DELETE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseFilter("", "upsert"); err == nil {
+		t.Error("expected an error for an unknown operation")
+	}
+
+	f := New(Options{}, zerolog.Nop())
+	s, _ := f.Subscribe(0, filter)
+	f.Publish(txn(10, change(stream.OpUpdate, "users")))
+	f.Publish(txn(20, change(stream.OpInsert, "other"), change(stream.OpInsert, "users")))
+	got := next(t, s)
+	if got.CommitLSN != 20 || len(got.Changes) != 1 || got.Changes[0].Table != "users" {
+		t.Errorf("filtered transaction = %+v", got)
+	}
+}
+
+func TestFeedSlowSubscriber(t *testing.T) {
+	f := New(Options{MaxTransactions: 2}, zerolog.Nop())
+	s, _ := f.Subscribe(0, Filter{})
+	for lsn := pglogrepl.LSN(10); lsn <= 30; lsn += 10 {
+		f.Publish(txn(lsn))
+	}
+	if _, err := s.Next(context.Background()); !errors.Is(err, ErrSlow) {
+		t.Errorf("Next = %v, want ErrSlow", err)
+	}
+}
+
+func TestTap(t *testing.T) {
+	f := New(Options{}, zerolog.Nop())
+	s, _ := f.Subscribe(0, Filter{})
+	in := make(chan stream.Message, 4)
+	in <- &stream.BeginMessage{XID: 7}
+	in <- change(stream.OpInsert, "users")
+	in <- &stream.CommitMessage{CommitLSN: 100}
+	close(in)
+
+	var n int
+	for range Tap(context.Background(), in, f) {
+		n++
+	}
+	if n != 3 {
+		t.Errorf("Tap passed %d messages, want 3", n)
+	}
+	got := next(t, s)
+	if got.XID != 7 || got.CommitLSN != 100 || len(got.Changes) != 1 {
+		t.Errorf("published %+v", got)
+	}
+
+	b, err := json.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"commit_lsn":"0/64"`) || !strings.Contains(string(b), `"new":{"id":"1"}`) {
+		t.Errorf("json = %s", b)
+	}
+}
@@ -0,0 +1,215 @@
+package identity
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog"
+)
+
+// Action is the kind of fix proposed for a table.
+type Action string
+
+const (
+	// ActionIndex sets REPLICA IDENTITY USING INDEX on an existing unique index.
+	ActionIndex Action = "index"
+	// ActionFull sets REPLICA IDENTITY FULL. Every UPDATE and DELETE then logs
+	// the whole old row, which costs WAL volume and apply-side lookups.
+	ActionFull Action = "full"
+)
+
+// Finding is a table that has no usable replica identity. Once such a table
+// is in a FOR ALL TABLES publication, UPDATE and DELETE on it fail on the
+// source with "cannot update table ... because it does not have a replica
+// identity and publishes updates".
+type Finding struct {
+	Schema   string `json:"schema"`
+	Table    string `json:"table"`
+	Identity string `json:"identity"` // default, nothing, index
+	Reason   string `json:"reason"`
+	Fix      Fix    `json:"fix"`
+}
+
+// QualifiedName returns the quoted schema.table name.
+func (f Finding) QualifiedName() string {
+	return quoteIdent(f.Schema) + "." + quoteIdent(f.Table)
+}
+
+// Fix is the statement proposed to give a table a replica identity.
+type Fix struct {
+	Action    Action `json:"action"`
+	Index     string `json:"index,omitempty"`
+	Statement string `json:"statement"`
+}
+
+// candidate is a unique index that could serve as replica identity.
+type candidate struct {
+	name    string
+	columns int
+}
+
+// Auditor finds tables without a usable replica identity and fixes them.
+type Auditor struct {
+	pool   *pgxpool.Pool
+	logger zerolog.Logger
+}
+
+// NewAuditor creates an Auditor for the given (source) database.
+func NewAuditor(pool *pgxpool.Pool, logger zerolog.Logger) *Auditor {
+	return &Auditor{
+		pool:   pool,
+		logger: logger.With().Str("component", "replica-identity").Logger(),
+	}
+}
+
+// Audit lists user tables whose replica identity cannot identify rows,
+// each with a proposed fix. Partitions are audited one by one: replica
+// identity is per relation, and setting it on a partitioned table does not
+// reach its existing partitions, where UPDATE and DELETE are checked.
+func (a *Auditor) Audit(ctx context.Context) ([]Finding, error) {
+	rows, err := a.pool.Query(ctx, `
+		SELECT n.nspname, c.relname, c.relreplident::text,
+		       EXISTS (SELECT 1 FROM pg_index i WHERE i.indrelid = c.oid AND i.indisprimary),
+		       EXISTS (SELECT 1 FROM pg_index i WHERE i.indrelid = c.oid AND i.indisreplident),
+		       COALESCE((
+		           SELECT array_agg(ic.relname || ':' || i.indnkeyatts ORDER BY i.indnkeyatts, ic.relname)
+		           FROM pg_index i
+		           JOIN pg_class ic ON ic.oid = i.indexrelid
+		           WHERE i.indrelid = c.oid
+		             AND i.indisunique AND i.indimmediate AND i.indisvalid
+		             AND i.indpred IS NULL
+		             AND NOT (0 = ANY (i.indkey::int2[]))
+		             AND NOT EXISTS (
+		                 SELECT 1 FROM pg_attribute att
+		                 WHERE att.attrelid = c.oid
+		                   AND att.attnum = ANY (i.indkey[0:i.indnkeyatts - 1])
+		                   AND NOT att.attnotnull)
+		       ), '{}')
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind IN ('r', 'p')
+		  AND n.nspname NOT IN ('pg_catalog', 'information_schema')
+		  AND n.nspname !~ '^pg_toast'
+		ORDER BY n.nspname, c.relname`)
+	if err != nil {
+		return nil, fmt.Errorf("audit replica identity: %w", err)
+	}
+	defer rows.Close()
+
+	var findings []Finding
+	for rows.Next() {
+		var (
+			schema, table, ident string
+			hasPK, hasIdentIdx   bool
+			indexes              []string
+		)
+		if err := rows.Scan(&schema, &table, &ident, &hasPK, &hasIdentIdx, &indexes); err != nil {
+			return nil, fmt.Errorf("scan replica identity: %w", err)
+		}
+		if f, ok := evaluate(schema, table, ident, hasPK, hasIdentIdx, parseCandidates(indexes)); ok {
+			findings = append(findings, f)
+		}
+	}
+	return findings, rows.Err()
+}
+
+// Apply runs the proposed fixes. Callers must obtain explicit operator
+// confirmation first: ALTER TABLE takes an ACCESS EXCLUSIVE lock, and FULL
+// increases WAL volume for every later UPDATE and DELETE.
+func (a *Auditor) Apply(ctx context.Context, findings []Finding) error {
+	for _, f := range findings {
+		a.logger.Info().Str("table", f.Schema+"."+f.Table).Str("action", string(f.Fix.Action)).
+			Str("statement", f.Fix.Statement).Msg("setting replica identity")
+		if _, err := a.pool.Exec(ctx, f.Fix.Statement); err != nil {
+			return fmt.Errorf("fix replica identity of %s.%s: %w", f.Schema, f.Table, err)
+		}
+	}
+	return nil
+}
+
+// Filter returns the findings whose schema.table name is in tables. An
+// empty list selects every finding.
+func Filter(findings []Finding, tables []string) []Finding {
+	if len(tables) == 0 {
+		return findings
+	}
+	want := make(map[string]bool, len(tables))
+	for _, t := range tables {
+		want[t] = true
+	}
+	var out []Finding
+	for _, f := range findings {
+		if want[f.Schema+"."+f.Table] {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+func evaluate(schema, table, ident string, hasPK, hasIdentIdx bool, candidates []candidate) (Finding, bool) {
+	f := Finding{Schema: schema, Table: table}
+	switch ident {
+	case "f":
+		return f, false
+	case "d":
+		if hasPK {
+			return f, false
+		}
+		f.Identity = "default"
+		f.Reason = "no primary key"
+	case "i":
+		if hasIdentIdx {
+			return f, false
+		}
+		f.Identity = "index"
+		f.Reason = "replica identity index was dropped"
+	case "n":
+		f.Identity = "nothing"
+		f.Reason = "replica identity is NOTHING"
+	default:
+		return f, false
+	}
+	f.Fix = propose(f.QualifiedName(), candidates)
+	return f, true
+}
+
+// propose picks the narrowest eligible unique index, falling back to FULL.
+func propose(qualified string, candidates []candidate) Fix {
+	if len(candidates) > 0 {
+		best := candidates[0]
+		for _, c := range candidates[1:] {
+			if c.columns < best.columns {
+				best = c
+			}
+		}
+		return Fix{
+			Action:    ActionIndex,
+			Index:     best.name,
+			Statement: fmt.Sprintf("ALTER TABLE %s REPLICA IDENTITY USING INDEX %s", qualified, quoteIdent(best.name)),
+		}
+	}
+	return Fix{
+		Action:    ActionFull,
+		Statement: fmt.Sprintf("ALTER TABLE %s REPLICA IDENTITY FULL", qualified),
+	}
+}
+
+func parseCandidates(raw []string) []candidate {
+	out := make([]candidate, 0, len(raw))
+	for _, r := range raw {
+		i := strings.LastIndexByte(r, ':')
+		if i < 0 {
+			continue
+		}
+		var n int
+		fmt.Sscanf(r[i+1:], "%d", &n)
+		out = append(out, candidate{name: r[:i], columns: n})
+	}
+	return out
+}
+
+func quoteIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
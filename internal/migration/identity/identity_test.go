@@ -0,0 +1,80 @@
+package identity
+
+import "testing"
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		name        string
+		ident       string
+		hasPK       bool
+		hasIdentIdx bool
+		candidates  []candidate
+		wantFinding bool
+		wantAction  Action
+	}{
+		{"pk", "d", true, false, nil, false, ""},
+		{"full", "f", false, false, nil, false, ""},
+		{"identity index", "i", false, true, nil, false, ""},
+		{"no pk, no index", "d", false, false, nil, true, ActionFull},
+		{"no pk, unique index", "d", false, false, []candidate{{"t_email_key", 1}}, true, ActionIndex},
+		{"nothing", "n", true, false, nil, true, ActionFull},
+		{"dropped identity index", "i", false, false, []candidate{{"t_a_b_key", 2}}, true, ActionIndex},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, ok := evaluate("public", "t", tt.ident, tt.hasPK, tt.hasIdentIdx, tt.candidates)
+			if ok != tt.wantFinding {
+				t.Fatalf("finding = %v, want %v", ok, tt.wantFinding)
+			}
+			if ok && f.Fix.Action != tt.wantAction {
+				t.Errorf("action = %s, want %s", f.Fix.Action, tt.wantAction)
+			}
+		})
+	}
+}
+
+func TestPropose(t *testing.T) {
+	fix := propose(`"public"."orders"`, []candidate{
+		{"orders_a_b_c_key", 3},
+		{"orders_ref_key", 1},
+		{"orders_x_y_key", 2},
+	})
+	if fix.Index != "orders_ref_key" {
+		t.Errorf("index = %s, want narrowest", fix.Index)
+	}
+	want := `ALTER TABLE "public"."orders" REPLICA IDENTITY USING INDEX "orders_ref_key"`
+	if fix.Statement != want {
+		t.Errorf("statement = %s", fix.Statement)
+	}
+
+	fix = propose(`"public"."log"`, nil)
+	if fix.Statement != `ALTER TABLE "public"."log" REPLICA IDENTITY FULL` {
+		t.Errorf("statement = %s", fix.Statement)
+	}
+}
+
+func TestParseCandidates(t *testing.T) {
+	got := parseCandidates([]string{"idx:a:2", "simple:1", "bad"})
+	if len(got) != 2 {
+		t.Fatalf("len = %d, want 2", len(got))
+	}
+	if got[0].name != "idx:a" || got[0].columns != 2 {
+		t.Errorf("got[0] = %+v", got[0])
+	}
+}
+
+func TestFilter(t *testing.T) {
+	findings := []Finding{
+		{Schema: "public", Table: "a"},
+		{Schema: "public", Table: "b"},
+		{Schema: "app", Table: "a"},
+	}
+	if got := Filter(findings, nil); len(got) != 3 {
+		t.Errorf("empty filter: %d", len(got))
+	}
+	got := Filter(findings, []string{"app.a", "public.b"})
+	if len(got) != 2 || got[0].Table != "b" || got[1].Schema != "app" {
+		t.Errorf("filtered = %+v", got)
+	}
+}
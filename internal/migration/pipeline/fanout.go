@@ -0,0 +1,165 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/jfoltran/pgmanager/internal/config"
+	"github.com/jfoltran/pgmanager/internal/metrics"
+	"github.com/jfoltran/pgmanager/internal/migration/fanout"
+	"github.com/jfoltran/pgmanager/internal/migration/replay"
+	"github.com/jfoltran/pgmanager/internal/migration/shard"
+	"github.com/jfoltran/pgmanager/internal/migration/snapshot"
+	"github.com/jfoltran/pgmanager/internal/migration/spool"
+	"github.com/jfoltran/pgmanager/internal/migration/stream"
+)
+
+// fanoutDest is a fan-out destination besides Dest.
+type fanoutDest struct {
+	name string
+	pool *pgxpool.Pool
+}
+
+// connectFanout opens a pool to each fan-out destination.
+func (p *Pipeline) connectFanout(ctx context.Context) error {
+	for _, d := range p.cfg.Fanout.Destinations {
+		p.logger.Info().Str("destination", d.Name).Str("host", d.DB.Host).Uint16("port", d.DB.Port).Str("db", d.DB.DBName).Msg("connecting to fan-out destination (pool)")
+		pool, err := p.openDestPool(ctx, d.DB)
+		if err != nil {
+			return fmt.Errorf("fan-out destination %s: %w", d.Name, err)
+		}
+		p.fanoutDests = append(p.fanoutDests, fanoutDest{name: d.Name, pool: pool})
+	}
+	return nil
+}
+
+// initFanout creates an applier per destination, Dest first. Under the
+// spool policy each one also gets a spool in Spool.Dir/<name>.
+func (p *Pipeline) initFanout() error {
+	policy := fanout.Policy(p.cfg.Fanout.Policy)
+	newTarget := func(pool *pgxpool.Pool, name string) fanout.Target {
+		a := replay.NewApplier(pool, p.logger.With().Str("destination", name).Logger())
+		a.SetMap(p.remap)
+		t := fanout.Target{Name: name, Applier: a}
+		if policy == fanout.PolicySpool {
+			// Each destination records what it applied, so its spool
+			// resumes exactly after a crash.
+			origin := p.applyOrigin()
+			a.SetOrigin(origin)
+			t.Progress = func(ctx context.Context) (pglogrepl.LSN, error) {
+				return replay.OriginProgress(ctx, pool, origin)
+			}
+		}
+		return t
+	}
+	targets := []fanout.Target{newTarget(p.dstPool, config.DestName)}
+	for _, d := range p.fanoutDests {
+		targets = append(targets, newTarget(d.pool, d.name))
+	}
+
+	if policy == fanout.PolicySpool {
+		for i := range targets {
+			sp, err := spool.Open(filepath.Join(p.cfg.Spool.Dir, targets[i].Name), spool.Options{
+				MaxBytes:     p.cfg.Spool.MaxBytes,
+				SegmentBytes: p.cfg.Spool.SegmentBytes,
+			}, p.logger.With().Str("destination", targets[i].Name).Logger())
+			if err != nil {
+				for _, t := range targets[:i] {
+					t.Spool.Close() //nolint:errcheck
+				}
+				return fmt.Errorf("open spool for %s: %w", targets[i].Name, err)
+			}
+			targets[i].Spool = sp
+		}
+	}
+
+	f, err := fanout.New(targets, policy, p.logger)
+	if err != nil {
+		return err
+	}
+	if p.cfg.Sharding.Enabled() {
+		r, err := shard.New(p.cfg.Sharding.Tables, len(targets))
+		if err != nil {
+			f.Close()
+			return err
+		}
+		f.SetRouter(r)
+		p.router = r
+		p.shardRows = make([]atomic.Int64, len(targets))
+	}
+	p.fanout = f
+	return nil
+}
+
+// copyFanout copies tables into each fan-out destination under the same
+// snapshot, one destination after the other. Table progress is tracked
+// for Dest only.
+func (p *Pipeline) copyFanout(ctx context.Context, tables []snapshot.TableInfo, snapshotName string) []snapshot.CopyResult {
+	var results []snapshot.CopyResult
+	for _, d := range p.fanoutDests {
+		p.logger.Info().Str("destination", d.name).Int("tables", len(tables)).Msg("copying to fan-out destination")
+		copier := snapshot.NewCopier(p.srcPool, d.pool, p.cfg.Snapshot.Workers, p.logger)
+		copier.SetMap(p.remap)
+		for _, r := range copier.CopyAll(ctx, tables, snapshotName) {
+			if r.Err != nil {
+				r.Err = fmt.Errorf("destination %s: %w", d.name, r.Err)
+				return append(results, r)
+			}
+			results = append(results, r)
+		}
+	}
+	return results
+}
+
+// copySharded copies tables once, routing every row to its shards: Dest
+// first, then the fan-out destinations in order.
+func (p *Pipeline) copySharded(ctx context.Context, tables []snapshot.TableInfo, snapshotName string) []snapshot.CopyResult {
+	pools := []*pgxpool.Pool{p.dstPool}
+	for _, d := range p.fanoutDests {
+		pools = append(pools, d.pool)
+	}
+	p.logger.Info().Int("shards", len(pools)).Int("sharded_tables", len(p.cfg.Sharding.Tables)).Msg("copying into shards")
+	results := p.copier.CopyAllSharded(ctx, tables, snapshotName, pools, func(m *stream.ChangeMessage) ([]int, error) {
+		routed, err := p.router.Route(m)
+		if err != nil {
+			return nil, err
+		}
+		shards := make([]int, len(routed))
+		for i, r := range routed {
+			shards[i] = r.Shard
+			p.shardRows[r.Shard].Add(1)
+		}
+		return shards, nil
+	})
+	p.reportDestinations()
+	return results
+}
+
+// reportDestinations records each fan-out destination's progress.
+func (p *Pipeline) reportDestinations() {
+	statuses := p.fanout.Status()
+	ds := make([]metrics.DestinationStatus, len(statuses))
+	for i, s := range statuses {
+		ds[i] = metrics.DestinationStatus{
+			Name:         s.Name,
+			AppliedLSN:   s.AppliedLSN.String(),
+			Transactions: s.Transactions,
+			Changes:      s.Changes,
+			Queued:       s.Queued,
+			LastError:    s.LastError,
+		}
+		if p.shardRows != nil {
+			ds[i].RowsCopied = p.shardRows[i].Load()
+		}
+		if s.Spool != nil {
+			d := spoolDepth(*s.Spool)
+			ds[i].Spool = &d
+		}
+	}
+	p.Metrics.RecordDestinations(ds)
+}
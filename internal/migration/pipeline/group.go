@@ -0,0 +1,196 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/jfoltran/pgmanager/internal/config"
+	"github.com/jfoltran/pgmanager/internal/metrics"
+	"github.com/jfoltran/pgmanager/internal/migration/remap"
+)
+
+// Group consolidates several sources into one destination: one Pipeline
+// per source, each with its own slot and decoder, run and switched over
+// together. Their schema phases run one at a time so that shared objects
+// are created once.
+type Group struct {
+	names   []string
+	members []*Pipeline
+	logger  zerolog.Logger
+}
+
+// NewGroup creates a Group from a validated configuration with
+// Consolidation sources.
+func NewGroup(cfg *config.Config, logger zerolog.Logger) *Group {
+	g := &Group{logger: logger.With().Str("component", "group").Logger()}
+	schemaMu := &sync.Mutex{}
+	for i, s := range cfg.Consolidation.Sources {
+		p := New(cfg.ForSource(s), logger.With().Str("source", s.Name).Logger())
+		if cfg.Consolidation.Column != "" {
+			p.remap = remap.WithColumn(cfg.Consolidation.Column, s.Name)
+		} else {
+			p.remap = remap.ToSchema(s.Schema)
+		}
+		p.schemaMu = schemaMu
+		p.noState = i > 0
+		g.names = append(g.names, s.Name)
+		g.members = append(g.members, p)
+	}
+	return g
+}
+
+// RunClone copies every source into the destination.
+func (g *Group) RunClone(ctx context.Context) error {
+	return g.runAll(ctx, (*Pipeline).RunClone)
+}
+
+// RunCloneAndFollow copies every source and then streams the changes of
+// all of them until ctx is cancelled.
+func (g *Group) RunCloneAndFollow(ctx context.Context) error {
+	return g.runAll(ctx, (*Pipeline).RunCloneAndFollow)
+}
+
+// runAll runs fn for every member concurrently. The first failure cancels
+// the others and is returned.
+func (g *Group) runAll(ctx context.Context, fn func(*Pipeline, context.Context) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for i, p := range g.members {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := fn(p, ctx); err != nil {
+				mu.Lock()
+				if firstErr == nil && !(errors.Is(err, context.Canceled) && ctx.Err() != nil) {
+					firstErr = fmt.Errorf("source %s: %w", g.names[i], err)
+				}
+				mu.Unlock()
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	return firstErr
+}
+
+// RunSwitchover confirms that the destination has caught up with every
+// source. Writes must be stopped on all of them first; the sentinels are
+// sent together and the switchover fails if any source does not confirm
+// within timeout.
+func (g *Group) RunSwitchover(ctx context.Context, timeout time.Duration) error {
+	errs := make([]error, len(g.members))
+	var wg sync.WaitGroup
+	for i, p := range g.members {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := p.RunSwitchover(ctx, timeout); err != nil {
+				errs[i] = fmt.Errorf("source %s: %w", g.names[i], err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	g.logger.Info().Int("sources", len(g.members)).Msg("switchover confirmed for all sources")
+	return nil
+}
+
+// phaseOrder ranks the phases so that the group reports its least
+// advanced member.
+var phaseOrder = map[string]int{
+	"idle":                0,
+	"connecting":          1,
+	"roles":               2,
+	"schema":              3,
+	"grants":              4,
+	"copy":                5,
+	"reindex":             6,
+	"streaming":           7,
+	"switchover":          8,
+	"switchover-complete": 9,
+	"done":                10,
+}
+
+// Status returns the progress of the least advanced source with the
+// tables of all of them.
+func (g *Group) Status() Progress {
+	var out Progress
+	for i, p := range g.members {
+		st := p.Status()
+		if i == 0 || phaseOrder[st.Phase] < phaseOrder[out.Phase] {
+			out.Phase = st.Phase
+		}
+		if out.StartedAt.IsZero() || (!st.StartedAt.IsZero() && st.StartedAt.Before(out.StartedAt)) {
+			out.StartedAt = st.StartedAt
+		}
+		out.TablesTotal += st.TablesTotal
+		out.TablesCopied += st.TablesCopied
+	}
+	return out
+}
+
+// Snapshot merges the members' metrics. Tables are listed under
+// "source/schema", and each source's LSNs are in Sources.
+func (g *Group) Snapshot() metrics.Snapshot {
+	var out metrics.Snapshot
+	for i, p := range g.members {
+		s := p.Metrics.Snapshot()
+		if i == 0 || phaseOrder[s.Phase] < phaseOrder[out.Phase] {
+			out.Phase = s.Phase
+		}
+		out.Timestamp = s.Timestamp
+		out.ElapsedSec = max(out.ElapsedSec, s.ElapsedSec)
+		if i == 0 || s.LagBytes > out.LagBytes {
+			out.LagBytes, out.LagFormatted = s.LagBytes, s.LagFormatted
+		}
+		out.TablesTotal += s.TablesTotal
+		out.TablesCopied += s.TablesCopied
+		for _, t := range s.Tables {
+			t.Schema = g.names[i] + "/" + t.Schema
+			out.Tables = append(out.Tables, t)
+		}
+		out.RowsPerSec += s.RowsPerSec
+		out.BytesPerSec += s.BytesPerSec
+		out.TotalRows += s.TotalRows
+		out.TotalBytes += s.TotalBytes
+		out.ErrorCount += s.ErrorCount
+		if s.LastError != "" {
+			out.LastError = s.LastError
+		}
+		out.Sources = append(out.Sources, metrics.SourceStatus{
+			Name:         g.names[i],
+			Phase:        s.Phase,
+			AppliedLSN:   s.AppliedLSN,
+			ConfirmedLSN: s.ConfirmedLSN,
+			LagBytes:     s.LagBytes,
+			LagFormatted: s.LagFormatted,
+			TablesTotal:  s.TablesTotal,
+			TablesCopied: s.TablesCopied,
+			LastError:    s.LastError,
+		})
+	}
+	return out
+}
+
+// Close shuts down every member.
+func (g *Group) Close() {
+	for _, p := range g.members {
+		p.Close()
+	}
+}
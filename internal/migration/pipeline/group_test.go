@@ -0,0 +1,45 @@
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/rs/zerolog"
+
+	"github.com/jfoltran/pgmanager/internal/config"
+)
+
+func TestNewGroup(t *testing.T) {
+	cfg := &config.Config{
+		Dest:        config.DatabaseConfig{Host: "dst", DBName: "app"},
+		Replication: config.ReplicationConfig{SlotName: "slot", Publication: "pub"},
+		Consolidation: config.ConsolidationConfig{Sources: []config.SourceConfig{
+			{Name: "acme", DB: config.DatabaseConfig{Host: "a", DBName: "app"}},
+			{Name: "globex", DB: config.DatabaseConfig{Host: "g", DBName: "app"}},
+		}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	g := NewGroup(cfg, zerolog.Nop())
+	defer g.Close()
+
+	if len(g.members) != 2 || g.members[0].schemaMu != g.members[1].schemaMu {
+		t.Fatal("members do not share the schema lock")
+	}
+	for i, want := range []string{"acme", "globex"} {
+		p := g.members[i]
+		if p.cfg.Replication.SlotName != "slot_"+want {
+			t.Errorf("member %d uses slot %s", i, p.cfg.Replication.SlotName)
+		}
+		if schema, _ := p.remap.Table("public", "orders"); schema != want {
+			t.Errorf("member %d maps public to %s", i, schema)
+		}
+	}
+
+	g.members[0].setPhase("streaming")
+	g.members[1].setPhase("copy")
+	g.members[1].progress.TablesTotal = 3
+	if st := g.Status(); st.Phase != "copy" || st.TablesTotal != 3 {
+		t.Errorf("group status %+v", st)
+	}
+}
@@ -2,8 +2,11 @@ package pipeline
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jackc/pglogrepl"
@@ -13,12 +16,24 @@ import (
 	"github.com/rs/zerolog"
 
 	"github.com/jfoltran/pgmanager/internal/migration/bidi"
+	"github.com/jfoltran/pgmanager/internal/migration/capture"
+	"github.com/jfoltran/pgmanager/internal/migration/collation"
+	"github.com/jfoltran/pgmanager/internal/migration/failover"
+	"github.com/jfoltran/pgmanager/internal/migration/fanout"
+	"github.com/jfoltran/pgmanager/internal/migration/feed"
 	"github.com/jfoltran/pgmanager/internal/config"
 	"github.com/jfoltran/pgmanager/internal/metrics"
+	"github.com/jfoltran/pgmanager/internal/migration/identity"
+	"github.com/jfoltran/pgmanager/internal/migration/remap"
 	"github.com/jfoltran/pgmanager/internal/migration/replay"
+	"github.com/jfoltran/pgmanager/internal/migration/roles"
 	"github.com/jfoltran/pgmanager/internal/migration/schema"
 	"github.com/jfoltran/pgmanager/internal/migration/sentinel"
+	"github.com/jfoltran/pgmanager/internal/migration/shard"
+	"github.com/jfoltran/pgmanager/internal/migration/slotguard"
+	"github.com/jfoltran/pgmanager/internal/migration/sink"
 	"github.com/jfoltran/pgmanager/internal/migration/snapshot"
+	"github.com/jfoltran/pgmanager/internal/migration/spool"
 	"github.com/jfoltran/pgmanager/internal/migration/stream"
 )
 
@@ -31,6 +46,15 @@ type Progress struct {
 	StartedAt    time.Time
 }
 
+// changeApplier consumes the change stream: replay.Applier for a
+// PostgreSQL destination or sink.Driver for any other sink.
+type changeApplier interface {
+	SetBudget(b *stream.Budget)
+	Start(ctx context.Context, messages <-chan stream.Message, onApplied replay.OnApplied, onSentinel replay.OnSentinel) error
+	LastLSN() pglogrepl.LSN
+	Close()
+}
+
 // Pipeline orchestrates the full migration lifecycle: wires
 // decoder → filter → applier, manages snapshot copies, and coordinates switchover.
 type Pipeline struct {
@@ -42,14 +66,55 @@ type Pipeline struct {
 	srcPool  *pgxpool.Pool
 	dstPool  *pgxpool.Pool
 
+	// primaryPool is the source primary when reading from a standby.
+	primaryPool *pgxpool.Pool
+
 	// Components
-	decoder     *stream.Decoder
-	applier     *replay.Applier
+	decoder     stream.Source
+	applier     changeApplier
 	copier      *snapshot.Copier
 	schemaMgr   *schema.Manager
+	rolesMgr    *roles.Manager
 	coordinator *sentinel.Coordinator
 	bidiFilter  *bidi.Filter
 
+	// capture records the decoded stream when Capture.Dir is set.
+	capture *capture.Writer
+
+	// feed retains committed transactions for subscribers when
+	// Feed.Enabled is set.
+	feed *feed.Feed
+
+	// sink replaces the destination database when Sink.Type is set; it is
+	// then also the applier.
+	sink *sink.Driver
+
+	// spool sits between decoder and applier when Spool.Dir is set,
+	// unless fanning out.
+	spool *spool.Spool
+
+	// fanout applies the stream to Dest and every Fanout.Destinations
+	// entry when they are configured; it is then also the applier.
+	fanout      *fanout.Fanout
+	fanoutDests []fanoutDest
+	// router splits rows between the fan-out destinations as shards, and
+	// shardRows counts the rows copied to each.
+	router    *shard.Router
+	shardRows []atomic.Int64
+
+	// remap writes source tables under their destination names, from
+	// Remap rules or a Group's consolidation, and schemaMu, shared by a
+	// Group, runs one member's schema phase at a time.
+	remap    *remap.Map
+	schemaMu *sync.Mutex
+	// noState skips the state file, which a Group's first member writes.
+	noState bool
+
+	// budget bounds the decoded bytes in flight between the source and
+	// the applier or spool.
+	budget       *stream.Budget
+	reporterOnce sync.Once
+
 	// Metrics
 	Metrics   *metrics.Collector
 	persister *metrics.StatePersister
@@ -60,19 +125,45 @@ type Pipeline struct {
 	mu       sync.Mutex
 	progress Progress
 
+	// Slot health guard; guardErr is its fatal error, if any.
+	guardOnce sync.Once
+	guardErr  error
+
+	// failoverSlot is set when the slot is synchronized to standbys.
+	failoverSlot bool
+
+	// sourceFactory, if set, creates the change source instead of
+	// stream.NewSource.
+	sourceFactory func(*pgconn.PgConn) (stream.Source, error)
+
 	cancel context.CancelFunc
 }
 
 // New creates a new Pipeline from the given configuration.
 func New(cfg *config.Config, logger zerolog.Logger) *Pipeline {
 	mc := metrics.NewCollector(logger)
-	return &Pipeline{
+	budget := cfg.Memory.BudgetBytes
+	if budget == 0 {
+		budget = config.DefaultMemoryBudget
+	}
+	p := &Pipeline{
 		cfg:      cfg,
 		logger:   logger.With().Str("component", "pipeline").Logger(),
 		messages: make(chan stream.Message, 256),
 		progress: Progress{Phase: "idle"},
 		Metrics:  mc,
+		budget:   stream.NewBudget(budget),
+	}
+	if cfg.Feed.Enabled {
+		p.feed = feed.New(feed.Options{
+			MaxTransactions: cfg.Feed.MaxTransactions,
+			MaxBytes:        cfg.Feed.MaxBytes,
+		}, logger)
 	}
+	if cfg.Remap.Enabled() {
+		p.remap = remap.New(cfg.Remap)
+	}
+	return p
 }
 
 // SetLogger replaces the pipeline logger. Use this to redirect log output
@@ -108,10 +199,50 @@ func (p *Pipeline) connect(ctx context.Context) error {
 	pingCancel()
 	p.srcPool = srcPool
 
+	if p.cfg.Sink.Enabled() {
+		p.logger.Info().Str("sink", p.cfg.Sink.Type).Msg("streaming to sink, no destination database")
+	} else if err := p.connectDest(ctx); err != nil {
+		return err
+	}
+	if p.cfg.Fanout.Enabled() {
+		if err := p.connectFanout(ctx); err != nil {
+			return err
+		}
+	}
+	if p.cfg.Sharding.Enabled() {
+		if err := shard.CheckKeys(ctx, p.srcPool, p.cfg.Sharding.Tables); err != nil {
+			return err
+		}
+	}
+
+	if p.cfg.ReadsFromStandby() {
+		if err := p.connectPrimary(ctx); err != nil {
+			return err
+		}
+	}
+
+	p.logger.Info().Msg("all connections established")
+	return nil
+}
+
+// connectDest opens the destination pool.
+func (p *Pipeline) connectDest(ctx context.Context) error {
 	p.logger.Info().Str("host", p.cfg.Dest.Host).Uint16("port", p.cfg.Dest.Port).Str("db", p.cfg.Dest.DBName).Msg("connecting to destination (pool)")
-	dstCfg, err := pgxpool.ParseConfig(p.cfg.Dest.DSN())
+	dstPool, err := p.openDestPool(ctx, p.cfg.Dest)
+	if err != nil {
+		return err
+	}
+	p.dstPool = dstPool
+	return nil
+}
+
+// openDestPool opens a pool to a destination whose sessions run with
+// session_replication_role = replica.
+func (p *Pipeline) openDestPool(ctx context.Context, db config.DatabaseConfig) (*pgxpool.Pool, error) {
+	connTimeout := 30 * time.Second
+	dstCfg, err := pgxpool.ParseConfig(db.DSN())
 	if err != nil {
-		return fmt.Errorf("parse dest pool config: %w", err)
+		return nil, fmt.Errorf("parse dest pool config: %w", err)
 	}
 	dstCfg.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
 		_, err := conn.Exec(ctx, "SET session_replication_role = 'replica'")
@@ -119,26 +250,95 @@ func (p *Pipeline) connect(ctx context.Context) error {
 	}
 	dstPool, err := pgxpool.NewWithConfig(ctx, dstCfg)
 	if err != nil {
-		return fmt.Errorf("dest pool: %w", err)
+		return nil, fmt.Errorf("dest pool: %w", err)
 	}
-	pingCtx2, pingCancel2 := context.WithTimeout(ctx, connTimeout)
-	if err := dstPool.Ping(pingCtx2); err != nil {
-		pingCancel2()
+	pingCtx, pingCancel := context.WithTimeout(ctx, connTimeout)
+	if err := dstPool.Ping(pingCtx); err != nil {
+		pingCancel()
 		dstPool.Close()
-		return fmt.Errorf("dest pool ping %s:%d/%s: %w", p.cfg.Dest.Host, p.cfg.Dest.Port, p.cfg.Dest.DBName, err)
+		return nil, fmt.Errorf("dest pool ping %s:%d/%s: %w", db.Host, db.Port, db.DBName, err)
 	}
-	pingCancel2()
-	p.dstPool = dstPool
+	pingCancel()
+	return dstPool, nil
+}
 
-	p.logger.Info().Msg("all connections established")
-	return nil
+// SetSourceFactory replaces how the pipeline creates its change source,
+// e.g. with a stream.MemorySource in tests. Call it before any Run method.
+func (p *Pipeline) SetSourceFactory(f func(*pgconn.PgConn) (stream.Source, error)) {
+	p.sourceFactory = f
+}
+
+// newSource creates the change source on a replication connection for
+// the configured output plugin, or through the factory if one is set.
+func (p *Pipeline) newSource(conn *pgconn.PgConn) (stream.Source, error) {
+	var src stream.Source
+	var err error
+	if p.sourceFactory != nil {
+		src, err = p.sourceFactory(conn)
+	} else {
+		src, err = stream.NewSource(p.cfg.Replication.OutputPlugin, conn, p.cfg.Replication.SlotName, p.cfg.Replication.Publication, p.logger)
+	}
+	if err != nil {
+		return nil, err
+	}
+	src.SetBudget(p.budget)
+	return src, nil
 }
 
 // initComponents creates all pipeline components.
-func (p *Pipeline) initComponents() {
-	p.decoder = stream.NewDecoder(p.replConn, p.cfg.Replication.SlotName, p.cfg.Replication.Publication, p.logger)
-	p.applier = replay.NewApplier(p.dstPool, p.logger)
+func (p *Pipeline) initComponents() error {
+	decoder, err := p.newSource(p.replConn)
+	if err != nil {
+		return err
+	}
+	p.decoder = decoder
+	if p.cfg.Capture.Dir != "" && p.capture == nil {
+		w, err := capture.NewWriter(p.cfg.Capture.Dir, capture.Options{
+			SegmentBytes: p.cfg.Capture.SegmentBytes,
+			MaxSegments:  p.cfg.Capture.MaxSegments,
+		}, p.logger)
+		if err != nil {
+			return fmt.Errorf("init capture: %w", err)
+		}
+		p.capture = w
+	}
+	// With fan-out, Spool.Dir holds a spool per destination instead.
+	if p.cfg.Spool.Dir != "" && p.spool == nil && !p.cfg.Fanout.Enabled() {
+		sp, err := spool.Open(p.cfg.Spool.Dir, spool.Options{
+			MaxBytes:     p.cfg.Spool.MaxBytes,
+			SegmentBytes: p.cfg.Spool.SegmentBytes,
+		}, p.logger)
+		if err != nil {
+			return fmt.Errorf("open spool: %w", err)
+		}
+		p.spool = sp
+	}
+	if p.cfg.Sink.Enabled() {
+		s, err := sink.New(p.cfg, p.logger)
+		if err != nil {
+			return fmt.Errorf("init sink: %w", err)
+		}
+		p.sink = sink.NewDriver(s, p.logger)
+		p.applier = p.sink
+	} else if p.cfg.Fanout.Enabled() {
+		if err := p.initFanout(); err != nil {
+			return fmt.Errorf("init fan-out: %w", err)
+		}
+		p.applier = p.fanout
+	} else {
+		a := replay.NewApplier(p.dstPool, p.logger)
+		a.SetMap(p.remap)
+		if p.spool != nil {
+			a.SetOrigin(p.applyOrigin())
+		}
+		p.applier = a
+	}
+	if p.spool == nil {
+		// With a spool, spoolWithRetry is the final consumer instead.
+		p.applier.SetBudget(p.budget)
+	}
 	p.copier = snapshot.NewCopier(p.srcPool, p.dstPool, p.cfg.Snapshot.Workers, p.logger)
+	p.copier.SetMap(p.remap)
 	lastReported := &sync.Map{}
 	p.copier.SetProgressFunc(func(table snapshot.TableInfo, event string, rowsCopied int64) {
 		key := table.Schema + "." + table.Name
@@ -171,15 +371,23 @@ func (p *Pipeline) initComponents() {
 		}
 	})
 	p.schemaMgr = schema.NewManager(p.srcPool, p.dstPool, p.logger)
+	if p.cfg.Roles.Enabled {
+		p.rolesMgr = roles.NewManager(p.srcPool, p.dstPool, p.logger)
+	}
 	p.coordinator = sentinel.NewCoordinator(p.messages, p.logger)
 
 	if p.cfg.Replication.OriginID != "" {
 		p.bidiFilter = bidi.NewFilter(p.cfg.Replication.OriginID, p.logger)
+		p.bidiFilter.SetBudget(p.budget)
 	}
+	return nil
 }
 
 // startPersister initializes state file persistence.
 func (p *Pipeline) startPersister() {
+	if p.noState {
+		return
+	}
 	persister, err := metrics.NewStatePersister(p.Metrics, p.logger)
 	if err != nil {
 		p.logger.Warn().Err(err).Msg("failed to start state persister")
@@ -189,7 +397,135 @@ func (p *Pipeline) startPersister() {
 	p.persister.Start()
 }
 
-// RunClone performs schema copy + full data copy (no CDC follow).
+// migrateSchema dumps the source schema and applies it to the destination.
+// When role migration is enabled, roles and memberships are created first so
+// DDL referencing them succeeds, and grants, default privileges and ownership
+// are replayed once the objects exist.
+func (p *Pipeline) migrateSchema(ctx context.Context) error {
+	if p.schemaMu != nil {
+		p.schemaMu.Lock()
+		defer p.schemaMu.Unlock()
+	}
+	var plan *roles.Plan
+	if p.rolesMgr != nil {
+		p.setPhase("roles")
+		inv, err := p.rolesMgr.Inventory(ctx)
+		if err != nil {
+			return fmt.Errorf("roles inventory: %w", err)
+		}
+		caps, err := p.rolesMgr.DestCapabilities(ctx)
+		if err != nil {
+			return err
+		}
+		plan = roles.BuildPlan(inv, caps)
+		for _, s := range plan.Skipped {
+			p.logger.Warn().Str("object", s.Object).Str("reason", s.Reason).Msg("skipping role item")
+		}
+		p.logger.Info().Int("roles", len(inv.Roles)).Int("statements", len(plan.PreSchema)).Msg("creating roles on destination")
+		planned := len(plan.Skipped)
+		if err := p.rolesMgr.ApplyPreSchema(ctx, plan); err != nil {
+			return fmt.Errorf("apply roles: %w", err)
+		}
+		if n := len(plan.Skipped) - planned; n > 0 {
+			p.logger.Warn().Int("statements", n).Msg("role statements refused by the destination; roles may lack attributes or memberships")
+		}
+	}
+
+	p.setPhase("schema")
+	// Move mapped objects back under their source names first, so that a
+	// resumed run applies the schema over what an earlier run created.
+	for _, dst := range p.schemaDests() {
+		if err := p.remap.Revert(ctx, p.srcPool, dst, p.logger); err != nil {
+			return fmt.Errorf("revert remap: %w", err)
+		}
+	}
+	p.logger.Info().Msg("dumping schema from source")
+	ddl, err := p.schemaMgr.DumpSchema(ctx, p.cfg.Source.DSN())
+	if err != nil {
+		return fmt.Errorf("dump schema: %w", err)
+	}
+	p.logger.Info().Msg("applying schema to destination")
+	if err := p.schemaMgr.ApplySchema(ctx, ddl); err != nil {
+		return fmt.Errorf("apply schema: %w", err)
+	}
+	for _, d := range p.fanoutDests {
+		p.logger.Info().Str("destination", d.name).Msg("applying schema to fan-out destination")
+		if err := schema.NewManager(p.srcPool, d.pool, p.logger).ApplySchema(ctx, ddl); err != nil {
+			return fmt.Errorf("apply schema to %s: %w", d.name, err)
+		}
+	}
+
+	if plan != nil {
+		p.setPhase("grants")
+		p.logger.Info().Int("statements", len(plan.PostSchema)).Msg("applying grants and ownership to destination")
+		planned := len(plan.Skipped)
+		if err := p.rolesMgr.ApplyPostSchema(ctx, plan); err != nil {
+			return fmt.Errorf("apply grants: %w", err)
+		}
+		if n := len(plan.Skipped) - planned; n > 0 {
+			p.logger.Warn().Int("statements", n).Msg("grant statements refused by the destination; privileges may be missing")
+		}
+	}
+	for _, dst := range p.schemaDests() {
+		if err := p.remap.Prepare(ctx, p.srcPool, dst, p.logger); err != nil {
+			return fmt.Errorf("remap schema: %w", err)
+		}
+	}
+	return nil
+}
+
+// schemaDests returns the pools the schema is applied to: Dest and the
+// fan-out destinations.
+func (p *Pipeline) schemaDests() []*pgxpool.Pool {
+	pools := []*pgxpool.Pool{p.dstPool}
+	for _, d := range p.fanoutDests {
+		pools = append(pools, d.pool)
+	}
+	return pools
+}
+
+// reindexCollations rebuilds destination indexes whose collation differs
+// from the source, when enabled.
+func (p *Pipeline) reindexCollations(ctx context.Context) error {
+	if !p.cfg.Snapshot.ReindexCollations {
+		return nil
+	}
+	p.setPhase("reindex")
+	if err := p.reindexDest(ctx, p.dstPool, p.logger); err != nil {
+		return err
+	}
+	for _, d := range p.fanoutDests {
+		if err := p.reindexDest(ctx, d.pool, p.logger.With().Str("destination", d.name).Logger()); err != nil {
+			return fmt.Errorf("destination %s: %w", d.name, err)
+		}
+	}
+	return nil
+}
+
+// reindexDest rebuilds the collation-dependent indexes of one destination.
+func (p *Pipeline) reindexDest(ctx context.Context, pool *pgxpool.Pool, logger zerolog.Logger) error {
+	analyzer := collation.NewAnalyzer(p.srcPool, pool, logger)
+	report, err := analyzer.Analyze(ctx)
+	if err != nil {
+		return fmt.Errorf("collation analysis: %w", err)
+	}
+	for _, c := range report.Collations {
+		if c.Changed {
+			logger.Warn().Str("collation", c.Name).Str("reason", c.Reason).Msg("collation differs between source and destination")
+		}
+	}
+	if len(report.ReindexNeeded) == 0 {
+		logger.Info().Int("indexes", report.IndexesChecked).Msg("no indexes need a collation reindex")
+		return nil
+	}
+	logger.Info().Int("indexes", len(report.ReindexNeeded)).Msg("reindexing collation-dependent indexes")
+	return analyzer.Reindex(ctx, report.ReindexNeeded)
+}
+
+// RunClone performs schema copy + full data copy (no CDC follow). It needs
+// no replication slot or publication: the copy runs under a snapshot
+// exported by a plain transaction, which ends when RunClone returns, so
+// nothing is left behind on the source after success or failure.
 func (p *Pipeline) RunClone(ctx context.Context) error {
 	ctx, p.cancel = context.WithCancel(ctx)
 	p.setPhase("connecting")
@@ -198,34 +534,24 @@ func (p *Pipeline) RunClone(ctx context.Context) error {
 	if err := p.connect(ctx); err != nil {
 		return err
 	}
-	p.initComponents()
-
-	if err := p.ensurePublication(ctx); err != nil {
+	if err := p.initComponents(); err != nil {
 		return err
 	}
 
 	// Dump and apply schema.
-	p.setPhase("schema")
-	p.logger.Info().Msg("dumping schema from source")
-	ddl, err := p.schemaMgr.DumpSchema(ctx, p.cfg.Source.DSN())
-	if err != nil {
-		return fmt.Errorf("dump schema: %w", err)
-	}
-	p.logger.Info().Msg("applying schema to destination")
-	if err := p.schemaMgr.ApplySchema(ctx, ddl); err != nil {
-		return fmt.Errorf("apply schema: %w", err)
+	if p.sink == nil {
+		if err := p.migrateSchema(ctx); err != nil {
+			return err
+		}
 	}
 
-	// Create replication slot to get consistent snapshot.
-	// The snapshot stays valid until StartStreaming is called.
-	p.logger.Info().Str("slot", p.cfg.Replication.SlotName).Msg("creating replication slot")
-	snapshotName, err := p.decoder.CreateSlot(ctx, 0)
+	snapshotName, release, err := p.exportSnapshot(ctx)
 	if err != nil {
-		return fmt.Errorf("create slot: %w", err)
+		return err
 	}
-	p.logger.Info().Str("snapshot", snapshotName).Msg("replication slot created")
+	defer release()
 
-	// Parallel COPY using the snapshot (must complete before StartStreaming).
+	// Parallel COPY using the exported snapshot.
 	p.setPhase("copy")
 	tables, err := p.copier.ListTables(ctx)
 	if err != nil {
@@ -239,7 +565,7 @@ func (p *Pipeline) RunClone(ctx context.Context) error {
 
 	p.initTableMetrics(tables)
 
-	results := p.copier.CopyAll(ctx, tables, snapshotName)
+	results := p.copyTables(ctx, tables, snapshotName)
 	for _, r := range results {
 		if r.Err != nil {
 			p.Metrics.RecordError(r.Err)
@@ -248,55 +574,82 @@ func (p *Pipeline) RunClone(ctx context.Context) error {
 		p.Metrics.RecordApplied(0, 0, r.Table.SizeBytes)
 	}
 
-	// Start and immediately drain the replication stream (clone-only, no CDC).
-	msgCh, err := p.decoder.StartStreaming(ctx)
-	if err != nil {
-		return fmt.Errorf("start streaming: %w", err)
+	if err := p.reindexCollations(ctx); err != nil {
+		return err
 	}
-	go func() {
-		for range msgCh {
-		}
-	}()
 
 	p.setPhase("done")
 	p.logger.Info().Msg("clone completed")
 	return nil
 }
 
+// exportSnapshot opens a REPEATABLE READ transaction on a dedicated source
+// connection and exports its snapshot for the COPY workers. The snapshot
+// stays importable until release ends the transaction. The connection is
+// separate from srcPool so it never takes a slot from the workers.
+func (p *Pipeline) exportSnapshot(ctx context.Context) (string, func(), error) {
+	conn, err := pgx.Connect(ctx, p.cfg.Source.DSN())
+	if err != nil {
+		return "", nil, fmt.Errorf("snapshot connection: %w", err)
+	}
+	tx, err := conn.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		conn.Close(context.Background()) //nolint:errcheck
+		return "", nil, fmt.Errorf("begin snapshot transaction: %w", err)
+	}
+	var name string
+	if err := tx.QueryRow(ctx, "SELECT pg_export_snapshot()").Scan(&name); err != nil {
+		conn.Close(context.Background()) //nolint:errcheck
+		return "", nil, fmt.Errorf("export snapshot: %w", err)
+	}
+	p.logger.Info().Str("snapshot", name).Msg("exported snapshot")
+
+	release := func() {
+		tx.Rollback(context.Background()) //nolint:errcheck
+		conn.Close(context.Background())  //nolint:errcheck
+	}
+	return name, release, nil
+}
+
 // RunCloneAndFollow performs clone then transitions to CDC streaming.
-func (p *Pipeline) RunCloneAndFollow(ctx context.Context) error {
+func (p *Pipeline) RunCloneAndFollow(ctx context.Context) (err error) {
 	ctx, p.cancel = context.WithCancel(ctx)
+	defer p.slotGuardErr(&err)
 	p.setPhase("connecting")
 	p.startPersister()
 
 	if err := p.connect(ctx); err != nil {
 		return err
 	}
-	p.initComponents()
+	if err := p.initComponents(); err != nil {
+		return err
+	}
+	if (p.spool != nil && p.spool.Pending()) || (p.fanout != nil && p.fanout.Pending()) {
+		return fmt.Errorf("spool %s holds unapplied transactions from an earlier run; resume that migration or empty the directory", p.cfg.Spool.Dir)
+	}
 
 	if err := p.ensurePublication(ctx); err != nil {
 		return err
 	}
 
 	// Schema.
-	p.setPhase("schema")
-	p.logger.Info().Msg("dumping schema from source")
-	ddl, err := p.schemaMgr.DumpSchema(ctx, p.cfg.Source.DSN())
-	if err != nil {
-		return fmt.Errorf("dump schema: %w", err)
-	}
-	p.logger.Info().Msg("applying schema to destination")
-	if err := p.schemaMgr.ApplySchema(ctx, ddl); err != nil {
-		return fmt.Errorf("apply schema: %w", err)
+	if p.sink == nil {
+		if err := p.migrateSchema(ctx); err != nil {
+			return err
+		}
 	}
 
+	p.prepareFailover(ctx, true)
+
 	// Create replication slot to get consistent snapshot.
 	p.logger.Info().Str("slot", p.cfg.Replication.SlotName).Msg("creating replication slot")
-	snapshotName, err := p.decoder.CreateSlot(ctx, 0)
+	snapshotName, err := p.createSlot(ctx)
 	if err != nil {
 		return fmt.Errorf("create slot: %w", err)
 	}
 	p.logger.Info().Str("snapshot", snapshotName).Msg("replication slot created")
+	p.startSlotGuard(ctx)
+	p.startReporter(ctx)
 
 	// Parallel COPY using the snapshot (must complete before StartStreaming).
 	p.setPhase("copy")
@@ -312,7 +665,7 @@ func (p *Pipeline) RunCloneAndFollow(ctx context.Context) error {
 
 	p.initTableMetrics(tables)
 
-	results := p.copier.CopyAll(ctx, tables, snapshotName)
+	results := p.copyTables(ctx, tables, snapshotName)
 	for _, r := range results {
 		if r.Err != nil {
 			p.Metrics.RecordError(r.Err)
@@ -321,6 +674,10 @@ func (p *Pipeline) RunCloneAndFollow(ctx context.Context) error {
 		p.Metrics.RecordApplied(0, 0, r.Table.SizeBytes)
 	}
 
+	if err := p.reindexCollations(ctx); err != nil {
+		return err
+	}
+
 	// COPY complete — now start streaming. This invalidates the snapshot
 	// but we no longer need it. WAL accumulated since the slot was created
 	// will be delivered through the channel.
@@ -345,6 +702,33 @@ func (p *Pipeline) RunCloneAndFollow(ctx context.Context) error {
 	return p.startApplier(ctx, applierCh)
 }
 
+// copyTables copies tables to the destination, or with a sink exports
+// their rows to it as inserts and flushes it.
+func (p *Pipeline) copyTables(ctx context.Context, tables []snapshot.TableInfo, snapshotName string) []snapshot.CopyResult {
+	if p.router != nil {
+		return p.copySharded(ctx, tables, snapshotName)
+	}
+	if p.sink == nil {
+		results := p.copier.CopyAll(ctx, tables, snapshotName)
+		for _, r := range results {
+			if r.Err != nil {
+				return results
+			}
+		}
+		return append(results, p.copyFanout(ctx, tables, snapshotName)...)
+	}
+	results := p.copier.ExportAll(ctx, tables, snapshotName, p.sink.Snapshot)
+	for _, r := range results {
+		if r.Err != nil {
+			return results
+		}
+	}
+	if err := p.sink.Flush(ctx); err != nil {
+		return append(results, snapshot.CopyResult{Err: fmt.Errorf("flush sink: %w", err)})
+	}
+	return results
+}
+
 // SlotInfo holds information about an existing replication slot.
 type SlotInfo struct {
 	SlotName      string
@@ -390,30 +774,32 @@ func (p *Pipeline) checkSlot(ctx context.Context) (*SlotInfo, error) {
 // 2. Compares source vs dest row counts to find incomplete tables
 // 3. Truncates and re-COPYs only incomplete tables (without snapshot)
 // 4. Starts CDC streaming from the slot's LSN
-func (p *Pipeline) RunResumeCloneAndFollow(ctx context.Context) error {
+func (p *Pipeline) RunResumeCloneAndFollow(ctx context.Context) (err error) {
 	ctx, p.cancel = context.WithCancel(ctx)
+	defer p.slotGuardErr(&err)
 	p.setPhase("connecting")
 	p.startPersister()
+	if p.cfg.Sink.Enabled() {
+		return errors.New("cannot resume a clone into a sink: it has no row counts to compare; start a follow from the slot instead")
+	}
+	if p.cfg.Fanout.Enabled() {
+		return errors.New("cannot resume a clone with fan-out destinations; start a new clone or a follow from the slot instead")
+	}
 
 	if err := p.connect(ctx); err != nil {
 		return err
 	}
-	p.initComponents()
+	if err := p.initComponents(); err != nil {
+		return err
+	}
 
 	if err := p.ensurePublication(ctx); err != nil {
 		return err
 	}
 
 	// Ensure schema exists on destination (idempotent).
-	p.setPhase("schema")
-	p.logger.Info().Msg("dumping schema from source")
-	ddl, err := p.schemaMgr.DumpSchema(ctx, p.cfg.Source.DSN())
-	if err != nil {
-		return fmt.Errorf("dump schema: %w", err)
-	}
-	p.logger.Info().Msg("applying schema to destination")
-	if err := p.schemaMgr.ApplySchema(ctx, ddl); err != nil {
-		return fmt.Errorf("apply schema: %w", err)
+	if err := p.migrateSchema(ctx); err != nil {
+		return err
 	}
 
 	// Check that the replication slot survived.
@@ -425,6 +811,9 @@ func (p *Pipeline) RunResumeCloneAndFollow(ctx context.Context) error {
 	if slotInfo.Active {
 		return fmt.Errorf("cannot resume: slot %q is active (another process is using it)", slotInfo.SlotName)
 	}
+	p.startSlotGuard(ctx)
+	p.startReporter(ctx)
+	p.prepareFailover(ctx, false)
 
 	startLSN := slotInfo.RestartLSN
 	if slotInfo.ConfirmedLSN > startLSN {
@@ -494,12 +883,15 @@ func (p *Pipeline) RunResumeCloneAndFollow(ctx context.Context) error {
 			}
 			p.Metrics.RecordApplied(0, 0, r.Table.SizeBytes)
 		}
+
+		if err := p.reindexCollations(ctx); err != nil {
+			return err
+		}
 	} else {
 		p.logger.Info().Msg("all tables complete — skipping COPY phase")
 	}
 
 	// Start streaming from the slot's LSN. The decoder won't create a new slot.
-	p.decoder = stream.NewDecoder(p.replConn, p.cfg.Replication.SlotName, p.cfg.Replication.Publication, p.logger)
 	p.decoder.CreateSlot(ctx, startLSN) //nolint:errcheck
 	msgCh, err := p.decoder.StartStreaming(ctx)
 	if err != nil {
@@ -522,15 +914,18 @@ func (p *Pipeline) RunResumeCloneAndFollow(ctx context.Context) error {
 }
 
 // RunFollow starts CDC streaming from the given LSN (slot must already exist).
-func (p *Pipeline) RunFollow(ctx context.Context, startLSN pglogrepl.LSN) error {
+func (p *Pipeline) RunFollow(ctx context.Context, startLSN pglogrepl.LSN) (err error) {
 	ctx, p.cancel = context.WithCancel(ctx)
+	defer p.slotGuardErr(&err)
 	p.setPhase("connecting")
 	p.startPersister()
 
 	if err := p.connect(ctx); err != nil {
 		return err
 	}
-	p.initComponents()
+	if err := p.initComponents(); err != nil {
+		return err
+	}
 
 	if err := p.ensurePublication(ctx); err != nil {
 		return err
@@ -540,6 +935,9 @@ func (p *Pipeline) RunFollow(ctx context.Context, startLSN pglogrepl.LSN) error
 	if err != nil {
 		return fmt.Errorf("start decoder: %w", err)
 	}
+	p.startSlotGuard(ctx)
+	p.startReporter(ctx)
+	p.prepareFailover(ctx, false)
 
 	p.setPhase("streaming")
 
@@ -582,6 +980,9 @@ func (p *Pipeline) RunSwitchover(ctx context.Context, timeout time.Duration) err
 func (p *Pipeline) SetupReverseReplication(ctx context.Context) (slotName string, startLSN pglogrepl.LSN, err error) {
 	reverseSlot := p.cfg.Replication.SlotName + "_reverse"
 	reversePub := p.cfg.Replication.Publication + "_reverse"
+	if p.dstPool == nil {
+		return "", 0, errors.New("reverse replication needs a PostgreSQL destination")
+	}
 
 	var walLevel string
 	if err := p.dstPool.QueryRow(ctx, "SHOW wal_level").Scan(&walLevel); err != nil {
@@ -681,15 +1082,118 @@ func (p *Pipeline) Close() {
 	if p.applier != nil {
 		p.applier.Close()
 	}
+	if p.capture != nil {
+		if err := p.capture.Close(); err != nil {
+			p.logger.Err(err).Msg("close capture")
+		}
+	}
+	if p.feed != nil {
+		p.feed.Close()
+	}
+	if p.spool != nil {
+		if err := p.spool.Close(); err != nil {
+			p.logger.Err(err).Msg("close spool")
+		}
+	}
 	if p.replConn != nil {
 		p.replConn.Close(context.Background()) //nolint:errcheck
 	}
 	if p.srcPool != nil {
 		p.srcPool.Close()
 	}
+	if p.primaryPool != nil {
+		p.primaryPool.Close()
+	}
 	if p.dstPool != nil {
 		p.dstPool.Close()
 	}
+	for _, d := range p.fanoutDests {
+		d.pool.Close()
+	}
+}
+
+// startSlotGuard starts watching the replication slot, once per pipeline.
+// A fatal guard error (slot lost, or dropped at the retention limit)
+// cancels the pipeline and is returned from the running Run method.
+func (p *Pipeline) startSlotGuard(ctx context.Context) {
+	p.guardOnce.Do(func() {
+		rc := p.cfg.Replication
+		g := slotguard.NewGuard(p.srcPool, rc.SlotName, slotguard.Thresholds{
+			WarnRetainedBytes: rc.SlotWarnBytes,
+			WarnSafeBytes:     rc.SlotWarnSafeBytes,
+			MaxRetainedBytes:  rc.SlotMaxBytes,
+			DropOnLimit:       rc.SlotDropOnLimit,
+			Interval:          rc.SlotCheckInterval,
+		}, p.Metrics, p.logger)
+		go func() {
+			if err := g.Run(ctx); err != nil {
+				p.mu.Lock()
+				p.guardErr = err
+				p.mu.Unlock()
+				p.Metrics.RecordError(err)
+				p.cancel()
+			}
+		}()
+	})
+}
+
+// prepareFailover decides whether the slot survives a failover of the
+// source primary. On PG17+ a new slot is created with FAILOVER and an
+// existing one is inspected; older servers only get a warning.
+func (p *Pipeline) prepareFailover(ctx context.Context, creating bool) {
+	if p.cfg.ReadsFromStandby() {
+		p.logger.Info().Msg("reading from a standby: the slot cannot be a failover slot")
+		return
+	}
+	version, err := failover.ServerVersion(ctx, p.srcPool)
+	if err != nil {
+		p.logger.Warn().Err(err).Msg("cannot determine failover slot support")
+		return
+	}
+	if version < failover.MinVersion {
+		p.logger.Warn().Int("server_version", version).
+			Msg("source is older than PostgreSQL 17: a primary failover loses the replication slot and requires a re-clone")
+		return
+	}
+	if creating {
+		p.decoder.SetFailover(true)
+		p.setFailoverSlot(true)
+		return
+	}
+	enabled, err := failover.SlotFailover(ctx, p.srcPool, p.cfg.Replication.SlotName)
+	if err != nil {
+		p.logger.Warn().Err(err).Msg("cannot inspect replication slot failover flag")
+		return
+	}
+	p.setFailoverSlot(enabled)
+	if !enabled {
+		p.logger.Warn().Str("slot", p.cfg.Replication.SlotName).
+			Msg("replication slot was created without FAILOVER: a primary failover loses it and requires a re-clone")
+	}
+}
+
+func (p *Pipeline) setFailoverSlot(enabled bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failoverSlot = enabled
+}
+
+// FailoverSlot reports whether the replication slot is synchronized to
+// standbys, so streaming can resume on a promoted standby.
+func (p *Pipeline) FailoverSlot() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.failoverSlot
+}
+
+// slotGuardErr replaces *err with the slot guard's error when the guard
+// stopped the pipeline, so callers see the cause instead of a cancellation.
+func (p *Pipeline) slotGuardErr(err *error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.guardErr != nil {
+		*err = p.guardErr
+	}
 }
 
 func (p *Pipeline) setPhase(phase string) {
@@ -722,18 +1226,42 @@ func (p *Pipeline) Config() *config.Config {
 	return p.cfg
 }
 
+// Feed returns the live change feed, or nil when Feed.Enabled is not set.
+func (p *Pipeline) Feed() *feed.Feed {
+	return p.feed
+}
+
 const (
 	maxDecoderRetries  = 5
 	initialRetryDelay  = 2 * time.Second
 	maxRetryDelay      = 30 * time.Second
+	// maxApplyRetries is how many times in a row applying from the spool
+	// may fail without progress before the migration fails.
+	maxApplyRetries = 20
 )
 
+// applyOrigin is the replication origin that records on the destination
+// what was applied from the spool. It is named after the slot, so cleanup
+// finds it with the migration's other artifacts.
+func (p *Pipeline) applyOrigin() string {
+	return p.cfg.Replication.SlotName
+}
+
 func (p *Pipeline) startApplier(ctx context.Context, ch <-chan stream.Message) error {
 	merged := p.mergeMessages(ctx, ch)
+	if p.spool != nil {
+		return p.runSpooled(ctx, merged)
+	}
 	return p.runApplierWithRetry(ctx, merged)
 }
 
 func (p *Pipeline) mergeMessages(ctx context.Context, decoder <-chan stream.Message) <-chan stream.Message {
+	if p.capture != nil {
+		decoder = capture.Tap(ctx, decoder, p.capture)
+	}
+	if p.feed != nil {
+		decoder = feed.Tap(ctx, decoder, p.feed)
+	}
 	out := make(chan stream.Message, cap(decoder))
 	go func() {
 		defer close(out)
@@ -774,28 +1302,32 @@ func (p *Pipeline) mergeMessages(ctx context.Context, decoder <-chan stream.Mess
 }
 
 func (p *Pipeline) runApplierWithRetry(ctx context.Context, ch <-chan stream.Message) error {
-	retries := 0
-	delay := initialRetryDelay
-	watermark := pglogrepl.LSN(0)
-
+	var retry decoderRetry
 	for {
 		err := p.applier.Start(ctx, ch, func(lsn pglogrepl.LSN) {
 			p.decoder.ConfirmLSN(lsn)
-			p.mu.Lock()
-			p.progress.LastLSN = lsn
-			p.mu.Unlock()
-			p.Metrics.RecordApplied(lsn, 1, 0)
-			p.Metrics.RecordConfirmedLSN(lsn)
-		}, func(id string) {
-			if p.coordinator != nil {
-				p.coordinator.Confirm(id)
+			if p.fanout != nil {
+				// The slot may be confirmed past what every destination
+				// applied: the rest is in their spools.
+				p.recordApplied(p.fanout.LastLSN())
+			} else {
+				p.recordApplied(lsn)
 			}
-		})
+			p.Metrics.RecordConfirmedLSN(lsn)
+		}, p.confirmSentinel)
+		decErr := p.decoder.Err()
 		if err != nil {
-			return err
+			if p.fanout == nil || !p.fanout.Restartable() || ctx.Err() != nil {
+				return err
+			}
+			// A destination failed while applying from memory. Its spool
+			// lacks what it took from its queue, so the stream restarts
+			// from the slot and every destination resumes from its spool.
+			p.decoder.Close()
+			for range ch {
+			}
+			decErr = err
 		}
-
-		decErr := p.decoder.Err()
 		if decErr == nil {
 			return nil
 		}
@@ -804,42 +1336,237 @@ func (p *Pipeline) runApplierWithRetry(ctx context.Context, ch <-chan stream.Mes
 			return ctx.Err()
 		}
 
-		retries++
-		if retries > maxDecoderRetries {
-			return fmt.Errorf("decoder: %w (exhausted %d retries)", decErr, maxDecoderRetries)
-		}
-
 		p.mu.Lock()
 		currentLSN := p.progress.LastLSN
 		p.mu.Unlock()
 
-		if currentLSN > watermark {
-			watermark = currentLSN
-			retries = 1
-			delay = initialRetryDelay
+		if ch, err = p.reconnect(ctx, &retry, decErr, currentLSN); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *Pipeline) recordApplied(lsn pglogrepl.LSN) {
+	p.mu.Lock()
+	p.progress.LastLSN = lsn
+	p.mu.Unlock()
+	var txns int64 = 1
+	if p.bidiFilter != nil && p.bidiFilter.Looped(lsn) {
+		txns = 0
+	}
+	p.Metrics.RecordApplied(lsn, txns, 0)
+}
+
+func (p *Pipeline) confirmSentinel(id string) {
+	if p.coordinator != nil {
+		p.coordinator.Confirm(id)
+	}
+}
+
+// decoderRetry tracks reconnect attempts after decoder failures.
+type decoderRetry struct {
+	retries   int
+	delay     time.Duration
+	watermark pglogrepl.LSN
+}
+
+// reconnect waits out the backoff and restarts the decoder at resumeLSN.
+// Progress past the previous failure resets the retry budget.
+func (p *Pipeline) reconnect(ctx context.Context, r *decoderRetry, decErr error, resumeLSN pglogrepl.LSN) (<-chan stream.Message, error) {
+	if r.delay == 0 {
+		r.delay = initialRetryDelay
+	}
+	r.retries++
+	if r.retries > maxDecoderRetries {
+		return nil, fmt.Errorf("decoder: %w (exhausted %d retries)", decErr, maxDecoderRetries)
+	}
+
+	if resumeLSN > r.watermark {
+		r.watermark = resumeLSN
+		r.retries = 1
+		r.delay = initialRetryDelay
+	}
+
+	p.logger.Warn().
+		Err(decErr).
+		Int("retry", r.retries).
+		Int("max_retries", maxDecoderRetries).
+		Stringer("resume_lsn", resumeLSN).
+		Dur("delay", r.delay).
+		Msg("decoder failed, reconnecting")
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(r.delay):
+	}
+
+	r.delay = min(r.delay*2, maxRetryDelay)
+
+	newCh, err := p.reconnectDecoder(ctx, resumeLSN)
+	if err != nil {
+		return nil, fmt.Errorf("reconnect decoder: %w (original: %v)", err, decErr)
+	}
+	return p.mergeMessages(ctx, newCh), nil
+}
+
+// runSpooled runs the decoder into the spool and the applier out of it as
+// two loops. The slot is confirmed once a transaction is fsynced to the
+// spool, and a failing destination is retried without stopping decoding.
+func (p *Pipeline) runSpooled(ctx context.Context, ch <-chan stream.Message) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	spoolErr := make(chan error, 1)
+	go func() {
+		err := p.spoolWithRetry(ctx, ch)
+		p.spool.CloseWrite()
+		spoolErr <- err
+	}()
+
+	err := p.applyFromSpool(ctx)
+	cancel()
+	if serr := <-spoolErr; err == nil {
+		err = serr
+	}
+	return err
+}
+
+// spoolWithRetry appends the decoded stream to the spool, reconnecting the
+// decoder after failures at the last spooled commit.
+func (p *Pipeline) spoolWithRetry(ctx context.Context, ch <-chan stream.Message) error {
+	var retry decoderRetry
+	for {
+		for msg := range ch {
+			lsn, durable, err := p.spool.Append(ctx, msg)
+			p.budget.Release(stream.MessageSize(msg))
+			if err != nil {
+				return fmt.Errorf("spool: %w", err)
+			}
+			if durable {
+				p.decoder.ConfirmLSN(lsn)
+				p.Metrics.RecordConfirmedLSN(lsn)
+			}
 		}
 
-		p.logger.Warn().
-			Err(decErr).
-			Int("retry", retries).
-			Int("max_retries", maxDecoderRetries).
-			Stringer("resume_lsn", currentLSN).
-			Dur("delay", delay).
-			Msg("decoder failed, reconnecting")
+		decErr := p.decoder.Err()
+		if decErr == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 
+		var err error
+		if ch, err = p.reconnect(ctx, &retry, decErr, p.spool.LastCommit()); err != nil {
+			return err
+		}
+	}
+}
+
+// applyFromSpool applies spooled transactions until the spool is closed
+// for writing and drained. Each attempt first skips what the destination
+// recorded as applied. Apply errors are retried with backoff from there,
+// while decoding continues into the spool, and fail the migration after
+// maxApplyRetries attempts in a row without progress.
+func (p *Pipeline) applyFromSpool(ctx context.Context) error {
+	delay := initialRetryDelay
+	retries := 0
+	for {
+		err := p.skipApplied(ctx)
+		if err == nil {
+			actx, acancel := context.WithCancel(ctx)
+			err = p.applier.Start(actx, p.spool.Messages(actx), func(lsn pglogrepl.LSN) {
+				if err := p.spool.Ack(lsn); err != nil {
+					p.logger.Err(err).Msg("spool ack failed")
+				}
+				p.recordApplied(lsn)
+				delay = initialRetryDelay
+				retries = 0
+			}, p.confirmSentinel)
+			acancel()
+		}
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+
+		p.Metrics.RecordError(err)
+		if retries++; retries > maxApplyRetries {
+			return fmt.Errorf("apply from spool: %w (gave up after %d retries)", err, maxApplyRetries)
+		}
+		p.logger.Warn().Err(err).Dur("delay", delay).Int("retry", retries).Msg("apply from spool failed, retrying")
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		case <-time.After(delay):
 		}
-
 		delay = min(delay*2, maxRetryDelay)
+	}
+}
 
-		newCh, err := p.reconnectDecoder(ctx, currentLSN)
-		if err != nil {
-			return fmt.Errorf("reconnect decoder: %w (original: %v)", err, decErr)
-		}
-		ch = p.mergeMessages(ctx, newCh)
+// skipApplied moves the spool past the transactions the destination
+// recorded as applied, as after a crash before the spool cursor was saved.
+func (p *Pipeline) skipApplied(ctx context.Context) error {
+	if _, ok := p.applier.(*replay.Applier); !ok {
+		return nil
+	}
+	lsn, err := replay.OriginProgress(ctx, p.dstPool, p.applyOrigin())
+	if err != nil {
+		return err
+	}
+	n, err := p.spool.Skip(lsn)
+	if err != nil {
+		return fmt.Errorf("skip applied transactions: %w", err)
+	}
+	if n > 0 {
+		p.logger.Info().Int("transactions", n).Stringer("lsn", lsn).Msg("skipped spooled transactions already applied")
+	}
+	return nil
+}
+
+// startReporter records the memory budget and, with a spool, its depth
+// into the metrics every second.
+func (p *Pipeline) startReporter(ctx context.Context) {
+	p.reporterOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(time.Second)
+			defer ticker.Stop()
+			for {
+				p.reportBuffers()
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+	})
+}
+
+func (p *Pipeline) reportBuffers() {
+	p.Metrics.RecordBufferUsage(metrics.BufferUsage{
+		Bytes:      p.budget.Used(),
+		LimitBytes: p.budget.Limit(),
+		Blocked:    p.budget.Blocked(),
+	})
+	if p.fanout != nil {
+		p.reportDestinations()
+	}
+	if p.spool == nil {
+		return
+	}
+	p.Metrics.RecordSpoolDepth(spoolDepth(p.spool.Depth()))
+}
+
+func spoolDepth(d spool.Depth) metrics.SpoolDepth {
+	return metrics.SpoolDepth{
+		Bytes:        d.Bytes,
+		MaxBytes:     d.MaxBytes,
+		Transactions: d.Transactions,
+		Segments:     d.Segments,
+		SpooledLSN:   d.SpooledLSN.String(),
+		AppliedLSN:   d.AppliedLSN.String(),
+		Blocked:      d.Blocked,
 	}
 }
 
@@ -859,7 +1586,9 @@ func (p *Pipeline) reconnectDecoder(ctx context.Context, resumeLSN pglogrepl.LSN
 	}
 	p.replConn = replConn
 
-	p.decoder = stream.NewDecoder(replConn, p.cfg.Replication.SlotName, p.cfg.Replication.Publication, p.logger)
+	if p.decoder, err = p.newSource(replConn); err != nil {
+		return nil, err
+	}
 	if _, err := p.decoder.CreateSlot(ctx, resumeLSN); err != nil {
 		return nil, fmt.Errorf("create slot for resume: %w", err)
 	}
@@ -876,9 +1605,22 @@ func (p *Pipeline) reconnectDecoder(ctx context.Context, resumeLSN pglogrepl.LSN
 }
 
 func (p *Pipeline) ensurePublication(ctx context.Context) error {
+	if err := p.createPublication(ctx); err != nil {
+		return err
+	}
+	if p.primaryPool != nil {
+		return p.waitForPublication(ctx)
+	}
+	return nil
+}
+
+// createPublication creates the FOR ALL TABLES publication on the primary
+// unless it already exists.
+func (p *Pipeline) createPublication(ctx context.Context) error {
+	pool := p.publishPool()
 	pubName := p.cfg.Replication.Publication
 	var exists bool
-	err := p.srcPool.QueryRow(ctx,
+	err := pool.QueryRow(ctx,
 		"SELECT EXISTS(SELECT 1 FROM pg_publication WHERE pubname = $1)", pubName).Scan(&exists)
 	if err != nil {
 		return fmt.Errorf("check publication: %w", err)
@@ -887,7 +1629,26 @@ func (p *Pipeline) ensurePublication(ctx context.Context) error {
 		p.logger.Info().Str("publication", pubName).Msg("publication already exists")
 		return nil
 	}
-	_, err = p.srcPool.Exec(ctx,
+
+	// A FOR ALL TABLES publication makes UPDATE/DELETE fail on any table
+	// without a replica identity, so refuse unless explicitly overridden.
+	findings, err := identity.NewAuditor(pool, p.logger).Audit(ctx)
+	if err != nil {
+		return err
+	}
+	if len(findings) > 0 {
+		names := make([]string, len(findings))
+		for i, f := range findings {
+			names[i] = f.Schema + "." + f.Table
+		}
+		if !p.cfg.Replication.AllowMissingIdentity {
+			return fmt.Errorf("refusing to create publication: %d table(s) have no replica identity and would reject UPDATE/DELETE on the source: %s (fix them or allow missing replica identity)",
+				len(findings), strings.Join(names, ", "))
+		}
+		p.logger.Warn().Strs("tables", names).Msg("creating publication with tables lacking replica identity (override enabled)")
+	}
+
+	_, err = pool.Exec(ctx,
 		fmt.Sprintf("CREATE PUBLICATION %q FOR ALL TABLES", pubName))
 	if err != nil {
 		return fmt.Errorf("create publication: %w", err)
@@ -13,6 +13,7 @@ import (
 	"github.com/rs/zerolog"
 
 	"github.com/jfoltran/pgmanager/internal/config"
+	"github.com/jfoltran/pgmanager/internal/migration/identity"
 	"github.com/jfoltran/pgmanager/internal/migration/pipeline"
 	"github.com/jfoltran/pgmanager/internal/testutil"
 )
@@ -143,6 +144,47 @@ func TestClone_SingleTable(t *testing.T) {
 	}
 }
 
+func TestClone_LeavesNothingOnSource(t *testing.T) {
+	srcPool, dstPool := setupSourceAndDest(t)
+
+	tableName := uniqueName("test_slotless")
+	slotName := uniqueName("slot_slotless")
+	pubName := uniqueName("pub_slotless")
+
+	testutil.CreateTestTable(t, srcPool, "public", tableName, 50)
+	t.Cleanup(func() {
+		testutil.DropTestTable(t, srcPool, "public", tableName)
+		testutil.DropTestTable(t, dstPool, "public", tableName)
+		testutil.CleanupReplication(t, srcPool, slotName, pubName)
+	})
+
+	cfg := testConfig(slotName, pubName)
+	logger := zerolog.New(zerolog.NewTestWriter(t)).With().Timestamp().Logger()
+	p := pipeline.New(cfg, logger)
+	defer p.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	if err := p.RunClone(ctx); err != nil {
+		t.Fatalf("RunClone failed: %v", err)
+	}
+	if got := testutil.TableRowCount(t, dstPool, "public", tableName); got != 50 {
+		t.Errorf("expected 50 rows on dest, got %d", got)
+	}
+
+	var slots, pubs int
+	if err := srcPool.QueryRow(ctx, "SELECT count(*) FROM pg_replication_slots WHERE slot_name = $1", slotName).Scan(&slots); err != nil {
+		t.Fatalf("count slots: %v", err)
+	}
+	if err := srcPool.QueryRow(ctx, "SELECT count(*) FROM pg_publication WHERE pubname = $1", pubName).Scan(&pubs); err != nil {
+		t.Fatalf("count publications: %v", err)
+	}
+	if slots != 0 || pubs != 0 {
+		t.Errorf("clone left %d slot(s) and %d publication(s) on the source", slots, pubs)
+	}
+}
+
 func TestClone_MultipleTables(t *testing.T) {
 	srcPool, dstPool := setupSourceAndDest(t)
 
@@ -680,6 +722,216 @@ func TestClone_InvalidConfig(t *testing.T) {
 	}
 }
 
+func TestIdentityAudit_Partitions(t *testing.T) {
+	srcPool, _ := setupSourceAndDest(t)
+	ctx := context.Background()
+
+	parent := uniqueName("test_ident_part")
+	leaves := []string{parent + "_a", parent + "_b"}
+	stmts := []string{
+		fmt.Sprintf("CREATE TABLE %s (id int NOT NULL, v text) PARTITION BY RANGE (id)", quoteQN("public", parent)),
+		fmt.Sprintf("CREATE TABLE %s PARTITION OF %s FOR VALUES FROM (0) TO (100)", quoteQN("public", leaves[0]), quoteQN("public", parent)),
+		fmt.Sprintf("CREATE TABLE %s PARTITION OF %s FOR VALUES FROM (100) TO (200)", quoteQN("public", leaves[1]), quoteQN("public", parent)),
+	}
+	for _, stmt := range stmts {
+		if _, err := srcPool.Exec(ctx, stmt); err != nil {
+			t.Fatalf("create partitioned table: %v", err)
+		}
+	}
+	t.Cleanup(func() {
+		srcPool.Exec(context.Background(), "DROP TABLE IF EXISTS "+quoteQN("public", parent)) //nolint:errcheck
+	})
+
+	names := []string{"public." + parent, "public." + leaves[0], "public." + leaves[1]}
+	auditor := identity.NewAuditor(srcPool, zerolog.New(zerolog.NewTestWriter(t)))
+	findings, err := auditor.Audit(ctx)
+	if err != nil {
+		t.Fatalf("audit: %v", err)
+	}
+	findings = identity.Filter(findings, names)
+	if len(findings) != 3 {
+		t.Fatalf("expected a finding for the parent and each partition, got %+v", findings)
+	}
+
+	// Fixing the parent alone leaves the partitions without an identity.
+	if _, err := srcPool.Exec(ctx, fmt.Sprintf("ALTER TABLE %s REPLICA IDENTITY FULL", quoteQN("public", parent))); err != nil {
+		t.Fatalf("alter parent: %v", err)
+	}
+	findings, err = auditor.Audit(ctx)
+	if err != nil {
+		t.Fatalf("audit: %v", err)
+	}
+	findings = identity.Filter(findings, names[1:])
+	if len(findings) != 2 {
+		t.Fatalf("expected a finding for each partition, got %+v", findings)
+	}
+
+	if err := auditor.Apply(ctx, findings); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	findings, err = auditor.Audit(ctx)
+	if err != nil {
+		t.Fatalf("audit: %v", err)
+	}
+	if left := identity.Filter(findings, names); len(left) != 0 {
+		t.Errorf("findings left after fix: %+v", left)
+	}
+}
+
+func TestGroup_DiscriminatorSharedKeys(t *testing.T) {
+	srcPool, dstPool := setupSourceAndDest(t)
+	ctx := context.Background()
+
+	parent := uniqueName("test_disc_orders")
+	child := parent + "_items"
+	slotName := uniqueName("slot_disc")
+	pubName := uniqueName("pub_disc")
+
+	// Both sources read the same database, so every id is in both.
+	stmts := []string{
+		fmt.Sprintf("CREATE TABLE %s (id serial PRIMARY KEY, ref text UNIQUE)", quoteQN("public", parent)),
+		fmt.Sprintf("CREATE TABLE %s (id serial PRIMARY KEY, order_id int REFERENCES %s (id) ON DELETE CASCADE)",
+			quoteQN("public", child), quoteQN("public", parent)),
+		fmt.Sprintf("INSERT INTO %s (ref) SELECT 'r' || g FROM generate_series(1, 10) g", quoteQN("public", parent)),
+		fmt.Sprintf("INSERT INTO %s (order_id) SELECT g FROM generate_series(1, 10) g", quoteQN("public", child)),
+	}
+	for _, stmt := range stmts {
+		if _, err := srcPool.Exec(ctx, stmt); err != nil {
+			t.Fatalf("setup: %v", err)
+		}
+	}
+	sources := []string{"acme", "globex"}
+	t.Cleanup(func() {
+		for _, table := range []string{child, parent} {
+			testutil.DropTestTable(t, srcPool, "public", table)
+			testutil.DropTestTable(t, dstPool, "public", table)
+		}
+		for _, name := range sources {
+			testutil.DropReplicationSlot(t, srcPool, slotName+"_"+name)
+		}
+		testutil.DropPublication(t, srcPool, pubName)
+	})
+
+	testutil.CreatePublication(t, srcPool, pubName)
+
+	cfg := testConfig(slotName, pubName)
+	cfg.Consolidation.Column = "tenant"
+	for _, name := range sources {
+		cfg.Consolidation.Sources = append(cfg.Consolidation.Sources, config.SourceConfig{Name: name, DB: cfg.Source})
+	}
+	logger := zerolog.New(zerolog.NewTestWriter(t)).With().Timestamp().Logger()
+	g := pipeline.NewGroup(cfg, logger)
+	defer g.Close()
+
+	runCtx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	if err := g.RunClone(runCtx); err != nil {
+		t.Fatalf("RunClone failed: %v", err)
+	}
+
+	for _, table := range []string{parent, child} {
+		for _, name := range sources {
+			var n int64
+			err := dstPool.QueryRow(ctx, fmt.Sprintf("SELECT count(*) FROM %s WHERE tenant = $1", quoteQN("public", table)), name).Scan(&n)
+			if err != nil {
+				t.Fatalf("count %s rows of %s: %v", table, name, err)
+			}
+			if n != 10 {
+				t.Errorf("%s: expected 10 rows of %s, got %d", table, name, n)
+			}
+		}
+	}
+
+	// Deleting one source's order only cascades to that source's items.
+	if _, err := dstPool.Exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE id = 1 AND tenant = 'acme'", quoteQN("public", parent))); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if got := testutil.TableRowCount(t, dstPool, "public", child); got != 19 {
+		t.Errorf("expected 19 items after the cascade, got %d", got)
+	}
+}
+
+func TestClone_Sharded(t *testing.T) {
+	srcPool, dstPool := setupSourceAndDest(t)
+	ctx := context.Background()
+
+	sharded := uniqueName("test_shard_orders")
+	reference := uniqueName("test_shard_ref")
+	shardDB := uniqueName("shard1")
+	slotName := uniqueName("slot_shard")
+	pubName := uniqueName("pub_shard")
+
+	// The second shard is another database on the destination server.
+	if _, err := dstPool.Exec(ctx, "CREATE DATABASE "+quoteQN("", shardDB)); err != nil {
+		t.Fatalf("create shard database: %v", err)
+	}
+	cfg := testConfig(slotName, pubName)
+	shard := cfg.Dest
+	shard.DBName = shardDB
+	shardPool := testutil.MustConnectPool(t, shard.DSN())
+
+	testutil.CreateTestTable(t, srcPool, "public", sharded, 100)
+	testutil.CreateTestTable(t, srcPool, "public", reference, 20)
+	t.Cleanup(func() {
+		shardPool.Close()
+		dstPool.Exec(context.Background(), "DROP DATABASE IF EXISTS "+quoteQN("", shardDB)+" WITH (FORCE)") //nolint:errcheck
+		for _, table := range []string{sharded, reference} {
+			testutil.DropTestTable(t, srcPool, "public", table)
+			testutil.DropTestTable(t, dstPool, "public", table)
+		}
+		testutil.CleanupReplication(t, srcPool, slotName, pubName)
+	})
+
+	testutil.CreatePublication(t, srcPool, pubName)
+
+	// Ids below 50 stay on Dest, the rest go to the second shard.
+	cfg.Fanout.Destinations = []config.DestinationConfig{{Name: "shard1", DB: shard}}
+	cfg.Sharding.Tables = []config.ShardTableConfig{{Table: sharded, Key: "id", Method: "range", Bounds: []int64{50}}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	logger := zerolog.New(zerolog.NewTestWriter(t)).With().Timestamp().Logger()
+	p := pipeline.New(cfg, logger)
+	defer p.Close()
+
+	runCtx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	if err := p.RunClone(runCtx); err != nil {
+		t.Fatalf("RunClone failed: %v", err)
+	}
+
+	shards := []struct {
+		pool     *pgxpool.Pool
+		min, max int
+	}{
+		{dstPool, 1, 49},
+		{shardPool, 50, 100},
+	}
+	for i, s := range shards {
+		var n, lo, hi int
+		err := s.pool.QueryRow(ctx, fmt.Sprintf("SELECT count(*), min(id), max(id) FROM %s", quoteQN("public", sharded))).Scan(&n, &lo, &hi)
+		if err != nil {
+			t.Fatalf("shard %d: read %s: %v", i, sharded, err)
+		}
+		if n != s.max-s.min+1 || lo != s.min || hi != s.max {
+			t.Errorf("shard %d: expected ids %d-%d, got %d rows with ids %d-%d", i, s.min, s.max, n, lo, hi)
+		}
+		if got := testutil.TableRowCount(t, s.pool, "public", reference); got != 20 {
+			t.Errorf("shard %d: expected 20 reference rows, got %d", i, got)
+		}
+	}
+
+	dests := p.Metrics.Snapshot().Destinations
+	if len(dests) != 2 {
+		t.Fatalf("expected 2 destinations in metrics, got %+v", dests)
+	}
+	for i, s := range shards {
+		if want := int64(s.max - s.min + 1 + 20); dests[i].RowsCopied != want {
+			t.Errorf("shard %d: expected %d rows copied, got %d", i, want, dests[i].RowsCopied)
+		}
+	}
+}
+
 func waitForPhase(t *testing.T, p *pipeline.Pipeline, target string, timeout time.Duration) {
 	t.Helper()
 	deadline := time.Now().Add(timeout)
@@ -0,0 +1,129 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// minStandbyDecodingVersion is the first server_version_num that
+	// supports logical slots on a standby.
+	minStandbyDecodingVersion = 160000
+
+	publicationWait     = 2 * time.Minute
+	standbySnapshotTick = time.Second
+)
+
+// connectPrimary opens the pool to the source primary when the pipeline
+// reads from a standby, and checks that the standby can decode.
+func (p *Pipeline) connectPrimary(ctx context.Context) error {
+	primary := p.cfg.SourcePrimary
+	p.logger.Info().Str("host", primary.Host).Uint16("port", primary.Port).Str("db", primary.DBName).Msg("connecting to source primary (pool)")
+	pool, err := pgxpool.New(ctx, primary.DSN())
+	if err != nil {
+		return fmt.Errorf("source primary pool: %w", err)
+	}
+	pingCtx, pingCancel := context.WithTimeout(ctx, 30*time.Second)
+	defer pingCancel()
+	if err := pool.Ping(pingCtx); err != nil {
+		pool.Close()
+		return fmt.Errorf("source primary pool ping %s:%d/%s: %w", primary.Host, primary.Port, primary.DBName, err)
+	}
+	p.primaryPool = pool
+
+	return p.checkStandbySource(ctx)
+}
+
+// checkStandbySource verifies that the read source is a PG16+ standby.
+// Without hot_standby_feedback the primary may remove catalog rows the
+// slot still needs, and the standby then invalidates the slot.
+func (p *Pipeline) checkStandbySource(ctx context.Context) error {
+	var version int
+	var inRecovery bool
+	var feedback string
+	err := p.srcPool.QueryRow(ctx, `
+		SELECT current_setting('server_version_num')::int, pg_is_in_recovery(),
+		       current_setting('hot_standby_feedback')`).Scan(&version, &inRecovery, &feedback)
+	if err != nil {
+		return fmt.Errorf("check standby source: %w", err)
+	}
+	if !inRecovery {
+		return fmt.Errorf("read source %s:%d is not a standby (pg_is_in_recovery() = false)", p.cfg.Source.Host, p.cfg.Source.Port)
+	}
+	if version < minStandbyDecodingVersion {
+		return fmt.Errorf("read source server version %d does not support logical decoding on a standby (requires PostgreSQL 16)", version)
+	}
+	if feedback != "on" {
+		p.logger.Warn().Msg("hot_standby_feedback is off on the read source: the primary may remove catalog rows the slot needs and the slot will be invalidated")
+	}
+	return nil
+}
+
+// publishPool returns the pool where publications are managed: the
+// primary when reading from a standby, which cannot run DDL.
+func (p *Pipeline) publishPool() *pgxpool.Pool {
+	if p.primaryPool != nil {
+		return p.primaryPool
+	}
+	return p.srcPool
+}
+
+// waitForPublication waits until the publication created on the primary
+// has replayed on the standby; the walsender looks it up there.
+func (p *Pipeline) waitForPublication(ctx context.Context) error {
+	pubName := p.cfg.Replication.Publication
+	ctx, cancel := context.WithTimeout(ctx, publicationWait)
+	defer cancel()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		var exists bool
+		err := p.srcPool.QueryRow(ctx,
+			"SELECT EXISTS(SELECT 1 FROM pg_publication WHERE pubname = $1)", pubName).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("check publication on standby: %w", err)
+		}
+		if exists {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("publication %q did not replicate to the standby within %s", pubName, publicationWait)
+		case <-ticker.C:
+		}
+	}
+}
+
+// createSlot creates the replication slot and returns the exported
+// snapshot. On a standby, slot creation waits for a running-transactions
+// record from the primary; pg_log_standby_snapshot() on the primary is
+// called every second until then so an idle primary does not stall it.
+func (p *Pipeline) createSlot(ctx context.Context) (string, error) {
+	if p.primaryPool == nil {
+		return p.decoder.CreateSlot(ctx, 0)
+	}
+
+	nudgeCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	go func() {
+		ticker := time.NewTicker(standbySnapshotTick)
+		defer ticker.Stop()
+		for {
+			if _, err := p.primaryPool.Exec(nudgeCtx, "SELECT pg_log_standby_snapshot()"); err != nil && nudgeCtx.Err() == nil {
+				p.logger.Warn().Err(err).Msg("pg_log_standby_snapshot on primary failed")
+			}
+			select {
+			case <-nudgeCtx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	p.logger.Info().Msg("creating slot on standby, waiting for a consistent point from the primary")
+	return p.decoder.CreateSlot(ctx, 0)
+}
@@ -0,0 +1,704 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog"
+
+	"github.com/jfoltran/pgmanager/internal/migration/identity"
+	"github.com/jfoltran/pgmanager/internal/migration/remap"
+	"github.com/jfoltran/pgmanager/internal/migration/upgrade"
+)
+
+// Status is the outcome of a single preflight check.
+type Status string
+
+const (
+	StatusPass Status = "pass"
+	StatusWarn Status = "warn"
+	StatusFail Status = "fail"
+)
+
+// Check is one item of the preflight report.
+type Check struct {
+	Name    string   `json:"name"`
+	Status  Status   `json:"status"`
+	Message string   `json:"message"`
+	Hint    string   `json:"hint,omitempty"`
+	Details []string `json:"details,omitempty"`
+}
+
+// Report is the result of running all checks against a source/destination pair.
+type Report struct {
+	Checks    []Check   `json:"checks"`
+	Passed    int       `json:"passed"`
+	Warnings  int       `json:"warnings"`
+	Failures  int       `json:"failures"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// OK reports whether no check failed.
+func (r *Report) OK() bool {
+	return r.Failures == 0
+}
+
+// Add appends a check and updates the counters.
+func (r *Report) Add(c Check) {
+	r.Checks = append(r.Checks, c)
+	switch c.Status {
+	case StatusPass:
+		r.Passed++
+	case StatusWarn:
+		r.Warnings++
+	case StatusFail:
+		r.Failures++
+	}
+}
+
+// LongTxnThreshold is the transaction age above which a running transaction
+// on the source is reported as blocking slot creation.
+const LongTxnThreshold = 5 * time.Minute
+
+// maxDetails caps the number of offending objects listed per check.
+const maxDetails = 20
+
+// Checker runs preflight checks against a source and destination.
+type Checker struct {
+	source *pgxpool.Pool
+	dest   *pgxpool.Pool
+	remap  *remap.Map
+	logger zerolog.Logger
+}
+
+// NewChecker creates a preflight Checker.
+func NewChecker(source, dest *pgxpool.Pool, logger zerolog.Logger) *Checker {
+	return &Checker{
+		source: source,
+		dest:   dest,
+		logger: logger.With().Str("component", "preflight").Logger(),
+	}
+}
+
+// SetMap adds a check of the schema, table and column rules of m against
+// the source and destination.
+func (c *Checker) SetMap(m *remap.Map) {
+	c.remap = m
+}
+
+// Run executes every check and returns the report. Individual check errors
+// are reported as failed checks rather than aborting the run.
+func (c *Checker) Run(ctx context.Context) *Report {
+	report := &Report{CheckedAt: time.Now()}
+	checks := []func(context.Context) Check{
+		c.checkWalLevel,
+		c.checkReplicationSlots,
+		c.checkWalSenders,
+		c.checkReplicationPrivilege,
+		c.checkSourceCreatePrivilege,
+		c.checkDestCreatePrivilege,
+		c.checkSelectPrivilege,
+		c.checkReplicaIdentity,
+		c.checkExtensions,
+		c.checkEncoding,
+		c.checkDiskHeadroom,
+		c.checkLongTransactions,
+		c.checkUpgrade,
+	}
+	if c.remap != nil {
+		checks = append(checks, c.checkRemap)
+	}
+	for _, fn := range checks {
+		check := fn(ctx)
+		c.logger.Debug().Str("check", check.Name).Str("status", string(check.Status)).Msg(check.Message)
+		report.Add(check)
+	}
+	c.logger.Info().Int("passed", report.Passed).Int("warnings", report.Warnings).
+		Int("failures", report.Failures).Msg("preflight complete")
+	return report
+}
+
+func queryError(name string, err error) Check {
+	return Check{
+		Name:    name,
+		Status:  StatusFail,
+		Message: "check could not run: " + err.Error(),
+		Hint:    "Verify the connection and that the user can read the system catalogs.",
+	}
+}
+
+func (c *Checker) checkWalLevel(ctx context.Context) Check {
+	var level string
+	if err := c.source.QueryRow(ctx, "SHOW wal_level").Scan(&level); err != nil {
+		return queryError("wal_level", err)
+	}
+	return evalWalLevel(level)
+}
+
+func evalWalLevel(level string) Check {
+	if level == "logical" {
+		return Check{Name: "wal_level", Status: StatusPass, Message: "source wal_level is logical"}
+	}
+	return Check{
+		Name:    "wal_level",
+		Status:  StatusFail,
+		Message: fmt.Sprintf("source wal_level is %q, logical decoding requires \"logical\"", level),
+		Hint:    "ALTER SYSTEM SET wal_level = 'logical'; then restart the source server.",
+	}
+}
+
+func (c *Checker) checkReplicationSlots(ctx context.Context) Check {
+	var max, used int
+	err := c.source.QueryRow(ctx, `
+		SELECT current_setting('max_replication_slots')::int, (SELECT count(*) FROM pg_replication_slots)`,
+	).Scan(&max, &used)
+	if err != nil {
+		return queryError("replication_slots", err)
+	}
+	return evalFree("replication_slots", "replication slots", max, used,
+		"Raise max_replication_slots on the source (requires restart) or drop unused slots.")
+}
+
+func (c *Checker) checkWalSenders(ctx context.Context) Check {
+	var max, used int
+	err := c.source.QueryRow(ctx, `
+		SELECT current_setting('max_wal_senders')::int,
+		       (SELECT count(*) FROM pg_stat_activity WHERE backend_type = 'walsender')`,
+	).Scan(&max, &used)
+	if err != nil {
+		return queryError("wal_senders", err)
+	}
+	return evalFree("wal_senders", "WAL senders", max, used,
+		"Raise max_wal_senders on the source (requires restart) or stop idle replication connections.")
+}
+
+func evalFree(name, what string, max, used int, hint string) Check {
+	free := max - used
+	msg := fmt.Sprintf("%d of %d %s free", free, max, what)
+	switch {
+	case free <= 0:
+		return Check{Name: name, Status: StatusFail, Message: msg, Hint: hint}
+	case free == 1:
+		return Check{Name: name, Status: StatusWarn, Message: msg + ", no headroom for fallback or retries", Hint: hint}
+	default:
+		return Check{Name: name, Status: StatusPass, Message: msg}
+	}
+}
+
+func (c *Checker) checkReplicationPrivilege(ctx context.Context) Check {
+	var repl, super bool
+	err := c.source.QueryRow(ctx,
+		"SELECT rolreplication, rolsuper FROM pg_roles WHERE rolname = current_user",
+	).Scan(&repl, &super)
+	if err != nil {
+		return queryError("replication_privilege", err)
+	}
+	if repl || super {
+		return Check{Name: "replication_privilege", Status: StatusPass, Message: "source user has REPLICATION"}
+	}
+	return Check{
+		Name:    "replication_privilege",
+		Status:  StatusFail,
+		Message: "source user lacks the REPLICATION attribute",
+		Hint:    "ALTER ROLE <user> WITH REPLICATION; on the source.",
+	}
+}
+
+func (c *Checker) checkSourceCreatePrivilege(ctx context.Context) Check {
+	var create, super bool
+	err := c.source.QueryRow(ctx, `
+		SELECT has_database_privilege(current_database(), 'CREATE'),
+		       (SELECT rolsuper FROM pg_roles WHERE rolname = current_user)`,
+	).Scan(&create, &super)
+	if err != nil {
+		return queryError("source_create_privilege", err)
+	}
+	switch {
+	case super:
+		return Check{Name: "source_create_privilege", Status: StatusPass, Message: "source user is superuser"}
+	case create:
+		return Check{
+			Name:    "source_create_privilege",
+			Status:  StatusWarn,
+			Message: "source user has CREATE on the database but is not superuser; CREATE PUBLICATION ... FOR ALL TABLES requires superuser",
+			Hint:    "Create the publication ahead of time as a superuser, or grant superuser to the migration user.",
+		}
+	default:
+		return Check{
+			Name:    "source_create_privilege",
+			Status:  StatusFail,
+			Message: "source user lacks CREATE on the database, the publication cannot be created",
+			Hint:    "GRANT CREATE ON DATABASE <db> TO <user>; on the source.",
+		}
+	}
+}
+
+func (c *Checker) checkDestCreatePrivilege(ctx context.Context) Check {
+	var create bool
+	err := c.dest.QueryRow(ctx, "SELECT has_database_privilege(current_database(), 'CREATE')").Scan(&create)
+	if err != nil {
+		return queryError("dest_create_privilege", err)
+	}
+	if create {
+		return Check{Name: "dest_create_privilege", Status: StatusPass, Message: "destination user has CREATE on the database"}
+	}
+	return Check{
+		Name:    "dest_create_privilege",
+		Status:  StatusFail,
+		Message: "destination user lacks CREATE on the database, the schema cannot be applied",
+		Hint:    "GRANT CREATE ON DATABASE <db> TO <user>; on the destination.",
+	}
+}
+
+const userTables = `
+	FROM pg_class c
+	JOIN pg_namespace n ON n.oid = c.relnamespace
+	WHERE c.relkind IN ('r', 'p')
+	  AND n.nspname NOT IN ('pg_catalog', 'information_schema')
+	  AND n.nspname !~ '^pg_toast'
+	  AND NOT c.relispartition`
+
+func (c *Checker) checkSelectPrivilege(ctx context.Context) Check {
+	missing, err := c.listStrings(ctx, c.source, `
+		SELECT quote_ident(n.nspname) || '.' || quote_ident(c.relname)`+userTables+`
+		  AND NOT has_table_privilege(c.oid, 'SELECT')
+		ORDER BY 1`)
+	if err != nil {
+		return queryError("select_privilege", err)
+	}
+	if len(missing) == 0 {
+		return Check{Name: "select_privilege", Status: StatusPass, Message: "source user can SELECT every table"}
+	}
+	return Check{
+		Name:    "select_privilege",
+		Status:  StatusFail,
+		Message: fmt.Sprintf("source user cannot SELECT %d table(s)", len(missing)),
+		Hint:    "GRANT SELECT ON ALL TABLES IN SCHEMA <schema> TO <user>; on the source.",
+		Details: truncate(missing),
+	}
+}
+
+func (c *Checker) checkReplicaIdentity(ctx context.Context) Check {
+	findings, err := identity.NewAuditor(c.source, c.logger).Audit(ctx)
+	if err != nil {
+		return queryError("replica_identity", err)
+	}
+	if len(findings) == 0 {
+		return Check{Name: "replica_identity", Status: StatusPass, Message: "every table has a replica identity"}
+	}
+	details := make([]string, len(findings))
+	for i, f := range findings {
+		details[i] = fmt.Sprintf("%s.%s (%s): %s", f.Schema, f.Table, f.Reason, f.Fix.Statement)
+	}
+	return Check{
+		Name:    "replica_identity",
+		Status:  StatusFail,
+		Message: fmt.Sprintf("%d table(s) have no usable replica identity, UPDATE and DELETE will fail once published", len(findings)),
+		Hint:    "Apply the proposed fixes (replica identity fix endpoint), add a primary key, or explicitly allow missing replica identity.",
+		Details: truncate(details),
+	}
+}
+
+type extension struct {
+	name    string
+	version string
+}
+
+func (c *Checker) checkExtensions(ctx context.Context) Check {
+	rows, err := c.source.Query(ctx, "SELECT extname, extversion FROM pg_extension WHERE extname <> 'plpgsql' ORDER BY extname")
+	if err != nil {
+		return queryError("extensions", err)
+	}
+	var src []extension
+	for rows.Next() {
+		var e extension
+		if err := rows.Scan(&e.name, &e.version); err != nil {
+			rows.Close()
+			return queryError("extensions", err)
+		}
+		src = append(src, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return queryError("extensions", err)
+	}
+
+	rows, err = c.dest.Query(ctx, "SELECT name, version FROM pg_available_extension_versions")
+	if err != nil {
+		return queryError("extensions", err)
+	}
+	available := make(map[string][]string)
+	for rows.Next() {
+		var name, version string
+		if err := rows.Scan(&name, &version); err != nil {
+			rows.Close()
+			return queryError("extensions", err)
+		}
+		available[name] = append(available[name], version)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return queryError("extensions", err)
+	}
+
+	return evalExtensions(src, available)
+}
+
+func evalExtensions(src []extension, available map[string][]string) Check {
+	var missing, versionDiff []string
+	for _, e := range src {
+		versions, ok := available[e.name]
+		if !ok {
+			missing = append(missing, e.name+" "+e.version)
+			continue
+		}
+		found := false
+		for _, v := range versions {
+			if v == e.version {
+				found = true
+				break
+			}
+		}
+		if !found {
+			versionDiff = append(versionDiff, fmt.Sprintf("%s %s (destination has %s)", e.name, e.version, strings.Join(versions, ", ")))
+		}
+	}
+
+	switch {
+	case len(missing) > 0:
+		return Check{
+			Name:    "extensions",
+			Status:  StatusFail,
+			Message: fmt.Sprintf("%d extension(s) used on the source are not installable on the destination", len(missing)),
+			Hint:    "Install the extension packages on the destination server before migrating.",
+			Details: truncate(append(missing, versionDiff...)),
+		}
+	case len(versionDiff) > 0:
+		return Check{
+			Name:    "extensions",
+			Status:  StatusWarn,
+			Message: fmt.Sprintf("%d extension(s) are available on the destination only in a different version", len(versionDiff)),
+			Hint:    "The destination will create the default version; run ALTER EXTENSION ... UPDATE afterwards if the source version is required.",
+			Details: truncate(versionDiff),
+		}
+	default:
+		return Check{Name: "extensions", Status: StatusPass, Message: fmt.Sprintf("all %d source extension(s) are available on the destination", len(src))}
+	}
+}
+
+// dbLocale is the encoding and collation of a database.
+type dbLocale struct {
+	Encoding string
+	Collate  string
+	Ctype    string
+}
+
+func (c *Checker) locale(ctx context.Context, pool *pgxpool.Pool) (dbLocale, error) {
+	var l dbLocale
+	err := pool.QueryRow(ctx, `
+		SELECT pg_encoding_to_char(encoding), datcollate, datctype
+		FROM pg_database WHERE datname = current_database()`,
+	).Scan(&l.Encoding, &l.Collate, &l.Ctype)
+	return l, err
+}
+
+func (c *Checker) checkEncoding(ctx context.Context) Check {
+	src, err := c.locale(ctx, c.source)
+	if err != nil {
+		return queryError("encoding", err)
+	}
+	dst, err := c.locale(ctx, c.dest)
+	if err != nil {
+		return queryError("encoding", err)
+	}
+	return evalEncoding(src, dst)
+}
+
+func evalEncoding(src, dst dbLocale) Check {
+	if src.Encoding != dst.Encoding {
+		return Check{
+			Name:    "encoding",
+			Status:  StatusFail,
+			Message: fmt.Sprintf("encoding differs: source %s, destination %s", src.Encoding, dst.Encoding),
+			Hint:    fmt.Sprintf("Recreate the destination database with ENCODING '%s'.", src.Encoding),
+		}
+	}
+	if src.Collate != dst.Collate || src.Ctype != dst.Ctype {
+		return Check{
+			Name:   "encoding",
+			Status: StatusWarn,
+			Message: fmt.Sprintf("collation differs: source %s/%s, destination %s/%s",
+				src.Collate, src.Ctype, dst.Collate, dst.Ctype),
+			Hint: fmt.Sprintf("Text ordering and indexes may behave differently; recreate the destination with LC_COLLATE '%s' LC_CTYPE '%s' if that matters.", src.Collate, src.Ctype),
+		}
+	}
+	return Check{Name: "encoding", Status: StatusPass, Message: fmt.Sprintf("encoding %s and collation %s match", src.Encoding, src.Collate)}
+}
+
+func (c *Checker) checkDiskHeadroom(ctx context.Context) Check {
+	var srcSize, dstSize int64
+	if err := c.source.QueryRow(ctx, "SELECT pg_database_size(current_database())").Scan(&srcSize); err != nil {
+		return queryError("disk_headroom", err)
+	}
+	if err := c.dest.QueryRow(ctx, "SELECT pg_database_size(current_database())").Scan(&dstSize); err != nil {
+		return queryError("disk_headroom", err)
+	}
+	return evalDiskHeadroom(srcSize, dstSize)
+}
+
+func evalDiskHeadroom(srcSize, dstSize int64) Check {
+	// Indexes are rebuilt after COPY and the source retains WAL for the slot
+	// while the copy runs, so plan for ~20% on top of the raw database size.
+	need := srcSize + srcSize/5
+	msg := fmt.Sprintf("source database is %s; plan for at least %s free on the destination", formatBytes(srcSize), formatBytes(need))
+	hint := "PostgreSQL does not expose free disk space over SQL; verify it on the destination host. The source also retains WAL for the slot until the copy finishes."
+	if dstSize > srcSize/10 && dstSize > 64<<20 {
+		return Check{
+			Name:    "disk_headroom",
+			Status:  StatusWarn,
+			Message: msg + fmt.Sprintf(" (destination database already holds %s)", formatBytes(dstSize)),
+			Hint:    hint,
+		}
+	}
+	return Check{Name: "disk_headroom", Status: StatusPass, Message: msg, Hint: hint}
+}
+
+func (c *Checker) checkLongTransactions(ctx context.Context) Check {
+	long, err := c.listStrings(ctx, c.source, fmt.Sprintf(`
+		SELECT format('pid %%s (%%s@%%s) open for %%s: %%s',
+		              pid, usename, datname, date_trunc('second', now() - xact_start), left(query, 80))
+		FROM pg_stat_activity
+		WHERE xact_start IS NOT NULL
+		  AND pid <> pg_backend_pid()
+		  AND backend_type = 'client backend'
+		  AND now() - xact_start > interval '%d seconds'
+		ORDER BY xact_start`, int(LongTxnThreshold.Seconds())))
+	if err != nil {
+		return queryError("long_transactions", err)
+	}
+	if len(long) == 0 {
+		return Check{Name: "long_transactions", Status: StatusPass, Message: "no long-running transactions on the source"}
+	}
+	return Check{
+		Name:    "long_transactions",
+		Status:  StatusWarn,
+		Message: fmt.Sprintf("%d transaction(s) older than %s; CREATE_REPLICATION_SLOT waits for them to finish", len(long), LongTxnThreshold),
+		Hint:    "Wait for them to finish or terminate them with pg_terminate_backend(pid) before starting the migration.",
+		Details: truncate(long),
+	}
+}
+
+func (c *Checker) checkUpgrade(ctx context.Context) Check {
+	advice, err := upgrade.NewAdvisor(c.source, c.dest, c.logger).Advise(ctx)
+	if err != nil {
+		return queryError("upgrade", err)
+	}
+	return evalUpgrade(advice)
+}
+
+func evalUpgrade(advice *upgrade.Advice) Check {
+	var details []string
+	for _, f := range advice.Findings {
+		if f.Severity == upgrade.SeverityInfo {
+			continue
+		}
+		d := fmt.Sprintf("[%s] %s", f.Severity, f.Title)
+		if len(f.Objects) > 0 {
+			d += ": " + strings.Join(truncate(f.Objects), ", ")
+		}
+		details = append(details, d)
+	}
+
+	versions := fmt.Sprintf("PostgreSQL %d → %d", advice.SourceVersion, advice.DestVersion)
+	switch advice.Worst() {
+	case upgrade.SeverityFail:
+		return Check{
+			Name:    "upgrade",
+			Status:  StatusFail,
+			Message: versions + ": source uses features removed or changed on the destination version",
+			Hint:    "Review the upgrade advice and fix the listed objects on the source before migrating.",
+			Details: details,
+		}
+	case upgrade.SeverityWarn:
+		return Check{
+			Name:    "upgrade",
+			Status:  StatusWarn,
+			Message: versions + ": behavior or defaults change on the destination version",
+			Hint:    "Review the upgrade advice; post-upgrade steps are listed there.",
+			Details: details,
+		}
+	default:
+		return Check{Name: "upgrade", Status: StatusPass, Message: versions + ": no incompatibilities found"}
+	}
+}
+
+// relationColumns returns the user relations of pool whose relkind is in
+// kinds by "schema.name" with their column names.
+func (c *Checker) relationColumns(ctx context.Context, pool *pgxpool.Pool, kinds string) (map[string][]string, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT n.nspname || '.' || c.relname, a.attname
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		LEFT JOIN pg_attribute a ON a.attrelid = c.oid AND a.attnum > 0 AND NOT a.attisdropped
+		WHERE strpos($1, c.relkind::text) > 0
+			AND n.nspname NOT IN ('pg_catalog', 'information_schema')
+			AND n.nspname NOT LIKE 'pg_toast%'
+		ORDER BY 1, a.attnum`, kinds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[string][]string)
+	for rows.Next() {
+		var rel string
+		var column *string
+		if err := rows.Scan(&rel, &column); err != nil {
+			return nil, err
+		}
+		cols := out[rel]
+		if column != nil {
+			cols = append(cols, *column)
+		}
+		out[rel] = cols
+	}
+	return out, rows.Err()
+}
+
+func (c *Checker) checkRemap(ctx context.Context) Check {
+	rels, err := c.relationColumns(ctx, c.source, remap.RelationKinds)
+	if err != nil {
+		return queryError("remap", err)
+	}
+	src, err := c.relationColumns(ctx, c.source, "rp")
+	if err != nil {
+		return queryError("remap", err)
+	}
+	dst, err := c.relationColumns(ctx, c.dest, "rp")
+	if err != nil {
+		return queryError("remap", err)
+	}
+	return evalRemap(c.remap, rels, src, dst)
+}
+
+// evalRemap checks the rules of m against the source's relations and the
+// source and destination tables: every rule must match a relation, no two
+// source tables may map to the same destination table, and a destination
+// table that already exists must have every mapped column.
+func evalRemap(m *remap.Map, rels, src, dst map[string][]string) Check {
+	if unmatched := m.Unmatched(rels); len(unmatched) > 0 {
+		return Check{
+			Name:    "remap",
+			Status:  StatusFail,
+			Message: fmt.Sprintf("%d remap rule(s) match nothing on the source", len(unmatched)),
+			Hint:    "Fix the names in the remap rules; they refer to source schemas, tables and columns.",
+			Details: truncate(unmatched),
+		}
+	}
+
+	tables := make([]string, 0, len(src))
+	for t := range src {
+		tables = append(tables, t)
+	}
+	sort.Strings(tables)
+
+	targets := make(map[string]string)
+	var collisions, missing []string
+	mapped := 0
+	for _, t := range tables {
+		schema, table, _ := strings.Cut(t, ".")
+		ds, dt := m.Table(schema, table)
+		target := ds + "." + dt
+		if target != t {
+			mapped++
+		}
+		if prev, ok := targets[target]; ok {
+			collisions = append(collisions, fmt.Sprintf("%s and %s both map to %s", prev, t, target))
+			continue
+		}
+		targets[target] = t
+
+		existing, ok := dst[target]
+		if !ok || target == t {
+			continue
+		}
+		for _, col := range m.Columns(schema, table, src[t]) {
+			if !slices.Contains(existing, col) {
+				missing = append(missing, fmt.Sprintf("%s.%s (from %s)", target, col, t))
+			}
+		}
+	}
+
+	switch {
+	case len(collisions) > 0:
+		return Check{
+			Name:    "remap",
+			Status:  StatusFail,
+			Message: fmt.Sprintf("%d destination table(s) would receive more than one source table", len(collisions)),
+			Hint:    "Give every source table its own destination name.",
+			Details: truncate(collisions),
+		}
+	case len(missing) > 0:
+		return Check{
+			Name:    "remap",
+			Status:  StatusFail,
+			Message: fmt.Sprintf("%d mapped column(s) are missing from destination tables that already exist", len(missing)),
+			Hint:    "Drop the destination tables so they are created from the source schema, or add the missing columns.",
+			Details: truncate(missing),
+		}
+	default:
+		return Check{Name: "remap", Status: StatusPass, Message: fmt.Sprintf("%d source table(s) map to a new name", mapped)}
+	}
+}
+
+func (c *Checker) listStrings(ctx context.Context, pool *pgxpool.Pool, query string) ([]string, error) {
+	rows, err := pool.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+func truncate(items []string) []string {
+	if len(items) <= maxDetails {
+		return items
+	}
+	out := append([]string{}, items[:maxDetails]...)
+	return append(out, fmt.Sprintf("... and %d more", len(items)-maxDetails))
+}
+
+func formatBytes(b int64) string {
+	const (
+		kb = 1024
+		mb = kb * 1024
+		gb = mb * 1024
+		tb = gb * 1024
+	)
+	switch {
+	case b >= tb:
+		return fmt.Sprintf("%.1f TB", float64(b)/float64(tb))
+	case b >= gb:
+		return fmt.Sprintf("%.1f GB", float64(b)/float64(gb))
+	case b >= mb:
+		return fmt.Sprintf("%.1f MB", float64(b)/float64(mb))
+	case b >= kb:
+		return fmt.Sprintf("%.1f kB", float64(b)/float64(kb))
+	default:
+		return fmt.Sprintf("%d B", b)
+	}
+}
@@ -0,0 +1,206 @@
+package preflight
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/jfoltran/pgmanager/internal/config"
+	"github.com/jfoltran/pgmanager/internal/migration/remap"
+	"github.com/jfoltran/pgmanager/internal/migration/upgrade"
+)
+
+func TestReport_Add(t *testing.T) {
+	r := &Report{}
+	r.Add(Check{Name: "a", Status: StatusPass})
+	r.Add(Check{Name: "b", Status: StatusWarn})
+	r.Add(Check{Name: "c", Status: StatusPass})
+	if !r.OK() {
+		t.Error("report with warnings only should be OK")
+	}
+	r.Add(Check{Name: "d", Status: StatusFail})
+
+	if r.Passed != 2 || r.Warnings != 1 || r.Failures != 1 {
+		t.Errorf("counts = %d/%d/%d, want 2/1/1", r.Passed, r.Warnings, r.Failures)
+	}
+	if r.OK() {
+		t.Error("report with a failure should not be OK")
+	}
+}
+
+func TestEvalWalLevel(t *testing.T) {
+	if c := evalWalLevel("logical"); c.Status != StatusPass {
+		t.Errorf("logical: %s", c.Status)
+	}
+	c := evalWalLevel("replica")
+	if c.Status != StatusFail || c.Hint == "" {
+		t.Errorf("replica: status=%s hint=%q", c.Status, c.Hint)
+	}
+}
+
+func TestEvalFree(t *testing.T) {
+	tests := []struct {
+		max, used int
+		want      Status
+	}{
+		{10, 2, StatusPass},
+		{10, 9, StatusWarn},
+		{10, 10, StatusFail},
+		{0, 0, StatusFail},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d/%d", tt.used, tt.max), func(t *testing.T) {
+			if got := evalFree("slots", "slots", tt.max, tt.used, "hint").Status; got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalExtensions(t *testing.T) {
+	available := map[string][]string{
+		"pgcrypto":  {"1.3"},
+		"hstore":    {"1.7", "1.8"},
+		"uuid-ossp": {"1.1"},
+	}
+
+	tests := []struct {
+		name string
+		src  []extension
+		want Status
+	}{
+		{"none", nil, StatusPass},
+		{"all available", []extension{{"pgcrypto", "1.3"}, {"hstore", "1.7"}}, StatusPass},
+		{"version differs", []extension{{"uuid-ossp", "1.0"}}, StatusWarn},
+		{"missing", []extension{{"postgis", "3.4.0"}, {"hstore", "1.8"}}, StatusFail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := evalExtensions(tt.src, available)
+			if c.Status != tt.want {
+				t.Errorf("status = %s, want %s (%s)", c.Status, tt.want, c.Message)
+			}
+			if c.Status != StatusPass && len(c.Details) == 0 {
+				t.Error("expected details for non-passing check")
+			}
+		})
+	}
+
+	// Missing and version-mismatched extensions share one capped list.
+	var many []extension
+	for i := range maxDetails {
+		many = append(many, extension{fmt.Sprintf("ext%d", i), "1.0"})
+	}
+	many = append(many, extension{"uuid-ossp", "1.0"})
+	c := evalExtensions(many, available)
+	if len(c.Details) != maxDetails+1 || c.Details[maxDetails] != "... and 1 more" {
+		t.Errorf("details = %v", c.Details)
+	}
+}
+
+func TestEvalEncoding(t *testing.T) {
+	utf8 := dbLocale{Encoding: "UTF8", Collate: "en_US.UTF-8", Ctype: "en_US.UTF-8"}
+
+	if c := evalEncoding(utf8, utf8); c.Status != StatusPass {
+		t.Errorf("same locale: %s", c.Status)
+	}
+	c := utf8
+	c.Collate = "C"
+	if got := evalEncoding(utf8, c).Status; got != StatusWarn {
+		t.Errorf("collation differs: %s", got)
+	}
+	latin := dbLocale{Encoding: "LATIN1", Collate: "C", Ctype: "C"}
+	if got := evalEncoding(utf8, latin).Status; got != StatusFail {
+		t.Errorf("encoding differs: %s", got)
+	}
+}
+
+func TestEvalDiskHeadroom(t *testing.T) {
+	if c := evalDiskHeadroom(10<<30, 8<<20); c.Status != StatusPass {
+		t.Errorf("empty dest: %s", c.Status)
+	}
+	if c := evalDiskHeadroom(10<<30, 5<<30); c.Status != StatusWarn {
+		t.Errorf("populated dest: %s", c.Status)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	items := make([]string, maxDetails+5)
+	got := truncate(items)
+	if len(got) != maxDetails+1 {
+		t.Fatalf("len = %d, want %d", len(got), maxDetails+1)
+	}
+	if got[maxDetails] != "... and 5 more" {
+		t.Errorf("last = %q", got[maxDetails])
+	}
+	if len(truncate(items[:3])) != 3 {
+		t.Error("short list should be unchanged")
+	}
+}
+
+func TestEvalUpgrade(t *testing.T) {
+	advice := &upgrade.Advice{SourceVersion: 12, DestVersion: 17}
+	if c := evalUpgrade(advice); c.Status != StatusPass {
+		t.Errorf("no findings: %s", c.Status)
+	}
+
+	advice.Findings = []upgrade.Finding{{Severity: upgrade.SeverityInfo, Title: "info only"}}
+	if c := evalUpgrade(advice); c.Status != StatusPass || len(c.Details) != 0 {
+		t.Errorf("info only: %s %v", c.Status, c.Details)
+	}
+
+	advice.Findings = append(advice.Findings, upgrade.Finding{Severity: upgrade.SeverityWarn, Title: "default changed"})
+	if c := evalUpgrade(advice); c.Status != StatusWarn || len(c.Details) != 1 {
+		t.Errorf("warn: %s %v", c.Status, c.Details)
+	}
+
+	advice.Findings = append(advice.Findings, upgrade.Finding{Severity: upgrade.SeverityFail, Title: "removed", Objects: []string{"public.f"}})
+	c := evalUpgrade(advice)
+	if c.Status != StatusFail || len(c.Details) != 2 || c.Details[1] != "[fail] removed: public.f" {
+		t.Errorf("fail: %s %v", c.Status, c.Details)
+	}
+}
+
+func TestEvalRemap(t *testing.T) {
+	m := remap.New(config.RemapConfig{
+		Tables:  []config.TableMapConfig{{From: "public.users", To: "auth.accounts"}},
+		Columns: []config.ColumnMapConfig{{Table: "public.users", From: "mail", To: "email"}},
+	})
+	src := map[string][]string{"public.users": {"id", "mail"}}
+
+	tests := []struct {
+		name string
+		src  map[string][]string
+		dst  map[string][]string
+		want Status
+	}{
+		{"new destination", src, nil, StatusPass},
+		{"existing destination", src, map[string][]string{"auth.accounts": {"id", "email"}}, StatusPass},
+		{"missing column", src, map[string][]string{"auth.accounts": {"id", "mail"}}, StatusFail},
+		{"unmatched rule", map[string][]string{"public.orders": {"id"}}, nil, StatusFail},
+		{"collision", map[string][]string{"public.users": {"id", "mail"}, "auth.accounts": {"id"}}, nil, StatusFail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := evalRemap(m, tt.src, tt.src, tt.dst)
+			if c.Status != tt.want {
+				t.Errorf("status = %s, want %s (%s)", c.Status, tt.want, c.Message)
+			}
+			if c.Status != StatusPass && len(c.Details) == 0 {
+				t.Error("expected details for non-passing check")
+			}
+		})
+	}
+
+	// A schema rule matches a schema that holds only views.
+	reports := remap.New(config.RemapConfig{
+		Schemas: []config.SchemaMapConfig{{From: "reports", To: "analytics"}},
+	})
+	rels := map[string][]string{"public.users": {"id"}, "reports.daily_totals": {"day", "total"}}
+	tables := map[string][]string{"public.users": {"id"}}
+	if c := evalRemap(reports, rels, tables, nil); c.Status != StatusPass {
+		t.Errorf("view-only schema: %s (%s %v)", c.Status, c.Message, c.Details)
+	}
+	if c := evalRemap(reports, tables, tables, nil); c.Status != StatusFail {
+		t.Errorf("empty schema: %s", c.Status)
+	}
+}
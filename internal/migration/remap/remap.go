@@ -0,0 +1,618 @@
+// Package remap maps source schemas, tables and columns onto their
+// destination names: user-defined renames, or consolidating several source
+// databases into one destination.
+package remap
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog"
+
+	"github.com/jfoltran/pgmanager/internal/config"
+	"github.com/jfoltran/pgmanager/internal/migration/stream"
+)
+
+// textOID is the type OID of the discriminator column.
+const textOID = 25
+
+// name is a qualified table name.
+type name struct {
+	schema, table string
+}
+
+// Map rewrites the destination of a source's rows. A nil Map leaves every
+// name unchanged.
+type Map struct {
+	// Consolidation: the destination schema of the source's public
+	// schema, or a discriminator column and its value.
+	schema string
+	column string
+	value  string
+
+	// Rules: source schema to destination schema, source "schema.table"
+	// to destination table, and source "schema.table" to column renames.
+	schemas map[string]string
+	tables  map[string]name
+	columns map[string]map[string]string
+}
+
+// New creates a Map from the rules of a RemapConfig normalised by
+// config.Validate.
+func New(cfg config.RemapConfig) *Map {
+	m := &Map{
+		schemas: make(map[string]string),
+		tables:  make(map[string]name),
+		columns: make(map[string]map[string]string),
+	}
+	for _, s := range cfg.Schemas {
+		m.schemas[s.From] = s.To
+	}
+	for _, t := range cfg.Tables {
+		schema, table, _ := strings.Cut(t.To, ".")
+		m.tables[t.From] = name{schema, table}
+	}
+	for _, c := range cfg.Columns {
+		if m.columns[c.Table] == nil {
+			m.columns[c.Table] = make(map[string]string)
+		}
+		m.columns[c.Table][c.From] = c.To
+	}
+	return m
+}
+
+// ToSchema moves a source into its own destination schema: public maps to
+// schema and any other schema s maps to schema_s.
+func ToSchema(schema string) *Map {
+	return &Map{schema: schema}
+}
+
+// WithColumn keeps a source's table names and adds column, holding value,
+// to every row it writes.
+func WithColumn(column, value string) *Map {
+	return &Map{column: column, value: value}
+}
+
+// Table returns the destination schema and name of a source table.
+func (m *Map) Table(schema, table string) (string, string) {
+	if m == nil {
+		return schema, table
+	}
+	if t, ok := m.tables[schema+"."+table]; ok {
+		return t.schema, t.table
+	}
+	if s, ok := m.schemas[schema]; ok {
+		return s, table
+	}
+	if m.schema == "" {
+		return schema, table
+	}
+	if schema == "" || schema == "public" {
+		return m.schema, table
+	}
+	return m.schema + "_" + schema, table
+}
+
+// Columns returns the destination names of columns of a source table. The
+// result is names itself when none is renamed.
+func (m *Map) Columns(schema, table string, names []string) []string {
+	if m == nil || len(m.columns[schema+"."+table]) == 0 {
+		return names
+	}
+	renames := m.columns[schema+"."+table]
+	out := make([]string, len(names))
+	for i, n := range names {
+		if to, ok := renames[n]; ok {
+			n = to
+		}
+		out[i] = n
+	}
+	return out
+}
+
+// Discriminator returns the column added to every row and its value, or
+// empty strings when the map adds none.
+func (m *Map) Discriminator() (column, value string) {
+	if m == nil {
+		return "", ""
+	}
+	return m.column, m.value
+}
+
+// Change returns c as written to the destination. The original is not
+// modified.
+func (m *Map) Change(c *stream.ChangeMessage) *stream.ChangeMessage {
+	if m == nil {
+		return c
+	}
+	schema, table := m.Table(c.Namespace, c.Table)
+	renames := m.columns[c.Namespace+"."+c.Table]
+	if schema == c.Namespace && table == c.Table && len(renames) == 0 && m.column == "" {
+		return c
+	}
+	out := *c
+	out.Namespace, out.Table = schema, table
+	if len(renames) > 0 || m.column != "" {
+		out.NewTuple = m.tuple(c.NewTuple, renames)
+		out.OldTuple = m.tuple(c.OldTuple, renames)
+	}
+	return &out
+}
+
+// tuple returns a copy of t with its columns renamed and the discriminator
+// appended. The discriminator is part of the key so that updates and
+// deletes only touch this source's rows.
+func (m *Map) tuple(t *stream.TupleData, renames map[string]string) *stream.TupleData {
+	if t == nil {
+		return nil
+	}
+	cols := make([]stream.Column, len(t.Columns), len(t.Columns)+1)
+	copy(cols, t.Columns)
+	for i := range cols {
+		if to, ok := renames[cols[i].Name]; ok {
+			cols[i].Name = to
+		}
+	}
+	if m.column != "" {
+		cols = append(cols, stream.Column{Name: m.column, DataType: textOID, Value: []byte(m.value), Key: true})
+	}
+	return &stream.TupleData{Columns: cols}
+}
+
+// Unmatched lists the rules that match nothing in relations, the source's
+// relations of RelationKinds by "schema.name" with their columns.
+func (m *Map) Unmatched(relations map[string][]string) []string {
+	if m == nil {
+		return nil
+	}
+	var out []string
+	for schema := range m.schemas {
+		found := false
+		for t := range relations {
+			if strings.HasPrefix(t, schema+".") {
+				found = true
+				break
+			}
+		}
+		if !found {
+			out = append(out, "schema "+schema)
+		}
+	}
+	for t := range m.tables {
+		if _, ok := relations[t]; !ok {
+			out = append(out, "table "+t)
+		}
+	}
+	for t, renames := range m.columns {
+		cols, ok := relations[t]
+		for from := range renames {
+			if !ok || !slices.Contains(cols, from) {
+				out = append(out, "column "+t+"."+from)
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Prepare rearranges the source's schema, already applied to dest under
+// the source's names, for the map. It moves and renames relations into
+// their destination schemas and names, renames columns, and adds the
+// discriminator column to every table that lacks it. Functions, types and
+// extensions stay where they were created.
+func (m *Map) Prepare(ctx context.Context, source, dest *pgxpool.Pool, logger zerolog.Logger) error {
+	if m == nil {
+		return nil
+	}
+	log := logger.With().Str("component", "remap").Logger()
+	if m.column != "" {
+		if err := m.addColumn(ctx, source, dest, log); err != nil {
+			return err
+		}
+	}
+	if err := m.moveRelations(ctx, source, dest, log, false); err != nil {
+		return err
+	}
+	return m.renameColumns(ctx, dest, log, false)
+}
+
+// Revert moves and renames what Prepare changed back to the source's
+// names, so that the source's schema can be applied to dest again. Objects
+// that are not in their destination place are left alone.
+func (m *Map) Revert(ctx context.Context, source, dest *pgxpool.Pool, logger zerolog.Logger) error {
+	if m == nil {
+		return nil
+	}
+	log := logger.With().Str("component", "remap").Logger()
+	if err := m.renameColumns(ctx, dest, log, true); err != nil {
+		return err
+	}
+	return m.moveRelations(ctx, source, dest, log, true)
+}
+
+type relation struct {
+	schema, name string
+	kind         byte
+	partition    bool
+}
+
+// listRelations returns the source's user relations of the given kinds.
+// Sequences owned by a column move with their table and are left out, as
+// are extension members.
+func listRelations(ctx context.Context, pool *pgxpool.Pool, kinds string) ([]relation, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT n.nspname, c.relname, c.relkind::text, c.relispartition
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE strpos($1, c.relkind::text) > 0
+			AND n.nspname NOT IN ('pg_catalog', 'information_schema')
+			AND n.nspname NOT LIKE 'pg_toast%'
+			AND n.nspname NOT LIKE 'pg_temp%'
+			AND NOT EXISTS (
+				SELECT 1 FROM pg_depend d
+				WHERE d.classid = 'pg_class'::regclass AND d.objid = c.oid
+					AND (d.deptype = 'e' OR (c.relkind = 'S' AND d.deptype IN ('a', 'i'))))
+		ORDER BY n.nspname, c.relname`, kinds)
+	if err != nil {
+		return nil, fmt.Errorf("list relations: %w", err)
+	}
+	defer rows.Close()
+
+	var rels []relation
+	for rows.Next() {
+		var r relation
+		var kind string
+		if err := rows.Scan(&r.schema, &r.name, &kind, &r.partition); err != nil {
+			return nil, fmt.Errorf("scan relation: %w", err)
+		}
+		r.kind = kind[0]
+		rels = append(rels, r)
+	}
+	return rels, rows.Err()
+}
+
+// RelationKinds are the pg_class relkinds Prepare moves: tables,
+// partitioned and foreign tables, views, materialized views and sequences.
+const RelationKinds = "rpfvmS"
+
+var alterKind = map[byte]string{
+	'r': "TABLE",
+	'p': "TABLE",
+	'f': "FOREIGN TABLE",
+	'v': "VIEW",
+	'm': "MATERIALIZED VIEW",
+	'S': "SEQUENCE",
+}
+
+// moveRelations moves every mapped relation from its source name to its
+// destination name, or back when reverse is set.
+func (m *Map) moveRelations(ctx context.Context, source, dest *pgxpool.Pool, log zerolog.Logger, reverse bool) error {
+	rels, err := listRelations(ctx, source, RelationKinds)
+	if err != nil {
+		return err
+	}
+	moved := 0
+	for _, r := range rels {
+		schema, table := m.Table(r.schema, r.name)
+		from, to := name{r.schema, r.name}, name{schema, table}
+		if from == to {
+			continue
+		}
+		if reverse {
+			from, to = to, from
+		}
+		fromExists, err := relationExists(ctx, dest, from)
+		if err != nil {
+			return err
+		}
+		if !fromExists {
+			continue
+		}
+		toExists, err := relationExists(ctx, dest, to)
+		if err != nil {
+			return err
+		}
+		if toExists {
+			log.Warn().Str("from", from.String()).Str("to", to.String()).Msg("both names exist on the destination, leaving the relation in place")
+			continue
+		}
+		if err := moveRelation(ctx, dest, alterKind[r.kind], from, to); err != nil {
+			return fmt.Errorf("move %s to %s: %w", from, to, err)
+		}
+		moved++
+	}
+	if moved > 0 {
+		log.Info().Int("relations", moved).Bool("revert", reverse).Msg("moved relations to their mapped names")
+	}
+	return nil
+}
+
+func moveRelation(ctx context.Context, dest *pgxpool.Pool, kind string, from, to name) error {
+	if from.schema != to.schema {
+		if _, err := dest.Exec(ctx, "CREATE SCHEMA IF NOT EXISTS "+quoteIdent(to.schema)); err != nil {
+			return fmt.Errorf("create schema %s: %w", to.schema, err)
+		}
+		if _, err := dest.Exec(ctx, fmt.Sprintf("ALTER %s %s SET SCHEMA %s", kind, from.quoted(), quoteIdent(to.schema))); err != nil {
+			return err
+		}
+	}
+	if from.table != to.table {
+		moved := name{to.schema, from.table}
+		if _, err := dest.Exec(ctx, fmt.Sprintf("ALTER %s %s RENAME TO %s", kind, moved.quoted(), quoteIdent(to.table))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func relationExists(ctx context.Context, pool *pgxpool.Pool, n name) (bool, error) {
+	var exists bool
+	if err := pool.QueryRow(ctx, "SELECT to_regclass($1) IS NOT NULL", n.quoted()).Scan(&exists); err != nil {
+		return false, fmt.Errorf("look up %s: %w", n, err)
+	}
+	return exists, nil
+}
+
+// renameColumns renames the mapped columns of every destination table, or
+// back when reverse is set. Columns already renamed are skipped.
+func (m *Map) renameColumns(ctx context.Context, dest *pgxpool.Pool, log zerolog.Logger, reverse bool) error {
+	tables := make([]string, 0, len(m.columns))
+	for t := range m.columns {
+		tables = append(tables, t)
+	}
+	sort.Strings(tables)
+
+	renamed := 0
+	for _, t := range tables {
+		schema, table, _ := strings.Cut(t, ".")
+		schema, table = m.Table(schema, table)
+		target := name{schema, table}
+		for from, to := range m.columns[t] {
+			if reverse {
+				from, to = to, from
+			}
+			var hasFrom, hasTo bool
+			err := dest.QueryRow(ctx, `
+				SELECT
+					EXISTS (SELECT 1 FROM pg_attribute WHERE attrelid = to_regclass($1) AND attname = $2 AND attnum > 0 AND NOT attisdropped),
+					EXISTS (SELECT 1 FROM pg_attribute WHERE attrelid = to_regclass($1) AND attname = $3 AND attnum > 0 AND NOT attisdropped)`,
+				target.quoted(), from, to).Scan(&hasFrom, &hasTo)
+			if err != nil {
+				return fmt.Errorf("look up columns of %s: %w", target, err)
+			}
+			if !hasFrom || hasTo {
+				continue
+			}
+			stmt := fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s", target.quoted(), quoteIdent(from), quoteIdent(to))
+			if _, err := dest.Exec(ctx, stmt); err != nil {
+				return fmt.Errorf("rename column %s of %s: %w", from, target, err)
+			}
+			renamed++
+		}
+	}
+	if renamed > 0 {
+		log.Info().Int("columns", renamed).Bool("revert", reverse).Msg("renamed mapped columns")
+	}
+	return nil
+}
+
+func (m *Map) addColumn(ctx context.Context, source, dest *pgxpool.Pool, log zerolog.Logger) error {
+	rels, err := listRelations(ctx, source, "rp")
+	if err != nil {
+		return err
+	}
+	var tables []string
+	for _, r := range rels {
+		// Partitions inherit the column from their parent.
+		if r.partition {
+			continue
+		}
+		stmt := fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s text",
+			name{r.schema, r.name}.quoted(), quoteIdent(m.column))
+		if _, err := dest.Exec(ctx, stmt); err != nil {
+			return fmt.Errorf("add column %s to %s.%s: %w", m.column, r.schema, r.name, err)
+		}
+		tables = append(tables, name{r.schema, r.name}.quoted())
+	}
+	log.Info().Int("tables", len(tables)).Str("column", m.column).Msg("added discriminator column")
+	return m.widenKeys(ctx, dest, tables, log)
+}
+
+// key is a primary key or unique constraint.
+type key struct {
+	table, name string // quoted
+	primary     bool
+	columns     []string // quoted
+	deferrable  string
+}
+
+// foreignKey is a foreign key constraint referencing a key.
+type foreignKey struct {
+	table, name        string // quoted
+	columns            []string
+	refTable           string
+	refColumns         []string
+	onUpdate, onDelete byte
+	match              byte
+	deferrable         string
+}
+
+// keyColumns lists the quoted columns of a constraint key array in order.
+const keyColumns = `ARRAY(SELECT quote_ident(a.attname)
+	FROM unnest(%s) WITH ORDINALITY k(attnum, ord)
+	JOIN pg_attribute a ON a.attrelid = %s AND a.attnum = k.attnum
+	ORDER BY k.ord)`
+
+// widenKeys adds the discriminator column to the primary key and unique
+// constraints of tables, so that the sources' rows can share key values,
+// and rebuilds the foreign keys referencing them to match on it too.
+// Constraints that already include the column are left alone.
+func (m *Map) widenKeys(ctx context.Context, dest *pgxpool.Pool, tables []string, log zerolog.Logger) error {
+	var version int
+	if err := dest.QueryRow(ctx, "SELECT current_setting('server_version_num')::int").Scan(&version); err != nil {
+		return fmt.Errorf("destination version: %w", err)
+	}
+
+	rows, err := dest.Query(ctx, `
+		SELECT con.conrelid::regclass::text, quote_ident(con.conname), con.contype = 'p',
+			`+fmt.Sprintf(keyColumns, "con.conkey", "con.conrelid")+`,
+			con.condeferrable, con.condeferred
+		FROM pg_constraint con
+		JOIN pg_attribute d ON d.attrelid = con.conrelid AND d.attname = $2 AND NOT d.attisdropped
+		WHERE con.contype IN ('p', 'u') AND con.conparentid = 0
+			AND con.conrelid IN (SELECT to_regclass(t) FROM unnest($1::text[]) t)
+			AND NOT d.attnum = ANY(con.conkey)
+		ORDER BY 1, 2`, tables, m.column)
+	if err != nil {
+		return fmt.Errorf("list keys: %w", err)
+	}
+	var keys []key
+	for rows.Next() {
+		var k key
+		var deferrable, deferred bool
+		if err := rows.Scan(&k.table, &k.name, &k.primary, &k.columns, &deferrable, &deferred); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan key: %w", err)
+		}
+		k.deferrable = deferrableClause(deferrable, deferred)
+		keys = append(keys, k)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("list keys: %w", err)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	rows, err = dest.Query(ctx, `
+		SELECT con.conrelid::regclass::text, quote_ident(con.conname),
+			`+fmt.Sprintf(keyColumns, "con.conkey", "con.conrelid")+`,
+			con.confrelid::regclass::text,
+			`+fmt.Sprintf(keyColumns, "con.confkey", "con.confrelid")+`,
+			con.confupdtype::text, con.confdeltype::text, con.confmatchtype::text,
+			con.condeferrable, con.condeferred
+		FROM pg_constraint con
+		JOIN pg_attribute d ON d.attrelid = con.confrelid AND d.attname = $2 AND NOT d.attisdropped
+		WHERE con.contype = 'f' AND con.conparentid = 0
+			AND con.confrelid IN (SELECT to_regclass(t) FROM unnest($1::text[]) t)
+			AND NOT d.attnum = ANY(con.confkey)
+		ORDER BY 1, 2`, tables, m.column)
+	if err != nil {
+		return fmt.Errorf("list foreign keys: %w", err)
+	}
+	var fks []foreignKey
+	for rows.Next() {
+		var fk foreignKey
+		var onUpdate, onDelete, match string
+		var deferrable, deferred bool
+		if err := rows.Scan(&fk.table, &fk.name, &fk.columns, &fk.refTable, &fk.refColumns,
+			&onUpdate, &onDelete, &match, &deferrable, &deferred); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan foreign key: %w", err)
+		}
+		fk.onUpdate, fk.onDelete, fk.match = onUpdate[0], onDelete[0], match[0]
+		fk.deferrable = deferrableClause(deferrable, deferred)
+		fks = append(fks, fk)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("list foreign keys: %w", err)
+	}
+
+	// Foreign keys depend on the key they reference, so they are dropped
+	// first and added back once the keys include the column.
+	var stmts []string
+	for _, fk := range fks {
+		stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s", fk.table, fk.name))
+	}
+	for _, k := range keys {
+		stmts = append(stmts, keyStmt(k, m.column))
+	}
+	for _, fk := range fks {
+		stmts = append(stmts, foreignKeyStmt(fk, m.column, version >= 150000))
+	}
+
+	tx, err := dest.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(ctx, stmt); err != nil {
+			return fmt.Errorf("%s: %w", stmt, err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit keys: %w", err)
+	}
+	log.Info().Int("keys", len(keys)).Int("foreign_keys", len(fks)).Str("column", m.column).
+		Msg("added discriminator column to keys")
+	return nil
+}
+
+// keyStmt replaces k with the same key followed by column.
+func keyStmt(k key, column string) string {
+	kind := "UNIQUE"
+	if k.primary {
+		kind = "PRIMARY KEY"
+	}
+	cols := append(slices.Clone(k.columns), quoteIdent(column))
+	return fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s, ADD CONSTRAINT %s %s (%s)%s",
+		k.table, k.name, k.name, kind, strings.Join(cols, ", "), k.deferrable)
+}
+
+var fkActions = map[byte]string{
+	'a': "NO ACTION",
+	'r': "RESTRICT",
+	'c': "CASCADE",
+	'n': "SET NULL",
+	'd': "SET DEFAULT",
+}
+
+// foreignKeyStmt adds fk back with column on both sides. When setColumns
+// is true (PostgreSQL 15 and later), ON DELETE SET NULL and SET DEFAULT are
+// limited to fk's own columns, as column is part of the referencing table's
+// key and cannot be cleared.
+func foreignKeyStmt(fk foreignKey, column string, setColumns bool) string {
+	disc := quoteIdent(column)
+	cols := append(slices.Clone(fk.columns), disc)
+	refCols := append(slices.Clone(fk.refColumns), disc)
+	stmt := fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s (%s)",
+		fk.table, fk.name, strings.Join(cols, ", "), fk.refTable, strings.Join(refCols, ", "))
+	if fk.match == 'f' {
+		stmt += " MATCH FULL"
+	}
+	stmt += " ON UPDATE " + fkActions[fk.onUpdate] + " ON DELETE " + fkActions[fk.onDelete]
+	if setColumns && (fk.onDelete == 'n' || fk.onDelete == 'd') {
+		stmt += " (" + strings.Join(fk.columns, ", ") + ")"
+	}
+	return stmt + fk.deferrable
+}
+
+func deferrableClause(deferrable, deferred bool) string {
+	switch {
+	case deferred:
+		return " DEFERRABLE INITIALLY DEFERRED"
+	case deferrable:
+		return " DEFERRABLE"
+	default:
+		return ""
+	}
+}
+
+func (n name) String() string {
+	return n.schema + "." + n.table
+}
+
+func (n name) quoted() string {
+	return quoteIdent(n.schema) + "." + quoteIdent(n.table)
+}
+
+func quoteIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
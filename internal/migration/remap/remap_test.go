@@ -0,0 +1,177 @@
+package remap
+
+import (
+	"testing"
+
+	"github.com/jfoltran/pgmanager/internal/config"
+	"github.com/jfoltran/pgmanager/internal/migration/stream"
+)
+
+func TestTableToSchema(t *testing.T) {
+	m := ToSchema("acme")
+	for _, tc := range []struct{ schema, want string }{
+		{"public", "acme"},
+		{"", "acme"},
+		{"billing", "acme_billing"},
+	} {
+		if got, name := m.Table(tc.schema, "orders"); got != tc.want || name != "orders" {
+			t.Errorf("%q: got %s.%s, want %s.orders", tc.schema, got, name, tc.want)
+		}
+	}
+	var none *Map
+	if got, _ := none.Table("billing", "orders"); got != "billing" {
+		t.Errorf("nil map moved table to %s", got)
+	}
+}
+
+func TestChangeAddsColumn(t *testing.T) {
+	m := WithColumn("tenant", "acme")
+	c := &stream.ChangeMessage{Op: stream.OpUpdate, Namespace: "public", Table: "orders",
+		OldTuple: &stream.TupleData{Columns: []stream.Column{{Name: "id", Value: []byte("1"), Key: true}}},
+		NewTuple: &stream.TupleData{Columns: []stream.Column{{Name: "id", Value: []byte("1"), Key: true}, {Name: "total", Value: []byte("9")}}},
+	}
+	out := m.Change(c)
+	if out.Namespace != "public" || out.Table != "orders" {
+		t.Errorf("column map renamed table to %s.%s", out.Namespace, out.Table)
+	}
+	for _, tuple := range []*stream.TupleData{out.OldTuple, out.NewTuple} {
+		last := tuple.Columns[len(tuple.Columns)-1]
+		if last.Name != "tenant" || string(last.Value) != "acme" || !last.Key {
+			t.Errorf("expected tenant key column, got %+v", last)
+		}
+	}
+	if len(c.NewTuple.Columns) != 2 || len(c.OldTuple.Columns) != 1 {
+		t.Error("Change modified the original tuples")
+	}
+}
+
+func TestChangeToSchema(t *testing.T) {
+	c := &stream.ChangeMessage{Op: stream.OpDelete, Namespace: "public", Table: "orders"}
+	out := ToSchema("acme").Change(c)
+	if out.Namespace != "acme" || c.Namespace != "public" || out.OldTuple != nil {
+		t.Errorf("got %s, original %s", out.Namespace, c.Namespace)
+	}
+	if (*Map)(nil).Change(c) != c {
+		t.Error("nil map copied the change")
+	}
+}
+
+func testRules() *Map {
+	return New(config.RemapConfig{
+		Schemas: []config.SchemaMapConfig{{From: "public", To: "app"}},
+		Tables:  []config.TableMapConfig{{From: "public.users", To: "auth.accounts"}},
+		Columns: []config.ColumnMapConfig{{Table: "public.orders", From: "amt", To: "amount"}},
+	})
+}
+
+func TestTableRules(t *testing.T) {
+	m := testRules()
+	for _, tc := range []struct{ schema, table, want string }{
+		{"public", "users", "auth.accounts"},
+		{"public", "orders", "app.orders"},
+		{"billing", "invoices", "billing.invoices"},
+	} {
+		if s, n := m.Table(tc.schema, tc.table); s+"."+n != tc.want {
+			t.Errorf("%s.%s: got %s.%s, want %s", tc.schema, tc.table, s, n, tc.want)
+		}
+	}
+}
+
+func TestColumns(t *testing.T) {
+	m := testRules()
+	names := []string{"id", "amt"}
+	got := m.Columns("public", "orders", names)
+	if got[0] != "id" || got[1] != "amount" || names[1] != "amt" {
+		t.Errorf("got %v, original %v", got, names)
+	}
+	if got := m.Columns("public", "users", names); &got[0] != &names[0] {
+		t.Error("unrenamed columns were copied")
+	}
+}
+
+func TestChangeRenamesColumns(t *testing.T) {
+	c := &stream.ChangeMessage{Op: stream.OpInsert, Namespace: "public", Table: "orders",
+		NewTuple: &stream.TupleData{Columns: []stream.Column{{Name: "id", Value: []byte("1"), Key: true}, {Name: "amt", Value: []byte("9")}}},
+	}
+	out := testRules().Change(c)
+	if out.Namespace != "app" || out.Table != "orders" {
+		t.Errorf("got table %s.%s", out.Namespace, out.Table)
+	}
+	if len(out.NewTuple.Columns) != 2 || out.NewTuple.Columns[1].Name != "amount" {
+		t.Errorf("got columns %+v", out.NewTuple.Columns)
+	}
+	if c.NewTuple.Columns[1].Name != "amt" {
+		t.Error("Change modified the original tuple")
+	}
+
+	same := &stream.ChangeMessage{Op: stream.OpInsert, Namespace: "billing", Table: "invoices"}
+	if testRules().Change(same) != same {
+		t.Error("unmapped change was copied")
+	}
+}
+
+func TestUnmatched(t *testing.T) {
+	m := testRules()
+	tables := map[string][]string{
+		"public.users":  {"id"},
+		"public.orders": {"id", "amt"},
+	}
+	if got := m.Unmatched(tables); len(got) != 0 {
+		t.Errorf("unexpected unmatched rules %v", got)
+	}
+	got := m.Unmatched(map[string][]string{"public.orders": {"id"}})
+	want := []string{"column public.orders.amt", "table public.users"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// A schema holding only a view or a sequence still has relations to move.
+	views := New(config.RemapConfig{
+		Schemas: []config.SchemaMapConfig{{From: "reports", To: "analytics"}, {From: "ids", To: "app"}},
+	})
+	rels := map[string][]string{
+		"reports.daily_totals": {"day", "total"},
+		"ids.ticket_seq":       {"last_value", "log_cnt", "is_called"},
+	}
+	if got := views.Unmatched(rels); len(got) != 0 {
+		t.Errorf("unexpected unmatched rules %v", got)
+	}
+	if got := views.Unmatched(map[string][]string{"reports.daily_totals": nil}); len(got) != 1 || got[0] != "schema ids" {
+		t.Errorf("got %v, want [schema ids]", got)
+	}
+}
+
+func TestKeyStmt(t *testing.T) {
+	k := key{table: "public.orders", name: "orders_pkey", primary: true, columns: []string{"id"}}
+	want := `ALTER TABLE public.orders DROP CONSTRAINT orders_pkey, ADD CONSTRAINT orders_pkey PRIMARY KEY (id, "tenant")`
+	if got := keyStmt(k, "tenant"); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	k = key{table: "public.orders", name: "orders_ref_key", columns: []string{"region", "ref"}, deferrable: deferrableClause(true, false)}
+	want = `ALTER TABLE public.orders DROP CONSTRAINT orders_ref_key, ADD CONSTRAINT orders_ref_key UNIQUE (region, ref, "tenant") DEFERRABLE`
+	if got := keyStmt(k, "tenant"); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestForeignKeyStmt(t *testing.T) {
+	fk := foreignKey{
+		table: "public.items", name: "items_order_id_fkey", columns: []string{"order_id"},
+		refTable: "public.orders", refColumns: []string{"id"},
+		onUpdate: 'a', onDelete: 'n', match: 's',
+	}
+	want := `ALTER TABLE public.items ADD CONSTRAINT items_order_id_fkey FOREIGN KEY (order_id, "tenant") REFERENCES public.orders (id, "tenant") ON UPDATE NO ACTION ON DELETE SET NULL (order_id)`
+	if got := foreignKeyStmt(fk, "tenant", true); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if got := foreignKeyStmt(fk, "tenant", false); got != want[:len(want)-len(" (order_id)")] {
+		t.Errorf("got %s without column lists", got)
+	}
+
+	fk.onDelete, fk.match, fk.deferrable = 'c', 'f', deferrableClause(true, true)
+	want = `ALTER TABLE public.items ADD CONSTRAINT items_order_id_fkey FOREIGN KEY (order_id, "tenant") REFERENCES public.orders (id, "tenant") MATCH FULL ON UPDATE NO ACTION ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED`
+	if got := foreignKeyStmt(fk, "tenant", true); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -2,6 +2,7 @@ package replay
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
@@ -12,15 +13,20 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/rs/zerolog"
 
+	"github.com/jfoltran/pgmanager/internal/migration/conflict"
+	"github.com/jfoltran/pgmanager/internal/migration/remap"
 	"github.com/jfoltran/pgmanager/internal/migration/sentinel"
 	"github.com/jfoltran/pgmanager/internal/migration/stream"
 )
 
 const (
 	insertBatchSize = 1000
-	copyThreshold   = 5
-	coalesceTxLimit = 500
-	coalesceMaxWait = 50 * time.Millisecond
+	// insertBatchBytes flushes a batch of wide rows before it reaches
+	// insertBatchSize.
+	insertBatchBytes = 8 << 20
+	copyThreshold    = 5
+	coalesceTxLimit  = 500
+	coalesceMaxWait  = 50 * time.Millisecond
 )
 
 // Applier reads Messages from a channel and applies DML to the destination.
@@ -33,6 +39,10 @@ type Applier struct {
 
 	relations map[uint32]*stream.RelationMessage
 	stmtCache map[string]string
+	resolver  *conflict.Resolver
+	origin    string
+	budget    *stream.Budget
+	remap     *remap.Map
 
 	txCount   int64
 	lastLogAt time.Time
@@ -48,6 +58,68 @@ func NewApplier(pool *pgxpool.Pool, logger zerolog.Logger) *Applier {
 	}
 }
 
+// SetResolver enables last-writer-wins conflict resolution. Changes are then
+// applied row by row against the current local row, every source transaction
+// is committed on its own, and each commit is stamped with the source commit
+// time through pg_replication_origin_xact_setup, so the pool's sessions must
+// have a replication origin set up. Call before Start.
+func (a *Applier) SetResolver(r *conflict.Resolver) {
+	a.resolver = r
+}
+
+// SetOrigin makes every destination transaction record the last source
+// commit it applies as the progress of the named replication origin, in the
+// same transaction, so OriginProgress tells exactly what was applied after
+// a crash. Transactions run on a connection of their own with the origin
+// set up. Not for use with SetResolver. Call before Start.
+func (a *Applier) SetOrigin(name string) {
+	a.origin = name
+}
+
+// OriginProgress returns the source commit LSN the named replication
+// origin has recorded on the destination, or 0 if it does not exist.
+func OriginProgress(ctx context.Context, pool *pgxpool.Pool, name string) (pglogrepl.LSN, error) {
+	var lsn *string
+	err := pool.QueryRow(ctx,
+		"SELECT pg_replication_origin_progress(roname, true)::text FROM pg_replication_origin WHERE roname = $1",
+		name).Scan(&lsn)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && lsn == nil) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("read replication origin %s: %w", name, err)
+	}
+	return pglogrepl.ParseLSN(*lsn)
+}
+
+// beginOrigin takes a connection out of the pool and sets up the
+// replication origin on it. The caller closes it.
+func (a *Applier) beginOrigin(ctx context.Context) (*pgx.Conn, error) {
+	pc, err := a.pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquire origin connection: %w", err)
+	}
+	conn := pc.Hijack()
+	if _, err := conn.Exec(ctx,
+		"SELECT pg_replication_origin_create($1) WHERE NOT EXISTS (SELECT 1 FROM pg_replication_origin WHERE roname = $1)",
+		a.origin); err != nil {
+		_ = conn.Close(ctx)
+		return nil, fmt.Errorf("create replication origin %s: %w", a.origin, err)
+	}
+	if _, err := conn.Exec(ctx, "SELECT pg_replication_origin_session_setup($1)", a.origin); err != nil {
+		_ = conn.Close(ctx)
+		return nil, fmt.Errorf("set up replication origin %s: %w", a.origin, err)
+	}
+	return conn, nil
+}
+
+// SetBudget makes the applier release each message's size to b as it takes
+// the message off the channel. Set it when the applier is the final
+// consumer of a budgeted source. Call before Start.
+func (a *Applier) SetBudget(b *stream.Budget) {
+	a.budget = b
+}
+
 // OnApplied is a callback invoked after a commit message has been applied.
 type OnApplied func(lsn pglogrepl.LSN)
 
@@ -60,6 +132,7 @@ type insertBatch struct {
 	table     string
 	cols      []string
 	rows      [][]any
+	bytes     int64
 }
 
 func (b *insertBatch) add(m *stream.ChangeMessage) {
@@ -75,6 +148,7 @@ func (b *insertBatch) add(m *stream.ChangeMessage) {
 	row := make([]any, len(m.NewTuple.Columns))
 	for i, c := range m.NewTuple.Columns {
 		row[i] = string(c.Value)
+		b.bytes += int64(len(c.Value))
 	}
 	b.rows = append(b.rows, row)
 }
@@ -92,6 +166,13 @@ func (b *insertBatch) reset(namespace, table string) {
 	b.table = table
 	b.cols = nil
 	b.rows = b.rows[:0]
+	b.bytes = 0
+}
+
+// SetMap rewrites every change to its destination table through m before
+// it is applied. Call before Start.
+func (a *Applier) SetMap(m *remap.Map) {
+	a.remap = m
 }
 
 // Start consumes messages and applies them to the destination database.
@@ -103,6 +184,17 @@ func (a *Applier) Start(ctx context.Context, messages <-chan stream.Message, onA
 	var pendingCommits []pglogrepl.LSN
 	var coalescedTx int
 	var txStartTime time.Time
+	var remoteTime, commitTime time.Time
+
+	begin := a.pool.Begin
+	if a.origin != "" {
+		conn, err := a.beginOrigin(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close(context.Background())
+		begin = conn.Begin
+	}
 
 	commitCoalesced := func() error {
 		if tx == nil {
@@ -115,6 +207,16 @@ func (a *Applier) Start(ctx context.Context, messages <-chan stream.Message, onA
 			coalescedTx = 0
 			return err
 		}
+		if a.origin != "" && len(pendingCommits) > 0 {
+			if _, err := tx.Exec(ctx, "SELECT pg_replication_origin_xact_setup($1::pg_lsn, $2)",
+				pendingCommits[len(pendingCommits)-1].String(), commitTime); err != nil {
+				_ = tx.Rollback(ctx)
+				tx = nil
+				pendingCommits = pendingCommits[:0]
+				coalescedTx = 0
+				return fmt.Errorf("record applied position: %w", err)
+			}
+		}
 		if err := tx.Commit(ctx); err != nil {
 			tx = nil
 			pendingCommits = pendingCommits[:0]
@@ -171,6 +273,7 @@ func (a *Applier) Start(ctx context.Context, messages <-chan stream.Message, onA
 				}
 				return nil
 			}
+			a.budget.Release(stream.MessageSize(msg))
 
 			switch m := msg.(type) {
 			case *stream.RelationMessage:
@@ -182,19 +285,28 @@ func (a *Applier) Start(ctx context.Context, messages <-chan stream.Message, onA
 			case *stream.BeginMessage:
 				if tx == nil {
 					var err error
-					tx, err = a.pool.Begin(ctx)
+					tx, err = begin(ctx)
 					if err != nil {
 						return fmt.Errorf("begin tx: %w", err)
 					}
 					txStartTime = time.Now()
 				}
 				coalescedTx++
+				remoteTime = m.TxnTime
 
 			case *stream.ChangeMessage:
 				if tx == nil {
 					a.logger.Warn().Msg("change outside transaction, skipping")
 					continue
 				}
+				m = a.remap.Change(m)
+
+				if a.resolver != nil {
+					if err := a.applyResolved(ctx, tx, m, remoteTime); err != nil {
+						return rollbackAndFail(fmt.Errorf("apply %s on %s.%s: %w", m.Op, m.Namespace, m.Table, err))
+					}
+					continue
+				}
 
 				if m.Op == stream.OpInsert {
 					if batch.len() > 0 && !batch.matches(m) {
@@ -206,7 +318,7 @@ func (a *Applier) Start(ctx context.Context, messages <-chan stream.Message, onA
 						batch.reset(m.Namespace, m.Table)
 					}
 					batch.add(m)
-					if batch.len() >= insertBatchSize {
+					if batch.len() >= insertBatchSize || batch.bytes >= insertBatchBytes {
 						if err := a.flushBatch(ctx, tx, &batch); err != nil {
 							return rollbackAndFail(err)
 						}
@@ -233,9 +345,19 @@ func (a *Applier) Start(ctx context.Context, messages <-chan stream.Message, onA
 				if err := a.flushBatch(ctx, tx, &batch); err != nil {
 					return rollbackAndFail(err)
 				}
+				if a.resolver != nil && tx != nil {
+					// Record the source commit time as this commit's timestamp so
+					// later conflicts compare against when the change was made.
+					if _, err := tx.Exec(ctx, "SELECT pg_replication_origin_xact_setup($1::pg_lsn, $2)",
+						m.CommitLSN.String(), m.TxnTime); err != nil {
+						return rollbackAndFail(fmt.Errorf("set origin commit timestamp: %w", err))
+					}
+				}
 				pendingCommits = append(pendingCommits, m.CommitLSN)
+				commitTime = m.TxnTime
 
-				shouldCommit := coalescedTx >= coalesceTxLimit ||
+				shouldCommit := a.resolver != nil ||
+					coalescedTx >= coalesceTxLimit ||
 					time.Since(txStartTime) >= coalesceMaxWait ||
 					len(messages) == 0
 
@@ -267,7 +389,7 @@ func (a *Applier) flushBatch(ctx context.Context, tx pgx.Tx, batch *insertBatch)
 		return nil
 	}
 	n := batch.len()
-	defer func() { batch.rows = batch.rows[:0]; batch.cols = nil }()
+	defer func() { batch.rows = batch.rows[:0]; batch.cols = nil; batch.bytes = 0 }()
 
 	if n <= copyThreshold {
 		return a.flushBatchExec(ctx, tx, batch)
@@ -186,3 +186,19 @@ func TestInsertBatch_NilTuple(t *testing.T) {
 		t.Errorf("expected 0 rows for nil tuple, got %d", b.len())
 	}
 }
+
+func TestInsertBatch_Bytes(t *testing.T) {
+	var b insertBatch
+	b.reset("public", "users")
+	wide := make([]byte, 1<<20)
+	b.add(&stream.ChangeMessage{NewTuple: &stream.TupleData{
+		Columns: []stream.Column{{Name: "id", Value: []byte("1")}, {Name: "doc", Value: wide}},
+	}})
+	if b.bytes != 1<<20+1 {
+		t.Errorf("bytes = %d, want %d", b.bytes, 1<<20+1)
+	}
+	b.reset("public", "orders")
+	if b.bytes != 0 {
+		t.Errorf("bytes after reset = %d, want 0", b.bytes)
+	}
+}
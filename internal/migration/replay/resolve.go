@@ -0,0 +1,208 @@
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/jfoltran/pgmanager/internal/migration/conflict"
+	"github.com/jfoltran/pgmanager/internal/migration/stream"
+)
+
+// localRow is the current local version of the row a change targets.
+type localRow struct {
+	exists     bool
+	commitTime *time.Time
+	data       json.RawMessage
+}
+
+// applyResolved applies one change under last-writer-wins. The local row is
+// locked and its commit time compared with the remote commit time; the
+// loser of a conflict is recorded through the resolver.
+func (a *Applier) applyResolved(ctx context.Context, tx pgx.Tx, m *stream.ChangeMessage, remoteTime time.Time) error {
+	key := keyColumns(m)
+	if len(key) == 0 {
+		return errors.New("change carries no key columns")
+	}
+	local, err := a.lookupLocal(ctx, tx, m, key)
+	if err != nil {
+		return err
+	}
+
+	c := conflict.Conflict{
+		Schema:     m.Namespace,
+		Table:      m.Table,
+		Key:        columnMap(key),
+		RemoteTime: remoteTime,
+		LocalTime:  local.commitTime,
+	}
+	remoteWins := a.resolver.RemoteWins(remoteTime, local.commitTime)
+
+	switch m.Op {
+	case stream.OpInsert:
+		if !local.exists {
+			return a.insertRow(ctx, tx, m)
+		}
+		c.Kind = conflict.KindInsertInsert
+		if remoteWins {
+			c.Winner, c.Discarded = conflict.WinnerRemote, local.data
+			if err := a.updateByKey(ctx, tx, m, key); err != nil {
+				return err
+			}
+		} else {
+			c.Winner, c.Discarded = conflict.WinnerLocal, tupleJSON(m.NewTuple)
+		}
+
+	case stream.OpUpdate:
+		if !local.exists {
+			// Deleted here. Without a tombstone there is no delete time to
+			// compare, so deletes always win and the update is discarded.
+			c.Kind, c.Winner, c.Discarded = conflict.KindUpdateDelete, conflict.WinnerLocal, tupleJSON(m.NewTuple)
+			break
+		}
+		if remoteWins {
+			return a.updateByKey(ctx, tx, m, key)
+		}
+		c.Kind, c.Winner, c.Discarded = conflict.KindUpdateUpdate, conflict.WinnerLocal, tupleJSON(m.NewTuple)
+
+	case stream.OpDelete:
+		if !local.exists {
+			return nil
+		}
+		if err := a.deleteByKey(ctx, tx, m, key); err != nil {
+			return err
+		}
+		if remoteWins {
+			return nil
+		}
+		// The local row changed after the remote delete. Deletes still win:
+		// the other node discards the update (update_delete above), and only
+		// this choice lets both nodes converge.
+		c.Kind, c.Winner, c.Discarded = conflict.KindDeleteUpdate, conflict.WinnerRemote, local.data
+
+	default:
+		return nil
+	}
+
+	return a.resolver.Record(ctx, tx, c)
+}
+
+// lookupLocal locks the local row with the change's key and reads its
+// commit time and contents.
+func (a *Applier) lookupLocal(ctx context.Context, tx pgx.Tx, m *stream.ChangeMessage, key []stream.Column) (localRow, error) {
+	where, vals := keyWhere(key, 0)
+	commitTS := "NULL::timestamptz"
+	if a.resolver.CommitTimestamps() {
+		commitTS = "pg_xact_commit_timestamp(t.xmin)"
+	}
+	query := a.cachedStmt("L", m.Namespace, m.Table, 0, len(vals), func() string {
+		return fmt.Sprintf("SELECT %s, to_jsonb(t) FROM %s t WHERE %s FOR UPDATE",
+			commitTS, qualifiedName(m.Namespace, m.Table), where)
+	})
+
+	var row localRow
+	err := tx.QueryRow(ctx, query, vals...).Scan(&row.commitTime, &row.data)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return localRow{}, nil
+	}
+	if err != nil {
+		return localRow{}, fmt.Errorf("look up local row: %w", err)
+	}
+	row.exists = true
+	return row, nil
+}
+
+func (a *Applier) insertRow(ctx context.Context, tx pgx.Tx, m *stream.ChangeMessage) error {
+	var b insertBatch
+	b.reset(m.Namespace, m.Table)
+	b.add(m)
+	return a.flushBatchExec(ctx, tx, &b)
+}
+
+func (a *Applier) updateByKey(ctx context.Context, tx pgx.Tx, m *stream.ChangeMessage, key []stream.Column) error {
+	if m.NewTuple == nil {
+		return nil
+	}
+	setClauses, setVals := a.buildSetClauses(m.NewTuple)
+	where, whereVals := keyWhere(key, len(setVals))
+	query := a.cachedStmt("UK", m.Namespace, m.Table, len(setVals), len(whereVals), func() string {
+		return fmt.Sprintf("UPDATE %s SET %s WHERE %s",
+			qualifiedName(m.Namespace, m.Table), strings.Join(setClauses, ", "), where)
+	})
+	_, err := tx.Exec(ctx, query, append(setVals, whereVals...)...)
+	return err
+}
+
+func (a *Applier) deleteByKey(ctx context.Context, tx pgx.Tx, m *stream.ChangeMessage, key []stream.Column) error {
+	where, vals := keyWhere(key, 0)
+	query := a.cachedStmt("DK", m.Namespace, m.Table, 0, len(vals), func() string {
+		return fmt.Sprintf("DELETE FROM %s WHERE %s", qualifiedName(m.Namespace, m.Table), where)
+	})
+	_, err := tx.Exec(ctx, query, vals...)
+	return err
+}
+
+// keyColumns returns the replica identity columns that locate the row: from
+// the old tuple when the key changed or for deletes, else from the new one.
+// A tuple without key flags (REPLICA IDENTITY FULL) uses every column.
+func keyColumns(m *stream.ChangeMessage) []stream.Column {
+	source := m.OldTuple
+	if source == nil {
+		source = m.NewTuple
+	}
+	if source == nil {
+		return nil
+	}
+	var key []stream.Column
+	for _, c := range source.Columns {
+		if c.Key {
+			key = append(key, c)
+		}
+	}
+	if len(key) == 0 {
+		return source.Columns
+	}
+	return key
+}
+
+func keyWhere(key []stream.Column, offset int) (string, []any) {
+	clauses := make([]string, len(key))
+	vals := make([]any, len(key))
+	for i, c := range key {
+		clauses[i] = fmt.Sprintf("%s = $%d", quoteIdent(c.Name), offset+i+1)
+		vals[i] = string(c.Value)
+	}
+	return strings.Join(clauses, " AND "), vals
+}
+
+func columnMap(cols []stream.Column) map[string]string {
+	out := make(map[string]string, len(cols))
+	for _, c := range cols {
+		out[c.Name] = string(c.Value)
+	}
+	return out
+}
+
+// tupleJSON encodes a tuple as a JSON object of text values; NULL and
+// unchanged TOAST values are null.
+func tupleJSON(t *stream.TupleData) json.RawMessage {
+	if t == nil {
+		return nil
+	}
+	row := make(map[string]*string, len(t.Columns))
+	for _, c := range t.Columns {
+		if c.Value == nil {
+			row[c.Name] = nil
+			continue
+		}
+		v := string(c.Value)
+		row[c.Name] = &v
+	}
+	data, _ := json.Marshal(row)
+	return data
+}
@@ -0,0 +1,73 @@
+package replay
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/jfoltran/pgmanager/internal/migration/stream"
+)
+
+func TestKeyColumns(t *testing.T) {
+	newTuple := &stream.TupleData{Columns: []stream.Column{
+		{Name: "id", Value: []byte("1"), Key: true},
+		{Name: "name", Value: []byte("bob")},
+	}}
+
+	key := keyColumns(&stream.ChangeMessage{Op: stream.OpInsert, NewTuple: newTuple})
+	if len(key) != 1 || key[0].Name != "id" {
+		t.Errorf("insert key = %+v", key)
+	}
+
+	// Key changed: the old tuple locates the row.
+	oldTuple := &stream.TupleData{Columns: []stream.Column{
+		{Name: "id", Value: []byte("7"), Key: true},
+		{Name: "name"},
+	}}
+	key = keyColumns(&stream.ChangeMessage{Op: stream.OpUpdate, OldTuple: oldTuple, NewTuple: newTuple})
+	if len(key) != 1 || string(key[0].Value) != "7" {
+		t.Errorf("update key = %+v", key)
+	}
+
+	// REPLICA IDENTITY FULL: no key flags, every column is used.
+	full := &stream.TupleData{Columns: []stream.Column{{Name: "a"}, {Name: "b"}}}
+	if key := keyColumns(&stream.ChangeMessage{Op: stream.OpDelete, OldTuple: full}); len(key) != 2 {
+		t.Errorf("full identity key = %+v", key)
+	}
+
+	if key := keyColumns(&stream.ChangeMessage{Op: stream.OpDelete}); key != nil {
+		t.Errorf("empty change key = %+v", key)
+	}
+}
+
+func TestKeyWhere(t *testing.T) {
+	where, vals := keyWhere([]stream.Column{
+		{Name: "tenant", Value: []byte("3")},
+		{Name: "id", Value: []byte("42")},
+	}, 2)
+	if where != `"tenant" = $3 AND "id" = $4` {
+		t.Errorf("where = %s", where)
+	}
+	if len(vals) != 2 || vals[0] != "3" || vals[1] != "42" {
+		t.Errorf("vals = %v", vals)
+	}
+}
+
+func TestTupleJSON(t *testing.T) {
+	data := tupleJSON(&stream.TupleData{Columns: []stream.Column{
+		{Name: "id", Value: []byte("1")},
+		{Name: "note"},
+	}})
+	var got map[string]*string
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["id"] == nil || *got["id"] != "1" {
+		t.Errorf("id = %v", got["id"])
+	}
+	if v, ok := got["note"]; !ok || v != nil {
+		t.Errorf("note = %v, want null", v)
+	}
+	if tupleJSON(nil) != nil {
+		t.Error("nil tuple should encode as nil")
+	}
+}
@@ -0,0 +1,728 @@
+package roles
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog"
+)
+
+// Role describes a login or group role on the source cluster.
+type Role struct {
+	Name        string `json:"name"`
+	Superuser   bool   `json:"superuser"`
+	Inherit     bool   `json:"inherit"`
+	CreateRole  bool   `json:"create_role"`
+	CreateDB    bool   `json:"create_db"`
+	CanLogin    bool   `json:"can_login"`
+	Replication bool   `json:"replication"`
+	BypassRLS   bool   `json:"bypass_rls"`
+	ConnLimit   int    `json:"conn_limit"`
+	ValidUntil  string `json:"valid_until,omitempty"`
+
+	// PasswordHash holds the SCRAM (or md5) verifier when the source user
+	// can read pg_authid. It is never serialized.
+	PasswordHash string `json:"-"`
+	// PasswordKnown is false when the hash could not be read.
+	PasswordKnown bool `json:"password_known"`
+}
+
+// Membership records that Member is granted Role.
+type Membership struct {
+	Role        string `json:"role"`
+	Member      string `json:"member"`
+	AdminOption bool   `json:"admin_option"`
+}
+
+// Grant is a single privilege on the database, a schema, table, sequence
+// or function.
+type Grant struct {
+	ObjectType string `json:"object_type"` // DATABASE, SCHEMA, TABLE, SEQUENCE, FUNCTION, PROCEDURE
+	Schema     string `json:"schema"`
+	Name       string `json:"name"`
+	Args       string `json:"args,omitempty"` // identity arguments for routines
+	Grantee    string `json:"grantee"`        // "PUBLIC" for the public pseudo-role
+	Privilege  string `json:"privilege"`
+	Grantable  bool   `json:"grantable"`
+}
+
+// DefaultPrivilege is one entry from ALTER DEFAULT PRIVILEGES.
+type DefaultPrivilege struct {
+	Owner      string `json:"owner"`
+	Schema     string `json:"schema,omitempty"` // empty for global defaults
+	ObjectType string `json:"object_type"`      // TABLES, SEQUENCES, FUNCTIONS, TYPES, SCHEMAS
+	Grantee    string `json:"grantee"`
+	Privilege  string `json:"privilege"`
+	Grantable  bool   `json:"grantable"`
+}
+
+// Owner records the owning role of a schema, table, sequence or function.
+type Owner struct {
+	ObjectType string `json:"object_type"`
+	Schema     string `json:"schema"`
+	Name       string `json:"name"`
+	Args       string `json:"args,omitempty"`
+	Role       string `json:"role"`
+}
+
+// Inventory is everything read from the source that the roles phase replays.
+type Inventory struct {
+	Roles             []Role             `json:"roles"`
+	Memberships       []Membership       `json:"memberships"`
+	Grants            []Grant            `json:"grants"`
+	DefaultPrivileges []DefaultPrivilege `json:"default_privileges"`
+	Owners            []Owner            `json:"owners"`
+	// Revokes are built-in privileges, such as EXECUTE on functions for
+	// PUBLIC, that the source revoked. Grants alone would leave them in
+	// place on the destination.
+	Revokes []Grant `json:"revokes"`
+	// DefaultRevokes are built-in default privileges the source revoked
+	// with a global ALTER DEFAULT PRIVILEGES ... REVOKE.
+	DefaultRevokes []DefaultPrivilege `json:"default_revokes"`
+}
+
+// Capabilities describes what the destination user is allowed to do.
+type Capabilities struct {
+	User       string `json:"user"`
+	Superuser  bool   `json:"superuser"`
+	CreateRole bool   `json:"create_role"`
+}
+
+// Skipped is an item that cannot be copied with the current privileges.
+type Skipped struct {
+	Object string `json:"object"`
+	Reason string `json:"reason"`
+}
+
+// Plan is the ordered set of statements to run on the destination.
+// PreSchema statements create roles and memberships so that DDL referencing
+// them (e.g. CREATE POLICY ... TO role) succeeds. PostSchema statements
+// replay grants, default privileges and ownership once objects exist.
+type Plan struct {
+	PreSchema  []string  `json:"pre_schema"`
+	PostSchema []string  `json:"post_schema"`
+	Skipped    []Skipped `json:"skipped"`
+}
+
+// Report is the dry-run output returned by the API.
+type Report struct {
+	Capabilities Capabilities `json:"capabilities"`
+	Roles        int          `json:"roles"`
+	Memberships  int          `json:"memberships"`
+	Grants       int          `json:"grants"`
+	Revokes      int          `json:"revokes"`
+	Defaults     int          `json:"default_privileges"`
+	Owners       int          `json:"owners"`
+	Plan         *Plan        `json:"plan"`
+}
+
+// Manager reads roles and privileges from the source and replays them on
+// the destination.
+type Manager struct {
+	source *pgxpool.Pool
+	dest   *pgxpool.Pool
+	logger zerolog.Logger
+}
+
+// NewManager creates a roles Manager.
+func NewManager(source, dest *pgxpool.Pool, logger zerolog.Logger) *Manager {
+	return &Manager{
+		source: source,
+		dest:   dest,
+		logger: logger.With().Str("component", "roles").Logger(),
+	}
+}
+
+// Inventory reads roles, memberships, grants, default privileges, object
+// owners and revoked built-in privileges from the source database.
+func (m *Manager) Inventory(ctx context.Context) (*Inventory, error) {
+	inv := &Inventory{}
+	var err error
+	if inv.Roles, err = m.listRoles(ctx); err != nil {
+		return nil, fmt.Errorf("list roles: %w", err)
+	}
+	if inv.Memberships, err = m.listMemberships(ctx); err != nil {
+		return nil, fmt.Errorf("list memberships: %w", err)
+	}
+	if inv.Grants, err = m.listGrants(ctx); err != nil {
+		return nil, fmt.Errorf("list grants: %w", err)
+	}
+	if inv.DefaultPrivileges, err = m.listDefaultPrivileges(ctx); err != nil {
+		return nil, fmt.Errorf("list default privileges: %w", err)
+	}
+	if inv.Owners, err = m.listOwners(ctx); err != nil {
+		return nil, fmt.Errorf("list owners: %w", err)
+	}
+	if inv.Revokes, err = m.listRevokes(ctx); err != nil {
+		return nil, fmt.Errorf("list revoked privileges: %w", err)
+	}
+	if inv.DefaultRevokes, err = m.listDefaultRevokes(ctx); err != nil {
+		return nil, fmt.Errorf("list revoked default privileges: %w", err)
+	}
+	return inv, nil
+}
+
+// DestCapabilities reports the role attributes of the destination user.
+func (m *Manager) DestCapabilities(ctx context.Context) (Capabilities, error) {
+	var c Capabilities
+	err := m.dest.QueryRow(ctx,
+		"SELECT current_user, rolsuper, rolcreaterole FROM pg_roles WHERE rolname = current_user",
+	).Scan(&c.User, &c.Superuser, &c.CreateRole)
+	if err != nil {
+		return c, fmt.Errorf("destination capabilities: %w", err)
+	}
+	return c, nil
+}
+
+// DryRun builds the plan without applying it.
+func (m *Manager) DryRun(ctx context.Context) (*Report, error) {
+	inv, err := m.Inventory(ctx)
+	if err != nil {
+		return nil, err
+	}
+	caps, err := m.DestCapabilities(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Report{
+		Capabilities: caps,
+		Roles:        len(inv.Roles),
+		Memberships:  len(inv.Memberships),
+		Grants:       len(inv.Grants),
+		Revokes:      len(inv.Revokes) + len(inv.DefaultRevokes),
+		Defaults:     len(inv.DefaultPrivileges),
+		Owners:       len(inv.Owners),
+		Plan:         BuildPlan(inv, caps),
+	}, nil
+}
+
+// ApplyPreSchema runs the role and membership statements of the plan.
+// Statements refused for lack of privilege are added to plan.Skipped.
+func (m *Manager) ApplyPreSchema(ctx context.Context, plan *Plan) error {
+	return m.apply(ctx, plan, plan.PreSchema)
+}
+
+// ApplyPostSchema runs the grant, default privilege and ownership
+// statements. Statements refused for lack of privilege are added to
+// plan.Skipped.
+func (m *Manager) ApplyPostSchema(ctx context.Context, plan *Plan) error {
+	return m.apply(ctx, plan, plan.PostSchema)
+}
+
+func (m *Manager) apply(ctx context.Context, plan *Plan, stmts []string) error {
+	applied := 0
+	for _, stmt := range stmts {
+		m.logger.Debug().Str("statement", stmt).Msg("applying roles statement")
+		if _, err := m.dest.Exec(ctx, stmt); err != nil {
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && pgErr.Code == "42501" {
+				m.logger.Warn().Str("statement", stmt).Err(err).Msg("insufficient privilege, skipping")
+				plan.skip(stmt, "insufficient privilege on the destination: "+pgErr.Message)
+				continue
+			}
+			return fmt.Errorf("apply %q: %w", stmt, err)
+		}
+		applied++
+	}
+	m.logger.Info().Int("statements", applied).Int("skipped", len(stmts)-applied).Msg("roles statements applied")
+	return nil
+}
+
+func (m *Manager) listRoles(ctx context.Context) ([]Role, error) {
+	const base = `
+		SELECT r.rolname, r.rolsuper, r.rolinherit, r.rolcreaterole, r.rolcreatedb,
+		       r.rolcanlogin, r.rolreplication, r.rolbypassrls, r.rolconnlimit,
+		       COALESCE(r.rolvaliduntil::text, ''), %s
+		FROM %s r
+		WHERE r.rolname !~ '^pg_' AND r.oid >= 16384
+		ORDER BY r.rolname`
+
+	rows, err := m.source.Query(ctx, fmt.Sprintf(base, "COALESCE(r.rolpassword, ''), true", "pg_authid"))
+	if err != nil {
+		if !isPermissionDenied(err) {
+			return nil, err
+		}
+		m.logger.Warn().Msg("pg_authid not readable on source, role passwords will not be copied")
+		rows, err = m.source.Query(ctx, fmt.Sprintf(base, "'', false", "pg_roles"))
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer rows.Close()
+
+	var roles []Role
+	for rows.Next() {
+		var r Role
+		if err := rows.Scan(&r.Name, &r.Superuser, &r.Inherit, &r.CreateRole, &r.CreateDB,
+			&r.CanLogin, &r.Replication, &r.BypassRLS, &r.ConnLimit, &r.ValidUntil,
+			&r.PasswordHash, &r.PasswordKnown); err != nil {
+			return nil, err
+		}
+		roles = append(roles, r)
+	}
+	return roles, rows.Err()
+}
+
+func (m *Manager) listMemberships(ctx context.Context) ([]Membership, error) {
+	rows, err := m.source.Query(ctx, `
+		SELECT r.rolname, u.rolname, am.admin_option
+		FROM pg_auth_members am
+		JOIN pg_roles r ON r.oid = am.roleid
+		JOIN pg_roles u ON u.oid = am.member
+		WHERE r.oid >= 16384 AND u.oid >= 16384
+		ORDER BY r.rolname, u.rolname`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Membership
+	for rows.Next() {
+		var ms Membership
+		if err := rows.Scan(&ms.Role, &ms.Member, &ms.AdminOption); err != nil {
+			return nil, err
+		}
+		out = append(out, ms)
+	}
+	return out, rows.Err()
+}
+
+// userNamespaceFilter filters out system schemas. Objects that belong to an
+// extension are managed by the extension script; each query excludes them
+// through pg_depend.
+const userNamespaceFilter = `n.nspname NOT IN ('pg_catalog', 'information_schema') AND n.nspname !~ '^pg_toast' AND n.nspname !~ '^pg_temp'`
+
+func (m *Manager) listGrants(ctx context.Context) ([]Grant, error) {
+	rows, err := m.source.Query(ctx, `
+		SELECT 'SCHEMA', n.nspname, n.nspname, '',
+		       COALESCE(g.rolname, 'PUBLIC'), a.privilege_type, a.is_grantable
+		FROM pg_namespace n, aclexplode(n.nspacl) a
+		LEFT JOIN pg_roles g ON g.oid = a.grantee
+		WHERE n.nspacl IS NOT NULL AND `+userNamespaceFilter+`
+		  AND NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.classid = 'pg_namespace'::regclass AND d.objid = n.oid AND d.deptype = 'e')
+		UNION ALL
+		SELECT CASE WHEN c.relkind = 'S' THEN 'SEQUENCE' ELSE 'TABLE' END, n.nspname, c.relname, '',
+		       COALESCE(g.rolname, 'PUBLIC'), a.privilege_type, a.is_grantable
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace, aclexplode(c.relacl) a
+		LEFT JOIN pg_roles g ON g.oid = a.grantee
+		WHERE c.relacl IS NOT NULL AND c.relkind IN ('r', 'p', 'v', 'm', 'f', 'S') AND `+userNamespaceFilter+`
+		  AND NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.classid = 'pg_class'::regclass AND d.objid = c.oid AND d.deptype = 'e')
+		UNION ALL
+		SELECT CASE WHEN p.prokind = 'p' THEN 'PROCEDURE' ELSE 'FUNCTION' END, n.nspname, p.proname,
+		       pg_get_function_identity_arguments(p.oid),
+		       COALESCE(g.rolname, 'PUBLIC'), a.privilege_type, a.is_grantable
+		FROM pg_proc p
+		JOIN pg_namespace n ON n.oid = p.pronamespace, aclexplode(p.proacl) a
+		LEFT JOIN pg_roles g ON g.oid = a.grantee
+		WHERE p.proacl IS NOT NULL AND `+userNamespaceFilter+`
+		  AND NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.classid = 'pg_proc'::regclass AND d.objid = p.oid AND d.deptype = 'e')
+		UNION ALL
+		SELECT 'DATABASE', '', db.datname, '',
+		       COALESCE(g.rolname, 'PUBLIC'), a.privilege_type, a.is_grantable
+		FROM pg_database db, aclexplode(db.datacl) a
+		LEFT JOIN pg_roles g ON g.oid = a.grantee
+		WHERE db.datname = current_database() AND db.datacl IS NOT NULL
+		ORDER BY 1, 2, 3, 5, 6`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Grant
+	for rows.Next() {
+		var g Grant
+		if err := rows.Scan(&g.ObjectType, &g.Schema, &g.Name, &g.Args, &g.Grantee, &g.Privilege, &g.Grantable); err != nil {
+			return nil, err
+		}
+		out = append(out, g)
+	}
+	return out, rows.Err()
+}
+
+func (m *Manager) listDefaultPrivileges(ctx context.Context) ([]DefaultPrivilege, error) {
+	rows, err := m.source.Query(ctx, `
+		SELECT o.rolname, COALESCE(n.nspname, ''),
+		       CASE d.defaclobjtype
+		            WHEN 'r' THEN 'TABLES' WHEN 'S' THEN 'SEQUENCES' WHEN 'f' THEN 'FUNCTIONS'
+		            WHEN 'T' THEN 'TYPES' WHEN 'n' THEN 'SCHEMAS' END,
+		       COALESCE(g.rolname, 'PUBLIC'), a.privilege_type, a.is_grantable
+		FROM pg_default_acl d
+		JOIN pg_roles o ON o.oid = d.defaclrole
+		LEFT JOIN pg_namespace n ON n.oid = d.defaclnamespace, aclexplode(d.defaclacl) a
+		LEFT JOIN pg_roles g ON g.oid = a.grantee
+		ORDER BY 1, 2, 3, 4, 5`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []DefaultPrivilege
+	for rows.Next() {
+		var d DefaultPrivilege
+		if err := rows.Scan(&d.Owner, &d.Schema, &d.ObjectType, &d.Grantee, &d.Privilege, &d.Grantable); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// listRevokes finds the privileges PUBLIC holds on a fresh object but not
+// on the source: EXECUTE on functions and CONNECT or TEMPORARY on the
+// database, compared with acldefault(), and USAGE or CREATE on the public
+// schema, whose initial ACL depends on the server version.
+func (m *Manager) listRevokes(ctx context.Context) ([]Grant, error) {
+	rows, err := m.source.Query(ctx, `
+		SELECT 'DATABASE', '', db.datname, '', a.privilege_type
+		FROM pg_database db, aclexplode(acldefault('d', db.datdba)) a
+		WHERE db.datname = current_database() AND db.datacl IS NOT NULL AND a.grantee = 0
+		  AND NOT EXISTS (SELECT 1 FROM aclexplode(db.datacl) x WHERE x.grantee = 0 AND x.privilege_type = a.privilege_type)
+		UNION ALL
+		SELECT 'SCHEMA', n.nspname, n.nspname, '', p.privilege
+		FROM pg_namespace n, unnest(ARRAY['CREATE', 'USAGE']) p(privilege)
+		WHERE n.nspname = 'public'
+		  AND NOT EXISTS (SELECT 1 FROM aclexplode(COALESCE(n.nspacl, acldefault('n', n.nspowner))) x
+		                  WHERE x.grantee = 0 AND x.privilege_type = p.privilege)
+		UNION ALL
+		SELECT CASE WHEN p.prokind = 'p' THEN 'PROCEDURE' ELSE 'FUNCTION' END, n.nspname, p.proname,
+		       pg_get_function_identity_arguments(p.oid), a.privilege_type
+		FROM pg_proc p
+		JOIN pg_namespace n ON n.oid = p.pronamespace, aclexplode(acldefault('f', p.proowner)) a
+		WHERE p.proacl IS NOT NULL AND a.grantee = 0 AND `+userNamespaceFilter+`
+		  AND NOT EXISTS (SELECT 1 FROM aclexplode(p.proacl) x WHERE x.grantee = 0 AND x.privilege_type = a.privilege_type)
+		  AND NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.classid = 'pg_proc'::regclass AND d.objid = p.oid AND d.deptype = 'e')
+		ORDER BY 1, 2, 3, 5`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Grant
+	for rows.Next() {
+		g := Grant{Grantee: "PUBLIC"}
+		if err := rows.Scan(&g.ObjectType, &g.Schema, &g.Name, &g.Args, &g.Privilege); err != nil {
+			return nil, err
+		}
+		out = append(out, g)
+	}
+	return out, rows.Err()
+}
+
+// listDefaultRevokes finds the built-in default privileges, compared with
+// acldefault(), missing from global pg_default_acl entries. Per-schema
+// entries only add to the global ones, so they cannot revoke.
+func (m *Manager) listDefaultRevokes(ctx context.Context) ([]DefaultPrivilege, error) {
+	rows, err := m.source.Query(ctx, `
+		SELECT o.rolname,
+		       CASE d.defaclobjtype
+		            WHEN 'r' THEN 'TABLES' WHEN 'S' THEN 'SEQUENCES' WHEN 'f' THEN 'FUNCTIONS'
+		            WHEN 'T' THEN 'TYPES' WHEN 'n' THEN 'SCHEMAS' END,
+		       COALESCE(g.rolname, 'PUBLIC'), a.privilege_type
+		FROM pg_default_acl d
+		JOIN pg_roles o ON o.oid = d.defaclrole,
+		     aclexplode(acldefault(CASE d.defaclobjtype WHEN 'S' THEN 's' ELSE d.defaclobjtype END, d.defaclrole)) a
+		LEFT JOIN pg_roles g ON g.oid = a.grantee
+		WHERE d.defaclnamespace = 0
+		  AND NOT EXISTS (SELECT 1 FROM aclexplode(d.defaclacl) x WHERE x.grantee = a.grantee AND x.privilege_type = a.privilege_type)
+		ORDER BY 1, 2, 3, 4`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []DefaultPrivilege
+	for rows.Next() {
+		var d DefaultPrivilege
+		if err := rows.Scan(&d.Owner, &d.ObjectType, &d.Grantee, &d.Privilege); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+func (m *Manager) listOwners(ctx context.Context) ([]Owner, error) {
+	rows, err := m.source.Query(ctx, `
+		SELECT 'SCHEMA', n.nspname, n.nspname, '', r.rolname
+		FROM pg_namespace n JOIN pg_roles r ON r.oid = n.nspowner
+		WHERE `+userNamespaceFilter+` AND n.nspname <> 'public'
+		  AND NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.classid = 'pg_namespace'::regclass AND d.objid = n.oid AND d.deptype = 'e')
+		UNION ALL
+		SELECT CASE c.relkind WHEN 'S' THEN 'SEQUENCE' WHEN 'v' THEN 'VIEW' WHEN 'm' THEN 'MATERIALIZED VIEW'
+		            WHEN 'f' THEN 'FOREIGN TABLE' ELSE 'TABLE' END,
+		       n.nspname, c.relname, '', r.rolname
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		JOIN pg_roles r ON r.oid = c.relowner
+		WHERE c.relkind IN ('r', 'p', 'v', 'm', 'f', 'S') AND `+userNamespaceFilter+`
+		  AND NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.classid = 'pg_class'::regclass AND d.objid = c.oid AND d.deptype IN ('e', 'a', 'i'))
+		UNION ALL
+		SELECT CASE WHEN p.prokind = 'p' THEN 'PROCEDURE' ELSE 'FUNCTION' END, n.nspname, p.proname,
+		       pg_get_function_identity_arguments(p.oid), r.rolname
+		FROM pg_proc p
+		JOIN pg_namespace n ON n.oid = p.pronamespace
+		JOIN pg_roles r ON r.oid = p.proowner
+		WHERE p.prokind IN ('f', 'p') AND `+userNamespaceFilter+`
+		  AND NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.classid = 'pg_proc'::regclass AND d.objid = p.oid AND d.deptype = 'e')
+		ORDER BY 1, 2, 3`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Owner
+	for rows.Next() {
+		var o Owner
+		if err := rows.Scan(&o.ObjectType, &o.Schema, &o.Name, &o.Args, &o.Role); err != nil {
+			return nil, err
+		}
+		out = append(out, o)
+	}
+	return out, rows.Err()
+}
+
+// BuildPlan turns an inventory into idempotent statements, given what the
+// destination user is allowed to do. Anything that would fail for lack of
+// privilege is listed in Plan.Skipped instead.
+func BuildPlan(inv *Inventory, caps Capabilities) *Plan {
+	plan := &Plan{}
+	created := make(map[string]bool)
+
+	for _, r := range inv.Roles {
+		if r.Name == caps.User {
+			created[r.Name] = true
+			continue
+		}
+		if !caps.Superuser && !caps.CreateRole {
+			plan.skip("role "+r.Name, "creating roles requires SUPERUSER or CREATEROLE on the destination")
+			continue
+		}
+		created[r.Name] = true
+		plan.PreSchema = append(plan.PreSchema, createRoleStmt(r.Name))
+
+		attrs := roleAttributes(r, caps.Superuser)
+		if !caps.Superuser {
+			for _, a := range []struct {
+				on   bool
+				name string
+			}{{r.Superuser, "SUPERUSER"}, {r.Replication, "REPLICATION"}, {r.BypassRLS, "BYPASSRLS"}} {
+				if a.on {
+					plan.skip("role "+r.Name, a.name+" attribute requires SUPERUSER on the destination")
+				}
+			}
+		}
+		if r.PasswordKnown && r.PasswordHash != "" {
+			attrs = append(attrs, "PASSWORD "+quoteLiteral(r.PasswordHash))
+		} else if r.CanLogin && !r.PasswordKnown {
+			plan.skip("role "+r.Name, "password hash not readable on source (pg_authid requires SUPERUSER)")
+		}
+		if len(attrs) > 0 {
+			plan.PreSchema = append(plan.PreSchema,
+				fmt.Sprintf("ALTER ROLE %s WITH %s", quoteIdent(r.Name), strings.Join(attrs, " ")))
+		}
+	}
+
+	exists := func(role string) bool {
+		return role == "PUBLIC" || created[role]
+	}
+
+	for _, ms := range inv.Memberships {
+		obj := fmt.Sprintf("membership %s in %s", ms.Member, ms.Role)
+		if !exists(ms.Role) || !exists(ms.Member) {
+			plan.skip(obj, "role not created on the destination")
+			continue
+		}
+		if !caps.Superuser && !caps.CreateRole {
+			plan.skip(obj, "granting role membership requires SUPERUSER, CREATEROLE or ADMIN OPTION")
+			continue
+		}
+		stmt := fmt.Sprintf("GRANT %s TO %s", quoteIdent(ms.Role), quoteIdent(ms.Member))
+		if ms.AdminOption {
+			stmt += " WITH ADMIN OPTION"
+		}
+		plan.PreSchema = append(plan.PreSchema, stmt)
+	}
+
+	for _, g := range inv.Grants {
+		if !exists(g.Grantee) {
+			target := objectRef(g.ObjectType, g.Schema, g.Name, g.Args)
+			plan.skip(fmt.Sprintf("%s %s to %s", g.Privilege, target, g.Grantee), "grantee role not present on the destination")
+			continue
+		}
+		plan.PostSchema = append(plan.PostSchema, privilegeStmt(g, false))
+	}
+	// Revokes only concern PUBLIC, which always exists.
+	for _, g := range inv.Revokes {
+		plan.PostSchema = append(plan.PostSchema, privilegeStmt(g, true))
+	}
+
+	defaults := func(list []DefaultPrivilege, revoke bool) {
+		for _, d := range list {
+			obj := fmt.Sprintf("default %s on %s for %s", d.Privilege, d.ObjectType, d.Owner)
+			if revoke {
+				obj = "revoked " + obj
+			}
+			if !exists(d.Owner) || !exists(d.Grantee) {
+				plan.skip(obj, "role not present on the destination")
+				continue
+			}
+			if !caps.Superuser && d.Owner != caps.User {
+				plan.skip(obj, "ALTER DEFAULT PRIVILEGES FOR ROLE requires SUPERUSER or membership in the role")
+				continue
+			}
+			plan.PostSchema = append(plan.PostSchema, defaultPrivilegeStmt(d, revoke))
+		}
+	}
+	defaults(inv.DefaultPrivileges, false)
+	defaults(inv.DefaultRevokes, true)
+
+	for _, o := range inv.Owners {
+		target := objectRef(o.ObjectType, o.Schema, o.Name, o.Args)
+		if o.Role == caps.User {
+			continue
+		}
+		if !exists(o.Role) {
+			plan.skip("owner of "+target, "owning role not present on the destination")
+			continue
+		}
+		if !caps.Superuser {
+			plan.skip("owner of "+target, "changing ownership to another role requires SUPERUSER")
+			continue
+		}
+		plan.PostSchema = append(plan.PostSchema,
+			fmt.Sprintf("ALTER %s %s OWNER TO %s", o.ObjectType, ownerTarget(o), quoteIdent(o.Role)))
+	}
+
+	return plan
+}
+
+// privilegeStmt grants or revokes g. The database is addressed through
+// current_database(), as its name on the destination may differ.
+func privilegeStmt(g Grant, revoke bool) string {
+	grantee := granteeIdent(g.Grantee)
+	target := objectRef(g.ObjectType, g.Schema, g.Name, g.Args)
+	if g.ObjectType == "DATABASE" {
+		grantee = strings.ReplaceAll(grantee, "%", "%%")
+		target = "DATABASE %I"
+	}
+	var stmt string
+	if revoke {
+		stmt = fmt.Sprintf("REVOKE %s ON %s FROM %s", g.Privilege, target, grantee)
+	} else {
+		stmt = fmt.Sprintf("GRANT %s ON %s TO %s", g.Privilege, target, grantee)
+		if g.Grantable {
+			stmt += " WITH GRANT OPTION"
+		}
+	}
+	if g.ObjectType == "DATABASE" {
+		return fmt.Sprintf("DO $pgm$ BEGIN EXECUTE format(%s, current_database()); END $pgm$", quoteLiteral(stmt))
+	}
+	return stmt
+}
+
+func defaultPrivilegeStmt(d DefaultPrivilege, revoke bool) string {
+	stmt := fmt.Sprintf("ALTER DEFAULT PRIVILEGES FOR ROLE %s", quoteIdent(d.Owner))
+	if d.Schema != "" {
+		stmt += " IN SCHEMA " + quoteIdent(d.Schema)
+	}
+	if revoke {
+		return stmt + fmt.Sprintf(" REVOKE %s ON %s FROM %s", d.Privilege, d.ObjectType, granteeIdent(d.Grantee))
+	}
+	stmt += fmt.Sprintf(" GRANT %s ON %s TO %s", d.Privilege, d.ObjectType, granteeIdent(d.Grantee))
+	if d.Grantable {
+		stmt += " WITH GRANT OPTION"
+	}
+	return stmt
+}
+
+func (p *Plan) skip(object, reason string) {
+	p.Skipped = append(p.Skipped, Skipped{Object: object, Reason: reason})
+}
+
+func createRoleStmt(name string) string {
+	return fmt.Sprintf(
+		"DO $pgm$ BEGIN IF NOT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = %s) THEN CREATE ROLE %s; END IF; END $pgm$",
+		quoteLiteral(name), quoteIdent(name))
+}
+
+// roleAttributes returns the ALTER ROLE options for r. Only a superuser
+// may name SUPERUSER, REPLICATION or BYPASSRLS, even in their NO form, so
+// they are left out when superuser is false.
+func roleAttributes(r Role, superuser bool) []string {
+	flag := func(on bool, yes, no string) string {
+		if on {
+			return yes
+		}
+		return no
+	}
+	var attrs []string
+	if superuser {
+		attrs = append(attrs, flag(r.Superuser, "SUPERUSER", "NOSUPERUSER"))
+	}
+	attrs = append(attrs,
+		flag(r.Inherit, "INHERIT", "NOINHERIT"),
+		flag(r.CreateRole, "CREATEROLE", "NOCREATEROLE"),
+		flag(r.CreateDB, "CREATEDB", "NOCREATEDB"),
+		flag(r.CanLogin, "LOGIN", "NOLOGIN"),
+	)
+	if superuser {
+		attrs = append(attrs,
+			flag(r.Replication, "REPLICATION", "NOREPLICATION"),
+			flag(r.BypassRLS, "BYPASSRLS", "NOBYPASSRLS"),
+		)
+	}
+	attrs = append(attrs, fmt.Sprintf("CONNECTION LIMIT %d", r.ConnLimit))
+	if r.ValidUntil != "" {
+		attrs = append(attrs, "VALID UNTIL "+quoteLiteral(r.ValidUntil))
+	}
+	return attrs
+}
+
+func objectRef(objType, schema, name, args string) string {
+	switch objType {
+	case "DATABASE", "SCHEMA":
+		return objType + " " + quoteIdent(name)
+	case "FUNCTION", "PROCEDURE":
+		return fmt.Sprintf("%s %s.%s(%s)", objType, quoteIdent(schema), quoteIdent(name), args)
+	case "SEQUENCE":
+		return fmt.Sprintf("SEQUENCE %s.%s", quoteIdent(schema), quoteIdent(name))
+	default:
+		return fmt.Sprintf("TABLE %s.%s", quoteIdent(schema), quoteIdent(name))
+	}
+}
+
+func ownerTarget(o Owner) string {
+	switch o.ObjectType {
+	case "SCHEMA":
+		return quoteIdent(o.Name)
+	case "FUNCTION", "PROCEDURE":
+		return fmt.Sprintf("%s.%s(%s)", quoteIdent(o.Schema), quoteIdent(o.Name), o.Args)
+	default:
+		return quoteIdent(o.Schema) + "." + quoteIdent(o.Name)
+	}
+}
+
+func granteeIdent(g string) string {
+	if g == "PUBLIC" {
+		return "PUBLIC"
+	}
+	return quoteIdent(g)
+}
+
+func isPermissionDenied(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "42501"
+}
+
+func quoteIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+func quoteLiteral(s string) string {
+	return `'` + strings.ReplaceAll(s, `'`, `''`) + `'`
+}
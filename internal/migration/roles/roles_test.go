@@ -0,0 +1,205 @@
+package roles
+
+import (
+	"strings"
+	"testing"
+)
+
+func testInventory() *Inventory {
+	return &Inventory{
+		Roles: []Role{
+			{Name: "app", Inherit: true, CanLogin: true, ConnLimit: -1, PasswordHash: "SCRAM-SHA-256$4096:abc", PasswordKnown: true},
+			{Name: "readers", Inherit: true, ConnLimit: -1, PasswordKnown: true},
+			{Name: "admin", Superuser: true, CanLogin: true, ConnLimit: -1, PasswordKnown: true},
+		},
+		Memberships: []Membership{
+			{Role: "readers", Member: "app"},
+		},
+		Grants: []Grant{
+			{ObjectType: "TABLE", Schema: "public", Name: "users", Grantee: "readers", Privilege: "SELECT"},
+			{ObjectType: "FUNCTION", Schema: "public", Name: "f", Args: "integer", Grantee: "PUBLIC", Privilege: "EXECUTE"},
+			{ObjectType: "SCHEMA", Schema: "app", Name: "app", Grantee: "ghost", Privilege: "USAGE"},
+		},
+		DefaultPrivileges: []DefaultPrivilege{
+			{Owner: "app", Schema: "public", ObjectType: "TABLES", Grantee: "readers", Privilege: "SELECT"},
+		},
+		Owners: []Owner{
+			{ObjectType: "TABLE", Schema: "public", Name: "users", Role: "app"},
+		},
+	}
+}
+
+func contains(stmts []string, sub string) bool {
+	for _, s := range stmts {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestBuildPlan_Superuser(t *testing.T) {
+	plan := BuildPlan(testInventory(), Capabilities{User: "postgres", Superuser: true})
+
+	for _, want := range []string{
+		`CREATE ROLE "app"`,
+		`ALTER ROLE "app" WITH NOSUPERUSER INHERIT`,
+		`PASSWORD 'SCRAM-SHA-256$4096:abc'`,
+		`ALTER ROLE "admin" WITH SUPERUSER`,
+		`GRANT "readers" TO "app"`,
+	} {
+		if !contains(plan.PreSchema, want) {
+			t.Errorf("PreSchema missing %q:\n%s", want, strings.Join(plan.PreSchema, "\n"))
+		}
+	}
+
+	for _, want := range []string{
+		`GRANT SELECT ON TABLE "public"."users" TO "readers"`,
+		`GRANT EXECUTE ON FUNCTION "public"."f"(integer) TO PUBLIC`,
+		`ALTER DEFAULT PRIVILEGES FOR ROLE "app" IN SCHEMA "public" GRANT SELECT ON TABLES TO "readers"`,
+		`ALTER TABLE "public"."users" OWNER TO "app"`,
+	} {
+		if !contains(plan.PostSchema, want) {
+			t.Errorf("PostSchema missing %q:\n%s", want, strings.Join(plan.PostSchema, "\n"))
+		}
+	}
+
+	if len(plan.Skipped) != 1 || !strings.Contains(plan.Skipped[0].Object, "ghost") {
+		t.Errorf("Skipped = %+v, want only the grant to the unknown role", plan.Skipped)
+	}
+}
+
+func TestBuildPlan_CreateRoleOnly(t *testing.T) {
+	plan := BuildPlan(testInventory(), Capabilities{User: "migrator", CreateRole: true})
+
+	if contains(plan.PreSchema, `"admin" WITH SUPERUSER`) {
+		t.Error("SUPERUSER attribute should not be granted without superuser")
+	}
+	if contains(plan.PostSchema, "OWNER TO") {
+		t.Error("ownership changes should be skipped without superuser")
+	}
+	if contains(plan.PostSchema, "ALTER DEFAULT PRIVILEGES") {
+		t.Error("default privileges for other roles should be skipped without superuser")
+	}
+
+	reasons := map[string]bool{}
+	for _, s := range plan.Skipped {
+		reasons[s.Reason] = true
+	}
+	for _, want := range []string{
+		"SUPERUSER attribute requires SUPERUSER on the destination",
+		"changing ownership to another role requires SUPERUSER",
+	} {
+		if !reasons[want] {
+			t.Errorf("missing skip reason %q in %+v", want, plan.Skipped)
+		}
+	}
+}
+
+func TestBuildPlan_CreateRoleOnlyAttributes(t *testing.T) {
+	inv := testInventory()
+	inv.Roles = append(inv.Roles, Role{Name: "repl", Replication: true, BypassRLS: true, CanLogin: true, ConnLimit: 5, ValidUntil: "2030-01-01 00:00:00+00", PasswordKnown: true})
+	plan := BuildPlan(inv, Capabilities{User: "migrator", CreateRole: true})
+
+	// A non-superuser may not name these attributes in either form.
+	for _, kw := range []string{"NOSUPERUSER", "SUPERUSER", "NOREPLICATION", "REPLICATION", "NOBYPASSRLS", "BYPASSRLS"} {
+		if contains(plan.PreSchema, kw) {
+			t.Errorf("PreSchema contains %s:\n%s", kw, strings.Join(plan.PreSchema, "\n"))
+		}
+	}
+	for _, want := range []string{
+		`ALTER ROLE "app" WITH INHERIT NOCREATEROLE NOCREATEDB LOGIN CONNECTION LIMIT -1 PASSWORD 'SCRAM-SHA-256$4096:abc'`,
+		`ALTER ROLE "repl" WITH NOINHERIT NOCREATEROLE NOCREATEDB LOGIN CONNECTION LIMIT 5 VALID UNTIL '2030-01-01 00:00:00+00'`,
+	} {
+		if !contains(plan.PreSchema, want) {
+			t.Errorf("PreSchema missing %q:\n%s", want, strings.Join(plan.PreSchema, "\n"))
+		}
+	}
+
+	reasons := map[string]bool{}
+	for _, s := range plan.Skipped {
+		if s.Object == "role repl" {
+			reasons[s.Reason] = true
+		}
+	}
+	for _, want := range []string{
+		"REPLICATION attribute requires SUPERUSER on the destination",
+		"BYPASSRLS attribute requires SUPERUSER on the destination",
+	} {
+		if !reasons[want] {
+			t.Errorf("missing skip reason %q in %+v", want, plan.Skipped)
+		}
+	}
+}
+
+func TestBuildPlan_NoCreateRole(t *testing.T) {
+	plan := BuildPlan(testInventory(), Capabilities{User: "migrator"})
+
+	if len(plan.PreSchema) != 0 {
+		t.Errorf("PreSchema = %v, want empty", plan.PreSchema)
+	}
+	// Only the PUBLIC grant survives: every other grantee was not created.
+	if len(plan.PostSchema) != 1 || !strings.Contains(plan.PostSchema[0], "TO PUBLIC") {
+		t.Errorf("PostSchema = %v, want only the PUBLIC grant", plan.PostSchema)
+	}
+}
+
+func TestBuildPlan_UnknownPassword(t *testing.T) {
+	inv := &Inventory{Roles: []Role{{Name: "app", CanLogin: true}}}
+	plan := BuildPlan(inv, Capabilities{User: "postgres", Superuser: true})
+
+	if contains(plan.PreSchema, "PASSWORD") {
+		t.Error("no PASSWORD clause expected when hash is unknown")
+	}
+	if len(plan.Skipped) != 1 || !strings.Contains(plan.Skipped[0].Reason, "pg_authid") {
+		t.Errorf("Skipped = %+v, want password skip", plan.Skipped)
+	}
+}
+
+func TestBuildPlan_Revokes(t *testing.T) {
+	inv := &Inventory{
+		Roles: []Role{{Name: "app", CanLogin: true, ConnLimit: -1, PasswordKnown: true}},
+		Grants: []Grant{
+			{ObjectType: "DATABASE", Name: "shop", Grantee: "app", Privilege: "CONNECT"},
+		},
+		Revokes: []Grant{
+			{ObjectType: "DATABASE", Name: "shop", Grantee: "PUBLIC", Privilege: "CONNECT"},
+			{ObjectType: "SCHEMA", Schema: "public", Name: "public", Grantee: "PUBLIC", Privilege: "CREATE"},
+			{ObjectType: "FUNCTION", Schema: "public", Name: "f", Args: "integer", Grantee: "PUBLIC", Privilege: "EXECUTE"},
+		},
+		DefaultRevokes: []DefaultPrivilege{
+			{Owner: "app", ObjectType: "FUNCTIONS", Grantee: "PUBLIC", Privilege: "EXECUTE"},
+		},
+	}
+	plan := BuildPlan(inv, Capabilities{User: "postgres", Superuser: true})
+
+	for _, want := range []string{
+		`DO $pgm$ BEGIN EXECUTE format('GRANT CONNECT ON DATABASE %I TO "app"', current_database()); END $pgm$`,
+		`DO $pgm$ BEGIN EXECUTE format('REVOKE CONNECT ON DATABASE %I FROM PUBLIC', current_database()); END $pgm$`,
+		`REVOKE CREATE ON SCHEMA "public" FROM PUBLIC`,
+		`REVOKE EXECUTE ON FUNCTION "public"."f"(integer) FROM PUBLIC`,
+		`ALTER DEFAULT PRIVILEGES FOR ROLE "app" REVOKE EXECUTE ON FUNCTIONS FROM PUBLIC`,
+	} {
+		if !contains(plan.PostSchema, want) {
+			t.Errorf("PostSchema missing %q:\n%s", want, strings.Join(plan.PostSchema, "\n"))
+		}
+	}
+
+	// A role name is not a format() directive.
+	g := Grant{ObjectType: "DATABASE", Name: "shop", Grantee: "100%", Privilege: "CONNECT"}
+	if got := privilegeStmt(g, false); !strings.Contains(got, `TO "100%%"'`) {
+		t.Errorf("privilegeStmt = %s", got)
+	}
+}
+
+func TestQuoting(t *testing.T) {
+	if got := quoteIdent(`we"ird`); got != `"we""ird"` {
+		t.Errorf("quoteIdent = %s", got)
+	}
+	if got := quoteLiteral("it's"); got != "'it''s'" {
+		t.Errorf("quoteLiteral = %s", got)
+	}
+	if got := createRoleStmt("o'neil"); !strings.Contains(got, "rolname = 'o''neil'") || !strings.Contains(got, `CREATE ROLE "o'neil"`) {
+		t.Errorf("createRoleStmt = %s", got)
+	}
+}
@@ -171,11 +171,13 @@ func truncate(s string, n int) string {
 	return s[:n] + "..."
 }
 
+// isDuplicateObjectErr reports whether err is an object that already
+// exists, such as a schema or function shared by consolidated sources.
 func isDuplicateObjectErr(err error) bool {
 	var pgErr *pgconn.PgError
 	if errors.As(err, &pgErr) {
 		switch pgErr.Code {
-		case "42P07", "42P16", "42710":
+		case "42P06", "42P07", "42P16", "42710", "42723":
 			return true
 		}
 	}
@@ -0,0 +1,184 @@
+// Package shard routes rows between destination shards by a per-table
+// shard key, for splitting one database into several.
+package shard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/jfoltran/pgmanager/internal/config"
+	"github.com/jfoltran/pgmanager/internal/migration/stream"
+)
+
+// Routed is a change bound for one shard.
+type Routed struct {
+	Shard  int
+	Change *stream.ChangeMessage
+}
+
+type table struct {
+	key    string
+	method string
+	bounds []int64
+}
+
+// Router picks the shard of each row. Tables without a rule are reference
+// tables and go to every shard.
+type Router struct {
+	shards int
+	tables map[string]*table
+}
+
+// New creates a Router over shards destinations. The table names and
+// methods are those normalised by config.Validate.
+func New(tables []config.ShardTableConfig, shards int) (*Router, error) {
+	if shards < 2 {
+		return nil, errors.New("sharding requires at least two shards")
+	}
+	r := &Router{shards: shards, tables: make(map[string]*table)}
+	for _, t := range tables {
+		if t.Method == "range" && len(t.Bounds) != shards-1 {
+			return nil, fmt.Errorf("table %s: %d range bounds for %d shards", t.Table, len(t.Bounds), shards)
+		}
+		r.tables[t.Table] = &table{key: t.Key, method: t.Method, bounds: t.Bounds}
+	}
+	return r, nil
+}
+
+// Shards returns the number of shards.
+func (r *Router) Shards() int {
+	return r.shards
+}
+
+// Route returns the changes each shard gets for m. An update that moves a
+// row to another shard becomes a delete on the old shard and an insert on
+// the new one.
+func (r *Router) Route(m *stream.ChangeMessage) ([]Routed, error) {
+	t := r.tables[m.Namespace+"."+m.Table]
+	if t == nil {
+		out := make([]Routed, r.shards)
+		for i := range out {
+			out[i] = Routed{Shard: i, Change: m}
+		}
+		return out, nil
+	}
+
+	switch m.Op {
+	case stream.OpInsert:
+		s, err := r.shardOf(t, m, m.NewTuple)
+		if err != nil {
+			return nil, err
+		}
+		return []Routed{{Shard: s, Change: m}}, nil
+
+	case stream.OpDelete:
+		s, err := r.shardOf(t, m, m.OldTuple)
+		if err != nil {
+			return nil, err
+		}
+		return []Routed{{Shard: s, Change: m}}, nil
+
+	case stream.OpUpdate:
+		to, err := r.shardOf(t, m, m.NewTuple)
+		if err != nil {
+			return nil, err
+		}
+		// Without an old tuple the replica identity, and with it the shard
+		// key, did not change.
+		if m.OldTuple == nil {
+			return []Routed{{Shard: to, Change: m}}, nil
+		}
+		from, err := r.shardOf(t, m, m.OldTuple)
+		if err != nil {
+			return nil, err
+		}
+		if from == to {
+			return []Routed{{Shard: to, Change: m}}, nil
+		}
+		del := *m
+		del.Op, del.NewTuple = stream.OpDelete, nil
+		ins := *m
+		ins.Op, ins.OldTuple = stream.OpInsert, nil
+		return []Routed{{Shard: from, Change: &del}, {Shard: to, Change: &ins}}, nil
+	}
+	return nil, fmt.Errorf("%s.%s: cannot route %s", m.Namespace, m.Table, m.Op)
+}
+
+// shardOf returns the shard of the row in tuple. A NULL key is on shard 0.
+func (r *Router) shardOf(t *table, m *stream.ChangeMessage, tuple *stream.TupleData) (int, error) {
+	if tuple == nil {
+		return 0, fmt.Errorf("%s.%s: %s without a tuple", m.Namespace, m.Table, m.Op)
+	}
+	var col *stream.Column
+	for i := range tuple.Columns {
+		if tuple.Columns[i].Name == t.key {
+			col = &tuple.Columns[i]
+			break
+		}
+	}
+	if col == nil {
+		return 0, fmt.Errorf("%s.%s has no shard key column %s", m.Namespace, m.Table, t.key)
+	}
+	// An old tuple only carries the replica identity; other columns are
+	// NULL in it.
+	if tuple == m.OldTuple && !col.Key {
+		return 0, fmt.Errorf("shard key %s of %s.%s is not in the replica identity", t.key, m.Namespace, m.Table)
+	}
+	if col.Value == nil {
+		return 0, nil
+	}
+
+	if t.method == "range" {
+		v, err := strconv.ParseInt(string(col.Value), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("shard key %s of %s.%s: %q is not an integer", t.key, m.Namespace, m.Table, col.Value)
+		}
+		return sort.Search(len(t.bounds), func(i int) bool { return v < t.bounds[i] }), nil
+	}
+	h := fnv.New64a()
+	h.Write(col.Value)
+	return int(h.Sum64() % uint64(r.shards)), nil
+}
+
+// CheckKeys verifies on the source that every shard key is part of its
+// table's replica identity, so deletes and key updates carry it.
+func CheckKeys(ctx context.Context, pool *pgxpool.Pool, tables []config.ShardTableConfig) error {
+	for _, t := range tables {
+		schema, name, _ := strings.Cut(t.Table, ".")
+		qn := quoteIdent(schema) + "." + quoteIdent(name)
+		var hasColumn, inIdentity bool
+		err := pool.QueryRow(ctx, `
+			SELECT
+				EXISTS (SELECT 1 FROM pg_attribute a
+					WHERE a.attrelid = c.oid AND a.attname = $2 AND a.attnum > 0 AND NOT a.attisdropped),
+				c.relreplident = 'f' OR EXISTS (
+					SELECT 1 FROM pg_index i
+					JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+					WHERE i.indrelid = c.oid AND a.attname = $2
+						AND ((c.relreplident = 'd' AND i.indisprimary)
+							OR (c.relreplident = 'i' AND i.indisreplident)))
+			FROM pg_class c
+			WHERE c.oid = $1::regclass`, qn, t.Key).Scan(&hasColumn, &inIdentity)
+		if err != nil {
+			return fmt.Errorf("check shard key of %s: %w", t.Table, err)
+		}
+		switch {
+		case !hasColumn:
+			return fmt.Errorf("table %s has no shard key column %s", t.Table, t.Key)
+		case !inIdentity:
+			return fmt.Errorf("shard key %s of %s is not in its primary key or replica identity; add it or set REPLICA IDENTITY FULL", t.Key, t.Table)
+		}
+	}
+	return nil
+}
+
+func quoteIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
@@ -0,0 +1,126 @@
+package shard
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jfoltran/pgmanager/internal/config"
+	"github.com/jfoltran/pgmanager/internal/migration/stream"
+)
+
+func row(id, customer string) *stream.TupleData {
+	return &stream.TupleData{Columns: []stream.Column{
+		{Name: "id", Value: []byte(id), Key: true},
+		{Name: "customer_id", Value: []byte(customer), Key: true},
+	}}
+}
+
+func newRouter(t *testing.T) *Router {
+	t.Helper()
+	r, err := New([]config.ShardTableConfig{
+		{Table: "public.orders", Key: "customer_id", Method: "hash"},
+		{Table: "public.invoices", Key: "customer_id", Method: "range", Bounds: []int64{100, 200}},
+	}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func shardsOf(rs []Routed) []int {
+	out := make([]int, len(rs))
+	for i, r := range rs {
+		out[i] = r.Shard
+	}
+	return out
+}
+
+func TestRouteHashIsStable(t *testing.T) {
+	r := newRouter(t)
+	seen := make(map[int]bool)
+	for _, c := range []string{"1", "2", "3", "4", "5", "6", "7", "8"} {
+		ins := &stream.ChangeMessage{Op: stream.OpInsert, Namespace: "public", Table: "orders", NewTuple: row("9", c)}
+		a, err := r.Route(ins)
+		if err != nil {
+			t.Fatal(err)
+		}
+		del := &stream.ChangeMessage{Op: stream.OpDelete, Namespace: "public", Table: "orders", OldTuple: row("9", c)}
+		b, _ := r.Route(del)
+		if len(a) != 1 || len(b) != 1 || a[0].Shard != b[0].Shard {
+			t.Errorf("customer %s: insert on %v, delete on %v", c, shardsOf(a), shardsOf(b))
+		}
+		seen[a[0].Shard] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("8 keys all hashed to shards %v", seen)
+	}
+}
+
+func TestRouteRange(t *testing.T) {
+	r := newRouter(t)
+	for key, want := range map[string]int{"-5": 0, "99": 0, "100": 1, "199": 1, "200": 2, "9000": 2} {
+		rs, err := r.Route(&stream.ChangeMessage{Op: stream.OpInsert, Namespace: "public", Table: "invoices", NewTuple: row("1", key)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rs[0].Shard != want {
+			t.Errorf("key %s on shard %d, want %d", key, rs[0].Shard, want)
+		}
+	}
+	_, err := r.Route(&stream.ChangeMessage{Op: stream.OpInsert, Namespace: "public", Table: "invoices", NewTuple: row("1", "abc")})
+	if err == nil || !strings.Contains(err.Error(), "not an integer") {
+		t.Errorf("expected integer error, got %v", err)
+	}
+}
+
+func TestRouteReferenceTable(t *testing.T) {
+	r := newRouter(t)
+	m := &stream.ChangeMessage{Op: stream.OpUpdate, Namespace: "public", Table: "countries", NewTuple: row("1", "1")}
+	rs, err := r.Route(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := shardsOf(rs); len(got) != 3 || got[0] != 0 || got[2] != 2 || rs[1].Change != m {
+		t.Errorf("reference change routed to %v", got)
+	}
+}
+
+func TestRouteShardKeyUpdate(t *testing.T) {
+	r := newRouter(t)
+	same := &stream.ChangeMessage{Op: stream.OpUpdate, Namespace: "public", Table: "invoices", NewTuple: row("1", "150")}
+	if rs, _ := r.Route(same); len(rs) != 1 || rs[0].Shard != 1 || rs[0].Change != same {
+		t.Errorf("update without old tuple routed to %v", shardsOf(rs))
+	}
+
+	moved := &stream.ChangeMessage{Op: stream.OpUpdate, Namespace: "public", Table: "invoices",
+		OldTuple: row("1", "50"), NewTuple: row("1", "250")}
+	rs, err := r.Route(moved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != 2 {
+		t.Fatalf("moved row routed to %v", shardsOf(rs))
+	}
+	del, ins := rs[0], rs[1]
+	if del.Shard != 0 || del.Change.Op != stream.OpDelete || del.Change.OldTuple == nil || del.Change.NewTuple != nil {
+		t.Errorf("old shard got %d %+v", del.Shard, del.Change)
+	}
+	if ins.Shard != 2 || ins.Change.Op != stream.OpInsert || ins.Change.NewTuple == nil || ins.Change.OldTuple != nil {
+		t.Errorf("new shard got %d %+v", ins.Shard, ins.Change)
+	}
+	if moved.Op != stream.OpUpdate {
+		t.Error("Route modified the original change")
+	}
+}
+
+func TestRouteKeyNotInIdentity(t *testing.T) {
+	r := newRouter(t)
+	old := &stream.TupleData{Columns: []stream.Column{
+		{Name: "id", Value: []byte("1"), Key: true},
+		{Name: "customer_id"},
+	}}
+	_, err := r.Route(&stream.ChangeMessage{Op: stream.OpDelete, Namespace: "public", Table: "orders", OldTuple: old})
+	if err == nil || !strings.Contains(err.Error(), "replica identity") {
+		t.Errorf("expected replica identity error, got %v", err)
+	}
+}
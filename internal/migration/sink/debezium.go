@@ -0,0 +1,342 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/jfoltran/pgmanager/internal/migration/stream"
+)
+
+// DebeziumOptions configures the Debezium envelope sink.
+type DebeziumOptions struct {
+	// ServerName is the logical server name: the prefix of schema names
+	// and source.name.
+	ServerName string
+	// Database is reported as source.db.
+	Database string
+}
+
+// Debezium writes each row change as a Debezium PostgreSQL connector change
+// event, in the JSON converter's format with the schema block included, one
+// event per line. Consumers of Debezium topics can read the output as is.
+type Debezium struct {
+	opts   DebeziumOptions
+	logger zerolog.Logger
+
+	w *bufio.Writer
+	f *os.File // nil for stdout
+
+	tables map[string]*dbzTable
+
+	// Current transaction.
+	inTxn      bool
+	xid        uint32
+	commitTime time.Time
+	lastCommit int64
+
+	buf []byte
+}
+
+// dbzTable is the cached schema of one table.
+type dbzTable struct {
+	columns []dbzColumn
+	schema  json.RawMessage
+}
+
+type dbzColumn struct {
+	name string
+	typ  dbzType
+}
+
+// NewDebezium creates a sink that appends to path, or writes to stdout when
+// path is empty or "-".
+func NewDebezium(path string, opts DebeziumOptions, logger zerolog.Logger) (*Debezium, error) {
+	s := &Debezium{
+		opts:   opts,
+		logger: logger.With().Str("component", "debezium-sink").Logger(),
+		tables: make(map[string]*dbzTable),
+	}
+	var out io.Writer = os.Stdout
+	if path != "" && path != "-" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("open sink file: %w", err)
+		}
+		s.f = f
+		out = f
+	}
+	s.w = bufio.NewWriterSize(out, 256<<10)
+	return s, nil
+}
+
+// newDebeziumWriter creates a sink writing to w, for tests.
+func newDebeziumWriter(w io.Writer, opts DebeziumOptions) *Debezium {
+	return &Debezium{
+		opts:   opts,
+		logger: zerolog.Nop(),
+		tables: make(map[string]*dbzTable),
+		w:      bufio.NewWriter(w),
+	}
+}
+
+func (s *Debezium) Relation(_ context.Context, m *stream.RelationMessage) error {
+	s.tables[m.Namespace+"."+m.Name] = s.newTable(m.Namespace, m.Name, m.Columns)
+	return nil
+}
+
+func (s *Debezium) Begin(_ context.Context, m *stream.BeginMessage) error {
+	s.inTxn = true
+	s.xid = m.XID
+	s.commitTime = m.TxnTime
+	return nil
+}
+
+func (s *Debezium) Change(_ context.Context, m *stream.ChangeMessage) error {
+	tbl := s.table(m)
+
+	ev := dbzPayload{
+		Source: dbzSource{
+			Version:   "pgmanager",
+			Connector: "postgresql",
+			Name:      s.opts.ServerName,
+			DB:        s.opts.Database,
+			Schema:    m.Namespace,
+			Table:     m.Table,
+		},
+		TsMs: time.Now().UnixMilli(),
+	}
+	if s.inTxn {
+		lsn, xid := int64(m.MsgLSN), int64(s.xid)
+		seq := fmt.Sprintf(`["%d","%d"]`, s.lastCommit, lsn)
+		ev.Source.Snapshot = "false"
+		ev.Source.TsMs = s.commitTime.UnixMilli()
+		ev.Source.LSN = &lsn
+		ev.Source.TxID = &xid
+		ev.Source.Sequence = &seq
+		switch m.Op {
+		case stream.OpInsert:
+			ev.Op = "c"
+		case stream.OpUpdate:
+			ev.Op = "u"
+		case stream.OpDelete:
+			ev.Op = "d"
+		}
+	} else {
+		ev.Source.Snapshot = "true"
+		ev.Source.TsMs = ev.TsMs
+		ev.Op = "r"
+	}
+
+	var err error
+	if m.OldTuple != nil {
+		if ev.Before, err = tbl.row(m.OldTuple.Columns); err != nil {
+			return fmt.Errorf("%s.%s: %w", m.Namespace, m.Table, err)
+		}
+	}
+	if m.NewTuple != nil {
+		if ev.After, err = tbl.row(m.NewTuple.Columns); err != nil {
+			return fmt.Errorf("%s.%s: %w", m.Namespace, m.Table, err)
+		}
+	}
+
+	b, err := json.Marshal(dbzEvent{Schema: tbl.schema, Payload: ev})
+	if err != nil {
+		return fmt.Errorf("encode event: %w", err)
+	}
+	s.buf = append(append(s.buf[:0], b...), '\n')
+	if _, err := s.w.Write(s.buf); err != nil {
+		return fmt.Errorf("write event: %w", err)
+	}
+	return nil
+}
+
+func (s *Debezium) Commit(_ context.Context, m *stream.CommitMessage) error {
+	s.inTxn = false
+	s.lastCommit = int64(m.CommitLSN)
+	return nil
+}
+
+// Flush writes buffered events and fsyncs the output file.
+func (s *Debezium) Flush(context.Context) error {
+	if err := s.w.Flush(); err != nil {
+		return fmt.Errorf("flush sink output: %w", err)
+	}
+	if s.f != nil {
+		if err := s.f.Sync(); err != nil {
+			return fmt.Errorf("sync sink file: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close flushes and closes the output file.
+func (s *Debezium) Close() error {
+	err := s.Flush(context.Background())
+	if s.f != nil {
+		if cerr := s.f.Close(); err == nil {
+			err = cerr
+		}
+		s.f = nil
+	}
+	return err
+}
+
+// table returns the schema of m's table from its last relation message,
+// or from the row itself for snapshot rows, which have none.
+func (s *Debezium) table(m *stream.ChangeMessage) *dbzTable {
+	key := m.Namespace + "." + m.Table
+	if tbl, ok := s.tables[key]; ok {
+		return tbl
+	}
+	var cols []stream.Column
+	switch {
+	case m.NewTuple != nil:
+		cols = m.NewTuple.Columns
+	case m.OldTuple != nil:
+		cols = m.OldTuple.Columns
+	}
+	tbl := s.newTable(m.Namespace, m.Table, cols)
+	s.tables[key] = tbl
+	return tbl
+}
+
+func (s *Debezium) newTable(namespace, name string, cols []stream.Column) *dbzTable {
+	tbl := &dbzTable{columns: make([]dbzColumn, len(cols))}
+	fields := make([]dbzField, len(cols))
+	for i, c := range cols {
+		t := dbzTypeOf(c.DataType)
+		tbl.columns[i] = dbzColumn{name: c.Name, typ: t}
+		fields[i] = dbzField{Type: t.Type, Optional: !c.Key, Name: t.Name, Field: c.Name}
+		if t.Name != "" {
+			fields[i].Version = 1
+		}
+	}
+	prefix := s.opts.ServerName + "." + namespace + "." + name
+	value := func(field string) dbzField {
+		return dbzField{Type: "struct", Fields: fields, Optional: true, Name: prefix + ".Value", Field: field}
+	}
+	schema := dbzField{
+		Type: "struct",
+		Fields: []dbzField{
+			value("before"),
+			value("after"),
+			dbzSourceSchema,
+			{Type: "string", Field: "op"},
+			{Type: "int64", Optional: true, Field: "ts_ms"},
+			dbzTransactionSchema,
+		},
+		Name:    prefix + ".Envelope",
+		Version: 1,
+	}
+	tbl.schema, _ = json.Marshal(schema)
+	return tbl
+}
+
+// row encodes a tuple as a JSON object in the table's column order.
+// Columns missing from the tuple, such as non-key columns of an old
+// tuple, are null.
+func (t *dbzTable) row(cols []stream.Column) (json.RawMessage, error) {
+	byName := make(map[string][]byte, len(cols))
+	for _, c := range cols {
+		byName[c.Name] = c.Value
+	}
+	buf := []byte{'{'}
+	for i, c := range t.columns {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		name, _ := json.Marshal(c.name)
+		buf = append(append(buf, name...), ':')
+		v, err := c.typ.value(byName[c.name])
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", c.name, err)
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", c.name, err)
+		}
+		buf = append(buf, b...)
+	}
+	return append(buf, '}'), nil
+}
+
+type dbzEvent struct {
+	Schema  json.RawMessage `json:"schema"`
+	Payload dbzPayload      `json:"payload"`
+}
+
+type dbzPayload struct {
+	Before      json.RawMessage `json:"before"`
+	After       json.RawMessage `json:"after"`
+	Source      dbzSource       `json:"source"`
+	Op          string          `json:"op"`
+	TsMs        int64           `json:"ts_ms"`
+	Transaction json.RawMessage `json:"transaction"`
+}
+
+type dbzSource struct {
+	Version   string  `json:"version"`
+	Connector string  `json:"connector"`
+	Name      string  `json:"name"`
+	TsMs      int64   `json:"ts_ms"`
+	Snapshot  string  `json:"snapshot"`
+	DB        string  `json:"db"`
+	Sequence  *string `json:"sequence"`
+	Schema    string  `json:"schema"`
+	Table     string  `json:"table"`
+	TxID      *int64  `json:"txId"`
+	LSN       *int64  `json:"lsn"`
+	Xmin      *int64  `json:"xmin"`
+}
+
+// dbzField is a Kafka Connect schema.
+type dbzField struct {
+	Type       string            `json:"type"`
+	Fields     []dbzField        `json:"fields,omitempty"`
+	Optional   bool              `json:"optional"`
+	Name       string            `json:"name,omitempty"`
+	Version    int               `json:"version,omitempty"`
+	Parameters map[string]string `json:"parameters,omitempty"`
+	Field      string            `json:"field,omitempty"`
+}
+
+var dbzSourceSchema = dbzField{
+	Type: "struct",
+	Fields: []dbzField{
+		{Type: "string", Field: "version"},
+		{Type: "string", Field: "connector"},
+		{Type: "string", Field: "name"},
+		{Type: "int64", Field: "ts_ms"},
+		{Type: "string", Optional: true, Name: "io.debezium.data.Enum", Version: 1,
+			Parameters: map[string]string{"allowed": "true,last,false,incremental"}, Field: "snapshot"},
+		{Type: "string", Field: "db"},
+		{Type: "string", Optional: true, Field: "sequence"},
+		{Type: "string", Field: "schema"},
+		{Type: "string", Field: "table"},
+		{Type: "int64", Optional: true, Field: "txId"},
+		{Type: "int64", Optional: true, Field: "lsn"},
+		{Type: "int64", Optional: true, Field: "xmin"},
+	},
+	Name:  "io.debezium.connector.postgresql.Source",
+	Field: "source",
+}
+
+var dbzTransactionSchema = dbzField{
+	Type: "struct",
+	Fields: []dbzField{
+		{Type: "string", Field: "id"},
+		{Type: "int64", Field: "total_order"},
+		{Type: "int64", Field: "data_collection_order"},
+	},
+	Optional: true,
+	Name:     "event.block",
+	Version:  1,
+	Field:    "transaction",
+}
@@ -0,0 +1,205 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/jfoltran/pgmanager/internal/migration/stream"
+)
+
+func TestDbzTypeValues(t *testing.T) {
+	tests := []struct {
+		oid  uint32
+		in   string
+		want any
+	}{
+		{pgtype.BoolOID, "t", true},
+		{pgtype.BoolOID, "false", false},
+		{pgtype.Int2OID, "-7", int64(-7)},
+		{pgtype.Int8OID, "9007199254740993", int64(9007199254740993)},
+		{pgtype.Float8OID, "1.5", 1.5},
+		{pgtype.Float8OID, "NaN", nil},
+		{pgtype.NumericOID, "12345678901234567890.5", "12345678901234567890.5"},
+		{pgtype.ByteaOID, `\x01ff`, []byte{0x01, 0xff}},
+		{pgtype.DateOID, "1970-01-11", int32(10)},
+		{pgtype.DateOID, "1969-12-31", int32(-1)},
+		{pgtype.DateOID, "infinity", int32(math.MaxInt32)},
+		{pgtype.TimeOID, "01:00:00.5", int64(3600_500_000)},
+		{pgtype.TimeOID, "24:00:00", int64(86400_000_000)},
+		{pgtype.TimetzOID, "15:04:05.25+02", "13:04:05.25Z"},
+		{pgtype.TimestampOID, "1970-01-01 00:00:01.000002", int64(1_000_002)},
+		{pgtype.TimestampOID, "0001-01-01 00:00:00 BC", time.Date(0, 1, 1, 0, 0, 0, 0, time.UTC).UnixMicro()},
+		{pgtype.TimestamptzOID, "2024-03-01 12:00:00.123+05:30", "2024-03-01T06:30:00.123Z"},
+		{pgtype.IntervalOID, "1 day 01:00:00", int64(25 * time.Hour / time.Microsecond)},
+		{pgtype.IntervalOID, "-00:00:01.5", int64(-1_500_000)},
+		{pgtype.IntervalOID, "1 mon", int64(dbzDaysPerMonth * 86400 * 1e6)},
+		{pgtype.UUIDOID, "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11", "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"},
+		{pgtype.TextArrayOID, "{a,b}", "{a,b}"},
+	}
+	for _, tt := range tests {
+		got, err := dbzTypeOf(tt.oid).value([]byte(tt.in))
+		if err != nil {
+			t.Errorf("%d %q: %v", tt.oid, tt.in, err)
+			continue
+		}
+		if b, ok := tt.want.([]byte); ok {
+			if !bytes.Equal(got.([]byte), b) {
+				t.Errorf("%d %q = %v, want %v", tt.oid, tt.in, got, tt.want)
+			}
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%d %q = %#v, want %#v", tt.oid, tt.in, got, tt.want)
+		}
+	}
+
+	if v, err := dbzTypeOf(pgtype.Int4OID).value(nil); v != nil || err != nil {
+		t.Errorf("NULL = %v, %v", v, err)
+	}
+	if _, err := dbzTypeOf(pgtype.Int4OID).value([]byte("x")); err == nil {
+		t.Error("expected an error for an invalid integer")
+	}
+}
+
+func TestDebeziumEvents(t *testing.T) {
+	var out bytes.Buffer
+	s := newDebeziumWriter(&out, DebeziumOptions{ServerName: "srv", Database: "app"})
+	ctx := context.Background()
+
+	cols := []stream.Column{
+		{Name: "id", DataType: pgtype.Int4OID, Key: true},
+		{Name: "name", DataType: pgtype.TextOID},
+	}
+	row := func(id, name string) *stream.TupleData {
+		c := append([]stream.Column{}, cols...)
+		c[0].Value = []byte(id)
+		if name != "" {
+			c[1].Value = []byte(name)
+		}
+		return &stream.TupleData{Columns: c}
+	}
+
+	// A snapshot row, before any relation message.
+	must(t, s.Change(ctx, &stream.ChangeMessage{Op: stream.OpInsert, Namespace: "public", Table: "users", NewTuple: row("1", "alice")}))
+
+	commitTime := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	must(t, s.Relation(ctx, &stream.RelationMessage{Namespace: "public", Name: "users", Columns: cols}))
+	must(t, s.Begin(ctx, &stream.BeginMessage{XID: 42, TxnTime: commitTime}))
+	must(t, s.Change(ctx, &stream.ChangeMessage{Op: stream.OpUpdate, Namespace: "public", Table: "users", MsgLSN: 100,
+		NewTuple: row("1", "bob")}))
+	must(t, s.Change(ctx, &stream.ChangeMessage{Op: stream.OpDelete, Namespace: "public", Table: "users", MsgLSN: 110,
+		OldTuple: &stream.TupleData{Columns: []stream.Column{{Name: "id", Value: []byte("1")}}}}))
+	must(t, s.Commit(ctx, &stream.CommitMessage{CommitLSN: 120}))
+	must(t, s.Flush(ctx))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d events, want 3:\n%s", len(lines), out.String())
+	}
+
+	type event struct {
+		Schema struct {
+			Name   string `json:"name"`
+			Fields []struct {
+				Field  string `json:"field"`
+				Fields []struct {
+					Type     string `json:"type"`
+					Optional bool   `json:"optional"`
+					Field    string `json:"field"`
+				} `json:"fields"`
+			} `json:"fields"`
+		} `json:"schema"`
+		Payload struct {
+			Before map[string]any `json:"before"`
+			After  map[string]any `json:"after"`
+			Source map[string]any `json:"source"`
+			Op     string         `json:"op"`
+		} `json:"payload"`
+	}
+	var evs [3]event
+	for i, l := range lines {
+		if err := json.Unmarshal([]byte(l), &evs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snap := evs[0]
+	if snap.Schema.Name != "srv.public.users.Envelope" {
+		t.Errorf("schema name = %q", snap.Schema.Name)
+	}
+	if f := snap.Schema.Fields[1]; f.Field != "after" || f.Fields[0].Type != "int32" || f.Fields[0].Optional {
+		t.Errorf("after schema = %+v", f)
+	}
+	if snap.Payload.Op != "r" || snap.Payload.Source["snapshot"] != "true" || snap.Payload.After["id"] != float64(1) {
+		t.Errorf("snapshot payload = %+v", snap.Payload)
+	}
+
+	upd := evs[1]
+	if upd.Payload.Op != "u" || upd.Payload.Before != nil || upd.Payload.After["name"] != "bob" {
+		t.Errorf("update payload = %+v", upd.Payload)
+	}
+	src := upd.Payload.Source
+	if src["lsn"] != float64(100) || src["txId"] != float64(42) || src["ts_ms"] != float64(commitTime.UnixMilli()) ||
+		src["db"] != "app" || src["name"] != "srv" || src["snapshot"] != "false" {
+		t.Errorf("update source = %v", src)
+	}
+
+	del := evs[2]
+	if del.Payload.Op != "d" || del.Payload.After != nil || del.Payload.Before["id"] != float64(1) {
+		t.Errorf("delete payload = %+v", del.Payload)
+	}
+	if v, ok := del.Payload.Before["name"]; !ok || v != nil {
+		t.Errorf("delete before.name = %v, %v; want null", v, ok)
+	}
+}
+
+// TestDebeziumTextPlugins feeds the sink changes as the wal2json and
+// test_decoding decoders emit them: booleans spelled out, and type OIDs
+// from wal2json's typeoid or test_decoding's type names.
+func TestDebeziumTextPlugins(t *testing.T) {
+	for _, plugin := range []string{stream.PluginWal2JSON, stream.PluginTestDecoding} {
+		t.Run(plugin, func(t *testing.T) {
+			var out bytes.Buffer
+			s := newDebeziumWriter(&out, DebeziumOptions{ServerName: "srv", Database: "app"})
+			ctx := context.Background()
+
+			cols := []stream.Column{
+				{Name: "id", DataType: pgtype.Int4OID, Value: []byte("7"), Key: true},
+				{Name: "active", DataType: pgtype.BoolOID, Value: []byte("true")},
+				{Name: "deleted", DataType: pgtype.BoolOID, Value: []byte("false")},
+			}
+			must(t, s.Relation(ctx, &stream.RelationMessage{Namespace: "public", Name: "users", Columns: cols}))
+			must(t, s.Begin(ctx, &stream.BeginMessage{XID: 1}))
+			must(t, s.Change(ctx, &stream.ChangeMessage{Op: stream.OpInsert, Namespace: "public", Table: "users", MsgLSN: 100,
+				NewTuple: &stream.TupleData{Columns: cols}}))
+			must(t, s.Commit(ctx, &stream.CommitMessage{CommitLSN: 110}))
+			must(t, s.Flush(ctx))
+
+			var ev struct {
+				Payload struct {
+					After map[string]any `json:"after"`
+				} `json:"payload"`
+			}
+			if err := json.Unmarshal(out.Bytes(), &ev); err != nil {
+				t.Fatal(err)
+			}
+			if a := ev.Payload.After; a["id"] != float64(7) || a["active"] != true || a["deleted"] != false {
+				t.Errorf("after = %v", a)
+			}
+		})
+	}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
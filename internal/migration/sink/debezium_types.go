@@ -0,0 +1,286 @@
+package sink
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// dbzType maps a PostgreSQL type to a Kafka Connect schema type, following
+// the Debezium PostgreSQL connector with decimal.handling.mode=string and
+// the default time and interval modes.
+type dbzType struct {
+	// Type is the Connect type: boolean, int16, int32, int64, float32,
+	// float64, string or bytes.
+	Type string
+	// Name is the Debezium semantic type, if any.
+	Name string
+	// conv converts a value in PostgreSQL text format; nil keeps the text.
+	conv func(s string) (any, error)
+}
+
+var dbzString = dbzType{Type: "string"}
+
+var dbzTypes = map[uint32]dbzType{
+	pgtype.BoolOID:        {Type: "boolean", conv: parseBool},
+	pgtype.Int2OID:        {Type: "int16", conv: parseInt(16)},
+	pgtype.Int4OID:        {Type: "int32", conv: parseInt(32)},
+	pgtype.Int8OID:        {Type: "int64", conv: parseInt(64)},
+	pgtype.OIDOID:         {Type: "int64", conv: parseInt(64)},
+	pgtype.Float4OID:      {Type: "float32", conv: parseFloat(32)},
+	pgtype.Float8OID:      {Type: "float64", conv: parseFloat(64)},
+	pgtype.NumericOID:     dbzString,
+	pgtype.ByteaOID:       {Type: "bytes", conv: parseBytea},
+	pgtype.UUIDOID:        {Type: "string", Name: "io.debezium.data.Uuid"},
+	pgtype.JSONOID:        {Type: "string", Name: "io.debezium.data.Json"},
+	pgtype.JSONBOID:       {Type: "string", Name: "io.debezium.data.Json"},
+	pgtype.XMLOID:         {Type: "string", Name: "io.debezium.data.Xml"},
+	pgtype.DateOID:        {Type: "int32", Name: "io.debezium.time.Date", conv: parseDate},
+	pgtype.TimeOID:        {Type: "int64", Name: "io.debezium.time.MicroTime", conv: parseTime},
+	pgtype.TimetzOID:      {Type: "string", Name: "io.debezium.time.ZonedTime", conv: parseTimetz},
+	pgtype.TimestampOID:   {Type: "int64", Name: "io.debezium.time.MicroTimestamp", conv: parseTimestamp},
+	pgtype.TimestamptzOID: {Type: "string", Name: "io.debezium.time.ZonedTimestamp", conv: parseTimestamptz},
+	pgtype.IntervalOID:    {Type: "int64", Name: "io.debezium.time.MicroDuration", conv: parseInterval},
+}
+
+// dbzTypeOf returns the Connect type for oid. Types without a mapping,
+// including arrays, are strings in PostgreSQL text format.
+func dbzTypeOf(oid uint32) dbzType {
+	if t, ok := dbzTypes[oid]; ok {
+		return t
+	}
+	return dbzString
+}
+
+// value converts v, in PostgreSQL text format, to its JSON value.
+func (t dbzType) value(v []byte) (any, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if t.conv == nil {
+		return string(v), nil
+	}
+	return t.conv(string(v))
+}
+
+// parseBool accepts pgoutput's "t"/"f" and the "true"/"false" of wal2json
+// and test_decoding.
+func parseBool(s string) (any, error) {
+	switch s {
+	case "t", "true":
+		return true, nil
+	case "f", "false":
+		return false, nil
+	}
+	return nil, fmt.Errorf("invalid boolean %q", s)
+}
+
+func parseInt(bits int) func(string) (any, error) {
+	return func(s string) (any, error) {
+		return strconv.ParseInt(s, 10, bits)
+	}
+}
+
+// parseFloat returns null for NaN and infinities, which JSON cannot hold.
+func parseFloat(bits int) func(string) (any, error) {
+	return func(s string) (any, error) {
+		f, err := strconv.ParseFloat(s, bits)
+		if err != nil {
+			return nil, err
+		}
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, nil
+		}
+		return f, nil
+	}
+}
+
+// parseBytea decodes the hex output format; the JSON encoder writes the
+// bytes as base64.
+func parseBytea(s string) (any, error) {
+	if !strings.HasPrefix(s, `\x`) {
+		return nil, fmt.Errorf("bytea not in hex format")
+	}
+	return hex.DecodeString(s[2:])
+}
+
+// Infinite dates and timestamps become the largest and smallest values.
+func infinity(s string, bits int) (int64, bool) {
+	switch s {
+	case "infinity":
+		if bits == 32 {
+			return math.MaxInt32, true
+		}
+		return math.MaxInt64, true
+	case "-infinity":
+		if bits == 32 {
+			return math.MinInt32, true
+		}
+		return math.MinInt64, true
+	}
+	return 0, false
+}
+
+// parseBC parses s with layout, handling the " BC" suffix PostgreSQL
+// writes for years before 1 AD.
+func parseBC(layout, s string) (time.Time, error) {
+	bc := strings.HasSuffix(s, " BC")
+	t, err := time.Parse(layout, strings.TrimSuffix(s, " BC"))
+	if err != nil || !bc {
+		return t, err
+	}
+	return t.AddDate(1-2*t.Year(), 0, 0), nil
+}
+
+// parseDate returns days since the epoch.
+func parseDate(s string) (any, error) {
+	if n, ok := infinity(s, 32); ok {
+		return int32(n), nil
+	}
+	t, err := parseBC("2006-01-02", s)
+	if err != nil {
+		return nil, err
+	}
+	return int32(t.Unix() / 86400), nil
+}
+
+// parseTime returns microseconds since midnight.
+func parseTime(s string) (any, error) {
+	if s == "24:00:00" {
+		return int64(24 * time.Hour / time.Microsecond), nil
+	}
+	t, err := time.Parse("15:04:05", s)
+	if err != nil {
+		return nil, err
+	}
+	d := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())
+	return int64(d / time.Microsecond), nil
+}
+
+// parseTimetz returns the time in UTC, as in "13:04:05.5Z".
+func parseTimetz(s string) (any, error) {
+	t, err := parseWithOffset("15:04:05", s)
+	if err != nil {
+		return nil, err
+	}
+	return t.UTC().Format("15:04:05.999999Z07:00"), nil
+}
+
+// parseTimestamp returns microseconds since the epoch.
+func parseTimestamp(s string) (any, error) {
+	if n, ok := infinity(s, 64); ok {
+		return n, nil
+	}
+	t, err := parseBC("2006-01-02 15:04:05", s)
+	if err != nil {
+		return nil, err
+	}
+	return t.UnixMicro(), nil
+}
+
+// parseTimestamptz returns the timestamp in UTC in ISO 8601 format.
+func parseTimestamptz(s string) (any, error) {
+	switch s {
+	case "infinity", "-infinity":
+		return s, nil
+	}
+	bc := strings.HasSuffix(s, " BC")
+	t, err := parseWithOffset("2006-01-02 15:04:05", strings.TrimSuffix(s, " BC"))
+	if err != nil {
+		return nil, err
+	}
+	if bc {
+		t = t.AddDate(1-2*t.Year(), 0, 0)
+	}
+	return t.UTC().Format(time.RFC3339Nano), nil
+}
+
+// parseWithOffset parses s with layout followed by a UTC offset in any of
+// the forms PostgreSQL writes: +05, +05:30 or +05:30:15.
+func parseWithOffset(layout, s string) (time.Time, error) {
+	var err error
+	for _, offset := range []string{"-07", "-07:00", "-07:00:00"} {
+		var t time.Time
+		if t, err = time.Parse(layout+offset, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// Debezium converts months to days with an average month length.
+const dbzDaysPerMonth = 365.25 / 12
+
+// parseInterval returns the interval in microseconds. It reads the default
+// IntervalStyle, as in "1 year 2 mons -3 days 04:05:06.5".
+func parseInterval(s string) (any, error) {
+	var months, days float64
+	var micros int64
+	fields := strings.Fields(s)
+	for i := 0; i < len(fields); i++ {
+		f := fields[i]
+		if strings.Contains(f, ":") {
+			us, err := parseClock(f)
+			if err != nil {
+				return nil, fmt.Errorf("invalid interval %q: %w", s, err)
+			}
+			micros += us
+			continue
+		}
+		if i+1 == len(fields) {
+			return nil, fmt.Errorf("invalid interval %q", s)
+		}
+		n, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval %q: %w", s, err)
+		}
+		i++
+		switch unit := strings.TrimSuffix(fields[i], "s"); unit {
+		case "year":
+			months += 12 * n
+		case "mon":
+			months += n
+		case "day":
+			days += n
+		default:
+			return nil, fmt.Errorf("invalid interval unit %q", fields[i])
+		}
+	}
+	totalDays := months*dbzDaysPerMonth + days
+	return int64(totalDays*86400*1e6) + micros, nil
+}
+
+// parseClock parses [-]H:MM:SS[.ffffff] into microseconds. Hours may
+// exceed 24.
+func parseClock(s string) (int64, error) {
+	sign := int64(1)
+	if strings.HasPrefix(s, "-") {
+		sign, s = -1, s[1:]
+	} else {
+		s = strings.TrimPrefix(s, "+")
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	h, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	m, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	sec, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil {
+		return 0, err
+	}
+	us := (h*3600+m*60)*1e6 + int64(math.Round(sec*1e6))
+	return sign * us, nil
+}
@@ -0,0 +1,295 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/jfoltran/pgmanager/internal/migration/stream"
+)
+
+// DefaultNDJSONSegmentBytes is the file size at which the NDJSON sink
+// rotates.
+const DefaultNDJSONSegmentBytes = 64 << 20
+
+const ndjsonExt = ".ndjson"
+
+// NDJSONOptions controls file rotation and retention.
+type NDJSONOptions struct {
+	// SegmentBytes rotates to a new file once the current one is past
+	// this size, at the next transaction boundary (default 64 MiB).
+	SegmentBytes int64
+	// MaxSegments deletes the oldest files beyond this count; 0 keeps
+	// every file.
+	MaxSegments int
+}
+
+// NDJSON writes one JSON object per event to numbered files in a
+// directory. Files only rotate between transactions, so a transaction is
+// always in one file.
+type NDJSON struct {
+	dir    string
+	opts   NDJSONOptions
+	logger zerolog.Logger
+
+	f     *os.File
+	bw    *bufio.Writer
+	size  int64
+	seq   int
+	inTxn bool
+	buf   []byte
+}
+
+// Event is the JSON form of a stream message: one line of NDJSON output,
+// or one change in a webhook request.
+type Event struct {
+	// Op is begin, commit, relation, insert, update or delete.
+	Op     string     `json:"op"`
+	LSN    string     `json:"lsn,omitempty"`
+	XID    uint32     `json:"xid,omitempty"`
+	Time   *time.Time `json:"time,omitempty"`
+	Origin string     `json:"origin,omitempty"`
+	Schema string     `json:"schema,omitempty"`
+	Table  string     `json:"table,omitempty"`
+	// Snapshot marks rows of the initial snapshot.
+	Snapshot bool         `json:"snapshot,omitempty"`
+	Columns  []ColumnInfo `json:"columns,omitempty"`
+	Old      Row          `json:"old,omitempty"`
+	New      Row          `json:"new,omitempty"`
+}
+
+// ColumnInfo describes a column in a relation event.
+type ColumnInfo struct {
+	Name    string `json:"name"`
+	TypeOID uint32 `json:"type_oid"`
+	Key     bool   `json:"key,omitempty"`
+}
+
+// Row is a tuple encoded as a JSON object in column order. Values are
+// strings in PostgreSQL text format and NULL is null.
+type Row []stream.Column
+
+// MarshalJSON encodes the row as an object that keeps column order.
+func (r Row) MarshalJSON() ([]byte, error) {
+	buf := []byte{'{'}
+	for i, c := range r {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		name, err := json.Marshal(c.Name)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(append(buf, name...), ':')
+		if c.Value == nil {
+			buf = append(buf, "null"...)
+			continue
+		}
+		v, err := json.Marshal(string(c.Value))
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, v...)
+	}
+	return append(buf, '}'), nil
+}
+
+// NewNDJSON creates dir if needed and prepares the sink. Existing files are
+// kept; output continues in a new file after them.
+func NewNDJSON(dir string, opts NDJSONOptions, logger zerolog.Logger) (*NDJSON, error) {
+	if opts.SegmentBytes <= 0 {
+		opts.SegmentBytes = DefaultNDJSONSegmentBytes
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create sink dir: %w", err)
+	}
+	files, err := ndjsonFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &NDJSON{
+		dir:    dir,
+		opts:   opts,
+		logger: logger.With().Str("component", "ndjson-sink").Logger(),
+	}
+	if len(files) > 0 {
+		s.seq, _ = strconv.Atoi(strings.TrimSuffix(filepath.Base(files[len(files)-1]), ndjsonExt))
+	}
+	return s, nil
+}
+
+// ndjsonFiles lists the sink's files in order.
+func ndjsonFiles(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+ndjsonExt))
+	if err != nil {
+		return nil, fmt.Errorf("list sink files: %w", err)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func (s *NDJSON) Relation(_ context.Context, m *stream.RelationMessage) error {
+	cols := make([]ColumnInfo, len(m.Columns))
+	for i, c := range m.Columns {
+		cols[i] = ColumnInfo{Name: c.Name, TypeOID: c.DataType, Key: c.Key}
+	}
+	return s.write(&Event{Op: "relation", Schema: m.Namespace, Table: m.Name, Columns: cols})
+}
+
+func (s *NDJSON) Begin(_ context.Context, m *stream.BeginMessage) error {
+	s.inTxn = true
+	return s.write(&Event{Op: "begin", LSN: m.TxnLSN.String(), XID: m.XID, Time: timePtr(m.TxnTime), Origin: m.Origin})
+}
+
+func (s *NDJSON) Change(_ context.Context, m *stream.ChangeMessage) error {
+	if err := s.write(ChangeEvent(m, !s.inTxn)); err != nil {
+		return err
+	}
+	if !s.inTxn {
+		return s.maybeRotate()
+	}
+	return nil
+}
+
+// ChangeEvent converts a row change. Snapshot rows have no LSN.
+func ChangeEvent(m *stream.ChangeMessage, snapshot bool) *Event {
+	ev := &Event{
+		Op:       strings.ToLower(m.Op.String()),
+		Schema:   m.Namespace,
+		Table:    m.Table,
+		Snapshot: snapshot,
+	}
+	if !snapshot {
+		ev.LSN = m.MsgLSN.String()
+	}
+	if m.OldTuple != nil {
+		ev.Old = m.OldTuple.Columns
+	}
+	if m.NewTuple != nil {
+		ev.New = m.NewTuple.Columns
+	}
+	return ev
+}
+
+func (s *NDJSON) Commit(_ context.Context, m *stream.CommitMessage) error {
+	s.inTxn = false
+	if err := s.write(&Event{Op: "commit", LSN: m.CommitLSN.String(), Time: timePtr(m.TxnTime)}); err != nil {
+		return err
+	}
+	return s.maybeRotate()
+}
+
+// Flush writes buffered events and fsyncs the current file.
+func (s *NDJSON) Flush(context.Context) error {
+	if s.f == nil {
+		return nil
+	}
+	if err := s.bw.Flush(); err != nil {
+		return fmt.Errorf("flush sink file: %w", err)
+	}
+	if err := s.f.Sync(); err != nil {
+		return fmt.Errorf("sync sink file: %w", err)
+	}
+	return nil
+}
+
+// Close flushes and closes the current file.
+func (s *NDJSON) Close() error {
+	return s.closeFile()
+}
+
+func (s *NDJSON) write(ev *Event) error {
+	if s.f == nil {
+		if err := s.openFile(); err != nil {
+			return err
+		}
+	}
+	var err error
+	s.buf, err = appendJSONLine(s.buf[:0], ev)
+	if err != nil {
+		return fmt.Errorf("encode %s event: %w", ev.Op, err)
+	}
+	n, err := s.bw.Write(s.buf)
+	s.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("write sink file: %w", err)
+	}
+	return nil
+}
+
+func appendJSONLine(buf []byte, v any) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return buf, err
+	}
+	buf = append(buf, b...)
+	return append(buf, '\n'), nil
+}
+
+func (s *NDJSON) openFile() error {
+	s.seq++
+	path := filepath.Join(s.dir, fmt.Sprintf("%08d%s", s.seq, ndjsonExt))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("create sink file: %w", err)
+	}
+	s.f = f
+	s.bw = bufio.NewWriterSize(f, 256<<10)
+	s.size = 0
+	s.logger.Debug().Str("file", path).Msg("opened sink file")
+	return nil
+}
+
+func (s *NDJSON) closeFile() error {
+	if s.f == nil {
+		return nil
+	}
+	err := s.Flush(context.Background())
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	s.f = nil
+	return err
+}
+
+// maybeRotate closes the current file once it is past SegmentBytes and
+// prunes old files. The next event opens a new one.
+func (s *NDJSON) maybeRotate() error {
+	if s.size < s.opts.SegmentBytes {
+		return nil
+	}
+	if err := s.closeFile(); err != nil {
+		return err
+	}
+	if s.opts.MaxSegments <= 0 {
+		return nil
+	}
+	files, err := ndjsonFiles(s.dir)
+	if err != nil {
+		return err
+	}
+	for len(files) > s.opts.MaxSegments {
+		if err := os.Remove(files[0]); err != nil {
+			return fmt.Errorf("remove old sink file: %w", err)
+		}
+		s.logger.Debug().Str("file", files[0]).Msg("removed old sink file")
+		files = files[1:]
+	}
+	return nil
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
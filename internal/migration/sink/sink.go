@@ -0,0 +1,231 @@
+// Package sink feeds the decoded change stream to consumers other than a
+// PostgreSQL destination.
+package sink
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/rs/zerolog"
+
+	"github.com/jfoltran/pgmanager/internal/config"
+	"github.com/jfoltran/pgmanager/internal/migration/replay"
+	"github.com/jfoltran/pgmanager/internal/migration/sentinel"
+	"github.com/jfoltran/pgmanager/internal/migration/stream"
+)
+
+// Supported sink types.
+const (
+	TypeNDJSON   = "ndjson"
+	TypeDebezium = "debezium"
+	TypeWebhook  = "webhook"
+)
+
+// Sink consumes the change stream. A Driver calls it from one goroutine,
+// one transaction at a time: Begin, its changes, then Commit. Relation
+// precedes the first change of a table and is repeated when the table's
+// columns change.
+type Sink interface {
+	Relation(ctx context.Context, m *stream.RelationMessage) error
+	Begin(ctx context.Context, m *stream.BeginMessage) error
+	// Change delivers a row change. Rows of the initial snapshot arrive as
+	// inserts outside any transaction.
+	Change(ctx context.Context, m *stream.ChangeMessage) error
+	Commit(ctx context.Context, m *stream.CommitMessage) error
+	// Flush makes everything delivered so far durable. Commit LSNs are
+	// acknowledged to the source only after Flush returns.
+	Flush(ctx context.Context) error
+	Close() error
+}
+
+// New creates the sink selected by cfg.Sink.Type.
+func New(cfg *config.Config, logger zerolog.Logger) (Sink, error) {
+	switch cfg.Sink.Type {
+	case TypeNDJSON:
+		return NewNDJSON(cfg.Sink.Dir, NDJSONOptions{
+			SegmentBytes: cfg.Sink.SegmentBytes,
+			MaxSegments:  cfg.Sink.MaxSegments,
+		}, logger)
+	case TypeDebezium:
+		return NewDebezium(cfg.Sink.Path, DebeziumOptions{
+			ServerName: cfg.Sink.ServerName,
+			Database:   cfg.Source.DBName,
+		}, logger)
+	case TypeWebhook:
+		return NewWebhook(cfg.Sink.URL, WebhookOptions{
+			BatchSize:  cfg.Sink.BatchSize,
+			Linger:     cfg.Sink.Linger,
+			MaxRetries: cfg.Sink.MaxRetries,
+		}, logger), nil
+	}
+	return nil, fmt.Errorf("unsupported sink type %q", cfg.Sink.Type)
+}
+
+// Batcher is implemented by sinks that prefer fewer, larger flushes. The
+// driver then holds committed transactions for up to Linger before
+// flushing, instead of flushing whenever the channel runs empty.
+type Batcher interface {
+	Linger() time.Duration
+}
+
+const (
+	flushTxLimit = 500
+	flushMaxWait = 50 * time.Millisecond
+)
+
+// Driver drives a Sink from a message channel. It has the same Start
+// contract as replay.Applier, so the pipeline can use either one.
+type Driver struct {
+	sink   Sink
+	logger zerolog.Logger
+	budget *stream.Budget
+
+	// mu serializes snapshot rows, which arrive from several copy
+	// workers, and guards lastLSN.
+	mu      sync.Mutex
+	lastLSN pglogrepl.LSN
+}
+
+// NewDriver creates a Driver for s.
+func NewDriver(s Sink, logger zerolog.Logger) *Driver {
+	return &Driver{
+		sink:   s,
+		logger: logger.With().Str("component", "sink").Logger(),
+	}
+}
+
+// SetBudget makes the driver release each message's size to b as it takes
+// the message off the channel. Call before Start.
+func (d *Driver) SetBudget(b *stream.Budget) {
+	d.budget = b
+}
+
+// Snapshot passes one row of the initial snapshot to the sink. It is safe
+// for concurrent use, but must not overlap with Start.
+func (d *Driver) Snapshot(ctx context.Context, m *stream.ChangeMessage) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.sink.Change(ctx, m)
+}
+
+// Flush flushes the sink, as after the snapshot.
+func (d *Driver) Flush(ctx context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.sink.Flush(ctx)
+}
+
+// Start consumes messages until the channel closes or ctx ends. Commits
+// are grouped into one Flush while the channel has more waiting, or for
+// the linger time of a Batcher, and onApplied is called for each of them
+// once the flush succeeds.
+func (d *Driver) Start(ctx context.Context, messages <-chan stream.Message, onApplied replay.OnApplied, onSentinel replay.OnSentinel) error {
+	var pending []pglogrepl.LSN
+	var firstPending time.Time
+
+	var linger time.Duration
+	if b, ok := d.sink.(Batcher); ok {
+		linger = b.Linger()
+	}
+	var lingerTimer *time.Timer
+	var lingerC <-chan time.Time
+
+	flush := func() error {
+		if lingerTimer != nil {
+			lingerTimer.Stop()
+			lingerTimer, lingerC = nil, nil
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+		if err := d.sink.Flush(ctx); err != nil {
+			return fmt.Errorf("sink flush: %w", err)
+		}
+		d.mu.Lock()
+		d.lastLSN = pending[len(pending)-1]
+		d.mu.Unlock()
+		if onApplied != nil {
+			for _, lsn := range pending {
+				onApplied(lsn)
+			}
+		}
+		pending = pending[:0]
+		return nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-lingerC:
+			if err := flush(); err != nil {
+				return err
+			}
+		case msg, ok := <-messages:
+			if !ok {
+				return flush()
+			}
+			d.budget.Release(stream.MessageSize(msg))
+
+			switch m := msg.(type) {
+			case *stream.RelationMessage:
+				if err := d.sink.Relation(ctx, m); err != nil {
+					return fmt.Errorf("sink relation %s.%s: %w", m.Namespace, m.Name, err)
+				}
+			case *stream.BeginMessage:
+				if err := d.sink.Begin(ctx, m); err != nil {
+					return fmt.Errorf("sink begin: %w", err)
+				}
+			case *stream.ChangeMessage:
+				if err := d.sink.Change(ctx, m); err != nil {
+					return fmt.Errorf("sink %s on %s.%s: %w", m.Op, m.Namespace, m.Table, err)
+				}
+			case *stream.CommitMessage:
+				if err := d.sink.Commit(ctx, m); err != nil {
+					return fmt.Errorf("sink commit %s: %w", m.CommitLSN, err)
+				}
+				if len(pending) == 0 {
+					firstPending = time.Now()
+					if linger > 0 {
+						lingerTimer = time.NewTimer(linger)
+						lingerC = lingerTimer.C
+					}
+				}
+				pending = append(pending, m.CommitLSN)
+				due := len(pending) >= flushTxLimit
+				if linger <= 0 {
+					due = due || len(messages) == 0 || time.Since(firstPending) >= flushMaxWait
+				}
+				if due {
+					if err := flush(); err != nil {
+						return err
+					}
+				}
+			case *sentinel.SentinelMessage:
+				if err := flush(); err != nil {
+					return err
+				}
+				if onSentinel != nil {
+					onSentinel(m.ID)
+				}
+			}
+		}
+	}
+}
+
+// LastLSN returns the commit LSN of the last flushed transaction.
+func (d *Driver) LastLSN() pglogrepl.LSN {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.lastLSN
+}
+
+// Close closes the sink.
+func (d *Driver) Close() {
+	if err := d.sink.Close(); err != nil {
+		d.logger.Err(err).Msg("close sink")
+	}
+}
@@ -0,0 +1,252 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/rs/zerolog"
+
+	"github.com/jfoltran/pgmanager/internal/migration/sentinel"
+	"github.com/jfoltran/pgmanager/internal/migration/stream"
+)
+
+// recorder is a Sink that records calls as short strings.
+type recorder struct {
+	calls    []string
+	flushErr error
+	closed   bool
+}
+
+func (r *recorder) Relation(_ context.Context, m *stream.RelationMessage) error {
+	r.calls = append(r.calls, "relation "+m.Name)
+	return nil
+}
+
+func (r *recorder) Begin(_ context.Context, m *stream.BeginMessage) error {
+	r.calls = append(r.calls, "begin "+m.TxnLSN.String())
+	return nil
+}
+
+func (r *recorder) Change(_ context.Context, m *stream.ChangeMessage) error {
+	r.calls = append(r.calls, strings.ToLower(m.Op.String())+" "+m.Table)
+	return nil
+}
+
+func (r *recorder) Commit(_ context.Context, m *stream.CommitMessage) error {
+	r.calls = append(r.calls, "commit "+m.CommitLSN.String())
+	return nil
+}
+
+func (r *recorder) Flush(context.Context) error {
+	r.calls = append(r.calls, "flush")
+	return r.flushErr
+}
+
+func (r *recorder) Close() error {
+	r.closed = true
+	return nil
+}
+
+func txn(lsn pglogrepl.LSN, table string) []stream.Message {
+	return []stream.Message{
+		&stream.BeginMessage{TxnLSN: lsn},
+		&stream.ChangeMessage{
+			Op: stream.OpInsert, Namespace: "public", Table: table, MsgLSN: lsn,
+			NewTuple: &stream.TupleData{Columns: []stream.Column{{Name: "id", Value: []byte("1")}}},
+		},
+		&stream.CommitMessage{CommitLSN: lsn},
+	}
+}
+
+func feed(msgs ...[]stream.Message) <-chan stream.Message {
+	var all []stream.Message
+	for _, m := range msgs {
+		all = append(all, m...)
+	}
+	ch := make(chan stream.Message, len(all))
+	for _, m := range all {
+		ch <- m
+	}
+	close(ch)
+	return ch
+}
+
+func TestDriverAcknowledgesAfterFlush(t *testing.T) {
+	rec := &recorder{}
+	d := NewDriver(rec, zerolog.Nop())
+
+	rel := []stream.Message{&stream.RelationMessage{Namespace: "public", Name: "users"}}
+	var acked []pglogrepl.LSN
+	var sentinels []string
+	ch := feed(rel, txn(10, "users"), []stream.Message{&sentinel.SentinelMessage{ID: "s1"}}, txn(20, "users"))
+	err := d.Start(context.Background(), ch, func(lsn pglogrepl.LSN) {
+		acked = append(acked, lsn)
+	}, func(id string) {
+		sentinels = append(sentinels, id)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"relation users",
+		"begin 0/A", "insert users", "commit 0/A",
+		"flush", // before the sentinel
+		"begin 0/14", "insert users", "commit 0/14",
+		"flush",
+	}
+	if strings.Join(rec.calls, ", ") != strings.Join(want, ", ") {
+		t.Errorf("calls = %v\nwant    %v", rec.calls, want)
+	}
+	if len(acked) != 2 || acked[1] != 20 || d.LastLSN() != 20 {
+		t.Errorf("acked = %v, LastLSN = %s", acked, d.LastLSN())
+	}
+	if len(sentinels) != 1 {
+		t.Errorf("sentinels = %v", sentinels)
+	}
+
+	d.Close()
+	if !rec.closed {
+		t.Error("Close did not close the sink")
+	}
+}
+
+func TestDriverFlushError(t *testing.T) {
+	rec := &recorder{flushErr: errors.New("disk full")}
+	d := NewDriver(rec, zerolog.Nop())
+	var acked []pglogrepl.LSN
+	err := d.Start(context.Background(), feed(txn(10, "users")), func(lsn pglogrepl.LSN) {
+		acked = append(acked, lsn)
+	}, nil)
+	if err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Fatalf("Start = %v, want the flush error", err)
+	}
+	if len(acked) != 0 {
+		t.Errorf("acknowledged %v despite the failed flush", acked)
+	}
+}
+
+// readEvents decodes every line of an NDJSON file into generic maps.
+func readEvents(t *testing.T, path string) []map[string]any {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var events []map[string]any
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var ev map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			t.Fatalf("line %q: %v", sc.Text(), err)
+		}
+		events = append(events, ev)
+	}
+	return events
+}
+
+func TestNDJSON(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewNDJSON(dir, NDJSONOptions{}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDriver(s, zerolog.Nop())
+	ctx := context.Background()
+
+	snap := &stream.ChangeMessage{Op: stream.OpInsert, Namespace: "public", Table: "users",
+		NewTuple: &stream.TupleData{Columns: []stream.Column{
+			{Name: "id", Value: []byte("1"), Key: true},
+			{Name: "name", Value: nil},
+		}}}
+	if err := d.Snapshot(ctx, snap); err != nil {
+		t.Fatal(err)
+	}
+	update := []stream.Message{
+		&stream.BeginMessage{TxnLSN: 20, XID: 7},
+		&stream.ChangeMessage{Op: stream.OpUpdate, Namespace: "public", Table: "users", MsgLSN: 20,
+			OldTuple: &stream.TupleData{Columns: []stream.Column{{Name: "id", Value: []byte("1")}}},
+			NewTuple: &stream.TupleData{Columns: []stream.Column{
+				{Name: "id", Value: []byte("1")},
+				{Name: "name", Value: []byte(`a "quoted" name`)},
+			}}},
+		&stream.CommitMessage{CommitLSN: 20},
+	}
+	if err := d.Start(ctx, feed(update), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+
+	events := readEvents(t, filepath.Join(dir, "00000001.ndjson"))
+	if len(events) != 4 {
+		t.Fatalf("got %d events, want 4", len(events))
+	}
+	if events[0]["op"] != "insert" || events[0]["snapshot"] != true {
+		t.Errorf("snapshot row = %v", events[0])
+	}
+	if row := events[0]["new"].(map[string]any); row["name"] != nil || row["id"] != "1" {
+		t.Errorf("snapshot values = %v", row)
+	}
+	if events[1]["op"] != "begin" || events[1]["lsn"] != "0/14" || events[1]["xid"] != float64(7) {
+		t.Errorf("begin = %v", events[1])
+	}
+	if events[2]["op"] != "update" || events[2]["new"].(map[string]any)["name"] != `a "quoted" name` || events[2]["old"] == nil {
+		t.Errorf("update = %v", events[2])
+	}
+	if events[3]["op"] != "commit" {
+		t.Errorf("commit = %v", events[3])
+	}
+}
+
+func TestNDJSONRowKeepsColumnOrder(t *testing.T) {
+	b, err := json.Marshal(Row{{Name: "z", Value: []byte("1")}, {Name: "a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"z":"1","a":null}` {
+		t.Errorf("row = %s", b)
+	}
+}
+
+func TestNDJSONRotation(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewNDJSON(dir, NDJSONOptions{SegmentBytes: 1, MaxSegments: 2}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDriver(s, zerolog.Nop())
+	if err := d.Start(context.Background(), feed(txn(10, "a"), txn(20, "b"), txn(30, "c")), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+
+	files, _ := ndjsonFiles(dir)
+	if len(files) != 2 || filepath.Base(files[0]) != "00000002.ndjson" {
+		t.Fatalf("files = %v, want the newest two", files)
+	}
+	// A transaction never spans files.
+	if events := readEvents(t, files[1]); len(events) != 3 || events[1]["table"] != "c" {
+		t.Errorf("last file = %v", events)
+	}
+
+	// A restarted sink continues after the existing files.
+	s, err = NewNDJSON(dir, NDJSONOptions{}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Relation(context.Background(), &stream.RelationMessage{Name: "d"}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if _, err := os.Stat(filepath.Join(dir, "00000004.ndjson")); err != nil {
+		t.Errorf("restarted sink: %v", err)
+	}
+}
@@ -0,0 +1,253 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/jfoltran/pgmanager/internal/migration/stream"
+)
+
+// Webhook sink defaults.
+const (
+	DefaultWebhookBatchSize  = 500
+	DefaultWebhookLinger     = 200 * time.Millisecond
+	DefaultWebhookMaxRetries = 20
+)
+
+const (
+	webhookInitialBackoff = 500 * time.Millisecond
+	webhookMaxBackoff     = 30 * time.Second
+	webhookTimeout        = 30 * time.Second
+)
+
+// WebhookOptions configures the webhook sink.
+type WebhookOptions struct {
+	// BatchSize caps the changes in one request. Transactions are never
+	// split, so a larger transaction is sent on its own.
+	BatchSize int
+	// Linger is how long committed transactions wait for more before they
+	// are sent.
+	Linger time.Duration
+	// MaxRetries caps the retries of one request in a row; the sink then
+	// fails.
+	MaxRetries int
+	// Client sends the requests; nil uses a client with a 30s timeout.
+	Client *http.Client
+}
+
+// Webhook POSTs batches of committed transactions as JSON to a URL. A batch
+// is sent again, with exponential backoff, until the endpoint answers 2xx
+// or MaxRetries is reached, and only then are its transactions
+// acknowledged.
+type Webhook struct {
+	url    string
+	opts   WebhookOptions
+	logger zerolog.Logger
+
+	batch   WebhookBatch
+	changes int
+	txn     *WebhookTransaction
+	// runID and snapshotSeq make up the idempotency keys of snapshot
+	// batches, which have no LSN.
+	runID       string
+	snapshotSeq int
+}
+
+// WebhookBatch is the body of one webhook request. It holds either
+// committed transactions or rows of the initial snapshot.
+type WebhookBatch struct {
+	Transactions []*WebhookTransaction `json:"transactions,omitempty"`
+	// Snapshot holds snapshot rows as insert events.
+	Snapshot []*Event `json:"snapshot,omitempty"`
+}
+
+// WebhookTransaction is one committed transaction in a batch.
+type WebhookTransaction struct {
+	// IdempotencyKey identifies the transaction across retries and
+	// restarts, which may batch it differently: its commit LSN.
+	IdempotencyKey string     `json:"idempotency_key"`
+	XID            uint32     `json:"xid"`
+	CommitLSN      string     `json:"commit_lsn"`
+	CommitTime     *time.Time `json:"commit_time,omitempty"`
+	Origin         string     `json:"origin,omitempty"`
+	Changes        []*Event   `json:"changes"`
+}
+
+// NewWebhook creates a sink that sends to url.
+func NewWebhook(url string, opts WebhookOptions, logger zerolog.Logger) *Webhook {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultWebhookBatchSize
+	}
+	if opts.Linger <= 0 {
+		opts.Linger = DefaultWebhookLinger
+	}
+	if opts.MaxRetries <= 0 {
+		opts.MaxRetries = DefaultWebhookMaxRetries
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: webhookTimeout}
+	}
+	return &Webhook{
+		url:    url,
+		opts:   opts,
+		logger: logger.With().Str("component", "webhook-sink").Logger(),
+		runID:  strconv.FormatInt(time.Now().UnixNano(), 36),
+	}
+}
+
+// Linger implements Batcher.
+func (s *Webhook) Linger() time.Duration {
+	return s.opts.Linger
+}
+
+// Relation is a no-op; change events carry their column names.
+func (s *Webhook) Relation(context.Context, *stream.RelationMessage) error {
+	return nil
+}
+
+func (s *Webhook) Begin(_ context.Context, m *stream.BeginMessage) error {
+	s.txn = &WebhookTransaction{XID: m.XID, Origin: m.Origin}
+	return nil
+}
+
+func (s *Webhook) Change(ctx context.Context, m *stream.ChangeMessage) error {
+	if s.txn != nil {
+		s.txn.Changes = append(s.txn.Changes, ChangeEvent(m, false))
+		return nil
+	}
+	if len(s.batch.Transactions) > 0 {
+		if err := s.send(ctx); err != nil {
+			return err
+		}
+	}
+	s.batch.Snapshot = append(s.batch.Snapshot, ChangeEvent(m, true))
+	s.changes++
+	if s.changes >= s.opts.BatchSize {
+		return s.send(ctx)
+	}
+	return nil
+}
+
+func (s *Webhook) Commit(ctx context.Context, m *stream.CommitMessage) error {
+	txn := s.txn
+	s.txn = nil
+	if txn == nil {
+		return nil
+	}
+	txn.CommitLSN = m.CommitLSN.String()
+	txn.IdempotencyKey = txn.CommitLSN
+	txn.CommitTime = timePtr(m.TxnTime)
+
+	// Send what is batched first if this transaction would overflow it, or
+	// if it holds snapshot rows.
+	if len(s.batch.Snapshot) > 0 || (s.changes > 0 && s.changes+len(txn.Changes) > s.opts.BatchSize) {
+		if err := s.send(ctx); err != nil {
+			return err
+		}
+	}
+	s.batch.Transactions = append(s.batch.Transactions, txn)
+	s.changes += len(txn.Changes)
+	if s.changes >= s.opts.BatchSize {
+		return s.send(ctx)
+	}
+	return nil
+}
+
+// Flush sends the pending batch.
+func (s *Webhook) Flush(ctx context.Context) error {
+	return s.send(ctx)
+}
+
+// Close drops anything not yet flushed; it was never acknowledged.
+func (s *Webhook) Close() error {
+	s.opts.Client.CloseIdleConnections()
+	return nil
+}
+
+// idempotencyKey identifies a batch by the commit LSNs of its first and
+// last transactions, so a retried request carries the same key. A batch
+// may be regrouped after a restart; each transaction carries its own key
+// for that. Snapshot batches are numbered within the run.
+func (s *Webhook) idempotencyKey() string {
+	if txns := s.batch.Transactions; len(txns) > 0 {
+		return txns[0].CommitLSN + "-" + txns[len(txns)-1].CommitLSN
+	}
+	return "snapshot-" + s.runID + "-" + strconv.Itoa(s.snapshotSeq)
+}
+
+// send POSTs the pending batch, retrying until it is accepted, MaxRetries
+// is reached or ctx ends.
+func (s *Webhook) send(ctx context.Context) error {
+	if len(s.batch.Transactions) == 0 && len(s.batch.Snapshot) == 0 {
+		return nil
+	}
+	if len(s.batch.Snapshot) > 0 {
+		s.snapshotSeq++
+	}
+	body, err := json.Marshal(&s.batch)
+	if err != nil {
+		return fmt.Errorf("encode webhook batch: %w", err)
+	}
+	key := s.idempotencyKey()
+
+	backoff := webhookInitialBackoff
+	for attempt := 1; ; attempt++ {
+		retry, err := s.post(ctx, body, key)
+		if err == nil {
+			break
+		}
+		if !retry {
+			return err
+		}
+		if attempt > s.opts.MaxRetries {
+			return fmt.Errorf("%w (gave up after %d retries)", err, s.opts.MaxRetries)
+		}
+		s.logger.Warn().Err(err).Int("attempt", attempt).Str("key", key).Dur("backoff", backoff).Msg("webhook request failed, retrying")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, webhookMaxBackoff)
+	}
+
+	s.logger.Debug().Str("key", key).Int("changes", s.changes).Msg("webhook batch sent")
+	s.batch = WebhookBatch{}
+	s.changes = 0
+	return nil
+}
+
+// post sends one request. It reports whether a failure is worth retrying:
+// network errors, timeouts, 429 and 5xx are; other statuses are not.
+func (s *Webhook) post(ctx context.Context, body []byte, key string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "pgmanager")
+	req.Header.Set("Idempotency-Key", key)
+
+	resp, err := s.opts.Client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, fmt.Errorf("webhook post: %w", err)
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10)) //nolint:errcheck
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return false, fmt.Errorf("webhook returned %s", resp.Status)
+}
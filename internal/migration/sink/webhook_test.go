@@ -0,0 +1,192 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/rs/zerolog"
+
+	"github.com/jfoltran/pgmanager/internal/migration/stream"
+)
+
+// hookBatch decodes a webhook request body.
+type hookBatch struct {
+	Transactions []struct {
+		IdempotencyKey string      `json:"idempotency_key"`
+		CommitLSN      string      `json:"commit_lsn"`
+		Changes        []hookEvent `json:"changes"`
+	} `json:"transactions"`
+	Snapshot []hookEvent `json:"snapshot"`
+}
+
+type hookEvent struct {
+	Op       string         `json:"op"`
+	Table    string         `json:"table"`
+	Snapshot bool           `json:"snapshot"`
+	New      map[string]any `json:"new"`
+}
+
+// hookServer records webhook requests and answers with the queued status
+// codes, then 200.
+type hookServer struct {
+	mu       sync.Mutex
+	statuses []int
+	keys     []string
+	batches  []hookBatch
+}
+
+func (h *hookServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.keys = append(h.keys, r.Header.Get("Idempotency-Key"))
+	if len(h.statuses) > 0 {
+		status := h.statuses[0]
+		h.statuses = h.statuses[1:]
+		w.WriteHeader(status)
+		return
+	}
+	var b hookBatch
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	h.batches = append(h.batches, b)
+}
+
+func startHook(t *testing.T, statuses ...int) (*hookServer, *httptest.Server) {
+	h := &hookServer{statuses: statuses}
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return h, srv
+}
+
+func TestWebhookBatches(t *testing.T) {
+	h, srv := startHook(t)
+	d := NewDriver(NewWebhook(srv.URL, WebhookOptions{BatchSize: 2}, zerolog.Nop()), zerolog.Nop())
+
+	var acked []pglogrepl.LSN
+	err := d.Start(context.Background(), feed(txn(10, "a"), txn(20, "b"), txn(30, "c")), func(lsn pglogrepl.LSN) {
+		acked = append(acked, lsn)
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(h.batches) != 2 || len(h.batches[0].Transactions) != 2 || len(h.batches[1].Transactions) != 1 {
+		t.Fatalf("batches = %+v", h.batches)
+	}
+	if got := strings.Join(h.keys, ","); got != "0/A-0/14,0/1E-0/1E" {
+		t.Errorf("idempotency keys = %s", got)
+	}
+	tx := h.batches[0].Transactions[1]
+	if tx.CommitLSN != "0/14" || tx.IdempotencyKey != "0/14" || len(tx.Changes) != 1 || tx.Changes[0].Table != "b" || tx.Changes[0].Op != "insert" {
+		t.Errorf("transaction = %+v", tx)
+	}
+	if len(acked) != 3 {
+		t.Errorf("acked = %v", acked)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	h, srv := startHook(t, http.StatusServiceUnavailable)
+	d := NewDriver(NewWebhook(srv.URL, WebhookOptions{}, zerolog.Nop()), zerolog.Nop())
+
+	var acked []pglogrepl.LSN
+	if err := d.Start(context.Background(), feed(txn(10, "a")), func(lsn pglogrepl.LSN) {
+		acked = append(acked, lsn)
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(h.keys) != 2 || h.keys[0] != h.keys[1] {
+		t.Errorf("keys = %v, want one retry with the same key", h.keys)
+	}
+	if len(h.batches) != 1 || len(acked) != 1 {
+		t.Errorf("batches = %d, acked = %v", len(h.batches), acked)
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	h, srv := startHook(t, http.StatusServiceUnavailable, http.StatusBadGateway)
+	d := NewDriver(NewWebhook(srv.URL, WebhookOptions{MaxRetries: 1}, zerolog.Nop()), zerolog.Nop())
+
+	acked := false
+	err := d.Start(context.Background(), feed(txn(10, "a")), func(pglogrepl.LSN) { acked = true }, nil)
+	if err == nil || !strings.Contains(err.Error(), "gave up after 1 retries") {
+		t.Fatalf("Start = %v, want the retries to run out", err)
+	}
+	if acked || len(h.keys) != 2 {
+		t.Errorf("acked = %v after %d requests", acked, len(h.keys))
+	}
+}
+
+func TestWebhookRejected(t *testing.T) {
+	h, srv := startHook(t, http.StatusBadRequest)
+	d := NewDriver(NewWebhook(srv.URL, WebhookOptions{}, zerolog.Nop()), zerolog.Nop())
+
+	acked := false
+	err := d.Start(context.Background(), feed(txn(10, "a")), func(pglogrepl.LSN) { acked = true }, nil)
+	if err == nil || !strings.Contains(err.Error(), "400") {
+		t.Fatalf("Start = %v, want a 400 error", err)
+	}
+	if acked || len(h.keys) != 1 {
+		t.Errorf("acked = %v after %d requests", acked, len(h.keys))
+	}
+}
+
+func TestWebhookLinger(t *testing.T) {
+	h, srv := startHook(t)
+	d := NewDriver(NewWebhook(srv.URL, WebhookOptions{Linger: 200 * time.Millisecond}, zerolog.Nop()), zerolog.Nop())
+
+	ch := make(chan stream.Message)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	acked := make(chan pglogrepl.LSN, 2)
+	go d.Start(ctx, ch, func(lsn pglogrepl.LSN) { acked <- lsn }, nil) //nolint:errcheck
+
+	// Two transactions arriving within the linger time share a request,
+	// even though the channel runs empty between them.
+	for _, m := range append(txn(10, "a"), txn(20, "b")...) {
+		ch <- m
+	}
+	for range 2 {
+		select {
+		case <-acked:
+		case <-time.After(5 * time.Second):
+			t.Fatal("transaction not acknowledged after the linger time")
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.batches) != 1 || len(h.batches[0].Transactions) != 2 {
+		t.Errorf("batches = %+v", h.batches)
+	}
+}
+
+func TestWebhookSnapshot(t *testing.T) {
+	h, srv := startHook(t)
+	d := NewDriver(NewWebhook(srv.URL, WebhookOptions{BatchSize: 2}, zerolog.Nop()), zerolog.Nop())
+	ctx := context.Background()
+	for _, table := range []string{"a", "b", "c"} {
+		row := &stream.ChangeMessage{Op: stream.OpInsert, Namespace: "public", Table: table,
+			NewTuple: &stream.TupleData{Columns: []stream.Column{{Name: "id", Value: []byte("1")}}}}
+		if err := d.Snapshot(ctx, row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(h.batches) != 2 || len(h.batches[0].Snapshot) != 2 || !h.batches[1].Snapshot[0].Snapshot {
+		t.Fatalf("batches = %+v", h.batches)
+	}
+	if h.keys[0] == h.keys[1] || !strings.HasPrefix(h.keys[0], "snapshot-") {
+		t.Errorf("keys = %v", h.keys)
+	}
+}
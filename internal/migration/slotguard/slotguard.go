@@ -0,0 +1,212 @@
+package slotguard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog"
+
+	"github.com/jfoltran/pgmanager/internal/metrics"
+)
+
+// Slot states published in metrics.SlotHealth.
+const (
+	StateOK    = "ok"
+	StateWarn  = "warn"
+	StateLimit = "limit"
+	StateLost  = "lost"
+)
+
+var (
+	// ErrSlotLost means the slot was invalidated or removed: the WAL it
+	// needed is gone and the destination must be cloned again.
+	ErrSlotLost = errors.New("replication slot lost, re-clone required")
+	// ErrSlotLimit means the slot reached the hard retention limit and was
+	// dropped to protect the source.
+	ErrSlotLimit = errors.New("replication slot reached retention limit and was dropped")
+)
+
+// Thresholds configures when the guard warns and when it acts. A zero
+// value disables the corresponding check.
+type Thresholds struct {
+	WarnRetainedBytes int64 // warn when the slot retains more WAL than this
+	WarnSafeBytes     int64 // warn when safe_wal_size drops below this
+	MaxRetainedBytes  int64 // hard limit on retained WAL
+	DropOnLimit       bool  // drop the slot and fail at the hard limit
+	Interval          time.Duration
+}
+
+// sample is one reading of pg_replication_slots.
+type sample struct {
+	found         bool
+	active        bool
+	activePID     *int32
+	walStatus     string
+	safeWALSize   *int64
+	retainedBytes int64
+}
+
+// Guard watches a replication slot on the source and protects the source
+// from unbounded WAL retention.
+type Guard struct {
+	pool       *pgxpool.Pool
+	slot       string
+	thresholds Thresholds
+	metrics    *metrics.Collector
+	logger     zerolog.Logger
+}
+
+// NewGuard creates a Guard for slotName on the (source) database.
+func NewGuard(pool *pgxpool.Pool, slotName string, t Thresholds, mc *metrics.Collector, logger zerolog.Logger) *Guard {
+	if t.Interval <= 0 {
+		t.Interval = 10 * time.Second
+	}
+	return &Guard{
+		pool:       pool,
+		slot:       slotName,
+		thresholds: t,
+		metrics:    mc,
+		logger:     logger.With().Str("component", "slot-guard").Str("slot", slotName).Logger(),
+	}
+}
+
+// Run polls the slot until ctx is cancelled. It returns nil on cancellation,
+// ErrSlotLost once the slot is invalidated or gone, and ErrSlotLimit after
+// dropping the slot at the hard limit. Query errors are logged and retried.
+func (g *Guard) Run(ctx context.Context) error {
+	ticker := time.NewTicker(g.thresholds.Interval)
+	defer ticker.Stop()
+
+	prev := StateOK
+	for {
+		s, err := g.poll(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			g.logger.Warn().Err(err).Msg("slot health check failed")
+		} else {
+			h := evaluate(g.slot, s, g.thresholds)
+			h.CheckedAt = time.Now()
+			if g.metrics != nil {
+				g.metrics.RecordSlotHealth(h)
+			}
+			if h.State != prev {
+				g.logTransition(h)
+				prev = h.State
+			}
+
+			switch {
+			case h.State == StateLost:
+				return fmt.Errorf("%w: %s", ErrSlotLost, h.Message)
+			case h.State == StateLimit && g.thresholds.DropOnLimit:
+				if err := g.drop(ctx, s.activePID); err != nil {
+					return fmt.Errorf("drop slot at retention limit: %w", err)
+				}
+				return fmt.Errorf("%w: %s", ErrSlotLimit, h.Message)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (g *Guard) poll(ctx context.Context) (sample, error) {
+	var s sample
+	err := g.pool.QueryRow(ctx, `
+		SELECT s.active, s.active_pid,
+		       COALESCE(to_jsonb(s)->>'wal_status', ''),
+		       (to_jsonb(s)->>'safe_wal_size')::bigint,
+		       COALESCE(pg_wal_lsn_diff(
+		           CASE WHEN pg_is_in_recovery() THEN pg_last_wal_replay_lsn() ELSE pg_current_wal_lsn() END,
+		           s.restart_lsn), 0)::bigint
+		FROM pg_replication_slots s
+		WHERE s.slot_name = $1`, g.slot).Scan(&s.active, &s.activePID, &s.walStatus, &s.safeWALSize, &s.retainedBytes)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return sample{}, nil
+	}
+	if err != nil {
+		return sample{}, fmt.Errorf("query slot %q: %w", g.slot, err)
+	}
+	s.found = true
+	return s, nil
+}
+
+// evaluate turns a sample into the published health state.
+func evaluate(slot string, s sample, t Thresholds) metrics.SlotHealth {
+	h := metrics.SlotHealth{
+		Name:          slot,
+		Active:        s.active,
+		WALStatus:     s.walStatus,
+		SafeWALSize:   s.safeWALSize,
+		RetainedBytes: s.retainedBytes,
+		State:         StateOK,
+	}
+
+	switch {
+	case !s.found:
+		h.State, h.Message = StateLost, "slot no longer exists on the source"
+	case s.walStatus == "lost":
+		h.State, h.Message = StateLost, "slot was invalidated (max_slot_wal_keep_size exceeded)"
+	case t.MaxRetainedBytes > 0 && s.retainedBytes >= t.MaxRetainedBytes:
+		h.State = StateLimit
+		h.Message = fmt.Sprintf("slot retains %d bytes of WAL, limit is %d", s.retainedBytes, t.MaxRetainedBytes)
+	case s.walStatus == "unreserved":
+		h.State, h.Message = StateWarn, "required WAL is past max_slot_wal_keep_size and will be removed at the next checkpoint"
+	case t.WarnSafeBytes > 0 && s.safeWALSize != nil && *s.safeWALSize < t.WarnSafeBytes:
+		h.State = StateWarn
+		h.Message = fmt.Sprintf("slot is %d bytes from invalidation", *s.safeWALSize)
+	case t.WarnRetainedBytes > 0 && s.retainedBytes >= t.WarnRetainedBytes:
+		h.State = StateWarn
+		h.Message = fmt.Sprintf("slot retains %d bytes of WAL", s.retainedBytes)
+	}
+	return h
+}
+
+func (g *Guard) logTransition(h metrics.SlotHealth) {
+	ev := g.logger.Warn()
+	if h.State == StateOK {
+		ev = g.logger.Info()
+	} else if h.State == StateLost || h.State == StateLimit {
+		ev = g.logger.Error()
+	}
+	ev.Str("state", h.State).
+		Str("wal_status", h.WALStatus).
+		Int64("retained_bytes", h.RetainedBytes).
+		Str("detail", h.Message).
+		Msg("slot health changed")
+}
+
+// drop terminates the walsender holding the slot, if any, and drops it.
+// The walsender may take a moment to release the slot, so the drop is
+// retried briefly.
+func (g *Guard) drop(ctx context.Context, activePID *int32) error {
+	if activePID != nil {
+		if _, err := g.pool.Exec(ctx, "SELECT pg_terminate_backend($1)", *activePID); err != nil {
+			return fmt.Errorf("terminate walsender %d: %w", *activePID, err)
+		}
+	}
+
+	var err error
+	for attempt := 0; attempt < 10; attempt++ {
+		_, err = g.pool.Exec(ctx, "SELECT pg_drop_replication_slot($1)", g.slot)
+		if err == nil {
+			g.logger.Error().Msg("replication slot dropped at retention limit")
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+	return err
+}
@@ -0,0 +1,51 @@
+package slotguard
+
+import (
+	"testing"
+)
+
+func ptr(v int64) *int64 { return &v }
+
+func TestEvaluate(t *testing.T) {
+	th := Thresholds{
+		WarnRetainedBytes: 1000,
+		WarnSafeBytes:     500,
+		MaxRetainedBytes:  5000,
+	}
+
+	tests := []struct {
+		name  string
+		s     sample
+		state string
+	}{
+		{"healthy", sample{found: true, walStatus: "reserved", retainedBytes: 10}, StateOK},
+		{"missing slot", sample{}, StateLost},
+		{"invalidated", sample{found: true, walStatus: "lost"}, StateLost},
+		{"unreserved", sample{found: true, walStatus: "unreserved", retainedBytes: 10}, StateWarn},
+		{"retained over warn", sample{found: true, walStatus: "extended", retainedBytes: 1000}, StateWarn},
+		{"safe size low", sample{found: true, walStatus: "reserved", safeWALSize: ptr(100)}, StateWarn},
+		{"safe size ample", sample{found: true, walStatus: "reserved", safeWALSize: ptr(10000)}, StateOK},
+		{"hard limit", sample{found: true, walStatus: "extended", retainedBytes: 5000}, StateLimit},
+		{"limit beats unreserved", sample{found: true, walStatus: "unreserved", retainedBytes: 6000}, StateLimit},
+		{"no wal_status before PG13", sample{found: true, retainedBytes: 10}, StateOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := evaluate("slot", tt.s, th)
+			if h.State != tt.state {
+				t.Errorf("state = %q, want %q (message %q)", h.State, tt.state, h.Message)
+			}
+			if h.State != StateOK && h.Message == "" {
+				t.Error("expected a message for a non-ok state")
+			}
+		})
+	}
+}
+
+func TestEvaluate_DisabledThresholds(t *testing.T) {
+	h := evaluate("slot", sample{found: true, walStatus: "extended", retainedBytes: 1 << 40, safeWALSize: ptr(1)}, Thresholds{})
+	if h.State != StateOK {
+		t.Errorf("state = %q, want ok with all thresholds disabled", h.State)
+	}
+}
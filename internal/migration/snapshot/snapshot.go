@@ -2,6 +2,7 @@ package snapshot
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
@@ -10,6 +11,9 @@ import (
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/rs/zerolog"
+
+	"github.com/jfoltran/pgmanager/internal/migration/remap"
+	"github.com/jfoltran/pgmanager/internal/migration/stream"
 )
 
 // TableInfo describes a table eligible for COPY.
@@ -45,6 +49,7 @@ type Copier struct {
 	dest     *pgxpool.Pool
 	logger   zerolog.Logger
 	progress ProgressFunc
+	remap    *remap.Map
 
 	workers int
 }
@@ -64,6 +69,11 @@ func (c *Copier) SetProgressFunc(fn ProgressFunc) {
 	c.progress = fn
 }
 
+// SetMap makes CopyAll write each table to its destination under m.
+func (c *Copier) SetMap(m *remap.Map) {
+	c.remap = m
+}
+
 // ListTables returns all user tables from the source database.
 func (c *Copier) ListTables(ctx context.Context) ([]TableInfo, error) {
 	rows, err := c.source.Query(ctx, `
@@ -92,7 +102,7 @@ func (c *Copier) ListTables(ctx context.Context) ([]TableInfo, error) {
 
 // DestRowCount returns the exact row count for a table on the destination.
 func (c *Copier) DestRowCount(ctx context.Context, schema, name string) (int64, error) {
-	qn := quoteQualifiedName(schema, name)
+	qn := quoteQualifiedName(c.remap.Table(schema, name))
 	var count int64
 	err := c.dest.QueryRow(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s", qn)).Scan(&count)
 	return count, err
@@ -100,7 +110,7 @@ func (c *Copier) DestRowCount(ctx context.Context, schema, name string) (int64,
 
 // TruncateTable truncates a table on the destination.
 func (c *Copier) TruncateTable(ctx context.Context, schema, name string) error {
-	qn := quoteQualifiedName(schema, name)
+	qn := quoteQualifiedName(c.remap.Table(schema, name))
 	_, err := c.dest.Exec(ctx, fmt.Sprintf("TRUNCATE TABLE %s", qn))
 	return err
 }
@@ -108,7 +118,7 @@ func (c *Copier) TruncateTable(ctx context.Context, schema, name string) error {
 // DestHasData returns true if any of the given tables have rows on the destination.
 func (c *Copier) DestHasData(ctx context.Context, tables []TableInfo) (bool, error) {
 	for _, t := range tables {
-		qn := quoteQualifiedName(t.Schema, t.Name)
+		qn := quoteQualifiedName(c.remap.Table(t.Schema, t.Name))
 		var exists bool
 		err := c.dest.QueryRow(ctx, fmt.Sprintf("SELECT EXISTS(SELECT 1 FROM %s LIMIT 1)", qn)).Scan(&exists)
 		if err != nil {
@@ -124,6 +134,40 @@ func (c *Copier) DestHasData(ctx context.Context, tables []TableInfo) (bool, err
 // CopyAll copies all given tables in parallel using the provided snapshot name
 // for read consistency. It returns results for each table.
 func (c *Copier) CopyAll(ctx context.Context, tables []TableInfo, snapshotName string) []CopyResult {
+	return c.runWorkers(tables, func(t TableInfo, workerID int) CopyResult {
+		return c.copyTable(ctx, t, snapshotName, workerID)
+	})
+}
+
+// RowFunc receives one exported row as an INSERT change.
+type RowFunc func(ctx context.Context, m *stream.ChangeMessage) error
+
+// ExportAll reads all given tables in parallel using the provided snapshot
+// name and passes every row to fn as an INSERT. Values are in text format
+// and primary key columns are flagged Key. fn is called from several
+// workers at once.
+func (c *Copier) ExportAll(ctx context.Context, tables []TableInfo, snapshotName string, fn RowFunc) []CopyResult {
+	return c.runWorkers(tables, func(t TableInfo, workerID int) CopyResult {
+		return c.exportTable(ctx, t, snapshotName, workerID, fn)
+	})
+}
+
+// ShardFunc returns the destinations of one row, as indexes into the pools
+// given to CopyAllSharded.
+type ShardFunc func(m *stream.ChangeMessage) ([]int, error)
+
+// CopyAllSharded copies all given tables in parallel like CopyAll, but
+// splits their rows between dests: each row goes to the destinations
+// route returns for it. Rows are routed in text format, the one of
+// streamed changes, with primary key columns flagged Key.
+func (c *Copier) CopyAllSharded(ctx context.Context, tables []TableInfo, snapshotName string, dests []*pgxpool.Pool, route ShardFunc) []CopyResult {
+	return c.runWorkers(tables, func(t TableInfo, workerID int) CopyResult {
+		return c.copyTableSharded(ctx, t, snapshotName, workerID, dests, route)
+	})
+}
+
+// runWorkers runs fn for every table on the configured number of workers.
+func (c *Copier) runWorkers(tables []TableInfo, fn func(t TableInfo, workerID int) CopyResult) []CopyResult {
 	work := make(chan TableInfo, len(tables))
 	for _, t := range tables {
 		work <- t
@@ -141,7 +185,7 @@ func (c *Copier) CopyAll(ctx context.Context, tables []TableInfo, snapshotName s
 		go func(workerID int) {
 			defer wg.Done()
 			for t := range work {
-				result := c.copyTable(ctx, t, snapshotName, workerID)
+				result := fn(t, workerID)
 				mu.Lock()
 				results = append(results, result)
 				mu.Unlock()
@@ -161,29 +205,25 @@ func (c *Copier) reportProgress(table TableInfo, event string, rowsCopied int64)
 
 const progressReportInterval = 500 * time.Millisecond
 
+// target returns the destination table and column names of a source table
+// under the copier's map.
+func (c *Copier) target(table TableInfo, cols []string) (pgx.Identifier, []string) {
+	schema, name := c.remap.Table(table.Schema, table.Name)
+	return pgx.Identifier{schema, name}, c.remap.Columns(table.Schema, table.Name, cols)
+}
+
 func (c *Copier) copyTable(ctx context.Context, table TableInfo, snapshotName string, workerID int) CopyResult {
 	log := c.logger.With().Str("table", table.QualifiedName()).Int("worker", workerID).Logger()
 	log.Info().Msg("starting COPY")
 	c.reportProgress(table, "start", 0)
 
-	srcConn, err := c.source.Acquire(ctx)
+	srcConn, srcTx, err := c.beginSnapshot(ctx, snapshotName)
 	if err != nil {
-		return CopyResult{Table: table, Err: fmt.Errorf("acquire source conn: %w", err)}
+		return CopyResult{Table: table, Err: err}
 	}
 	defer srcConn.Release()
-
-	srcTx, err := srcConn.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
-	if err != nil {
-		return CopyResult{Table: table, Err: fmt.Errorf("begin source tx: %w", err)}
-	}
 	defer srcTx.Rollback(ctx) //nolint:errcheck
 
-	if snapshotName != "" {
-		if _, err := srcTx.Exec(ctx, fmt.Sprintf("SET TRANSACTION SNAPSHOT '%s'", snapshotName)); err != nil {
-			return CopyResult{Table: table, Err: fmt.Errorf("set snapshot: %w", err)}
-		}
-	}
-
 	qn := quoteQualifiedName(table.Schema, table.Name)
 	rows, err := srcTx.Query(ctx, fmt.Sprintf("SELECT * FROM %s", qn))
 	if err != nil {
@@ -202,11 +242,13 @@ func (c *Copier) copyTable(ctx context.Context, table TableInfo, snapshotName st
 		table:    table,
 		colCount: len(colNames),
 	}
+	dst, dstCols := c.target(table, colNames)
+	if col, val := c.remap.Discriminator(); col != "" {
+		dstCols = append(dstCols, col)
+		src.extra = []any{val}
+	}
 
-	n, err := c.dest.CopyFrom(ctx,
-		pgx.Identifier{table.Schema, table.Name},
-		colNames,
-		src)
+	n, err := c.dest.CopyFrom(ctx, dst, dstCols, src)
 	rows.Close()
 	if err != nil {
 		return CopyResult{Table: table, Err: fmt.Errorf("copy to %s: %w", qn, err)}
@@ -220,6 +262,252 @@ func (c *Copier) copyTable(ctx context.Context, table TableInfo, snapshotName st
 	return CopyResult{Table: table, RowsCopied: n}
 }
 
+// beginSnapshot starts a read-only source transaction, in snapshotName
+// when it is set. The caller rolls it back and releases the connection.
+func (c *Copier) beginSnapshot(ctx context.Context, snapshotName string) (*pgxpool.Conn, pgx.Tx, error) {
+	srcConn, err := c.source.Acquire(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("acquire source conn: %w", err)
+	}
+	srcTx, err := srcConn.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		srcConn.Release()
+		return nil, nil, fmt.Errorf("begin source tx: %w", err)
+	}
+	if snapshotName != "" {
+		if _, err := srcTx.Exec(ctx, fmt.Sprintf("SET TRANSACTION SNAPSHOT '%s'", snapshotName)); err != nil {
+			srcTx.Rollback(ctx) //nolint:errcheck
+			srcConn.Release()
+			return nil, nil, fmt.Errorf("set snapshot: %w", err)
+		}
+	}
+	return srcConn, srcTx, nil
+}
+
+func (c *Copier) exportTable(ctx context.Context, table TableInfo, snapshotName string, workerID int, fn RowFunc) CopyResult {
+	log := c.logger.With().Str("table", table.QualifiedName()).Int("worker", workerID).Logger()
+	log.Info().Msg("starting export")
+	c.reportProgress(table, "start", 0)
+
+	srcConn, srcTx, err := c.beginSnapshot(ctx, snapshotName)
+	if err != nil {
+		return CopyResult{Table: table, Err: err}
+	}
+	defer srcConn.Release()
+	defer srcTx.Rollback(ctx) //nolint:errcheck
+
+	qn := quoteQualifiedName(table.Schema, table.Name)
+	keys, err := primaryKey(ctx, srcTx, qn)
+	if err != nil {
+		return CopyResult{Table: table, Err: err}
+	}
+
+	rows, err := srcTx.Query(ctx, fmt.Sprintf("SELECT * FROM %s", qn), pgx.QueryResultFormats{pgx.TextFormatCode})
+	if err != nil {
+		return CopyResult{Table: table, Err: fmt.Errorf("select from %s: %w", qn, err)}
+	}
+	defer rows.Close()
+
+	fieldDescs := rows.FieldDescriptions()
+	var n int64
+	var lastReport time.Time
+	for rows.Next() {
+		raw := rows.RawValues()
+		cols := make([]stream.Column, len(fieldDescs))
+		for i, fd := range fieldDescs {
+			cols[i] = stream.Column{Name: fd.Name, DataType: fd.DataTypeOID, Key: keys[fd.Name]}
+			if raw[i] != nil {
+				cols[i].Value = append([]byte{}, raw[i]...)
+			}
+		}
+		err := fn(ctx, &stream.ChangeMessage{
+			Op:        stream.OpInsert,
+			Namespace: table.Schema,
+			Table:     table.Name,
+			NewTuple:  &stream.TupleData{Columns: cols},
+		})
+		if err != nil {
+			return CopyResult{Table: table, RowsCopied: n, Err: fmt.Errorf("export %s: %w", qn, err)}
+		}
+		n++
+		if time.Since(lastReport) >= progressReportInterval {
+			c.reportProgress(table, "progress", n)
+			lastReport = time.Now()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return CopyResult{Table: table, RowsCopied: n, Err: fmt.Errorf("read from %s: %w", qn, err)}
+	}
+
+	log.Info().Int64("rows", n).Msg("export complete")
+	c.reportProgress(table, "done", n)
+	return CopyResult{Table: table, RowsCopied: n}
+}
+
+func (c *Copier) copyTableSharded(ctx context.Context, table TableInfo, snapshotName string, workerID int, dests []*pgxpool.Pool, route ShardFunc) CopyResult {
+	log := c.logger.With().Str("table", table.QualifiedName()).Int("worker", workerID).Logger()
+	log.Info().Int("shards", len(dests)).Msg("starting sharded COPY")
+	c.reportProgress(table, "start", 0)
+
+	srcConn, srcTx, err := c.beginSnapshot(ctx, snapshotName)
+	if err != nil {
+		return CopyResult{Table: table, Err: err}
+	}
+	defer srcConn.Release()
+	defer srcTx.Rollback(ctx) //nolint:errcheck
+
+	qn := quoteQualifiedName(table.Schema, table.Name)
+	keys, err := primaryKey(ctx, srcTx, qn)
+	if err != nil {
+		return CopyResult{Table: table, Err: err}
+	}
+
+	rows, err := srcTx.Query(ctx, fmt.Sprintf("SELECT * FROM %s", qn), pgx.QueryResultFormats{pgx.TextFormatCode})
+	if err != nil {
+		return CopyResult{Table: table, Err: fmt.Errorf("select from %s: %w", qn, err)}
+	}
+	defer rows.Close()
+
+	fieldDescs := rows.FieldDescriptions()
+	colNames := make([]string, len(fieldDescs))
+	for i, fd := range fieldDescs {
+		colNames[i] = fd.Name
+	}
+
+	dst, dstCols := c.target(table, colNames)
+
+	// One COPY per shard, fed from the rows read here. On failure ctx is
+	// cancelled before the feeds end, so no COPY commits a partial table.
+	copyCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	feeds := make([]chan []any, len(dests))
+	errs := make([]error, len(dests))
+	var wg sync.WaitGroup
+	for i, dest := range dests {
+		feeds[i] = make(chan []any, 256)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := dest.CopyFrom(copyCtx, dst, dstCols,
+				&chanSource{ctx: copyCtx, rows: feeds[i]})
+			if err != nil {
+				errs[i] = fmt.Errorf("copy to %s on shard %d: %w", qn, i, err)
+				cancel()
+			}
+		}()
+	}
+	fail := func(n int64, err error) CopyResult {
+		cancel()
+		wg.Wait()
+		if errors.Is(err, context.Canceled) {
+			if ferr := errors.Join(errs...); ferr != nil {
+				err = ferr
+			}
+		}
+		return CopyResult{Table: table, RowsCopied: n, Err: err}
+	}
+
+	var n int64
+	var lastReport time.Time
+	for rows.Next() {
+		raw := rows.RawValues()
+		cols := make([]stream.Column, len(fieldDescs))
+		vals := make([]any, len(fieldDescs))
+		for i, fd := range fieldDescs {
+			cols[i] = stream.Column{Name: fd.Name, DataType: fd.DataTypeOID, Key: keys[fd.Name]}
+			if raw[i] != nil {
+				cols[i].Value = append([]byte{}, raw[i]...)
+				vals[i] = string(raw[i])
+			}
+		}
+		shards, err := route(&stream.ChangeMessage{
+			Op:        stream.OpInsert,
+			Namespace: table.Schema,
+			Table:     table.Name,
+			NewTuple:  &stream.TupleData{Columns: cols},
+		})
+		if err != nil {
+			return fail(n, fmt.Errorf("route %s: %w", qn, err))
+		}
+		for _, s := range shards {
+			select {
+			case feeds[s] <- vals:
+			case <-copyCtx.Done():
+				return fail(n, copyCtx.Err())
+			}
+		}
+		n++
+		if time.Since(lastReport) >= progressReportInterval {
+			c.reportProgress(table, "progress", n)
+			lastReport = time.Now()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fail(n, fmt.Errorf("read from %s: %w", qn, err))
+	}
+	for _, f := range feeds {
+		close(f)
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return CopyResult{Table: table, RowsCopied: n, Err: err}
+	}
+
+	log.Info().Int64("rows", n).Msg("sharded COPY complete")
+	c.reportProgress(table, "done", n)
+	return CopyResult{Table: table, RowsCopied: n}
+}
+
+// chanSource implements pgx.CopyFromSource over a channel of rows. It
+// ends with an error when ctx is cancelled, which aborts the COPY.
+type chanSource struct {
+	ctx  context.Context
+	rows <-chan []any
+	vals []any
+	err  error
+}
+
+func (s *chanSource) Next() bool {
+	select {
+	case vals, ok := <-s.rows:
+		s.vals = vals
+		return ok
+	case <-s.ctx.Done():
+		s.err = s.ctx.Err()
+		return false
+	}
+}
+
+func (s *chanSource) Values() ([]any, error) {
+	return s.vals, nil
+}
+
+func (s *chanSource) Err() error {
+	return s.err
+}
+
+// primaryKey returns the primary key column names of the table qn.
+func primaryKey(ctx context.Context, tx pgx.Tx, qn string) (map[string]bool, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT a.attname
+		FROM pg_index i
+		JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+		WHERE i.indrelid = $1::regclass AND i.indisprimary`, qn)
+	if err != nil {
+		return nil, fmt.Errorf("primary key of %s: %w", qn, err)
+	}
+	keys := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan primary key of %s: %w", qn, err)
+		}
+		keys[name] = true
+	}
+	return keys, rows.Err()
+}
+
 // rowStreamer implements pgx.CopyFromSource by streaming rows one at a time
 // from a pgx.Rows result set. This avoids buffering entire tables in memory.
 type rowStreamer struct {
@@ -229,6 +517,7 @@ type rowStreamer struct {
 	colCount   int
 	count      int64
 	vals       []any
+	extra      []any // appended to every row
 	err        error
 	lastReport time.Time
 }
@@ -242,7 +531,7 @@ func (s *rowStreamer) Next() bool {
 		s.err = err
 		return false
 	}
-	s.vals = vals
+	s.vals = append(vals, s.extra...)
 	s.count++
 	if s.report != nil && time.Since(s.lastReport) >= progressReportInterval {
 		s.report(s.table, "progress", s.count)
@@ -1,6 +1,8 @@
 package snapshot
 
 import (
+	"context"
+	"errors"
 	"testing"
 )
 
@@ -60,3 +62,25 @@ func TestQuoteQualifiedName(t *testing.T) {
 		}
 	}
 }
+
+func TestChanSourceFailsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	rows := make(chan []any, 1)
+	rows <- []any{"1"}
+	src := &chanSource{ctx: ctx, rows: rows}
+	if !src.Next() || src.Err() != nil {
+		t.Fatal("expected the queued row")
+	}
+
+	// A cancelled feed must fail the COPY rather than end it cleanly.
+	cancel()
+	if src.Next() || !errors.Is(src.Err(), context.Canceled) {
+		t.Errorf("Next after cancel: err = %v", src.Err())
+	}
+
+	close(rows)
+	src = &chanSource{ctx: context.Background(), rows: rows}
+	if src.Next() || src.Err() != nil {
+		t.Errorf("closed feed: err = %v", src.Err())
+	}
+}
@@ -0,0 +1,999 @@
+// Package spool is a durable on-disk queue between the decoder and the
+// applier. Transactions are fsynced to local segment files before the
+// source slot is allowed to advance, and the applier reads them back at its
+// own pace, so WAL no longer piles up on the source while the destination
+// is slow or down.
+package spool
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/rs/zerolog"
+
+	"github.com/jfoltran/pgmanager/internal/migration/capture"
+	"github.com/jfoltran/pgmanager/internal/migration/stream"
+)
+
+// Defaults for Options.
+const (
+	DefaultMaxBytes     = 1 << 30
+	DefaultSegmentBytes = 16 << 20
+)
+
+const (
+	segmentExt = ".spool"
+	cursorFile = "cursor.json"
+
+	// cursorInterval bounds how often the applied position is written. An
+	// applier that records its own progress on the destination makes the
+	// cursor an optimisation: Skip moves past what it already applied.
+	cursorInterval = 100 * time.Millisecond
+)
+
+// ErrClosed is returned by Append after CloseWrite or Close.
+var ErrClosed = errors.New("spool closed")
+
+// Options controls the spool size.
+type Options struct {
+	// MaxBytes makes Append block before a new transaction while this many
+	// spooled bytes are waiting to be applied (default 1 GiB).
+	MaxBytes int64
+	// SegmentBytes rotates to a new segment after the first commit past
+	// this size (default 16 MiB). Applied segments are deleted whole.
+	SegmentBytes int64
+}
+
+// Depth describes the backlog waiting in the spool.
+type Depth struct {
+	Bytes        int64         `json:"bytes"`
+	MaxBytes     int64         `json:"max_bytes"`
+	Transactions int64         `json:"transactions"`
+	Segments     int           `json:"segments"`
+	SpooledLSN   pglogrepl.LSN `json:"spooled_lsn"`
+	AppliedLSN   pglogrepl.LSN `json:"applied_lsn"`
+	// Blocked is set while Append waits for the applier to free space.
+	Blocked bool `json:"blocked"`
+}
+
+// position is a byte offset within a segment.
+type position struct {
+	Seq int   `json:"segment"`
+	Off int64 `json:"offset"`
+}
+
+func (p position) before(q position) bool {
+	return p.Seq < q.Seq || (p.Seq == q.Seq && p.Off < q.Off)
+}
+
+type segment struct {
+	seq  int
+	path string
+	// size is the durable length: it ends after the last fsynced commit.
+	size int64
+}
+
+// pendingCommit is a commit handed to the applier but not yet acknowledged.
+type pendingCommit struct {
+	lsn pglogrepl.LSN
+	end position
+}
+
+// marker is a message kept in memory, such as a sentinel, that is
+// delivered once the reader reaches the position it was appended at.
+type marker struct {
+	pos position
+	msg stream.Message
+}
+
+type cursorState struct {
+	position
+	LSN string `json:"lsn"`
+}
+
+// Spool is a durable queue of decoded transactions. Append is called by a
+// single producer and Messages by a single consumer.
+type Spool struct {
+	dir    string
+	opts   Options
+	logger zerolog.Logger
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	sealed bool
+	closed bool
+	stop   chan struct{}
+
+	segments []*segment
+
+	// Write side.
+	wf           *os.File
+	bw           *bufio.Writer
+	wsize        int64
+	inTxn        bool
+	txnStart     int64
+	txnRelations []*stream.RelationMessage
+	relations    map[uint32]*stream.RelationMessage
+	lastCommit   pglogrepl.LSN
+	blocked      bool
+	fullLogged   time.Time
+	rec          []byte
+
+	// Read side.
+	applied     position
+	appliedLSN  pglogrepl.LSN
+	pendingTxns int64
+	inflight    []pendingCommit
+	markers     []marker
+	readerDone  chan struct{}
+	cursorSaved time.Time
+}
+
+// Open opens or creates the spool in dir and recovers it: segments the
+// applier has finished are deleted, and a transaction left unfinished by a
+// crash is cut from the end of the last segment.
+func Open(dir string, opts Options, logger zerolog.Logger) (*Spool, error) {
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultMaxBytes
+	}
+	if opts.SegmentBytes <= 0 {
+		opts.SegmentBytes = DefaultSegmentBytes
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create spool dir: %w", err)
+	}
+	s := &Spool{
+		dir:       dir,
+		opts:      opts,
+		logger:    logger.With().Str("component", "spool").Logger(),
+		relations: make(map[uint32]*stream.RelationMessage),
+		stop:      make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	if err := s.recover(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func segmentName(seq int) string {
+	return fmt.Sprintf("%08d%s", seq, segmentExt)
+}
+
+func (s *Spool) recover() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("read spool dir: %w", err)
+	}
+	for _, e := range entries {
+		base, ok := strings.CutSuffix(e.Name(), segmentExt)
+		if !ok || e.IsDir() {
+			continue
+		}
+		seq, err := strconv.Atoi(base)
+		if err != nil {
+			continue
+		}
+		s.segments = append(s.segments, &segment{seq: seq, path: filepath.Join(s.dir, e.Name())})
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].seq < s.segments[j].seq })
+
+	cur, err := s.loadCursor()
+	if err != nil {
+		return err
+	}
+	s.applied = cur.position
+	if s.applied.Seq == 0 && len(s.segments) > 0 {
+		s.applied = position{Seq: s.segments[0].seq, Off: capture.HeaderSize}
+	}
+	if cur.LSN != "" {
+		s.appliedLSN, _ = pglogrepl.ParseLSN(cur.LSN)
+	}
+
+	kept := s.segments[:0]
+	for _, seg := range s.segments {
+		// A segment shorter than its header was cut off by a crash right
+		// after it was created.
+		if info, err := os.Stat(seg.path); seg.seq < s.applied.Seq || (err == nil && info.Size() < capture.HeaderSize) {
+			if err := os.Remove(seg.path); err != nil {
+				return fmt.Errorf("remove spool segment: %w", err)
+			}
+			continue
+		}
+		if err := s.scanSegment(seg); err != nil {
+			return err
+		}
+		kept = append(kept, seg)
+	}
+	s.segments = kept
+	if len(s.segments) > 0 && s.applied.Seq < s.segments[0].seq {
+		s.applied = position{Seq: s.segments[0].seq, Off: capture.HeaderSize}
+	}
+
+	s.logger.Info().
+		Int("segments", len(s.segments)).
+		Int64("pending_transactions", s.pendingTxns).
+		Int64("pending_bytes", s.pendingBytes()).
+		Stringer("spooled_lsn", s.lastCommit).
+		Msg("spool opened")
+	return nil
+}
+
+// scanSegment finds the durable end of seg, truncates anything after it,
+// and collects relations, the last commit and the pending transactions.
+func (s *Spool) scanSegment(seg *segment) error {
+	f, err := os.OpenFile(seg.path, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("open spool segment: %w", err)
+	}
+	defer f.Close()
+	if err := capture.CheckHeader(f); err != nil {
+		return fmt.Errorf("%s: %w", seg.path, err)
+	}
+
+	rr := capture.NewRecordReader(f, capture.HeaderSize)
+	durable := capture.HeaderSize
+	inTxn := false
+	var txnRels []*stream.RelationMessage
+	for {
+		msg, err := rr.Next()
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, capture.ErrDamaged) {
+				return fmt.Errorf("scan %s: %w", seg.path, err)
+			}
+			break
+		}
+		switch m := msg.(type) {
+		case *stream.BeginMessage:
+			inTxn = true
+			txnRels = nil
+		case *stream.RelationMessage:
+			if inTxn {
+				txnRels = append(txnRels, m)
+			} else {
+				s.relations[m.RelationID] = m
+				durable = rr.Offset
+			}
+		case *stream.CommitMessage:
+			inTxn = false
+			for _, rel := range txnRels {
+				s.relations[rel.RelationID] = rel
+			}
+			durable = rr.Offset
+			if m.CommitLSN > s.lastCommit {
+				s.lastCommit = m.CommitLSN
+			}
+			if s.applied.before(position{Seq: seg.seq, Off: durable}) {
+				s.pendingTxns++
+			}
+		}
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("stat spool segment: %w", err)
+	}
+	if info.Size() > durable {
+		s.logger.Warn().Str("segment", seg.path).Int64("bytes", info.Size()-durable).
+			Msg("discarding unfinished transaction at the end of the spool")
+		if err := f.Truncate(durable); err != nil {
+			return fmt.Errorf("truncate spool segment: %w", err)
+		}
+		if err := f.Sync(); err != nil {
+			return fmt.Errorf("sync spool segment: %w", err)
+		}
+	}
+	seg.size = durable
+	return nil
+}
+
+func (s *Spool) loadCursor() (cursorState, error) {
+	var cur cursorState
+	data, err := os.ReadFile(filepath.Join(s.dir, cursorFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return cur, nil
+	}
+	if err != nil {
+		return cur, fmt.Errorf("read spool cursor: %w", err)
+	}
+	if err := json.Unmarshal(data, &cur); err != nil {
+		return cur, fmt.Errorf("parse spool cursor: %w", err)
+	}
+	return cur, nil
+}
+
+// saveCursor writes the applied position with a rename, so a crash leaves
+// either the old or the new cursor.
+func (s *Spool) saveCursor() error {
+	data, err := json.Marshal(cursorState{position: s.applied, LSN: s.appliedLSN.String()})
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(s.dir, cursorFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("write spool cursor: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, cursorFile)); err != nil {
+		return fmt.Errorf("write spool cursor: %w", err)
+	}
+	s.cursorSaved = time.Now()
+	return nil
+}
+
+// Append adds msg to the spool. For a commit it returns the commit LSN and
+// true once the transaction is fsynced; the caller may then confirm that
+// LSN to the source. A transaction that commits at or below the last
+// spooled commit, as resent after a decoder reconnect, is dropped. A begin
+// blocks while the spool is full. Messages that are not recorded, such as
+// sentinels, are held in memory and delivered in order.
+func (s *Spool) Append(ctx context.Context, msg stream.Message) (pglogrepl.LSN, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sealed {
+		return 0, false, ErrClosed
+	}
+
+	switch m := msg.(type) {
+	case *stream.RelationMessage:
+		s.relations[m.RelationID] = m
+		if s.inTxn {
+			s.txnRelations = append(s.txnRelations, m)
+		}
+		opened, err := s.ensureSegment()
+		if err != nil || opened {
+			// A new segment starts with every known relation.
+			return 0, false, err
+		}
+		return 0, false, s.writeRecord(m)
+
+	case *stream.BeginMessage:
+		if s.inTxn {
+			if err := s.discardTxn(); err != nil {
+				return 0, false, err
+			}
+		}
+		if err := s.waitForSpace(ctx); err != nil {
+			return 0, false, err
+		}
+		if _, err := s.ensureSegment(); err != nil {
+			return 0, false, err
+		}
+		// Everything before the transaction must be in the file, so that
+		// discardTxn can truncate back to txnStart.
+		if err := s.bw.Flush(); err != nil {
+			return 0, false, fmt.Errorf("flush spool segment: %w", err)
+		}
+		s.inTxn = true
+		s.txnStart = s.wsize
+		return 0, false, s.writeRecord(m)
+
+	case *stream.ChangeMessage:
+		if !s.inTxn {
+			return 0, false, nil
+		}
+		return 0, false, s.writeRecord(m)
+
+	case *stream.CommitMessage:
+		if !s.inTxn {
+			return 0, false, nil
+		}
+		if m.CommitLSN <= s.lastCommit {
+			return 0, false, s.discardTxn()
+		}
+		if err := s.writeRecord(m); err != nil {
+			return 0, false, err
+		}
+		if err := s.sync(); err != nil {
+			return 0, false, err
+		}
+		s.inTxn = false
+		s.txnRelations = nil
+		s.lastCommit = m.CommitLSN
+		s.pendingTxns++
+		s.cond.Broadcast()
+		if s.wsize >= s.opts.SegmentBytes {
+			if err := s.closeWriteSegment(); err != nil {
+				return 0, false, err
+			}
+		}
+		return m.CommitLSN, true, nil
+	}
+
+	s.markers = append(s.markers, marker{pos: s.durableEnd(), msg: msg})
+	s.cond.Broadcast()
+	return 0, false, nil
+}
+
+// waitForSpace blocks while the backlog is at MaxBytes. Called with mu held.
+func (s *Spool) waitForSpace(ctx context.Context) error {
+	if s.pendingBytes() < s.opts.MaxBytes {
+		return nil
+	}
+	stop := context.AfterFunc(ctx, func() {
+		s.mu.Lock()
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	})
+	defer stop()
+
+	if time.Since(s.fullLogged) >= 30*time.Second {
+		s.fullLogged = time.Now()
+		s.logger.Warn().Int64("bytes", s.pendingBytes()).Int64("max_bytes", s.opts.MaxBytes).
+			Msg("spool full, waiting for the applier")
+	}
+	s.blocked = true
+	defer func() { s.blocked = false }()
+	for s.pendingBytes() >= s.opts.MaxBytes {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if s.sealed {
+			return ErrClosed
+		}
+		s.cond.Wait()
+	}
+	return nil
+}
+
+// ensureSegment opens a new write segment if none is open and writes the
+// known relations into it. It reports whether it opened one.
+func (s *Spool) ensureSegment() (bool, error) {
+	if s.wf != nil {
+		return false, nil
+	}
+	seq := 1
+	if n := len(s.segments); n > 0 {
+		seq = s.segments[n-1].seq + 1
+	}
+	path := filepath.Join(s.dir, segmentName(seq))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return false, fmt.Errorf("create spool segment: %w", err)
+	}
+	s.wf = f
+	s.bw = bufio.NewWriterSize(f, 256<<10)
+	if err := capture.WriteHeader(s.bw); err != nil {
+		return false, fmt.Errorf("write spool header: %w", err)
+	}
+	s.wsize = capture.HeaderSize
+
+	ids := make([]uint32, 0, len(s.relations))
+	for id := range s.relations {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		if err := s.writeRecord(s.relations[id]); err != nil {
+			return false, err
+		}
+	}
+	s.segments = append(s.segments, &segment{seq: seq, path: path})
+	if err := s.sync(); err != nil {
+		return false, err
+	}
+	if s.applied.Seq < s.segments[0].seq {
+		s.applied = position{Seq: s.segments[0].seq, Off: capture.HeaderSize}
+	}
+	s.cond.Broadcast()
+	return true, nil
+}
+
+func (s *Spool) writeRecord(msg stream.Message) error {
+	s.rec, _ = capture.AppendRecord(s.rec[:0], msg)
+	n, err := s.bw.Write(s.rec)
+	s.wsize += int64(n)
+	if err != nil {
+		return fmt.Errorf("write spool record: %w", err)
+	}
+	return nil
+}
+
+// sync makes everything written so far durable and visible to the reader.
+func (s *Spool) sync() error {
+	if err := s.bw.Flush(); err != nil {
+		return fmt.Errorf("flush spool segment: %w", err)
+	}
+	if err := s.wf.Sync(); err != nil {
+		return fmt.Errorf("sync spool segment: %w", err)
+	}
+	s.segments[len(s.segments)-1].size = s.wsize
+	return nil
+}
+
+// discardTxn cuts the open transaction from the write segment, keeping the
+// relations it carried since later transactions may rely on them.
+func (s *Spool) discardTxn() error {
+	s.bw.Reset(s.wf)
+	if err := s.wf.Truncate(s.txnStart); err != nil {
+		return fmt.Errorf("truncate spool segment: %w", err)
+	}
+	if _, err := s.wf.Seek(s.txnStart, io.SeekStart); err != nil {
+		return fmt.Errorf("seek spool segment: %w", err)
+	}
+	s.wsize = s.txnStart
+	s.inTxn = false
+	rels := s.txnRelations
+	s.txnRelations = nil
+	for _, rel := range rels {
+		if err := s.writeRecord(rel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Spool) closeWriteSegment() error {
+	if s.wf == nil {
+		return nil
+	}
+	err := s.wf.Close()
+	s.wf, s.bw = nil, nil
+	if err != nil {
+		return fmt.Errorf("close spool segment: %w", err)
+	}
+	return nil
+}
+
+// durableEnd is the position after the last durable record.
+func (s *Spool) durableEnd() position {
+	if len(s.segments) == 0 {
+		return position{}
+	}
+	last := s.segments[len(s.segments)-1]
+	return position{Seq: last.seq, Off: last.size}
+}
+
+// pendingBytes is the durable size of the spool past the applied position.
+func (s *Spool) pendingBytes() int64 {
+	var n int64
+	for _, seg := range s.segments {
+		switch {
+		case seg.seq == s.applied.Seq:
+			n += max(seg.size-s.applied.Off, 0)
+		case seg.seq > s.applied.Seq:
+			n += seg.size - capture.HeaderSize
+		}
+	}
+	return n
+}
+
+// LastCommit returns the highest durably spooled commit LSN. A decoder
+// restarted at this LSN loses nothing.
+func (s *Spool) LastCommit() pglogrepl.LSN {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastCommit
+}
+
+// Pending reports whether any spooled transaction is not yet applied.
+func (s *Spool) Pending() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pendingTxns > 0
+}
+
+// Depth returns the current backlog.
+func (s *Spool) Depth() Depth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return Depth{
+		Bytes:        s.pendingBytes(),
+		MaxBytes:     s.opts.MaxBytes,
+		Transactions: s.pendingTxns,
+		Segments:     len(s.segments),
+		SpooledLSN:   s.lastCommit,
+		AppliedLSN:   s.appliedLSN,
+		Blocked:      s.blocked,
+	}
+}
+
+// Messages streams the spool from the applied position: first the
+// relations recorded before it in its segment, then every durable record,
+// waiting for more as they are appended. The channel closes when ctx ends,
+// or once everything is delivered after CloseWrite. A new call, after the
+// previous consumer's ctx ended, restarts from the applied position.
+func (s *Spool) Messages(ctx context.Context) <-chan stream.Message {
+	return s.MessagesUntil(ctx, nil)
+}
+
+// MessagesUntil is Messages, but once drain is closed the channel also
+// closes as soon as everything durable has been delivered, instead of
+// waiting for more.
+func (s *Spool) MessagesUntil(ctx context.Context, drain <-chan struct{}) <-chan stream.Message {
+	s.mu.Lock()
+	prev := s.readerDone
+	s.mu.Unlock()
+	if prev != nil {
+		<-prev
+	}
+
+	s.mu.Lock()
+	s.inflight = nil
+	start := s.applied
+	done := make(chan struct{})
+	s.readerDone = done
+	s.mu.Unlock()
+
+	out := make(chan stream.Message, 256)
+	go func() {
+		defer close(done)
+		defer close(out)
+		stop := context.AfterFunc(ctx, func() {
+			s.mu.Lock()
+			s.cond.Broadcast()
+			s.mu.Unlock()
+		})
+		defer stop()
+		if drain != nil {
+			go func() {
+				select {
+				case <-drain:
+					s.mu.Lock()
+					s.cond.Broadcast()
+					s.mu.Unlock()
+				case <-done:
+				}
+			}()
+		}
+		if err := s.read(ctx, start, drain, out); err != nil && ctx.Err() == nil && !errors.Is(err, ErrClosed) {
+			s.logger.Err(err).Msg("spool read failed")
+		}
+	}()
+	return out
+}
+
+func (s *Spool) read(ctx context.Context, pos position, drain <-chan struct{}, out chan<- stream.Message) error {
+	send := func(msg stream.Message) error {
+		select {
+		case out <- msg:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.stop:
+			return ErrClosed
+		}
+	}
+
+	if pos.Seq != 0 && pos.Off > capture.HeaderSize {
+		if err := s.readRelations(pos, send); err != nil {
+			return err
+		}
+	}
+
+	for {
+		path, end, due, ok := s.next(ctx, &pos, drain)
+		for _, m := range due {
+			if err := send(m); err != nil {
+				return err
+			}
+		}
+		if !ok {
+			return ctx.Err()
+		}
+		if path == "" {
+			continue
+		}
+		if err := s.readRange(path, pos, end, send); err != nil {
+			return err
+		}
+		pos.Off = end
+	}
+}
+
+// next waits until there is something to deliver at pos. It returns the
+// markers that are due, and the segment range to read, if any. ok is
+// false once the reader should stop.
+func (s *Spool) next(ctx context.Context, pos *position, drain <-chan struct{}) (path string, end int64, due []stream.Message, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		if due = s.dueMarkers(*pos); len(due) > 0 {
+			return "", 0, due, true
+		}
+		if ctx.Err() != nil || s.closed {
+			return "", 0, nil, false
+		}
+
+		var cur, following *segment
+		for _, seg := range s.segments {
+			if seg.seq == pos.Seq {
+				cur = seg
+			} else if seg.seq > pos.Seq && following == nil {
+				following = seg
+			}
+		}
+		if cur != nil && cur.size > pos.Off {
+			return cur.path, cur.size, nil, true
+		}
+		if following != nil {
+			*pos = position{Seq: following.seq, Off: capture.HeaderSize}
+			continue
+		}
+		if s.sealed || isClosed(drain) {
+			return "", 0, nil, false
+		}
+		s.cond.Wait()
+	}
+}
+
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// dueMarkers removes and returns the markers at or before pos. Called
+// with mu held.
+func (s *Spool) dueMarkers(pos position) []stream.Message {
+	var due []stream.Message
+	for len(s.markers) > 0 && !pos.before(s.markers[0].pos) {
+		due = append(due, s.markers[0].msg)
+		s.markers = s.markers[1:]
+	}
+	return due
+}
+
+// readRange delivers the records of path in [pos.Off, end), with any
+// markers that fall between them.
+func (s *Spool) readRange(path string, pos position, end int64, send func(stream.Message) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open spool segment: %w", err)
+	}
+	defer f.Close()
+
+	rr := capture.NewRecordReader(io.NewSectionReader(f, pos.Off, end-pos.Off), pos.Off)
+	for {
+		s.mu.Lock()
+		due := s.dueMarkers(position{Seq: pos.Seq, Off: rr.Offset})
+		s.mu.Unlock()
+		for _, m := range due {
+			if err := send(m); err != nil {
+				return err
+			}
+		}
+
+		msg, err := rr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read %s at %d: %w", path, rr.Offset, err)
+		}
+		if msg.Kind() == stream.KindCommit {
+			s.mu.Lock()
+			s.inflight = append(s.inflight, pendingCommit{lsn: msg.LSN(), end: position{Seq: pos.Seq, Off: rr.Offset}})
+			s.mu.Unlock()
+		}
+		if err := send(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// readRelations delivers the relations recorded in pos's segment before
+// pos, which the applier needs but has already consumed before a restart.
+func (s *Spool) readRelations(pos position, send func(stream.Message) error) error {
+	f, err := os.Open(filepath.Join(s.dir, segmentName(pos.Seq)))
+	if err != nil {
+		return fmt.Errorf("open spool segment: %w", err)
+	}
+	defer f.Close()
+
+	rr := capture.NewRecordReader(io.NewSectionReader(f, capture.HeaderSize, pos.Off-capture.HeaderSize), capture.HeaderSize)
+	for {
+		msg, err := rr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read spool relations: %w", err)
+		}
+		if msg.Kind() == stream.KindRelation {
+			if err := send(msg); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Ack records that every transaction up to lsn is applied. Segments the
+// applier has moved past are deleted, and Append is unblocked.
+func (s *Spool) Ack(lsn pglogrepl.LSN) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for n < len(s.inflight) && s.inflight[n].lsn <= lsn {
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+	s.applied = s.inflight[n-1].end
+	s.appliedLSN = s.inflight[n-1].lsn
+	s.inflight = s.inflight[n:]
+	s.pendingTxns = max(s.pendingTxns-int64(n), 0)
+
+	if err := s.compact(); err != nil {
+		return err
+	}
+	s.cond.Broadcast()
+	if time.Since(s.cursorSaved) >= cursorInterval {
+		return s.saveCursor()
+	}
+	return nil
+}
+
+// Advance records that the destination already has every transaction
+// committing at or below lsn, so Append drops them as duplicates and they
+// count as spooled.
+func (s *Spool) Advance(lsn pglogrepl.LSN) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if lsn > s.lastCommit {
+		s.lastCommit = lsn
+	}
+}
+
+// Skip marks every spooled transaction that commits at or below lsn as
+// applied, as recorded on the destination by an applier that stores its
+// progress with the data, and returns how many it skipped. Call it before
+// Messages, while no reader is running.
+func (s *Spool) Skip(lsn pglogrepl.LSN) (int, error) {
+	s.mu.Lock()
+	prev := s.readerDone
+	s.mu.Unlock()
+	if prev != nil {
+		<-prev
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pos := s.applied
+	n := 0
+	var last pglogrepl.LSN
+	for _, seg := range s.segments {
+		if seg.seq < pos.Seq {
+			continue
+		}
+		off := int64(capture.HeaderSize)
+		if seg.seq == pos.Seq {
+			off = pos.Off
+		}
+		if off >= seg.size {
+			continue
+		}
+		end, skipped, commit, more, err := skipSegment(seg, off, lsn)
+		if err != nil {
+			return 0, err
+		}
+		if skipped > 0 {
+			pos = position{Seq: seg.seq, Off: end}
+			n += skipped
+			last = commit
+		}
+		if !more {
+			break
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	s.applied = pos
+	s.appliedLSN = last
+	s.inflight = nil
+	s.pendingTxns = max(s.pendingTxns-int64(n), 0)
+	if err := s.compact(); err != nil {
+		return n, err
+	}
+	s.cond.Broadcast()
+	return n, s.saveCursor()
+}
+
+// skipSegment reads seg from off up to the first commit above lsn. It
+// returns the offset after the last commit at or below lsn, how many such
+// commits there were and the last one's LSN, and whether the following
+// segments may hold more.
+func skipSegment(seg *segment, off int64, lsn pglogrepl.LSN) (end int64, n int, last pglogrepl.LSN, more bool, err error) {
+	f, err := os.Open(seg.path)
+	if err != nil {
+		return 0, 0, 0, false, fmt.Errorf("open spool segment: %w", err)
+	}
+	defer f.Close()
+
+	rr := capture.NewRecordReader(io.NewSectionReader(f, off, seg.size-off), off)
+	for {
+		msg, err := rr.Next()
+		if err == io.EOF {
+			return end, n, last, true, nil
+		}
+		if err != nil {
+			return 0, 0, 0, false, fmt.Errorf("read %s at %d: %w", seg.path, rr.Offset, err)
+		}
+		if msg.Kind() != stream.KindCommit {
+			continue
+		}
+		if msg.LSN() > lsn {
+			return end, n, last, false, nil
+		}
+		end, last = rr.Offset, msg.LSN()
+		n++
+	}
+}
+
+// compact deletes segments that are fully applied and no longer written.
+func (s *Spool) compact() error {
+	for len(s.segments) > 1 {
+		seg := s.segments[0]
+		if seg.seq > s.applied.Seq || (seg.seq == s.applied.Seq && s.applied.Off < seg.size) {
+			return nil
+		}
+		if seg.seq == s.applied.Seq {
+			s.applied = position{Seq: s.segments[1].seq, Off: capture.HeaderSize}
+		}
+		if err := os.Remove(seg.path); err != nil {
+			return fmt.Errorf("remove applied spool segment: %w", err)
+		}
+		s.segments = s.segments[1:]
+		s.logger.Debug().Str("segment", seg.path).Msg("removed applied spool segment")
+	}
+	return nil
+}
+
+// CloseWrite stops further appends. The reader delivers what is already
+// durable and then closes its channel.
+func (s *Spool) CloseWrite() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sealed = true
+	s.cond.Broadcast()
+}
+
+// Close stops the reader, saves the applied position and closes the
+// write segment. An unfinished transaction is cut on the next Open.
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.sealed = true
+	s.closed = true
+	close(s.stop)
+	s.cond.Broadcast()
+	done := s.readerDone
+	s.mu.Unlock()
+	if done != nil {
+		<-done
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	if s.wf != nil {
+		if err := s.bw.Flush(); err != nil {
+			errs = append(errs, err)
+		}
+		errs = append(errs, s.closeWriteSegment())
+	}
+	if s.applied.Seq != 0 {
+		errs = append(errs, s.saveCursor())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,341 @@
+package spool
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/rs/zerolog"
+
+	"github.com/jfoltran/pgmanager/internal/migration/sentinel"
+	"github.com/jfoltran/pgmanager/internal/migration/stream"
+)
+
+var testRelation = &stream.RelationMessage{
+	RelationID: 16384,
+	Namespace:  "public",
+	Name:       "users",
+	Columns:    []stream.Column{{Name: "id", DataType: 23, Key: true}},
+}
+
+func txn(lsn pglogrepl.LSN) []stream.Message {
+	return []stream.Message{
+		&stream.BeginMessage{TxnLSN: lsn},
+		&stream.ChangeMessage{
+			Op: stream.OpInsert, RelationID: 16384, Namespace: "public", Table: "users",
+			NewTuple: &stream.TupleData{Columns: []stream.Column{{Name: "id", DataType: 23, Value: []byte("1"), Key: true}}},
+			MsgLSN:   lsn,
+		},
+		&stream.CommitMessage{CommitLSN: lsn},
+	}
+}
+
+func openSpool(t *testing.T, dir string, opts Options) *Spool {
+	t.Helper()
+	s, err := Open(dir, opts, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	return s
+}
+
+// appendAll appends msgs and returns the LSNs reported durable.
+func appendAll(t *testing.T, s *Spool, msgs ...stream.Message) []pglogrepl.LSN {
+	t.Helper()
+	var durable []pglogrepl.LSN
+	for _, m := range msgs {
+		lsn, ok, err := s.Append(context.Background(), m)
+		if err != nil {
+			t.Fatalf("Append %s: %v", m.Kind(), err)
+		}
+		if ok {
+			durable = append(durable, lsn)
+		}
+	}
+	return durable
+}
+
+// receive reads n messages from ch.
+func receive(t *testing.T, ch <-chan stream.Message, n int) []stream.Message {
+	t.Helper()
+	var got []stream.Message
+	for len(got) < n {
+		select {
+		case m, ok := <-ch:
+			if !ok {
+				t.Fatalf("channel closed after %d of %d messages", len(got), n)
+			}
+			got = append(got, m)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out after %d of %d messages", len(got), n)
+		}
+	}
+	return got
+}
+
+func commits(msgs []stream.Message) []pglogrepl.LSN {
+	var out []pglogrepl.LSN
+	for _, m := range msgs {
+		if m.Kind() == stream.KindCommit {
+			out = append(out, m.LSN())
+		}
+	}
+	return out
+}
+
+func TestAppendAndRead(t *testing.T) {
+	s := openSpool(t, t.TempDir(), Options{})
+	defer s.Close()
+
+	durable := appendAll(t, s, testRelation)
+	durable = append(durable, appendAll(t, s, txn(10)...)...)
+	durable = append(durable, appendAll(t, s, txn(20)...)...)
+	if len(durable) != 2 || durable[0] != 10 || durable[1] != 20 {
+		t.Fatalf("durable = %v, want [0/A 0/14]", durable)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got := receive(t, s.Messages(ctx), 7)
+	if got[0].Kind() != stream.KindRelation {
+		t.Errorf("first message = %s, want Relation", got[0].Kind())
+	}
+	if c := commits(got); len(c) != 2 || c[1] != 20 {
+		t.Errorf("commits = %v", c)
+	}
+	if d := s.Depth(); d.Transactions != 2 || d.SpooledLSN != 20 || d.Bytes == 0 {
+		t.Errorf("depth = %+v", d)
+	}
+
+	if err := s.Ack(20); err != nil {
+		t.Fatal(err)
+	}
+	if d := s.Depth(); d.Transactions != 0 || d.Bytes != 0 || d.AppliedLSN != 20 {
+		t.Errorf("depth after ack = %+v", d)
+	}
+}
+
+func TestDuplicateAndPartialTransactions(t *testing.T) {
+	s := openSpool(t, t.TempDir(), Options{})
+	defer s.Close()
+
+	appendAll(t, s, txn(10)...)
+	// The decoder failed mid-transaction; the relation it sent must survive.
+	appendAll(t, s, txn(20)[0], testRelation, txn(20)[1])
+	// After reconnecting it resends 0/A before continuing.
+	if d := appendAll(t, s, txn(10)...); len(d) != 0 {
+		t.Errorf("resent transaction reported durable: %v", d)
+	}
+	appendAll(t, s, txn(20)...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got := receive(t, s.Messages(ctx), 7)
+	var kinds []stream.MessageKind
+	for _, m := range got {
+		kinds = append(kinds, m.Kind())
+	}
+	want := []stream.MessageKind{
+		stream.KindBegin, stream.KindChange, stream.KindCommit,
+		stream.KindRelation,
+		stream.KindBegin, stream.KindChange, stream.KindCommit,
+	}
+	for i := range want {
+		if kinds[i] != want[i] {
+			t.Fatalf("kinds = %v, want %v", kinds, want)
+		}
+	}
+	if c := commits(got); c[0] != 10 || c[1] != 20 {
+		t.Errorf("commits = %v", c)
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	dir := t.TempDir()
+	s := openSpool(t, dir, Options{})
+	appendAll(t, s, testRelation)
+	appendAll(t, s, txn(10)...)
+	appendAll(t, s, txn(20)...)
+	appendAll(t, s, txn(30)[:2]...) // unfinished at the crash
+
+	ctx, cancel := context.WithCancel(context.Background())
+	receive(t, s.Messages(ctx), 4)
+	if err := s.Ack(10); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openSpool(t, dir, Options{})
+	defer s.Close()
+	if got := s.LastCommit(); got != 20 {
+		t.Errorf("LastCommit = %s, want 0/14", got)
+	}
+	if d := s.Depth(); d.Transactions != 1 || d.AppliedLSN != 10 {
+		t.Errorf("depth = %+v, want one pending transaction", d)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	// The relation from before the cursor, then 0/14 only.
+	got := receive(t, s.Messages(ctx), 4)
+	if got[0].Kind() != stream.KindRelation {
+		t.Errorf("first message = %s, want Relation", got[0].Kind())
+	}
+	if c := commits(got); len(c) != 1 || c[0] != 20 {
+		t.Errorf("commits = %v, want [0/14]", c)
+	}
+	// 0/1E was cut, so it can be spooled again.
+	if d := appendAll(t, s, txn(30)...); len(d) != 1 {
+		t.Errorf("re-spooled transaction not durable: %v", d)
+	}
+}
+
+func TestCompaction(t *testing.T) {
+	dir := t.TempDir()
+	s := openSpool(t, dir, Options{SegmentBytes: 1})
+	defer s.Close()
+	appendAll(t, s, testRelation)
+	for lsn := pglogrepl.LSN(10); lsn <= 40; lsn += 10 {
+		appendAll(t, s, txn(lsn)...)
+	}
+	if n := s.Depth().Segments; n != 4 {
+		t.Fatalf("segments = %d, want 4", n)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := s.Messages(ctx)
+	receive(t, ch, 4+4+4)
+	if err := s.Ack(30); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if len(files) != 1 {
+		t.Errorf("segment files after ack = %v, want 1", files)
+	}
+
+	// A restarted reader begins in the remaining segment, which repeats
+	// the relation.
+	cancel()
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	got := receive(t, s.Messages(ctx), 4)
+	if got[0].Kind() != stream.KindRelation || commits(got)[0] != 40 {
+		t.Errorf("restarted reader got %v", commits(got))
+	}
+}
+
+func TestSkip(t *testing.T) {
+	dir := t.TempDir()
+	s := openSpool(t, dir, Options{SegmentBytes: 1})
+	appendAll(t, s, testRelation)
+	for lsn := pglogrepl.LSN(10); lsn <= 40; lsn += 10 {
+		appendAll(t, s, txn(lsn)...)
+	}
+	// A crash before the cursor was saved: the destination has 0/14.
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openSpool(t, dir, Options{SegmentBytes: 1})
+	defer s.Close()
+	if n, err := s.Skip(25); err != nil || n != 2 {
+		t.Fatalf("Skip = %d, %v, want 2", n, err)
+	}
+	if d := s.Depth(); d.Transactions != 2 || d.AppliedLSN != 20 || d.Segments != 2 {
+		t.Errorf("depth = %+v, want two pending transactions in two segments", d)
+	}
+	if n, err := s.Skip(20); err != nil || n != 0 {
+		t.Errorf("second Skip = %d, %v, want 0", n, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got := receive(t, s.Messages(ctx), 4)
+	if got[0].Kind() != stream.KindRelation {
+		t.Errorf("first message = %s, want Relation", got[0].Kind())
+	}
+	if c := commits(got); len(c) != 1 || c[0] != 30 {
+		t.Errorf("commits = %v, want [0/1E]", c)
+	}
+}
+
+func TestBackpressure(t *testing.T) {
+	s := openSpool(t, t.TempDir(), Options{MaxBytes: 1})
+	defer s.Close()
+	appendAll(t, s, txn(10)...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, _, err := s.Append(ctx, txn(20)[0]); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Append on a full spool = %v, want deadline exceeded", err)
+	}
+
+	rctx, rcancel := context.WithCancel(context.Background())
+	defer rcancel()
+	receive(t, s.Messages(rctx), 3)
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := s.Append(context.Background(), txn(20)[0])
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if !s.Depth().Blocked {
+		t.Error("depth should report the blocked writer")
+	}
+	if err := s.Ack(10); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Append still blocked after the applier caught up")
+	}
+}
+
+func TestMarkersKeepOrder(t *testing.T) {
+	s := openSpool(t, t.TempDir(), Options{})
+	defer s.Close()
+	appendAll(t, s, txn(10)...)
+	appendAll(t, s, &sentinel.SentinelMessage{ID: "s1"})
+	appendAll(t, s, txn(20)...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got := receive(t, s.Messages(ctx), 7)
+	if got[3].Kind() != stream.KindSentinel {
+		t.Errorf("message 3 = %s, want the sentinel between the transactions", got[3].Kind())
+	}
+	if _, err := os.Stat(filepath.Join(s.dir, segmentName(1))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCloseWriteDrains(t *testing.T) {
+	s := openSpool(t, t.TempDir(), Options{})
+	defer s.Close()
+	appendAll(t, s, txn(10)...)
+	s.CloseWrite()
+	if _, _, err := s.Append(context.Background(), txn(20)[0]); !errors.Is(err, ErrClosed) {
+		t.Errorf("Append after CloseWrite = %v, want ErrClosed", err)
+	}
+
+	var n int
+	for range s.Messages(context.Background()) {
+		n++
+	}
+	if n != 3 {
+		t.Errorf("drained %d messages, want 3", n)
+	}
+}
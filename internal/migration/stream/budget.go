@@ -0,0 +1,136 @@
+package stream
+
+import (
+	"context"
+	"sync"
+)
+
+// messageOverhead approximates the fixed cost of a message and of each
+// column, so narrow rows are not counted as free.
+const (
+	messageOverhead = 64
+	columnOverhead  = 32
+)
+
+// MessageSize returns the approximate memory held by msg: tuple values,
+// names and a fixed overhead. Messages that do not come from a decoder,
+// such as sentinels, count as zero.
+func MessageSize(msg Message) int64 {
+	switch m := msg.(type) {
+	case *BeginMessage, *CommitMessage:
+		return messageOverhead
+	case *RelationMessage:
+		return messageOverhead + int64(len(m.Namespace)+len(m.Name)) + columnsSize(m.Columns)
+	case *ChangeMessage:
+		n := messageOverhead + int64(len(m.Namespace)+len(m.Table))
+		if m.OldTuple != nil {
+			n += columnsSize(m.OldTuple.Columns)
+		}
+		if m.NewTuple != nil {
+			n += columnsSize(m.NewTuple.Columns)
+		}
+		return n
+	}
+	return 0
+}
+
+func columnsSize(cols []Column) int64 {
+	var n int64
+	for _, c := range cols {
+		n += columnOverhead + int64(len(c.Name)+len(c.Value))
+	}
+	return n
+}
+
+// Budget bounds the bytes of decoded messages held in memory between a
+// source and the end of the pipeline. The source acquires each message's
+// size before sending it and the final consumer releases it, so every
+// channel and filter in between is covered by one limit. A nil *Budget
+// does no accounting.
+type Budget struct {
+	limit int64
+
+	mu      sync.Mutex
+	used    int64
+	waiters int
+	wake    chan struct{}
+}
+
+// NewBudget creates a Budget of limit bytes. A limit of zero or less only
+// counts usage and never blocks.
+func NewBudget(limit int64) *Budget {
+	return &Budget{limit: limit, wake: make(chan struct{})}
+}
+
+// Acquire reserves n bytes, waiting while that would exceed the limit. A
+// message larger than the whole budget is let through once nothing else
+// is held, so it cannot block forever.
+func (b *Budget) Acquire(ctx context.Context, n int64) error {
+	if b == nil || n <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	for b.limit > 0 && b.used > 0 && b.used+n > b.limit {
+		wake := b.wake
+		b.waiters++
+		b.mu.Unlock()
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			b.mu.Lock()
+			b.waiters--
+			b.mu.Unlock()
+			return ctx.Err()
+		}
+		b.mu.Lock()
+		b.waiters--
+	}
+	b.used += n
+	b.mu.Unlock()
+	return nil
+}
+
+// Release returns n bytes to the budget and wakes waiting producers.
+func (b *Budget) Release(n int64) {
+	if b == nil || n <= 0 {
+		return
+	}
+	b.mu.Lock()
+	b.used -= n
+	if b.used < 0 {
+		b.used = 0
+	}
+	if b.waiters > 0 {
+		close(b.wake)
+		b.wake = make(chan struct{})
+	}
+	b.mu.Unlock()
+}
+
+// Used returns the bytes currently held.
+func (b *Budget) Used() int64 {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// Limit returns the configured limit in bytes.
+func (b *Budget) Limit() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.limit
+}
+
+// Blocked reports whether a producer is waiting for space.
+func (b *Budget) Blocked() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.waiters > 0
+}
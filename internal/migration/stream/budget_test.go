@@ -0,0 +1,94 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+)
+
+func TestMessageSize(t *testing.T) {
+	small := &ChangeMessage{Namespace: "public", Table: "t", NewTuple: &TupleData{
+		Columns: []Column{{Name: "id", Value: []byte("1")}},
+	}}
+	wide := &ChangeMessage{Namespace: "public", Table: "t", NewTuple: &TupleData{
+		Columns: []Column{{Name: "id", Value: make([]byte, 1<<20)}},
+	}}
+	if s, w := MessageSize(small), MessageSize(wide); w-s != 1<<20-1 {
+		t.Errorf("size difference = %d, want %d", w-s, 1<<20-1)
+	}
+	if n := MessageSize(&CommitMessage{}); n == 0 {
+		t.Error("commit should have a non-zero size")
+	}
+}
+
+func TestBudget(t *testing.T) {
+	b := NewBudget(100)
+	ctx := context.Background()
+	if err := b.Acquire(ctx, 80); err != nil {
+		t.Fatal(err)
+	}
+
+	short, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := b.Acquire(short, 30); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Acquire over the limit = %v, want deadline exceeded", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- b.Acquire(ctx, 30) }()
+	time.Sleep(10 * time.Millisecond)
+	if !b.Blocked() {
+		t.Error("Blocked should report the waiting producer")
+	}
+	b.Release(80)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Acquire still blocked after Release")
+	}
+	if b.Used() != 30 {
+		t.Errorf("Used = %d, want 30", b.Used())
+	}
+
+	// A message larger than the budget passes once nothing else is held.
+	b.Release(30)
+	if err := b.Acquire(ctx, 500); err != nil {
+		t.Errorf("oversized Acquire on an empty budget = %v", err)
+	}
+}
+
+func TestMemorySourceBudget(t *testing.T) {
+	msgs := []Message{
+		&BeginMessage{TxnLSN: 1},
+		&CommitMessage{CommitLSN: 1},
+		&BeginMessage{TxnLSN: 2},
+		&CommitMessage{CommitLSN: 2},
+	}
+	b := NewBudget(messageOverhead * 2)
+	src := NewMemorySource(msgs, nil)
+	src.SetBudget(b)
+	ch, err := src.StartStreaming(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	time.Sleep(20 * time.Millisecond)
+	if len(ch) != 2 || !b.Blocked() {
+		t.Fatalf("buffered %d messages (blocked %v), want 2 held by the budget", len(ch), b.Blocked())
+	}
+	var last pglogrepl.LSN
+	for m := range ch {
+		b.Release(MessageSize(m))
+		last = m.LSN()
+	}
+	if last != 2 || b.Used() != 0 {
+		t.Errorf("last = %s, used = %d", last, b.Used())
+	}
+}
@@ -3,6 +3,7 @@ package stream
 import (
 	"context"
 	"fmt"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -14,20 +15,32 @@ import (
 )
 
 // Decoder consumes WAL data via pglogrepl and emits Messages on a channel.
+// It speaks pgoutput by default; NewSource selects another output plugin.
 type Decoder struct {
 	conn   *pgconn.PgConn
 	logger zerolog.Logger
 
+	plugin      string
 	slotName    string
 	publication string
 	startLSN    pglogrepl.LSN
+	failover    bool
 
 	relations map[uint32]*RelationMessage
 	origin    string // current origin from OriginMessage
 
+	// Text plugins carry no relation IDs; tables get synthetic ones.
+	tableIDs  map[string]uint32
+	nextRelID uint32
+	// tables holds the publication's tables for text plugins, which do
+	// not filter by publication themselves; nil streams every table.
+	tables map[tableName]bool
+
 	pendingBegin   *BeginMessage
 	emptyTxSkipped int64
 
+	budget *Budget
+
 	mu             sync.Mutex
 	confirmedLSN   pglogrepl.LSN
 	serverWALEnd   pglogrepl.LSN
@@ -43,13 +56,27 @@ func NewDecoder(conn *pgconn.PgConn, slotName, publication string, logger zerolo
 	return &Decoder{
 		conn:        conn,
 		logger:      logger.With().Str("component", "decoder").Logger(),
+		plugin:      PluginPgoutput,
 		slotName:    strings.ReplaceAll(slotName, "-", "_"),
 		publication: publication,
 		relations:   make(map[uint32]*RelationMessage),
+		tableIDs:    make(map[string]uint32),
 		done:        make(chan struct{}),
 	}
 }
 
+// SetFailover makes CreateSlot create the slot with FAILOVER (PG17+), so
+// it is synchronized to standbys and survives a promotion.
+func (d *Decoder) SetFailover(enabled bool) {
+	d.failover = enabled
+}
+
+// SetBudget makes the decoder acquire each message's size from b before
+// emitting it. The consumer releases it. Call before StartStreaming.
+func (d *Decoder) SetBudget(b *Budget) {
+	d.budget = b
+}
+
 // CreateSlot creates a replication slot and returns the exported snapshot name.
 // The snapshot remains valid until StartStreaming is called, so callers must
 // complete their COPY phase using the snapshot before calling StartStreaming.
@@ -61,7 +88,11 @@ func (d *Decoder) CreateSlot(ctx context.Context, startLSN pglogrepl.LSN) (strin
 		return "", nil
 	}
 
-	sql := fmt.Sprintf(`CREATE_REPLICATION_SLOT %s LOGICAL pgoutput (SNAPSHOT 'export')`, d.slotName)
+	options := "SNAPSHOT 'export'"
+	if d.failover {
+		options += ", FAILOVER"
+	}
+	sql := fmt.Sprintf(`CREATE_REPLICATION_SLOT %s LOGICAL %s (%s)`, d.slotName, d.plugin, options)
 	result, err := pglogrepl.ParseCreateReplicationSlot(d.conn.Exec(ctx, sql))
 	if err != nil {
 		return "", fmt.Errorf("create replication slot: %w", err)
@@ -73,8 +104,10 @@ func (d *Decoder) CreateSlot(ctx context.Context, startLSN pglogrepl.LSN) (strin
 	d.startLSN = parsedLSN
 	d.logger.Info().
 		Str("slot", d.slotName).
+		Str("plugin", d.plugin).
 		Str("snapshot", result.SnapshotName).
 		Stringer("lsn", d.startLSN).
+		Bool("failover", d.failover).
 		Msg("created replication slot")
 
 	return result.SnapshotName, nil
@@ -89,13 +122,15 @@ func (d *Decoder) StartLSN() pglogrepl.LSN {
 // invalidates the snapshot returned by CreateSlot, so it must only be
 // called after the COPY phase is complete.
 func (d *Decoder) StartStreaming(ctx context.Context) (<-chan Message, error) {
+	if d.plugin != PluginPgoutput {
+		tables, err := d.publicationTables(ctx)
+		if err != nil {
+			return nil, err
+		}
+		d.tables = tables
+	}
 	err := pglogrepl.StartReplication(ctx, d.conn, d.slotName, d.startLSN,
-		pglogrepl.StartReplicationOptions{
-			PluginArgs: []string{
-				"proto_version '1'",
-				fmt.Sprintf("publication_names '%s'", d.publication),
-			},
-		})
+		pglogrepl.StartReplicationOptions{PluginArgs: d.pluginArgs()})
 	if err != nil {
 		return nil, fmt.Errorf("start replication: %w", err)
 	}
@@ -237,12 +272,90 @@ func (d *Decoder) receiveLoop(ctx context.Context, ch chan<- Message) {
 					Msg("decoder throughput")
 				lastDiag = time.Now()
 			}
-			d.decodeWALData(ctx, ch, xld)
+			if err := d.decodeWALData(ctx, ch, xld); err != nil {
+				d.logger.Err(err).Msg("decode failed")
+				setErr(err)
+				return
+			}
+		}
+	}
+}
+
+// pluginArgs returns the START_REPLICATION options for the output plugin.
+// pgoutput filters by publication; wal2json is given the publication's
+// tables, and test_decoding output is filtered as it is decoded.
+func (d *Decoder) pluginArgs() []string {
+	switch d.plugin {
+	case PluginWal2JSON:
+		if d.tables == nil {
+			return wal2jsonArgs
+		}
+		tables := strings.ReplaceAll(wal2jsonTables(d.tables), "'", "''")
+		return append(slices.Clone(wal2jsonArgs), fmt.Sprintf(`"add-tables" '%s'`, tables))
+	case PluginTestDecoding:
+		return testDecodingArgs
+	}
+	return []string{
+		"proto_version '1'",
+		fmt.Sprintf("publication_names '%s'", d.publication),
+	}
+}
+
+// decodeWALData decodes one record. A text plugin record that cannot be
+// decoded is an error, since skipping it would lose the change.
+func (d *Decoder) decodeWALData(ctx context.Context, ch chan<- Message, xld pglogrepl.XLogData) error {
+	switch d.plugin {
+	case PluginWal2JSON:
+		return d.decodeWal2JSON(ctx, ch, xld)
+	case PluginTestDecoding:
+		return d.decodeTestDecoding(ctx, ch, xld)
+	}
+	d.decodePgoutput(ctx, ch, xld)
+	return nil
+}
+
+// tableName is a schema-qualified table.
+type tableName struct {
+	schema, name string
+}
+
+// publicationTables returns the tables of the publication, or nil when it
+// is FOR ALL TABLES. It runs on the replication connection, so tables
+// added to the publication are picked up when streaming restarts.
+func (d *Decoder) publicationTables(ctx context.Context) (map[tableName]bool, error) {
+	pub := strings.ReplaceAll(d.publication, "'", "''")
+	results, err := d.conn.Exec(ctx, fmt.Sprintf(`SELECT p.puballtables, t.schemaname, t.tablename
+		FROM pg_publication p LEFT JOIN pg_publication_tables t ON t.pubname = p.pubname
+		WHERE p.pubname = '%s'`, pub)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("list publication tables: %w", err)
+	}
+	var rows [][][]byte
+	for _, r := range results {
+		rows = append(rows, r.Rows...)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("publication %q does not exist", d.publication)
+	}
+	if string(rows[0][0]) == "t" {
+		return nil, nil
+	}
+	tables := make(map[tableName]bool, len(rows))
+	for _, r := range rows {
+		if r[1] != nil {
+			tables[tableName{string(r[1]), string(r[2])}] = true
 		}
 	}
+	d.logger.Info().Str("publication", d.publication).Int("tables", len(tables)).Msg("streaming the publication's tables")
+	return tables, nil
+}
+
+// published reports whether changes to schema.table are streamed.
+func (d *Decoder) published(schema, table string) bool {
+	return d.tables == nil || d.tables[tableName{schema, table}]
 }
 
-func (d *Decoder) decodeWALData(ctx context.Context, ch chan<- Message, xld pglogrepl.XLogData) {
+func (d *Decoder) decodePgoutput(ctx context.Context, ch chan<- Message, xld pglogrepl.XLogData) {
 	logicalMsg, err := pglogrepl.Parse(xld.WALData)
 	if err != nil {
 		d.logger.Err(err).Msg("parse WAL data")
@@ -254,27 +367,17 @@ func (d *Decoder) decodeWALData(ctx context.Context, ch chan<- Message, xld pglo
 
 	switch msg := logicalMsg.(type) {
 	case *pglogrepl.BeginMessage:
-		d.pendingBegin = &BeginMessage{
-			TxnLSN:  pglogrepl.LSN(msg.FinalLSN),
-			TxnTime: msg.CommitTime,
-			XID:     msg.Xid,
-		}
+		// The origin is per transaction; pgoutput sends an OriginMessage
+		// right after BEGIN only when the transaction has one.
+		d.begin(pglogrepl.LSN(msg.FinalLSN), msg.CommitTime, msg.Xid)
 
 	case *pglogrepl.CommitMessage:
-		if d.pendingBegin != nil {
-			d.emptyTxSkipped++
-			d.pendingBegin = nil
-		} else {
-			d.emit(ctx, ch, &CommitMessage{
-				CommitLSN: pglogrepl.LSN(msg.CommitLSN),
-				TxnTime:   msg.CommitTime,
-			})
-		}
+		d.commit(ctx, ch, pglogrepl.LSN(msg.CommitLSN), msg.CommitTime)
 
 	case *pglogrepl.RelationMessage:
 		cols := make([]Column, len(msg.Columns))
 		for i, c := range msg.Columns {
-			cols[i] = Column{Name: c.Name, DataType: c.DataType}
+			cols[i] = Column{Name: c.Name, DataType: c.DataType, Key: c.Flags&1 != 0}
 		}
 		rel := &RelationMessage{
 			RelationID: msg.RelationID,
@@ -351,8 +454,77 @@ func (d *Decoder) decodeWALData(ctx context.Context, ch chan<- Message, xld pglo
 	}
 }
 
+// begin holds a BeginMessage back until the transaction's first change, so
+// empty transactions can be dropped at commit.
+func (d *Decoder) begin(lsn pglogrepl.LSN, t time.Time, xid uint32) {
+	d.origin = ""
+	d.pendingBegin = &BeginMessage{TxnLSN: lsn, TxnTime: t, XID: xid}
+}
+
+func (d *Decoder) commit(ctx context.Context, ch chan<- Message, lsn pglogrepl.LSN, t time.Time) {
+	if d.pendingBegin != nil {
+		d.emptyTxSkipped++
+		d.pendingBegin = nil
+		return
+	}
+	d.emit(ctx, ch, &CommitMessage{CommitLSN: lsn, TxnTime: t, Origin: d.origin})
+}
+
+// emitRow emits a change decoded by a text plugin. The first change of a
+// table, and any change whose columns differ from the last ones seen, is
+// preceded by a synthetic RelationMessage.
+func (d *Decoder) emitRow(ctx context.Context, ch chan<- Message, cm *ChangeMessage) {
+	key := cm.Namespace + "." + cm.Table
+	id, known := d.tableIDs[key]
+	if !known {
+		d.nextRelID++
+		id = d.nextRelID
+		d.tableIDs[key] = id
+	}
+	cm.RelationID = id
+
+	tuple := cm.NewTuple
+	if tuple == nil {
+		tuple = cm.OldTuple
+	}
+	if rel := d.relations[id]; rel == nil || (cm.NewTuple != nil && !sameColumns(rel.Columns, tuple.Columns)) {
+		cols := make([]Column, len(tuple.Columns))
+		for i, c := range tuple.Columns {
+			cols[i] = Column{Name: c.Name, DataType: c.DataType, Key: c.Key}
+		}
+		rel := &RelationMessage{
+			RelationID: id,
+			Namespace:  cm.Namespace,
+			Name:       cm.Table,
+			Columns:    cols,
+			MsgLSN:     cm.MsgLSN,
+			MsgTime:    cm.MsgTime,
+		}
+		d.relations[id] = rel
+		d.flushPendingBegin(ctx, ch)
+		d.emit(ctx, ch, rel)
+	}
+
+	d.flushPendingBegin(ctx, ch)
+	cm.Origin = d.origin
+	d.emit(ctx, ch, cm)
+}
+
+func sameColumns(a, b []Column) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name {
+			return false
+		}
+	}
+	return true
+}
+
 func (d *Decoder) flushPendingBegin(ctx context.Context, ch chan<- Message) {
 	if d.pendingBegin != nil {
+		d.pendingBegin.Origin = d.origin
 		d.emit(ctx, ch, d.pendingBegin)
 		d.pendingBegin = nil
 	}
@@ -368,6 +540,7 @@ func decodeTuple(tuple *pglogrepl.TupleData, cols []Column) *TupleData {
 		if i < len(cols) {
 			col.Name = cols[i].Name
 			col.DataType = cols[i].DataType
+			col.Key = cols[i].Key
 		}
 		td.Columns[i] = col
 	}
@@ -375,11 +548,16 @@ func decodeTuple(tuple *pglogrepl.TupleData, cols []Column) *TupleData {
 }
 
 func (d *Decoder) emit(ctx context.Context, ch chan<- Message, msg Message) {
+	size := MessageSize(msg)
+	if !d.acquire(ctx, size) {
+		return
+	}
 	for {
 		select {
 		case ch <- msg:
 			return
 		case <-ctx.Done():
+			d.budget.Release(size)
 			return
 		default:
 		}
@@ -404,11 +582,35 @@ func (d *Decoder) emit(ctx context.Context, ch chan<- Message, msg Message) {
 		case <-t.C:
 		case <-ctx.Done():
 			t.Stop()
+			d.budget.Release(size)
 			return
 		}
 	}
 }
 
+// acquire waits for size bytes of the memory budget, sending standby
+// heartbeats while blocked like emit does on a full channel. It reports
+// false if ctx ends first.
+func (d *Decoder) acquire(ctx context.Context, size int64) bool {
+	for {
+		waitCtx, cancel := context.WithTimeout(ctx, time.Second)
+		err := d.budget.Acquire(waitCtx, size)
+		cancel()
+		if err == nil {
+			return true
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+		d.mu.Lock()
+		lsn := d.confirmedLSN
+		d.mu.Unlock()
+		if err := d.sendStandbyStatus(ctx, lsn); err != nil {
+			d.logger.Err(err).Msg("memory budget wait: standby status failed")
+		}
+	}
+}
+
 func (d *Decoder) sendStandbyStatus(ctx context.Context, lsn pglogrepl.LSN) error {
 	d.lastStatusTime = time.Now()
 	return pglogrepl.SendStandbyStatusUpdate(ctx, d.conn,
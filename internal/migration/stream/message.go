@@ -72,6 +72,7 @@ type Column struct {
 	Name     string
 	DataType uint32
 	Value    []byte
+	Key      bool // part of the replica identity
 }
 
 // TupleData holds the column values for a row.
@@ -84,22 +85,24 @@ type BeginMessage struct {
 	TxnLSN  pglogrepl.LSN
 	TxnTime time.Time
 	XID     uint32
+	Origin  string
 }
 
 func (m *BeginMessage) Kind() MessageKind     { return KindBegin }
 func (m *BeginMessage) LSN() pglogrepl.LSN    { return m.TxnLSN }
-func (m *BeginMessage) OriginID() string       { return "" }
+func (m *BeginMessage) OriginID() string       { return m.Origin }
 func (m *BeginMessage) Timestamp() time.Time   { return m.TxnTime }
 
 // CommitMessage marks the end of a transaction.
 type CommitMessage struct {
 	CommitLSN pglogrepl.LSN
 	TxnTime   time.Time
+	Origin    string
 }
 
 func (m *CommitMessage) Kind() MessageKind     { return KindCommit }
 func (m *CommitMessage) LSN() pglogrepl.LSN    { return m.CommitLSN }
-func (m *CommitMessage) OriginID() string       { return "" }
+func (m *CommitMessage) OriginID() string       { return m.Origin }
 func (m *CommitMessage) Timestamp() time.Time   { return m.TxnTime }
 
 // RelationMessage carries schema metadata for a relation (table).
@@ -0,0 +1,152 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/rs/zerolog"
+)
+
+// Supported logical decoding output plugins.
+const (
+	PluginPgoutput     = "pgoutput"
+	PluginWal2JSON     = "wal2json"
+	PluginTestDecoding = "test_decoding"
+)
+
+// Source produces the change stream of a replication slot. The pipeline
+// depends on this interface rather than on a specific decoder so that
+// output plugins, and in tests an in-memory stream, can be swapped in.
+type Source interface {
+	// SetFailover makes CreateSlot create a failover slot (PG17+).
+	SetFailover(enabled bool)
+	// CreateSlot creates the slot and returns the exported snapshot name.
+	// A non-zero startLSN resumes an existing slot instead.
+	CreateSlot(ctx context.Context, startLSN pglogrepl.LSN) (string, error)
+	// StartLSN returns the LSN streaming begins at.
+	StartLSN() pglogrepl.LSN
+	// StartStreaming begins delivering messages. The channel is closed
+	// when the stream ends; Err reports why.
+	StartStreaming(ctx context.Context) (<-chan Message, error)
+	// Start calls CreateSlot followed by StartStreaming.
+	Start(ctx context.Context, startLSN pglogrepl.LSN) (<-chan Message, string, error)
+	// SetBudget makes the source acquire each message's size from the
+	// memory budget before sending it; the consumer releases it.
+	SetBudget(b *Budget)
+	// ConfirmLSN advances the position reported back to the server.
+	ConfirmLSN(lsn pglogrepl.LSN)
+	// Err returns the error that ended the stream, if any.
+	Err() error
+	// Close stops the stream.
+	Close()
+}
+
+var _ Source = (*Decoder)(nil)
+
+// NewSource creates a Source that decodes the given output plugin over a
+// replication connection. An empty plugin means pgoutput.
+func NewSource(plugin string, conn *pgconn.PgConn, slotName, publication string, logger zerolog.Logger) (Source, error) {
+	switch plugin {
+	case "", PluginPgoutput:
+		return NewDecoder(conn, slotName, publication, logger), nil
+	case PluginWal2JSON, PluginTestDecoding:
+		d := NewDecoder(conn, slotName, publication, logger)
+		d.plugin = plugin
+		d.logger = logger.With().Str("component", "decoder").Str("plugin", plugin).Logger()
+		return d, nil
+	}
+	return nil, fmt.Errorf("unsupported output plugin %q (supported: %s)", plugin,
+		strings.Join([]string{PluginPgoutput, PluginWal2JSON, PluginTestDecoding}, ", "))
+}
+
+// MemorySource is a Source backed by a fixed list of messages. It records
+// the LSNs confirmed by the consumer. It is meant for tests.
+type MemorySource struct {
+	messages []Message
+	startLSN pglogrepl.LSN
+	err      error
+	budget   *Budget
+
+	mu        sync.Mutex
+	confirmed pglogrepl.LSN
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+// NewMemorySource creates a MemorySource that streams messages and then
+// closes its channel. A non-nil err is reported by Err afterwards, as if
+// the stream had failed.
+func NewMemorySource(messages []Message, err error) *MemorySource {
+	return &MemorySource{messages: messages, err: err}
+}
+
+func (s *MemorySource) SetFailover(bool) {}
+
+func (s *MemorySource) SetBudget(b *Budget) { s.budget = b }
+
+func (s *MemorySource) CreateSlot(_ context.Context, startLSN pglogrepl.LSN) (string, error) {
+	s.startLSN = startLSN
+	return "", nil
+}
+
+func (s *MemorySource) StartLSN() pglogrepl.LSN { return s.startLSN }
+
+func (s *MemorySource) StartStreaming(ctx context.Context) (<-chan Message, error) {
+	ch := make(chan Message, len(s.messages))
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		defer close(ch)
+		for _, m := range s.messages {
+			if m.LSN() != 0 && m.LSN() < s.startLSN {
+				continue
+			}
+			size := MessageSize(m)
+			if s.budget.Acquire(ctx, size) != nil {
+				return
+			}
+			select {
+			case ch <- m:
+			case <-ctx.Done():
+				s.budget.Release(size)
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func (s *MemorySource) Start(ctx context.Context, startLSN pglogrepl.LSN) (<-chan Message, string, error) {
+	snapshot, _ := s.CreateSlot(ctx, startLSN)
+	ch, err := s.StartStreaming(ctx)
+	return ch, snapshot, err
+}
+
+func (s *MemorySource) ConfirmLSN(lsn pglogrepl.LSN) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if lsn > s.confirmed {
+		s.confirmed = lsn
+	}
+}
+
+// ConfirmedLSN returns the highest LSN passed to ConfirmLSN.
+func (s *MemorySource) ConfirmedLSN() pglogrepl.LSN {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.confirmed
+}
+
+func (s *MemorySource) Err() error { return s.err }
+
+func (s *MemorySource) Close() {
+	if s.cancel != nil {
+		s.cancel()
+		<-s.done
+	}
+}
@@ -0,0 +1,77 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/rs/zerolog"
+)
+
+func TestNewSource(t *testing.T) {
+	for _, plugin := range []string{"", PluginPgoutput, PluginWal2JSON, PluginTestDecoding} {
+		src, err := NewSource(plugin, nil, "my-slot", "pub", zerolog.Nop())
+		if err != nil {
+			t.Fatalf("NewSource(%q): %v", plugin, err)
+		}
+		d := src.(*Decoder)
+		want := plugin
+		if want == "" {
+			want = PluginPgoutput
+		}
+		if d.plugin != want {
+			t.Errorf("NewSource(%q).plugin = %q", plugin, d.plugin)
+		}
+		if d.slotName != "my_slot" {
+			t.Errorf("slot name = %q, want my_slot", d.slotName)
+		}
+	}
+
+	if _, err := NewSource("decoderbufs", nil, "s", "p", zerolog.Nop()); err == nil {
+		t.Error("expected error for unsupported plugin")
+	}
+}
+
+func TestPluginArgs(t *testing.T) {
+	src, _ := NewSource(PluginPgoutput, nil, "s", "mypub", zerolog.Nop())
+	args := src.(*Decoder).pluginArgs()
+	if len(args) != 2 || args[1] != "publication_names 'mypub'" {
+		t.Errorf("pgoutput args = %v", args)
+	}
+	src, _ = NewSource(PluginWal2JSON, nil, "s", "mypub", zerolog.Nop())
+	if args := src.(*Decoder).pluginArgs(); args[0] != `"format-version" '2'` {
+		t.Errorf("wal2json args = %v", args)
+	}
+}
+
+func TestMemorySource(t *testing.T) {
+	streamErr := errors.New("boom")
+	msgs := []Message{
+		&BeginMessage{TxnLSN: 10},
+		&CommitMessage{CommitLSN: 11},
+		&BeginMessage{TxnLSN: 20},
+		&CommitMessage{CommitLSN: 21},
+	}
+	var src Source = NewMemorySource(msgs, streamErr)
+
+	ch, _, err := src.Start(context.Background(), 20)
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	var got []pglogrepl.LSN
+	for m := range ch {
+		got = append(got, m.LSN())
+		src.ConfirmLSN(m.LSN())
+	}
+	if len(got) != 2 || got[0] != 20 || got[1] != 21 {
+		t.Errorf("streamed LSNs = %v, want [20 21]", got)
+	}
+	if c := src.(*MemorySource).ConfirmedLSN(); c != 21 {
+		t.Errorf("confirmed = %s, want 21", c)
+	}
+	if !errors.Is(src.Err(), streamErr) {
+		t.Errorf("Err() = %v", src.Err())
+	}
+	src.Close()
+}
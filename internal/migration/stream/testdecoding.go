@@ -0,0 +1,271 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// testDecodingArgs asks test_decoding for transaction IDs and commit
+// timestamps and drops empty transactions at the source.
+var testDecodingArgs = []string{
+	`"include-xids" '1'`,
+	`"include-timestamp" '1'`,
+	`"skip-empty-xacts" '1'`,
+}
+
+// errNoTuple marks a change test_decoding printed without row data, such
+// as a delete on a table without replica identity.
+var errNoTuple = errors.New("change has no tuple data")
+
+func (d *Decoder) decodeTestDecoding(ctx context.Context, ch chan<- Message, xld pglogrepl.XLogData) error {
+	line := string(xld.WALData)
+	lsn := pglogrepl.LSN(xld.WALStart)
+
+	switch {
+	case strings.HasPrefix(line, "BEGIN"):
+		xid, _ := parseTestDecodingTxn(strings.TrimPrefix(line, "BEGIN"))
+		d.begin(lsn, time.Now(), xid)
+	case strings.HasPrefix(line, "COMMIT"):
+		_, t := parseTestDecodingTxn(strings.TrimPrefix(line, "COMMIT"))
+		d.commit(ctx, ch, lsn, t)
+	case strings.HasPrefix(line, "table "):
+		cm, err := parseTestDecodingChange(line)
+		if errors.Is(err, errUnsupportedOp) {
+			return nil
+		}
+		if cm != nil && !d.published(cm.Namespace, cm.Table) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("decode test_decoding change at %s: %w (%s)", lsn, err, line)
+		}
+		cm.MsgLSN = lsn
+		cm.MsgTime = time.Now()
+		d.emitRow(ctx, ch, cm)
+	}
+	return nil
+}
+
+// parseTestDecodingTxn parses the tail of a BEGIN or COMMIT line:
+// " 529" or " 529 (at 2024-01-02 03:04:05.123456+00)".
+func parseTestDecodingTxn(rest string) (uint32, time.Time) {
+	rest = strings.TrimSpace(rest)
+	t := time.Now()
+	if i := strings.Index(rest, "(at "); i >= 0 {
+		t = parseCommitTime(strings.TrimSuffix(rest[i+len("(at "):], ")"))
+		rest = strings.TrimSpace(rest[:i])
+	}
+	xid, _ := strconv.ParseUint(rest, 10, 32)
+	return uint32(xid), t
+}
+
+var errUnsupportedOp = errors.New("unsupported operation")
+
+// parseTestDecodingChange parses a line such as
+//
+//	table public.users: UPDATE: old-key: id[integer]:1 new-tuple: id[integer]:2 name[text]:'x'
+//
+// Old-key and delete columns are the replica identity and are flagged as
+// key columns. Unchanged TOAST values are left out of the tuple. With
+// errNoTuple the change still names its table.
+func parseTestDecodingChange(line string) (*ChangeMessage, error) {
+	rest := strings.TrimPrefix(line, "table ")
+	schema, rest, err := parseIdent(rest)
+	if err != nil {
+		return nil, fmt.Errorf("schema: %w", err)
+	}
+	if !strings.HasPrefix(rest, ".") {
+		return nil, fmt.Errorf("expected '.' after schema")
+	}
+	table, rest, err := parseIdent(rest[1:])
+	if err != nil {
+		return nil, fmt.Errorf("table: %w", err)
+	}
+	if !strings.HasPrefix(rest, ": ") {
+		return nil, fmt.Errorf("expected ': ' after table")
+	}
+	rest = rest[2:]
+
+	opEnd := strings.Index(rest, ":")
+	if opEnd < 0 {
+		return nil, fmt.Errorf("missing operation")
+	}
+	cm := &ChangeMessage{Namespace: schema, Table: table}
+	switch rest[:opEnd] {
+	case "INSERT":
+		cm.Op = OpInsert
+	case "UPDATE":
+		cm.Op = OpUpdate
+	case "DELETE":
+		cm.Op = OpDelete
+	default:
+		return nil, errUnsupportedOp
+	}
+	rest = strings.TrimPrefix(rest[opEnd+1:], " ")
+
+	if strings.HasPrefix(rest, "(no-tuple data)") {
+		return cm, errNoTuple
+	}
+
+	switch cm.Op {
+	case OpInsert:
+		cm.NewTuple, _, err = parseTuple(rest, false, "")
+	case OpDelete:
+		cm.OldTuple, _, err = parseTuple(rest, true, "")
+	case OpUpdate:
+		switch {
+		case strings.HasPrefix(rest, "old-key: "):
+			cm.OldTuple, rest, err = parseTuple(rest[len("old-key: "):], true, "new-tuple: ")
+		case strings.HasPrefix(rest, "old-tuple: "):
+			cm.OldTuple, rest, err = parseTuple(rest[len("old-tuple: "):], false, "new-tuple: ")
+		}
+		if err == nil {
+			rest = strings.TrimPrefix(rest, "new-tuple: ")
+			cm.NewTuple, _, err = parseTuple(rest, false, "")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cm, nil
+}
+
+// parseTuple reads "name[type]:value" pairs until the input ends or stop
+// is reached, and returns the remaining input.
+func parseTuple(s string, key bool, stop string) (*TupleData, string, error) {
+	td := &TupleData{}
+	for s != "" {
+		if stop != "" && strings.HasPrefix(s, stop) {
+			break
+		}
+		name, rest, err := parseIdent(s)
+		if err != nil {
+			return nil, s, fmt.Errorf("column name: %w", err)
+		}
+		if !strings.HasPrefix(rest, "[") {
+			return nil, s, fmt.Errorf("column %s: expected type", name)
+		}
+		end := strings.Index(rest, "]:")
+		if end < 0 {
+			return nil, s, fmt.Errorf("column %s: unterminated type", name)
+		}
+		oid := typeNameOID(rest[1:end])
+		rest = rest[end+2:]
+
+		var value []byte
+		unchanged := false
+		if strings.HasPrefix(rest, "'") {
+			v, r, err := parseQuoted(rest, '\'')
+			if err != nil {
+				return nil, s, fmt.Errorf("column %s: %w", name, err)
+			}
+			value, rest = []byte(v), r
+		} else {
+			tok := rest
+			if i := strings.IndexByte(rest, ' '); i >= 0 {
+				tok, rest = rest[:i], rest[i:]
+			} else {
+				rest = ""
+			}
+			switch tok {
+			case "null":
+			case "unchanged-toast-datum":
+				unchanged = true
+			default:
+				value = []byte(tok)
+			}
+		}
+		if !unchanged {
+			td.Columns = append(td.Columns, Column{Name: name, DataType: oid, Value: value, Key: key})
+		}
+		s = strings.TrimPrefix(rest, " ")
+	}
+	return td, s, nil
+}
+
+// typeNameOIDs maps the type names test_decoding prints, as format_type
+// writes them without modifiers, to their OIDs. Other types, including
+// arrays and user-defined types, are left as 0 and read as text.
+var typeNameOIDs = map[string]uint32{
+	"boolean":                     pgtype.BoolOID,
+	"smallint":                    pgtype.Int2OID,
+	"integer":                     pgtype.Int4OID,
+	"bigint":                      pgtype.Int8OID,
+	"oid":                         pgtype.OIDOID,
+	"real":                        pgtype.Float4OID,
+	"double precision":            pgtype.Float8OID,
+	"numeric":                     pgtype.NumericOID,
+	"text":                        pgtype.TextOID,
+	"character varying":           pgtype.VarcharOID,
+	"character":                   pgtype.BPCharOID,
+	"name":                        pgtype.NameOID,
+	"bytea":                       pgtype.ByteaOID,
+	"uuid":                        pgtype.UUIDOID,
+	"json":                        pgtype.JSONOID,
+	"jsonb":                       pgtype.JSONBOID,
+	"xml":                         pgtype.XMLOID,
+	"date":                        pgtype.DateOID,
+	"time without time zone":      pgtype.TimeOID,
+	"time with time zone":         pgtype.TimetzOID,
+	"timestamp without time zone": pgtype.TimestampOID,
+	"timestamp with time zone":    pgtype.TimestamptzOID,
+	"interval":                    pgtype.IntervalOID,
+}
+
+// typeNameOID returns the OID of a printed type name such as
+// "character varying(20)" or "timestamp(3) with time zone".
+func typeNameOID(typ string) uint32 {
+	for {
+		open := strings.IndexByte(typ, '(')
+		if open < 0 {
+			break
+		}
+		end := strings.IndexByte(typ[open:], ')')
+		if end < 0 {
+			break
+		}
+		typ = typ[:open] + typ[open+end+1:]
+	}
+	return typeNameOIDs[typ]
+}
+
+// parseIdent reads an identifier as printed by quote_identifier: bare, or
+// double-quoted with "" escapes.
+func parseIdent(s string) (string, string, error) {
+	if strings.HasPrefix(s, `"`) {
+		return parseQuoted(s, '"')
+	}
+	end := strings.IndexAny(s, ".:[ ")
+	if end == 0 {
+		return "", s, fmt.Errorf("empty identifier")
+	}
+	if end < 0 {
+		return s, "", nil
+	}
+	return s[:end], s[end:], nil
+}
+
+// parseQuoted reads a string quoted with q, where a doubled q is a literal.
+func parseQuoted(s string, q byte) (string, string, error) {
+	var sb strings.Builder
+	for i := 1; i < len(s); i++ {
+		if s[i] != q {
+			sb.WriteByte(s[i])
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == q {
+			sb.WriteByte(q)
+			i++
+			continue
+		}
+		return sb.String(), s[i+1:], nil
+	}
+	return "", s, fmt.Errorf("unterminated quoted string")
+}
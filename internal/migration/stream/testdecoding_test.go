@@ -0,0 +1,147 @@
+package stream
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func TestTestDecoding_Transaction(t *testing.T) {
+	msgs := decodeAll(t, PluginTestDecoding,
+		"BEGIN 529",
+		"table public.data: INSERT: id[integer]:1 data[text]:'it''s' note[character varying]:null",
+		"table public.data: UPDATE: old-key: id[integer]:1 new-tuple: id[integer]:2 data[text]:'x y' note[character varying]:null",
+		"table public.data: DELETE: id[integer]:2",
+		"COMMIT 529 (at 2024-05-06 07:08:09.123456+00)",
+	)
+
+	kinds := []MessageKind{KindBegin, KindRelation, KindChange, KindChange, KindChange, KindCommit}
+	if len(msgs) != len(kinds) {
+		t.Fatalf("got %d messages, want %d", len(msgs), len(kinds))
+	}
+	for i, k := range kinds {
+		if msgs[i].Kind() != k {
+			t.Errorf("message %d kind = %s, want %s", i, msgs[i].Kind(), k)
+		}
+	}
+	if xid := msgs[0].(*BeginMessage).XID; xid != 529 {
+		t.Errorf("xid = %d, want 529", xid)
+	}
+
+	ins := msgs[2].(*ChangeMessage)
+	if ins.Namespace != "public" || ins.Table != "data" || len(ins.NewTuple.Columns) != 3 {
+		t.Fatalf("insert = %+v", ins)
+	}
+	if got := string(ins.NewTuple.Columns[1].Value); got != "it's" {
+		t.Errorf("quoted value = %q, want it's", got)
+	}
+	if ins.NewTuple.Columns[2].Value != nil {
+		t.Errorf("null value = %q, want nil", ins.NewTuple.Columns[2].Value)
+	}
+
+	upd := msgs[3].(*ChangeMessage)
+	if upd.OldTuple == nil || !upd.OldTuple.Columns[0].Key || string(upd.OldTuple.Columns[0].Value) != "1" {
+		t.Errorf("update old key = %+v", upd.OldTuple)
+	}
+	if string(upd.NewTuple.Columns[1].Value) != "x y" {
+		t.Errorf("update new value = %q", upd.NewTuple.Columns[1].Value)
+	}
+
+	del := msgs[4].(*ChangeMessage)
+	if del.Op != OpDelete || !del.OldTuple.Columns[0].Key {
+		t.Errorf("delete = %+v", del.OldTuple)
+	}
+
+	if ts := msgs[5].(*CommitMessage).TxnTime; ts.Year() != 2024 || ts.Second() != 9 {
+		t.Errorf("commit time = %v", ts)
+	}
+}
+
+func TestTestDecoding_PublicationTables(t *testing.T) {
+	tables := map[tableName]bool{{"public", "users"}: true}
+	msgs, err := decodeTables(PluginTestDecoding, tables,
+		"BEGIN 529",
+		"table public.audit: DELETE: (no-tuple data)",
+		"table public.users: INSERT: id[integer]:1",
+		"COMMIT 529",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 4 || msgs[2].(*ChangeMessage).Table != "users" {
+		t.Errorf("got %#v, want only the users change", msgs)
+	}
+
+	_, err = decodeTables(PluginTestDecoding, tables, "BEGIN 529", "table public.users: DELETE: (no-tuple data)")
+	if !errors.Is(err, errNoTuple) {
+		t.Errorf("no-tuple delete on a published table: err = %v, want errNoTuple", err)
+	}
+}
+
+func TestParseTestDecodingChange_QuotedIdentifiers(t *testing.T) {
+	cm, err := parseTestDecodingChange(`table "My Schema"."odd""table": INSERT: "Col: A"[integer[]]:'{1,2}' b[boolean]:true`)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if cm.Namespace != "My Schema" || cm.Table != `odd"table` {
+		t.Errorf("name = %s.%s", cm.Namespace, cm.Table)
+	}
+	cols := cm.NewTuple.Columns
+	if len(cols) != 2 || cols[0].Name != "Col: A" || string(cols[0].Value) != "{1,2}" || string(cols[1].Value) != "true" {
+		t.Errorf("columns = %+v", cols)
+	}
+}
+
+func TestParseTestDecodingChange_UnchangedToast(t *testing.T) {
+	cm, err := parseTestDecodingChange("table public.docs: UPDATE: id[integer]:1 body[text]:unchanged-toast-datum title[text]:'t'")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(cm.NewTuple.Columns) != 2 || cm.NewTuple.Columns[1].Name != "title" {
+		t.Errorf("unchanged TOAST column should be left out: %+v", cm.NewTuple.Columns)
+	}
+	if cm.OldTuple != nil {
+		t.Errorf("old tuple = %+v, want nil", cm.OldTuple)
+	}
+}
+
+func TestParseTestDecodingChange_Errors(t *testing.T) {
+	if _, err := parseTestDecodingChange("table public.t: DELETE: (no-tuple data)"); !errors.Is(err, errNoTuple) {
+		t.Errorf("no-tuple delete err = %v, want errNoTuple", err)
+	}
+	if _, err := parseTestDecodingChange("table public.t: TRUNCATE: (no-flags)"); !errors.Is(err, errUnsupportedOp) {
+		t.Errorf("truncate err = %v, want errUnsupportedOp", err)
+	}
+	if _, err := parseTestDecodingChange("table public.t: INSERT: a[text]:'open"); err == nil {
+		t.Error("expected error for unterminated value")
+	}
+}
+
+func TestTypeNameOID(t *testing.T) {
+	tests := []struct {
+		name string
+		want uint32
+	}{
+		{"boolean", pgtype.BoolOID},
+		{"integer", pgtype.Int4OID},
+		{"character varying(20)", pgtype.VarcharOID},
+		{"numeric(10,2)", pgtype.NumericOID},
+		{"timestamp(3) with time zone", pgtype.TimestamptzOID},
+		{"integer[]", 0},
+		{"mood", 0},
+	}
+	for _, tt := range tests {
+		if got := typeNameOID(tt.name); got != tt.want {
+			t.Errorf("%q = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	cm, err := parseTestDecodingChange("table public.t: INSERT: id[bigint]:1 ok[boolean]:true")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if cols := cm.NewTuple.Columns; cols[0].DataType != pgtype.Int8OID || cols[1].DataType != pgtype.BoolOID {
+		t.Errorf("columns = %+v", cols)
+	}
+}
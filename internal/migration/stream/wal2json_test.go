@@ -0,0 +1,209 @@
+package stream
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/rs/zerolog"
+)
+
+func decodeAll(t *testing.T, plugin string, records ...string) []Message {
+	t.Helper()
+	msgs, err := decodeTables(plugin, nil, records...)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	return msgs
+}
+
+// decodeTables decodes records streaming only tables, or every table if
+// nil, and stops at the first error.
+func decodeTables(plugin string, tables map[tableName]bool, records ...string) ([]Message, error) {
+	src, err := NewSource(plugin, nil, "slot", "pub", zerolog.Nop())
+	if err != nil {
+		return nil, err
+	}
+	d := src.(*Decoder)
+	d.tables = tables
+	ch := make(chan Message, 64)
+	for i, r := range records {
+		err = d.decodeWALData(context.Background(), ch, pglogrepl.XLogData{
+			WALStart: pglogrepl.LSN(100 + i),
+			WALData:  []byte(r),
+		})
+		if err != nil {
+			break
+		}
+	}
+	close(ch)
+	var out []Message
+	for m := range ch {
+		out = append(out, m)
+	}
+	return out, err
+}
+
+func TestWal2JSON_Transaction(t *testing.T) {
+	msgs := decodeAll(t, PluginWal2JSON,
+		`{"action":"B","xid":742,"timestamp":"2024-05-06 07:08:09.123456+00"}`,
+		`{"action":"I","xid":742,"schema":"public","table":"users","columns":[{"name":"id","type":"integer","typeoid":23,"value":1},{"name":"name","type":"text","typeoid":25,"value":"ann"},{"name":"bio","type":"text","typeoid":25,"value":null}],"pk":[{"name":"id","type":"integer","typeoid":23}]}`,
+		`{"action":"U","xid":742,"schema":"public","table":"users","columns":[{"name":"id","type":"integer","typeoid":23,"value":2},{"name":"name","type":"text","typeoid":25,"value":"ann"},{"name":"bio","type":"text","typeoid":25,"value":"x"}],"identity":[{"name":"id","type":"integer","typeoid":23,"value":1}],"pk":[{"name":"id","type":"integer","typeoid":23}]}`,
+		`{"action":"D","xid":742,"schema":"public","table":"users","identity":[{"name":"id","type":"integer","typeoid":23,"value":2}],"pk":[{"name":"id","type":"integer","typeoid":23}]}`,
+		`{"action":"C","xid":742,"timestamp":"2024-05-06 07:08:09.123456+00"}`,
+	)
+
+	kinds := []MessageKind{KindBegin, KindRelation, KindChange, KindChange, KindChange, KindCommit}
+	if len(msgs) != len(kinds) {
+		t.Fatalf("got %d messages, want %d: %#v", len(msgs), len(kinds), msgs)
+	}
+	for i, k := range kinds {
+		if msgs[i].Kind() != k {
+			t.Errorf("message %d kind = %s, want %s", i, msgs[i].Kind(), k)
+		}
+	}
+
+	begin := msgs[0].(*BeginMessage)
+	if begin.XID != 742 || begin.TxnTime.Year() != 2024 {
+		t.Errorf("begin = %+v", begin)
+	}
+
+	rel := msgs[1].(*RelationMessage)
+	if rel.Namespace != "public" || rel.Name != "users" || len(rel.Columns) != 3 || !rel.Columns[0].Key || rel.Columns[1].Key {
+		t.Errorf("relation = %+v", rel)
+	}
+
+	ins := msgs[2].(*ChangeMessage)
+	if ins.Op != OpInsert || ins.RelationID != rel.RelationID {
+		t.Errorf("insert = %+v", ins)
+	}
+	if got := string(ins.NewTuple.Columns[1].Value); got != "ann" {
+		t.Errorf("name = %q, want ann", got)
+	}
+	if ins.NewTuple.Columns[2].Value != nil {
+		t.Errorf("null column value = %q, want nil", ins.NewTuple.Columns[2].Value)
+	}
+	if ins.NewTuple.Columns[0].DataType != 23 {
+		t.Errorf("id type = %d, want 23", ins.NewTuple.Columns[0].DataType)
+	}
+
+	upd := msgs[3].(*ChangeMessage)
+	if upd.Op != OpUpdate || upd.OldTuple == nil || string(upd.OldTuple.Columns[0].Value) != "1" || !upd.OldTuple.Columns[0].Key {
+		t.Errorf("update old tuple = %+v", upd.OldTuple)
+	}
+
+	del := msgs[4].(*ChangeMessage)
+	if del.Op != OpDelete || del.NewTuple != nil || string(del.OldTuple.Columns[0].Value) != "2" {
+		t.Errorf("delete = %+v", del)
+	}
+
+	commit := msgs[5].(*CommitMessage)
+	if commit.CommitLSN != 104 {
+		t.Errorf("commit LSN = %s, want 0/68", commit.CommitLSN)
+	}
+}
+
+func TestWal2JSON_EmptyTransactionSkipped(t *testing.T) {
+	msgs := decodeAll(t, PluginWal2JSON,
+		`{"action":"B","xid":1}`,
+		`{"action":"C","xid":1}`,
+	)
+	if len(msgs) != 0 {
+		t.Errorf("got %d messages for an empty transaction, want 0", len(msgs))
+	}
+}
+
+func TestWal2JSON_RelationReemittedOnColumnChange(t *testing.T) {
+	msgs := decodeAll(t, PluginWal2JSON,
+		`{"action":"B","xid":1}`,
+		`{"action":"I","schema":"s","table":"t","columns":[{"name":"a","value":1}]}`,
+		`{"action":"I","schema":"s","table":"t","columns":[{"name":"a","value":2}]}`,
+		`{"action":"I","schema":"s","table":"t","columns":[{"name":"a","value":3},{"name":"b","value":true}]}`,
+		`{"action":"C","xid":1}`,
+	)
+	var relations int
+	for _, m := range msgs {
+		if m.Kind() == KindRelation {
+			relations++
+		}
+	}
+	if relations != 2 {
+		t.Errorf("relations = %d, want 2", relations)
+	}
+	last := msgs[len(msgs)-2].(*ChangeMessage)
+	if string(last.NewTuple.Columns[1].Value) != "true" {
+		t.Errorf("bool value = %q, want true", last.NewTuple.Columns[1].Value)
+	}
+}
+
+func TestWal2JSON_IgnoresTruncateAndMessages(t *testing.T) {
+	msgs := decodeAll(t, PluginWal2JSON,
+		`{"action":"B","xid":1}`,
+		`{"action":"T","schema":"s","table":"t"}`,
+		`{"action":"M","transactional":true,"prefix":"p","content":"c"}`,
+		`{"action":"C","xid":1}`,
+	)
+	if len(msgs) != 0 {
+		t.Errorf("got %d messages, want 0", len(msgs))
+	}
+}
+
+func TestWal2JSON_PublicationTables(t *testing.T) {
+	tables := map[tableName]bool{{"public", "users"}: true, {"odd", "a.b c'd"}: true}
+	msgs, err := decodeTables(PluginWal2JSON, tables,
+		`{"action":"B","xid":1}`,
+		`{"action":"I","xid":1,"schema":"public","table":"users","columns":[{"name":"id","type":"integer","typeoid":23,"value":1}]}`,
+		`{"action":"I","xid":1,"schema":"public","table":"audit","columns":[{"name":"id","type":"integer","typeoid":23,"value":1}]}`,
+		`{"action":"C","xid":1}`,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 4 || msgs[2].(*ChangeMessage).Table != "users" {
+		t.Errorf("got %#v, want only the users change", msgs)
+	}
+
+	src, _ := NewSource(PluginWal2JSON, nil, "s", "pub", zerolog.Nop())
+	d := src.(*Decoder)
+	d.tables = tables
+	args := d.pluginArgs()
+	if got, want := args[len(args)-1], `"add-tables" 'odd.a\.b\ c\''d,public.users'`; got != want {
+		t.Errorf("add-tables = %s, want %s", got, want)
+	}
+}
+
+func TestWal2JSON_DecodeError(t *testing.T) {
+	_, err := decodeTables(PluginWal2JSON, nil,
+		`{"action":"B","xid":1}`,
+		`{"action":"D","xid":1,"schema":"public","table":"users"}`,
+	)
+	if err == nil || !strings.Contains(err.Error(), "public.users") {
+		t.Errorf("delete without identity: err = %v", err)
+	}
+	if _, err := decodeTables(PluginWal2JSON, nil, `{"action":`); err == nil {
+		t.Error("expected an error for a truncated record")
+	}
+}
+
+func TestWal2JSONValue(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+		null bool
+	}{
+		{`"a\"b"`, `a"b`, false},
+		{`12.50`, `12.50`, false},
+		{`false`, `false`, false},
+		{`null`, ``, true},
+	}
+	for _, tt := range tests {
+		got, err := wal2jsonValue([]byte(tt.raw))
+		if err != nil {
+			t.Fatalf("wal2jsonValue(%s): %v", tt.raw, err)
+		}
+		if tt.null != (got == nil) || string(got) != tt.want {
+			t.Errorf("wal2jsonValue(%s) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,372 @@
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog"
+)
+
+// Severity ranks a finding.
+type Severity string
+
+const (
+	SeverityInfo Severity = "info"
+	SeverityWarn Severity = "warn"
+	SeverityFail Severity = "fail"
+)
+
+// Finding is one version-specific change that affects the migration.
+type Finding struct {
+	Severity Severity `json:"severity"`
+	Category string   `json:"category"` // removed, catalog, datatype, default, extension
+	Title    string   `json:"title"`
+	Version  int      `json:"version"` // major version that introduced the change
+	Hint     string   `json:"hint,omitempty"`
+	Objects  []string `json:"objects,omitempty"`
+}
+
+// Advice is the result of comparing a source and destination major version.
+type Advice struct {
+	SourceVersion int       `json:"source_version"`
+	DestVersion   int       `json:"dest_version"`
+	Findings      []Finding `json:"findings"`
+	PostSteps     []string  `json:"post_steps"`
+	CheckedAt     time.Time `json:"checked_at"`
+}
+
+// Worst returns the highest severity among the findings, or "" when empty.
+func (a *Advice) Worst() Severity {
+	var worst Severity
+	for _, f := range a.Findings {
+		switch {
+		case f.Severity == SeverityFail:
+			return SeverityFail
+		case f.Severity == SeverityWarn:
+			worst = SeverityWarn
+		case worst == "":
+			worst = SeverityInfo
+		}
+	}
+	return worst
+}
+
+// probe is how a rule decides whether the source is affected.
+type probe int
+
+const (
+	// probeAlways reports the rule whenever the version range is crossed
+	// (changed defaults).
+	probeAlways probe = iota
+	// probeBody matches the rule's pattern against function and view bodies.
+	probeBody
+	// probeQuery runs the rule's query on the source; each row is an object.
+	probeQuery
+)
+
+// rule describes a change introduced in a given major version.
+type rule struct {
+	since    int
+	severity Severity
+	category string
+	title    string
+	hint     string
+	probe    probe
+	pattern  string // probeBody: regex, probeQuery: SQL returning one text column
+}
+
+// applies reports whether upgrading from src to dst crosses the version
+// that introduced the rule.
+func (r rule) applies(src, dst int) bool {
+	return src < r.since && dst >= r.since
+}
+
+// rules is the catalog of version-specific changes. Queries must work on
+// every version before rule.since (they run on the source).
+var rules = []rule{
+	// Removed features.
+	{since: 12, severity: SeverityFail, category: "removed", title: "WITH OIDS tables are no longer supported",
+		hint:  "ALTER TABLE ... SET WITHOUT OIDS on the source before migrating.",
+		probe: probeQuery, pattern: `
+			SELECT n.nspname || '.' || c.relname FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+			WHERE (to_jsonb(c)->>'relhasoids')::bool AND n.nspname NOT IN ('pg_catalog', 'information_schema')`},
+	{since: 12, severity: SeverityFail, category: "datatype", title: "abstime, reltime and tinterval types were removed",
+		hint:  "Convert the columns to timestamptz / interval before migrating.",
+		probe: probeQuery, pattern: `
+			SELECT table_schema || '.' || table_name || '.' || column_name || ' (' || udt_name || ')'
+			FROM information_schema.columns
+			WHERE udt_name IN ('abstime', 'reltime', 'tinterval')
+			  AND table_schema NOT IN ('pg_catalog', 'information_schema')`},
+	{since: 12, severity: SeverityFail, category: "catalog", title: "pg_constraint.consrc, pg_attrdef.adsrc and relhasoids were removed",
+		hint:  "Use pg_get_constraintdef() / pg_get_expr() instead.",
+		probe: probeBody, pattern: `\m(consrc|adsrc|relhasoids)\M`},
+	{since: 13, severity: SeverityWarn, category: "removed", title: "wal_keep_segments was replaced by wal_keep_size",
+		hint:  "Set wal_keep_size on the destination instead.",
+		probe: probeQuery, pattern: `SELECT name FROM pg_settings WHERE name = 'wal_keep_segments' AND source <> 'default'`},
+	{since: 14, severity: SeverityFail, category: "removed", title: "Postfix operators are no longer supported",
+		hint:  "Replace them with prefix operators or functions.",
+		probe: probeQuery, pattern: `
+			SELECT n.nspname || '.' || o.oprname FROM pg_operator o JOIN pg_namespace n ON n.oid = o.oprnamespace
+			WHERE o.oprright = 0 AND n.nspname NOT IN ('pg_catalog', 'information_schema')`},
+	{since: 14, severity: SeverityFail, category: "datatype", title: "array_append, array_prepend, array_cat, array_position(s), array_remove, array_replace and width_bucket now take anycompatiblearray",
+		hint:  "User-defined aggregates and operators built on these functions must be dropped and recreated with anycompatible types.",
+		probe: probeQuery, pattern: `
+			SELECT n.nspname || '.' || p.proname || ' (aggregate)'
+			FROM pg_aggregate a JOIN pg_proc p ON p.oid = a.aggfnoid JOIN pg_namespace n ON n.oid = p.pronamespace
+			WHERE n.nspname NOT IN ('pg_catalog', 'information_schema')
+			  AND a.aggtransfn::text IN ('array_append', 'array_prepend', 'array_cat', 'array_position', 'array_positions', 'array_remove', 'array_replace', 'width_bucket')
+			UNION ALL
+			SELECT n.nspname || '.' || o.oprname || ' (operator)'
+			FROM pg_operator o JOIN pg_namespace n ON n.oid = o.oprnamespace
+			WHERE n.nspname NOT IN ('pg_catalog', 'information_schema')
+			  AND o.oprcode::text IN ('array_append', 'array_prepend', 'array_cat', 'array_position', 'array_positions', 'array_remove', 'array_replace', 'width_bucket')`},
+	{since: 15, severity: SeverityFail, category: "removed", title: "Exclusive backup mode was removed; pg_start_backup/pg_stop_backup are now pg_backup_start/pg_backup_stop",
+		hint:  "Update backup scripts and functions to the non-exclusive API.",
+		probe: probeBody, pattern: `\m(pg_start_backup|pg_stop_backup|pg_is_in_backup|pg_backup_start_time)\M`},
+	{since: 16, severity: SeverityWarn, category: "removed", title: "vacuum_defer_cleanup_age and promote_trigger_file were removed",
+		hint:  "Remove them from the destination configuration; use hot_standby_feedback / pg_promote() instead.",
+		probe: probeQuery, pattern: `
+			SELECT name FROM pg_settings
+			WHERE name IN ('vacuum_defer_cleanup_age', 'promote_trigger_file') AND source <> 'default'`},
+	{since: 17, severity: SeverityWarn, category: "removed", title: "old_snapshot_threshold and db_user_namespace were removed",
+		hint:  "Remove them from the destination configuration.",
+		probe: probeQuery, pattern: `
+			SELECT name FROM pg_settings
+			WHERE name IN ('old_snapshot_threshold', 'db_user_namespace') AND source <> 'default'`},
+	{since: 17, severity: SeverityFail, category: "extension", title: "The adminpack extension was removed",
+		hint:  "DROP EXTENSION adminpack on the source or exclude it from the schema.",
+		probe: probeQuery, pattern: `SELECT extname FROM pg_extension WHERE extname = 'adminpack'`},
+	{since: 17, severity: SeverityWarn, category: "catalog", title: "Checkpoint counters moved from pg_stat_bgwriter to pg_stat_checkpointer",
+		hint:  "Read checkpoints_timed/req and buffers_checkpoint from pg_stat_checkpointer; buffers_backend moved to pg_stat_io.",
+		probe: probeBody, pattern: `\m(checkpoints_timed|checkpoints_req|checkpoint_write_time|checkpoint_sync_time|buffers_checkpoint|buffers_backend|buffers_backend_fsync)\M`},
+
+	// Deprecated types still present.
+	{since: 0, severity: SeverityInfo, category: "datatype", title: "money columns are locale-dependent",
+		hint:  "Make sure lc_monetary matches on the destination, or convert to numeric.",
+		probe: probeQuery, pattern: `
+			SELECT table_schema || '.' || table_name || '.' || column_name
+			FROM information_schema.columns
+			WHERE udt_name = 'money' AND table_schema NOT IN ('pg_catalog', 'information_schema')`},
+
+	// Changed defaults.
+	{since: 14, severity: SeverityWarn, category: "default", title: "password_encryption now defaults to scram-sha-256",
+		hint:  "Copied md5 hashes still work, but clients that only speak md5 authentication must be updated before passwords are reset.",
+		probe: probeAlways},
+	{since: 15, severity: SeverityWarn, category: "default", title: "PUBLIC no longer has CREATE on the public schema",
+		hint:  "If applications create objects in public, GRANT CREATE ON SCHEMA public TO <role> on the destination.",
+		probe: probeAlways},
+	{since: 18, severity: SeverityWarn, category: "default", title: "MD5 password authentication is deprecated",
+		hint:  "Reset the listed roles' passwords so they are stored as SCRAM verifiers.",
+		probe: probeQuery, pattern: `SELECT rolname FROM pg_authid WHERE rolpassword LIKE 'md5%'`},
+	{since: 18, severity: SeverityInfo, category: "default", title: "initdb enables data checksums by default",
+		hint:  "No action needed for logical migration; expect slightly higher CPU on write-heavy workloads.",
+		probe: probeAlways},
+}
+
+// Advisor compares a source and destination server version.
+type Advisor struct {
+	source *pgxpool.Pool
+	dest   *pgxpool.Pool
+	logger zerolog.Logger
+}
+
+// NewAdvisor creates an Advisor.
+func NewAdvisor(source, dest *pgxpool.Pool, logger zerolog.Logger) *Advisor {
+	return &Advisor{
+		source: source,
+		dest:   dest,
+		logger: logger.With().Str("component", "upgrade-advisor").Logger(),
+	}
+}
+
+// Advise runs every rule that applies to the version pair and collects the
+// post-upgrade steps.
+func (a *Advisor) Advise(ctx context.Context) (*Advice, error) {
+	src, err := majorVersion(ctx, a.source)
+	if err != nil {
+		return nil, fmt.Errorf("source version: %w", err)
+	}
+	dst, err := majorVersion(ctx, a.dest)
+	if err != nil {
+		return nil, fmt.Errorf("dest version: %w", err)
+	}
+
+	advice := &Advice{SourceVersion: src, DestVersion: dst, Findings: []Finding{}, CheckedAt: time.Now()}
+	if dst < src {
+		advice.Findings = append(advice.Findings, Finding{
+			Severity: SeverityFail,
+			Category: "version",
+			Title:    fmt.Sprintf("Destination (%d) is older than source (%d)", dst, src),
+			Hint:     "Schema dumped from a newer server may not apply; downgrades are not supported.",
+		})
+	}
+
+	for _, r := range applicable(src, dst) {
+		f := Finding{Severity: r.severity, Category: r.category, Title: r.title, Version: r.since, Hint: r.hint}
+		switch r.probe {
+		case probeAlways:
+		case probeBody:
+			objs, err := a.matchBodies(ctx, r.pattern)
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: %w", r.title, err)
+			}
+			if len(objs) == 0 {
+				continue
+			}
+			f.Objects = objs
+		case probeQuery:
+			objs, err := listStrings(ctx, a.source, r.pattern)
+			if err != nil {
+				// Some probes need superuser (pg_authid); skip rather than fail.
+				a.logger.Debug().Err(err).Str("rule", r.title).Msg("rule probe failed, skipping")
+				continue
+			}
+			if len(objs) == 0 {
+				continue
+			}
+			f.Objects = objs
+		}
+		advice.Findings = append(advice.Findings, f)
+	}
+
+	steps, err := a.postSteps(ctx, src, dst)
+	if err != nil {
+		return nil, err
+	}
+	advice.PostSteps = steps
+
+	a.logger.Info().Int("source", src).Int("dest", dst).Int("findings", len(advice.Findings)).Msg("upgrade advice ready")
+	return advice, nil
+}
+
+// applicable returns the rules that apply when moving from major version
+// src to dst. Rules with since == 0 apply to every pair.
+func applicable(src, dst int) []rule {
+	var out []rule
+	for _, r := range rules {
+		if r.since == 0 || r.applies(src, dst) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+func (a *Advisor) matchBodies(ctx context.Context, pattern string) ([]string, error) {
+	return listStrings(ctx, a.source, `
+		SELECT kind || ' ' || name FROM (
+			SELECT 'function' AS kind, n.nspname || '.' || p.proname AS name, p.prosrc AS body
+			FROM pg_proc p
+			JOIN pg_namespace n ON n.oid = p.pronamespace
+			JOIN pg_language l ON l.oid = p.prolang
+			WHERE n.nspname NOT IN ('pg_catalog', 'information_schema')
+			  AND l.lanname NOT IN ('c', 'internal')
+			UNION ALL
+			SELECT 'view', schemaname || '.' || viewname, definition
+			FROM pg_views
+			WHERE schemaname NOT IN ('pg_catalog', 'information_schema')
+		) o
+		WHERE body ~ $1
+		ORDER BY 1`, pattern)
+}
+
+type extVersion struct {
+	name    string
+	current string
+	latest  string
+}
+
+func (a *Advisor) postSteps(ctx context.Context, src, dst int) ([]string, error) {
+	rows, err := a.source.Query(ctx, "SELECT extname, extversion FROM pg_extension WHERE extname <> 'plpgsql'")
+	if err != nil {
+		return nil, fmt.Errorf("list source extensions: %w", err)
+	}
+	current := make(map[string]string)
+	for rows.Next() {
+		var name, version string
+		if err := rows.Scan(&name, &version); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		current[name] = version
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var exts []extVersion
+	for name, version := range current {
+		var latest *string
+		if err := a.dest.QueryRow(ctx,
+			"SELECT default_version FROM pg_available_extensions WHERE name = $1", name,
+		).Scan(&latest); err != nil || latest == nil {
+			continue
+		}
+		exts = append(exts, extVersion{name: name, current: version, latest: *latest})
+	}
+	sort.Slice(exts, func(i, j int) bool { return exts[i].name < exts[j].name })
+
+	var matviews int
+	if err := a.source.QueryRow(ctx, "SELECT count(*) FROM pg_matviews").Scan(&matviews); err != nil {
+		return nil, fmt.Errorf("count materialized views: %w", err)
+	}
+	var sequences int
+	if err := a.source.QueryRow(ctx,
+		"SELECT count(*) FROM pg_sequences WHERE schemaname NOT IN ('pg_catalog', 'information_schema')",
+	).Scan(&sequences); err != nil {
+		return nil, fmt.Errorf("count sequences: %w", err)
+	}
+
+	return buildPostSteps(src, dst, exts, matviews, sequences), nil
+}
+
+// buildPostSteps lists the steps to run on the destination after switchover.
+func buildPostSteps(src, dst int, exts []extVersion, matviews, sequences int) []string {
+	steps := []string{
+		"Run ANALYZE (or vacuumdb --all --analyze-in-stages) on the destination: planner statistics are not migrated.",
+	}
+	if sequences > 0 {
+		steps = append(steps, fmt.Sprintf("Synchronize %d sequence(s) with setval(): logical replication does not carry sequence values.", sequences))
+	}
+	if matviews > 0 {
+		steps = append(steps, fmt.Sprintf("REFRESH MATERIALIZED VIEW for %d materialized view(s): their contents are not copied.", matviews))
+	}
+	for _, e := range exts {
+		if e.current != e.latest {
+			steps = append(steps, fmt.Sprintf("ALTER EXTENSION %q UPDATE; -- %s → %s", e.name, e.current, e.latest))
+		}
+	}
+	if src != dst {
+		steps = append(steps, "Check the collation report and REINDEX indexes it lists.")
+	}
+	return steps
+}
+
+func majorVersion(ctx context.Context, pool *pgxpool.Pool) (int, error) {
+	var num int
+	if err := pool.QueryRow(ctx, "SELECT current_setting('server_version_num')::int").Scan(&num); err != nil {
+		return 0, err
+	}
+	return num / 10000, nil
+}
+
+func listStrings(ctx context.Context, pool *pgxpool.Pool, query string, args ...any) ([]string, error) {
+	rows, err := pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,126 @@
+package upgrade
+
+import (
+	"strings"
+	"testing"
+)
+
+func titles(rs []rule) []string {
+	out := make([]string, len(rs))
+	for i, r := range rs {
+		out[i] = r.title
+	}
+	return out
+}
+
+func hasRule(rs []rule, since int, substr string) bool {
+	for _, r := range rs {
+		if r.since == since && strings.Contains(r.title, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestApplicable(t *testing.T) {
+	got := applicable(12, 17)
+	for _, want := range []struct {
+		since int
+		title string
+	}{
+		{14, "Postfix operators"},
+		{15, "Exclusive backup mode"},
+		{17, "adminpack"},
+		{14, "password_encryption"},
+		{0, "money"},
+	} {
+		if !hasRule(got, want.since, want.title) {
+			t.Errorf("12→17 missing rule %q; got %v", want.title, titles(got))
+		}
+	}
+	if hasRule(got, 12, "WITH OIDS") {
+		t.Error("12→17 should not include the PG12 OIDS rule")
+	}
+	if hasRule(got, 18, "MD5") {
+		t.Error("12→17 should not include PG18 rules")
+	}
+
+	same := applicable(16, 16)
+	for _, r := range same {
+		if r.since != 0 {
+			t.Errorf("same version should only include version-independent rules, got %q", r.title)
+		}
+	}
+}
+
+func TestRuleApplies(t *testing.T) {
+	r := rule{since: 15}
+	tests := []struct {
+		src, dst int
+		want     bool
+	}{
+		{14, 15, true},
+		{12, 17, true},
+		{15, 17, false},
+		{12, 14, false},
+		{16, 14, false},
+	}
+	for _, tt := range tests {
+		if got := r.applies(tt.src, tt.dst); got != tt.want {
+			t.Errorf("applies(%d, %d) = %v, want %v", tt.src, tt.dst, got, tt.want)
+		}
+	}
+}
+
+func TestRulesHaveProbes(t *testing.T) {
+	for _, r := range rules {
+		if r.probe != probeAlways && r.pattern == "" {
+			t.Errorf("rule %q has a probe but no pattern", r.title)
+		}
+		if r.hint == "" {
+			t.Errorf("rule %q has no hint", r.title)
+		}
+	}
+}
+
+func TestBuildPostSteps(t *testing.T) {
+	exts := []extVersion{
+		{name: "pg_stat_statements", current: "1.8", latest: "1.11"},
+		{name: "pgcrypto", current: "1.3", latest: "1.3"},
+	}
+	steps := buildPostSteps(12, 17, exts, 2, 5)
+	joined := strings.Join(steps, "\n")
+
+	for _, want := range []string{"ANALYZE", "5 sequence(s)", "2 materialized view(s)", `ALTER EXTENSION "pg_stat_statements" UPDATE`, "collation"} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("post steps missing %q:\n%s", want, joined)
+		}
+	}
+	if strings.Contains(joined, "pgcrypto") {
+		t.Error("up-to-date extension should not be listed")
+	}
+
+	steps = buildPostSteps(16, 16, nil, 0, 0)
+	if len(steps) != 1 {
+		t.Errorf("same version, no sequences: %v", steps)
+	}
+}
+
+func TestAdviceWorst(t *testing.T) {
+	a := &Advice{}
+	if a.Worst() != "" {
+		t.Error("empty advice should have no severity")
+	}
+	a.Findings = []Finding{{Severity: SeverityInfo}}
+	if a.Worst() != SeverityInfo {
+		t.Errorf("worst = %s", a.Worst())
+	}
+	a.Findings = append(a.Findings, Finding{Severity: SeverityWarn}, Finding{Severity: SeverityInfo})
+	if a.Worst() != SeverityWarn {
+		t.Errorf("worst = %s", a.Worst())
+	}
+	a.Findings = append(a.Findings, Finding{Severity: SeverityFail})
+	if a.Worst() != SeverityFail {
+		t.Errorf("worst = %s", a.Worst())
+	}
+}
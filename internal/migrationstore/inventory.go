@@ -0,0 +1,99 @@
+package migrationstore
+
+import (
+	"github.com/jfoltran/pgmanager/internal/cluster"
+	"github.com/jfoltran/pgmanager/internal/migration/cleanup"
+	"github.com/jfoltran/pgmanager/internal/migration/stream"
+)
+
+// AttributeReplication marks the objects in inv that belong to one of the
+// migrations, and flags inactive logical slots that no migration owns as
+// orphans.
+// A migration owns its forward objects on every node of its source cluster
+// and its _reverse objects on every node of its destination cluster, so
+// slots synced to standbys are attributed too. A consolidated migration
+// owns each source's slot on that source's cluster.
+func AttributeReplication(inv *cluster.ReplicationInventory, migrations []Migration) {
+	slots := map[string]string{}
+	pubs := map[string]string{}
+	origins := map[string]string{}
+	claim := func(m Migration, names cleanup.Names) {
+		for _, n := range names.Slots {
+			slots[n] = m.ID
+		}
+		for _, n := range names.Publications {
+			pubs[n] = m.ID
+		}
+		for _, n := range names.Origins {
+			if _, taken := origins[n]; !taken {
+				origins[n] = m.ID
+			}
+		}
+	}
+	for _, m := range migrations {
+		src, dst := cleanup.MigrationNames(m.SlotName, m.Publication)
+		if m.SourceClusterID == inv.ClusterID {
+			claim(m, src)
+		}
+		if m.DestClusterID == inv.ClusterID {
+			claim(m, dst)
+		}
+		// A consolidated migration has a slot per source, on that
+		// source's cluster, and that source's origins on the destination.
+		for _, s := range m.Sources {
+			src, dst := cleanup.MigrationNames(m.SlotName+"_"+s.Name, m.Publication)
+			if s.ClusterID == inv.ClusterID {
+				claim(m, src)
+			}
+			if m.DestClusterID == inv.ClusterID {
+				claim(m, dst)
+			}
+		}
+	}
+
+	for i := range inv.Nodes {
+		n := &inv.Nodes[i]
+		for j := range n.Slots {
+			s := &n.Slots[j]
+			s.Owner = slots[s.Name]
+			s.Orphan = s.Owner == "" && !s.Active && !s.Temporary && ownPlugin(*s)
+		}
+		for j := range n.Publications {
+			n.Publications[j].Owner = pubs[n.Publications[j].Name]
+		}
+		for j := range n.Origins {
+			n.Origins[j].Owner = origins[n.Origins[j].Name]
+		}
+	}
+}
+
+// ownPlugin reports whether s is a logical slot decoded with one of the
+// output plugins pgmanager uses. Physical slots, such as those of
+// standbys, and slots of other decoders are never orphans, as they are
+// likely owned by something outside pgmanager.
+func ownPlugin(s cluster.ReplicationSlot) bool {
+	if s.Type != "logical" {
+		return false
+	}
+	switch s.Plugin {
+	case stream.PluginPgoutput, stream.PluginWal2JSON, stream.PluginTestDecoding:
+		return true
+	}
+	return false
+}
+
+// Orphans returns the names of the orphaned slots on a node of inv.
+func Orphans(inv cluster.ReplicationInventory, nodeID string) map[string]bool {
+	orphans := map[string]bool{}
+	for _, n := range inv.Nodes {
+		if n.NodeID != nodeID {
+			continue
+		}
+		for _, s := range n.Slots {
+			if s.Orphan {
+				orphans[s.Name] = true
+			}
+		}
+	}
+	return orphans
+}
@@ -0,0 +1,133 @@
+package migrationstore
+
+import (
+	"testing"
+
+	"github.com/jfoltran/pgmanager/internal/cluster"
+)
+
+func testInventory() *cluster.ReplicationInventory {
+	return &cluster.ReplicationInventory{
+		ClusterID: "c1",
+		Nodes: []cluster.NodeReplication{
+			{
+				NodeID: "primary",
+				Slots: []cluster.ReplicationSlot{
+					{Name: "pgmanager_m1", Type: "logical", Active: true},
+					{Name: "pgmanager_old", Type: "logical", Plugin: "pgoutput"},
+					{Name: "standby1", Type: "physical", Active: true},
+					{Name: "pg_temp_1", Type: "logical", Temporary: true},
+				},
+				Publications: []cluster.Publication{
+					{Name: "pgmanager_pub_m1", Database: "app"},
+					{Name: "other_pub", Database: "app"},
+				},
+				Origins: []cluster.ReplicationOrigin{{Name: "pgmanager_m2_reverse"}},
+			},
+			{
+				NodeID: "standby",
+				Slots:  []cluster.ReplicationSlot{{Name: "pgmanager_m1", Type: "logical", Plugin: "pgoutput"}},
+			},
+		},
+	}
+}
+
+func TestAttributeReplication(t *testing.T) {
+	inv := testInventory()
+	migrations := []Migration{
+		{ID: "m1", SourceClusterID: "c1", DestClusterID: "c2", SlotName: "pgmanager_m1", Publication: "pgmanager_pub_m1"},
+		{ID: "m2", SourceClusterID: "c3", DestClusterID: "c1", SlotName: "pgmanager_m2", Publication: "pgmanager_pub_m2"},
+	}
+	AttributeReplication(inv, migrations)
+
+	primary := inv.Nodes[0]
+	if primary.Slots[0].Owner != "m1" || primary.Slots[0].Orphan {
+		t.Errorf("owned slot = %+v", primary.Slots[0])
+	}
+	if primary.Slots[1].Owner != "" || !primary.Slots[1].Orphan {
+		t.Errorf("inactive unowned slot should be an orphan: %+v", primary.Slots[1])
+	}
+	if primary.Slots[2].Orphan {
+		t.Errorf("active slot should not be an orphan: %+v", primary.Slots[2])
+	}
+	if primary.Slots[3].Orphan {
+		t.Errorf("temporary slot should not be an orphan: %+v", primary.Slots[3])
+	}
+	if primary.Publications[0].Owner != "m1" || primary.Publications[1].Owner != "" {
+		t.Errorf("publications = %+v", primary.Publications)
+	}
+	if primary.Origins[0].Owner != "m2" {
+		t.Errorf("origin owner = %q, want m2", primary.Origins[0].Owner)
+	}
+
+	standby := inv.Nodes[1]
+	if standby.Slots[0].Owner != "m1" || standby.Slots[0].Orphan {
+		t.Errorf("synced slot on standby = %+v", standby.Slots[0])
+	}
+}
+
+func TestAttributeReplication_OtherCluster(t *testing.T) {
+	inv := testInventory()
+	AttributeReplication(inv, []Migration{
+		{ID: "m1", SourceClusterID: "c9", DestClusterID: "c8", SlotName: "pgmanager_m1", Publication: "pgmanager_pub_m1"},
+	})
+	if inv.Nodes[1].Slots[0].Owner != "" || !inv.Nodes[1].Slots[0].Orphan {
+		t.Errorf("slot of a migration on another cluster should be orphaned: %+v", inv.Nodes[1].Slots[0])
+	}
+}
+
+func TestAttributeReplication_Sources(t *testing.T) {
+	inv := testInventory()
+	inv.Nodes[0].Slots = append(inv.Nodes[0].Slots,
+		cluster.ReplicationSlot{Name: "pgmanager_m3_acme", Type: "logical", Plugin: "pgoutput"},
+		cluster.ReplicationSlot{Name: "pgmanager_m3_globex", Type: "logical", Plugin: "pgoutput"},
+	)
+	AttributeReplication(inv, []Migration{{
+		ID: "m3", SourceClusterID: "c5", DestClusterID: "c2", SlotName: "pgmanager_m3", Publication: "pgmanager_pub_m3",
+		Sources: []MigrationSource{
+			{Name: "acme", ClusterID: "c5", NodeID: "n5"},
+			{Name: "globex", ClusterID: "c1", NodeID: "primary"},
+		},
+	}})
+
+	slots := inv.Nodes[0].Slots
+	if acme := slots[4]; acme.Owner != "" || !acme.Orphan {
+		t.Errorf("slot of a source on another cluster should be orphaned: %+v", acme)
+	}
+	if globex := slots[5]; globex.Owner != "m3" || globex.Orphan {
+		t.Errorf("inactive source slot should be owned: %+v", globex)
+	}
+}
+
+func TestAttributeReplication_ForeignSlots(t *testing.T) {
+	inv := testInventory()
+	inv.Nodes[0].Slots = []cluster.ReplicationSlot{
+		{Name: "standby2", Type: "physical"},
+		{Name: "debezium", Type: "logical", Plugin: "decoderbufs"},
+		{Name: "pgmanager_gone", Type: "logical", Plugin: "wal2json"},
+	}
+	AttributeReplication(inv, nil)
+
+	slots := inv.Nodes[0].Slots
+	if slots[0].Orphan {
+		t.Errorf("inactive physical slot should not be an orphan: %+v", slots[0])
+	}
+	if slots[1].Orphan {
+		t.Errorf("slot of another plugin should not be an orphan: %+v", slots[1])
+	}
+	if !slots[2].Orphan {
+		t.Errorf("inactive wal2json slot should be an orphan: %+v", slots[2])
+	}
+}
+
+func TestOrphans(t *testing.T) {
+	inv := testInventory()
+	AttributeReplication(inv, nil)
+	got := Orphans(*inv, "primary")
+	if len(got) != 1 || !got["pgmanager_old"] {
+		t.Errorf("Orphans(primary) = %v", got)
+	}
+	if got := Orphans(*inv, "missing"); len(got) != 0 {
+		t.Errorf("Orphans(missing) = %v, want none", got)
+	}
+}
@@ -2,17 +2,29 @@ package migrationstore
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 
 	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/rs/zerolog"
 
 	"github.com/jfoltran/pgmanager/internal/cluster"
 	"github.com/jfoltran/pgmanager/internal/config"
 	"github.com/jfoltran/pgmanager/internal/metrics"
+	"github.com/jfoltran/pgmanager/internal/migration/cleanup"
+	"github.com/jfoltran/pgmanager/internal/migration/collation"
+	"github.com/jfoltran/pgmanager/internal/migration/failover"
+	"github.com/jfoltran/pgmanager/internal/migration/feed"
+	"github.com/jfoltran/pgmanager/internal/migration/identity"
 	"github.com/jfoltran/pgmanager/internal/migration/pipeline"
+	"github.com/jfoltran/pgmanager/internal/migration/preflight"
+	"github.com/jfoltran/pgmanager/internal/migration/remap"
+	"github.com/jfoltran/pgmanager/internal/migration/roles"
+	"github.com/jfoltran/pgmanager/internal/migration/slotguard"
+	"github.com/jfoltran/pgmanager/internal/migration/upgrade"
 )
 
 type Runner struct {
@@ -27,6 +39,7 @@ type Runner struct {
 
 type runningJob struct {
 	pipeline     *pipeline.Pipeline
+	group        *pipeline.Group // instead of pipeline when consolidating
 	cancel       context.CancelFunc
 	switchedOver bool
 	done         chan struct{}
@@ -74,39 +87,11 @@ func (r *Runner) Start(ctx context.Context, migrationID string) error {
 		return fmt.Errorf("migration %q is already running", migrationID)
 	}
 
-	srcCluster, ok, err := r.clusters.Get(ctx, m.SourceClusterID)
+	cfg, err := r.buildConfig(ctx, m)
 	if err != nil {
-		return fmt.Errorf("get source cluster: %w", err)
-	}
-	if !ok {
-		return fmt.Errorf("source cluster %q not found", m.SourceClusterID)
-	}
-
-	dstCluster, ok, err := r.clusters.Get(ctx, m.DestClusterID)
-	if err != nil {
-		return fmt.Errorf("get dest cluster: %w", err)
-	}
-	if !ok {
-		return fmt.Errorf("destination cluster %q not found", m.DestClusterID)
-	}
-
-	srcNode := findNode(srcCluster.Nodes, m.SourceNodeID)
-	if srcNode == nil {
-		return fmt.Errorf("source node %q not found in cluster %q", m.SourceNodeID, m.SourceClusterID)
-	}
-	dstNode := findNode(dstCluster.Nodes, m.DestNodeID)
-	if dstNode == nil {
-		return fmt.Errorf("dest node %q not found in cluster %q", m.DestNodeID, m.DestClusterID)
+		return err
 	}
 
-	cfg := &config.Config{}
-	cfg.Source.ParseURI(srcNode.DSN())
-	cfg.Dest.ParseURI(dstNode.DSN())
-	cfg.Replication.SlotName = m.SlotName
-	cfg.Replication.Publication = m.Publication
-	cfg.Replication.OutputPlugin = "pgoutput"
-	cfg.Snapshot.Workers = m.CopyWorkers
-
 	r.mu.Lock()
 	if _, exists := r.running[migrationID]; exists {
 		r.mu.Unlock()
@@ -114,10 +99,16 @@ func (r *Runner) Start(ctx context.Context, migrationID string) error {
 	}
 
 	pipelineLogger := r.logger.With().Str("migration", migrationID).Logger()
-	p := pipeline.New(cfg, pipelineLogger)
+	job := &runningJob{done: make(chan struct{})}
+	if cfg.Consolidation.Enabled() {
+		job.group = pipeline.NewGroup(cfg, pipelineLogger)
+	} else {
+		job.pipeline = pipeline.New(cfg, pipelineLogger)
+	}
 
 	jobCtx, cancel := context.WithCancel(r.ctx)
-	r.running[migrationID] = &runningJob{pipeline: p, cancel: cancel, done: make(chan struct{})}
+	job.cancel = cancel
+	r.running[migrationID] = job
 	r.mu.Unlock()
 
 	if err := r.store.UpdateStatus(ctx, migrationID, StatusRunning, "initializing", ""); err != nil {
@@ -132,10 +123,15 @@ func (r *Runner) Start(ctx context.Context, migrationID string) error {
 		Str("migration", migrationID).
 		Str("mode", string(m.Mode)).
 		Str("source", m.SourceClusterID+"/"+m.SourceNodeID).
+		Int("sources", max(len(m.Sources), 1)).
 		Str("dest", m.DestClusterID+"/"+m.DestNodeID).
 		Msg("starting migration")
 
-	go r.run(jobCtx, migrationID, m.Mode, p)
+	if job.group != nil {
+		go r.runGroup(jobCtx, migrationID, m.Mode, job.group)
+	} else {
+		go r.run(jobCtx, migrationID, m.Mode, job.pipeline)
+	}
 
 	return nil
 }
@@ -153,6 +149,25 @@ func (r *Runner) Stop(ctx context.Context, migrationID string) error {
 	return nil
 }
 
+// StopAndWait stops a running migration and waits for its pipeline to
+// shut down. It is a no-op if the migration is not running.
+func (r *Runner) StopAndWait(ctx context.Context, migrationID string) error {
+	r.mu.Lock()
+	job, ok := r.running[migrationID]
+	r.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	job.cancel()
+	select {
+	case <-job.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (r *Runner) Switchover(ctx context.Context, migrationID string) error {
 	r.mu.Lock()
 	job, ok := r.running[migrationID]
@@ -172,7 +187,13 @@ func (r *Runner) Switchover(ctx context.Context, migrationID string) error {
 	go func() {
 		bgCtx := context.Background()
 
-		if err := job.pipeline.RunSwitchover(bgCtx, 30*time.Second); err != nil {
+		var err error
+		if job.group != nil {
+			err = job.group.RunSwitchover(bgCtx, 30*time.Second)
+		} else {
+			err = job.pipeline.RunSwitchover(bgCtx, 30*time.Second)
+		}
+		if err != nil {
 			r.logger.Err(err).Str("migration", migrationID).Msg("switchover failed")
 			r.store.UpdateStatus(bgCtx, migrationID, StatusFailed, "switchover_failed", err.Error())
 			return
@@ -241,35 +262,13 @@ func (r *Runner) Switchover(ctx context.Context, migrationID string) error {
 }
 
 func (r *Runner) startReverse(id string, m Migration, startLSN pglogrepl.LSN) {
-	srcCluster, ok, err := r.clusters.Get(context.Background(), m.SourceClusterID)
-	if err != nil || !ok {
-		r.logger.Err(err).Str("migration", id).Msg("reverse migration: source cluster not found")
-		r.store.UpdateStatus(context.Background(), id, StatusFailed, "error", "source cluster not found")
-		return
-	}
-	dstCluster, ok, err := r.clusters.Get(context.Background(), m.DestClusterID)
-	if err != nil || !ok {
-		r.logger.Err(err).Str("migration", id).Msg("reverse migration: dest cluster not found")
-		r.store.UpdateStatus(context.Background(), id, StatusFailed, "error", "dest cluster not found")
-		return
-	}
-
-	srcNode := findNode(srcCluster.Nodes, m.SourceNodeID)
-	dstNode := findNode(dstCluster.Nodes, m.DestNodeID)
-	if srcNode == nil || dstNode == nil {
-		r.logger.Error().Str("migration", id).Msg("reverse migration: node not found")
-		r.store.UpdateStatus(context.Background(), id, StatusFailed, "error", "node not found")
+	cfg, err := r.buildConfig(context.Background(), m)
+	if err != nil {
+		r.logger.Err(err).Str("migration", id).Msg("reverse migration: resolve nodes")
+		r.store.UpdateStatus(context.Background(), id, StatusFailed, "error", err.Error())
 		return
 	}
 
-	cfg := &config.Config{}
-	cfg.Source.ParseURI(srcNode.DSN())
-	cfg.Dest.ParseURI(dstNode.DSN())
-	cfg.Replication.SlotName = m.SlotName
-	cfg.Replication.Publication = m.Publication
-	cfg.Replication.OutputPlugin = "pgoutput"
-	cfg.Snapshot.Workers = m.CopyWorkers
-
 	pipelineLogger := r.logger.With().Str("migration", id).Logger()
 	p := pipeline.New(cfg, pipelineLogger)
 
@@ -303,11 +302,428 @@ func (r *Runner) startReverse(id string, m Migration, startLSN pglogrepl.LSN) {
 			r.store.UpdateStatus(bgCtx, id, StatusStopped, "stopped", "")
 		} else if err != nil {
 			r.logger.Err(err).Str("migration", id).Msg("reverse migration failed")
-			r.store.UpdateStatus(bgCtx, id, StatusFailed, "error", err.Error())
+			r.store.UpdateStatus(bgCtx, id, StatusFailed, failurePhase(err), err.Error())
 		}
 	}()
 }
 
+// buildConfig resolves the source and destination nodes of a migration and
+// builds the pipeline configuration for it.
+func (r *Runner) buildConfig(ctx context.Context, m Migration) (*config.Config, error) {
+	srcCluster, ok, err := r.clusters.Get(ctx, m.SourceClusterID)
+	if err != nil {
+		return nil, fmt.Errorf("get source cluster: %w", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("source cluster %q not found", m.SourceClusterID)
+	}
+
+	dstCluster, ok, err := r.clusters.Get(ctx, m.DestClusterID)
+	if err != nil {
+		return nil, fmt.Errorf("get dest cluster: %w", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("destination cluster %q not found", m.DestClusterID)
+	}
+
+	srcNode := findNode(srcCluster.Nodes, m.SourceNodeID)
+	if srcNode == nil {
+		return nil, fmt.Errorf("source node %q not found in cluster %q", m.SourceNodeID, m.SourceClusterID)
+	}
+	var readNode *cluster.Node
+	if m.ReadNodeID != "" {
+		readNode = findNode(srcCluster.Nodes, m.ReadNodeID)
+		if readNode == nil {
+			return nil, fmt.Errorf("read node %q not found in cluster %q", m.ReadNodeID, m.SourceClusterID)
+		}
+		if readNode.Role == cluster.RolePrimary {
+			return nil, fmt.Errorf("read node %q is a primary, pick a replica or standby", m.ReadNodeID)
+		}
+	}
+	dstNode := findNode(dstCluster.Nodes, m.DestNodeID)
+	if dstNode == nil {
+		return nil, fmt.Errorf("dest node %q not found in cluster %q", m.DestNodeID, m.DestClusterID)
+	}
+
+	cfg := &config.Config{}
+	cfg.Source.ParseURI(srcNode.DSN())
+	if readNode != nil {
+		// Decode and copy from the standby; the source node stays the
+		// primary for publications.
+		cfg.SourcePrimary = cfg.Source
+		cfg.Source = config.DatabaseConfig{}
+		cfg.Source.ParseURI(readNode.DSN())
+	}
+	cfg.Dest.ParseURI(dstNode.DSN())
+	cfg.Replication.SlotName = m.SlotName
+	cfg.Replication.Publication = m.Publication
+	cfg.Replication.OutputPlugin = m.OutputPlugin
+	if cfg.Replication.OutputPlugin == "" {
+		cfg.Replication.OutputPlugin = "pgoutput"
+	}
+	cfg.Snapshot.Workers = m.CopyWorkers
+	cfg.Snapshot.ReindexCollations = m.ReindexCollations
+	cfg.Feed.Enabled = m.Feed
+	cfg.Replication.AllowMissingIdentity = m.AllowMissingIdentity
+	cfg.Replication.SlotWarnBytes = m.SlotWarnBytes
+	cfg.Replication.SlotWarnSafeBytes = m.SlotWarnSafeBytes
+	cfg.Replication.SlotMaxBytes = m.SlotMaxBytes
+	cfg.Replication.SlotDropOnLimit = m.SlotDropOnLimit
+	cfg.Roles.Enabled = m.MigrateRoles
+
+	if len(m.Sources) > 0 {
+		cfg.Source = config.DatabaseConfig{}
+		cfg.Consolidation.Column = m.ConsolidateColumn
+		for _, s := range m.Sources {
+			c, ok, err := r.clusters.Get(ctx, s.ClusterID)
+			if err != nil {
+				return nil, fmt.Errorf("get cluster of source %s: %w", s.Name, err)
+			}
+			if !ok {
+				return nil, fmt.Errorf("cluster %q of source %s not found", s.ClusterID, s.Name)
+			}
+			node := findNode(c.Nodes, s.NodeID)
+			if node == nil {
+				return nil, fmt.Errorf("node %q of source %s not found in cluster %q", s.NodeID, s.Name, s.ClusterID)
+			}
+			sc := config.SourceConfig{Name: s.Name, Schema: s.Schema}
+			sc.DB.ParseURI(node.DSN())
+			cfg.Consolidation.Sources = append(cfg.Consolidation.Sources, sc)
+		}
+		if err := cfg.Validate(); err != nil {
+			return nil, fmt.Errorf("migration %s: %w", m.ID, err)
+		}
+	}
+	return cfg, nil
+}
+
+// singleSource rejects a consolidating configuration, for the reports
+// that look at one source.
+func singleSource(cfg *config.Config) error {
+	if cfg.Consolidation.Enabled() {
+		return errors.New("not supported for a migration with several sources")
+	}
+	return nil
+}
+
+// RolesDryRun reports the roles, memberships, grants and ownership that the
+// roles phase would copy for a migration, without changing the destination.
+func (r *Runner) RolesDryRun(ctx context.Context, migrationID string) (*roles.Report, error) {
+	cfg, err := r.configFor(ctx, migrationID)
+	if err != nil {
+		return nil, err
+	}
+	if err := singleSource(cfg); err != nil {
+		return nil, err
+	}
+
+	srcPool, dstPool, err := openPools(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer srcPool.Close()
+	defer dstPool.Close()
+
+	return roles.NewManager(srcPool, dstPool, r.logger).DryRun(ctx)
+}
+
+// Preflight runs the preflight checks against a migration's source and
+// destination nodes.
+func (r *Runner) Preflight(ctx context.Context, migrationID string) (*preflight.Report, error) {
+	cfg, err := r.configFor(ctx, migrationID)
+	if err != nil {
+		return nil, err
+	}
+	return RunPreflight(ctx, cfg, r.logger)
+}
+
+// RunPreflight connects to the source and destination of cfg and runs the
+// preflight checks. With several sources every source is checked, and the
+// check names are prefixed with the source name.
+func RunPreflight(ctx context.Context, cfg *config.Config, logger zerolog.Logger) (*preflight.Report, error) {
+	if cfg.Consolidation.Enabled() {
+		merged := &preflight.Report{CheckedAt: time.Now()}
+		for _, s := range cfg.Consolidation.Sources {
+			report, err := RunPreflight(ctx, cfg.ForSource(s), logger)
+			if err != nil {
+				return nil, fmt.Errorf("source %s: %w", s.Name, err)
+			}
+			for _, c := range report.Checks {
+				c.Name = s.Name + ": " + c.Name
+				merged.Checks = append(merged.Checks, c)
+			}
+			merged.Passed += report.Passed
+			merged.Warnings += report.Warnings
+			merged.Failures += report.Failures
+		}
+		return merged, nil
+	}
+	srcPool, dstPool, err := openPools(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer srcPool.Close()
+	defer dstPool.Close()
+
+	checker := preflight.NewChecker(srcPool, dstPool, logger)
+	if cfg.Remap.Enabled() {
+		checker.SetMap(remap.New(cfg.Remap))
+	}
+	return checker.Run(ctx), nil
+}
+
+// CollationReport compares encoding and collations between a migration's
+// source and destination and lists indexes that need a REINDEX after copy.
+func (r *Runner) CollationReport(ctx context.Context, migrationID string) (*collation.Report, error) {
+	cfg, err := r.configFor(ctx, migrationID)
+	if err != nil {
+		return nil, err
+	}
+	if err := singleSource(cfg); err != nil {
+		return nil, err
+	}
+	srcPool, dstPool, err := openPools(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer srcPool.Close()
+	defer dstPool.Close()
+
+	return collation.NewAnalyzer(srcPool, dstPool, r.logger).Analyze(ctx)
+}
+
+// UpgradeAdvice compares the major versions of a migration's source and
+// destination and records the result on the migration.
+func (r *Runner) UpgradeAdvice(ctx context.Context, migrationID string) (*upgrade.Advice, error) {
+	cfg, err := r.configFor(ctx, migrationID)
+	if err != nil {
+		return nil, err
+	}
+	if err := singleSource(cfg); err != nil {
+		return nil, err
+	}
+	return r.adviseUpgrade(ctx, migrationID, cfg)
+}
+
+func (r *Runner) adviseUpgrade(ctx context.Context, migrationID string, cfg *config.Config) (*upgrade.Advice, error) {
+	srcPool, dstPool, err := openPools(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer srcPool.Close()
+	defer dstPool.Close()
+
+	advice, err := upgrade.NewAdvisor(srcPool, dstPool, r.logger).Advise(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.store.UpdateUpgradeAdvice(ctx, migrationID, advice); err != nil {
+		return nil, err
+	}
+	return advice, nil
+}
+
+// ReplicaIdentityAudit lists source tables of a migration that have no
+// usable replica identity, with the proposed fix for each.
+func (r *Runner) ReplicaIdentityAudit(ctx context.Context, migrationID string) ([]identity.Finding, error) {
+	cfg, err := r.configFor(ctx, migrationID)
+	if err != nil {
+		return nil, err
+	}
+	if err := singleSource(cfg); err != nil {
+		return nil, err
+	}
+	pool, err := openPool(ctx, cfg.Source, "source")
+	if err != nil {
+		return nil, err
+	}
+	defer pool.Close()
+
+	findings, err := identity.NewAuditor(pool, r.logger).Audit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if findings == nil {
+		findings = []identity.Finding{}
+	}
+	return findings, nil
+}
+
+// FixReplicaIdentity applies the proposed replica identity fixes on the
+// source of a migration. tables limits the fix to the given schema.table
+// names; an empty list fixes every finding. It returns the applied fixes.
+func (r *Runner) FixReplicaIdentity(ctx context.Context, migrationID string, tables []string) ([]identity.Finding, error) {
+	cfg, err := r.configFor(ctx, migrationID)
+	if err != nil {
+		return nil, err
+	}
+	if err := singleSource(cfg); err != nil {
+		return nil, err
+	}
+	pool, err := openPool(ctx, cfg.Primary(), "source")
+	if err != nil {
+		return nil, err
+	}
+	defer pool.Close()
+
+	auditor := identity.NewAuditor(pool, r.logger)
+	findings, err := auditor.Audit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	selected := identity.Filter(findings, tables)
+	if err := auditor.Apply(ctx, selected); err != nil {
+		return nil, err
+	}
+	if selected == nil {
+		selected = []identity.Finding{}
+	}
+	return selected, nil
+}
+
+// Artifacts lists the replication slots, publications and origins a
+// migration left on its source and destination.
+func (r *Runner) Artifacts(ctx context.Context, migrationID string) ([]cleanup.Artifact, error) {
+	c, closeFn, err := r.cleaner(ctx, migrationID)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFn()
+	return c.List(ctx)
+}
+
+// CleanupArtifacts drops the replication artifacts of a stopped migration.
+// Active slots are only dropped when terminate is set.
+func (r *Runner) CleanupArtifacts(ctx context.Context, migrationID string, terminate bool) ([]cleanup.Artifact, error) {
+	if r.IsRunning(migrationID) {
+		return nil, fmt.Errorf("migration %q is running; stop it before cleanup", migrationID)
+	}
+	c, closeFn, err := r.cleaner(ctx, migrationID)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFn()
+	return c.Drop(ctx, terminate)
+}
+
+// cleaner builds a cleanup.Cleaner over the migration's source primary,
+// its standby read node if any, and its destination.
+func (r *Runner) cleaner(ctx context.Context, migrationID string) (*cleanup.Cleaner, func(), error) {
+	cfg, err := r.configFor(ctx, migrationID)
+	if err != nil {
+		return nil, nil, err
+	}
+	srcNames, dstNames := cleanup.MigrationNames(cfg.Replication.SlotName, cfg.Replication.Publication)
+
+	var pools []*pgxpool.Pool
+	closeAll := func() {
+		for _, p := range pools {
+			p.Close()
+		}
+	}
+
+	var targets []cleanup.Target
+	if cfg.Consolidation.Enabled() {
+		// Every source has its own slot, named after the source.
+		for _, s := range cfg.Consolidation.Sources {
+			sub := cfg.ForSource(s)
+			names, dst := cleanup.MigrationNames(sub.Replication.SlotName, sub.Replication.Publication)
+			dstNames.Origins = append(dstNames.Origins, dst.Origins...)
+			pool, err := openPool(ctx, sub.Source, "source "+s.Name)
+			if err != nil {
+				closeAll()
+				return nil, nil, err
+			}
+			pools = append(pools, pool)
+			targets = append(targets, cleanup.Target{Side: cleanup.SideSource, Pool: pool, Names: names})
+		}
+	} else {
+		srcPool, err := openPool(ctx, cfg.Primary(), "source")
+		if err != nil {
+			return nil, nil, err
+		}
+		pools = append(pools, srcPool)
+		targets = append(targets, cleanup.Target{Side: cleanup.SideSource, Pool: srcPool, Names: srcNames})
+	}
+
+	if cfg.ReadsFromStandby() {
+		standbyPool, err := openPool(ctx, cfg.Source, "source standby")
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		pools = append(pools, standbyPool)
+		targets = append(targets, cleanup.Target{
+			Side:  cleanup.SideSourceStandby,
+			Pool:  standbyPool,
+			Names: cleanup.Names{Slots: srcNames.Slots},
+		})
+	}
+
+	dstPool, err := openPool(ctx, cfg.Dest, "dest")
+	if err != nil {
+		closeAll()
+		return nil, nil, err
+	}
+	pools = append(pools, dstPool)
+	targets = append(targets, cleanup.Target{Side: cleanup.SideDest, Pool: dstPool, Names: dstNames})
+
+	for _, d := range cfg.Fanout.Destinations {
+		pool, err := openPool(ctx, d.DB, "fan-out destination "+d.Name)
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		pools = append(pools, pool)
+		targets = append(targets, cleanup.Target{
+			Side:  cleanup.FanoutSide(d.Name),
+			Pool:  pool,
+			Names: cleanup.FanoutNames(cfg.Replication.SlotName),
+		})
+	}
+
+	return cleanup.NewCleaner(targets, r.logger.With().Str("migration", migrationID).Logger()), closeAll, nil
+}
+
+// configFor loads a migration and builds its pipeline configuration.
+func (r *Runner) configFor(ctx context.Context, migrationID string) (*config.Config, error) {
+	m, ok, err := r.store.Get(ctx, migrationID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("migration %q not found", migrationID)
+	}
+	return r.buildConfig(ctx, m)
+}
+
+// openPool opens and pings a short-lived pool.
+func openPool(ctx context.Context, db config.DatabaseConfig, label string) (*pgxpool.Pool, error) {
+	pool, err := pgxpool.New(ctx, db.DSN())
+	if err != nil {
+		return nil, fmt.Errorf("%s pool: %w", label, err)
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("%s ping %s:%d/%s: %w", label, db.Host, db.Port, db.DBName, err)
+	}
+	return pool, nil
+}
+
+// openPools opens and pings short-lived pools to the source and destination.
+func openPools(ctx context.Context, cfg *config.Config) (*pgxpool.Pool, *pgxpool.Pool, error) {
+	srcPool, err := openPool(ctx, cfg.Source, "source")
+	if err != nil {
+		return nil, nil, err
+	}
+	dstPool, err := openPool(ctx, cfg.Dest, "dest")
+	if err != nil {
+		srcPool.Close()
+		return nil, nil, err
+	}
+	return srcPool, dstPool, nil
+}
+
 func (r *Runner) IsRunning(migrationID string) bool {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -315,6 +731,18 @@ func (r *Runner) IsRunning(migrationID string) bool {
 	return ok
 }
 
+// Feed returns the change feed of a running migration, or nil if it is not
+// running or has no feed.
+func (r *Runner) Feed(migrationID string) *feed.Feed {
+	r.mu.Lock()
+	job, ok := r.running[migrationID]
+	r.mu.Unlock()
+	if !ok || job.pipeline == nil {
+		return nil
+	}
+	return job.pipeline.Feed()
+}
+
 func (r *Runner) Status(migrationID string) *pipeline.Progress {
 	r.mu.Lock()
 	job, ok := r.running[migrationID]
@@ -322,6 +750,10 @@ func (r *Runner) Status(migrationID string) *pipeline.Progress {
 	if !ok {
 		return nil
 	}
+	if job.group != nil {
+		p := job.group.Status()
+		return &p
+	}
 	p := job.pipeline.Status()
 	return &p
 }
@@ -333,6 +765,10 @@ func (r *Runner) MetricsSnapshot(migrationID string) *metrics.Snapshot {
 	if !ok {
 		return nil
 	}
+	if job.group != nil {
+		snap := job.group.Snapshot()
+		return &snap
+	}
 	snap := job.pipeline.Metrics.Snapshot()
 	return &snap
 }
@@ -342,34 +778,18 @@ func (r *Runner) run(ctx context.Context, id string, mode Mode, p *pipeline.Pipe
 
 	defer func() {
 		p.Close()
+		r.finish(ctx, id, err)
+	}()
 
-		r.mu.Lock()
-		job, exists := r.running[id]
-		wasSwitchover := exists && job.switchedOver
-		if exists {
-			close(job.done)
-		}
-		r.mu.Unlock()
-
-		if wasSwitchover {
-			return
-		}
+	// Best effort: the advice is informational and must not block the run.
+	if _, aerr := r.adviseUpgrade(ctx, id, p.Config()); aerr != nil {
+		r.logger.Warn().Err(aerr).Str("migration", id).Msg("upgrade advice unavailable")
+	}
 
-		bgCtx := context.Background()
-		if err != nil && ctx.Err() != nil {
-			r.logger.Info().Str("migration", id).Msg("migration stopped by cancellation")
-			r.store.UpdateStatus(bgCtx, id, StatusStopped, "stopped", "")
-		} else if err != nil {
-			r.logger.Err(err).Str("migration", id).Msg("migration failed")
-			r.store.UpdateStatus(bgCtx, id, StatusFailed, "error", err.Error())
-		} else {
-			r.logger.Info().Str("migration", id).Msg("migration completed")
-			r.store.UpdateStatus(bgCtx, id, StatusCompleted, "done", "")
-		}
-		r.cleanup(id)
-	}()
+	r.checkFailover(ctx, id)
 
-	go r.pollProgress(ctx, id, p)
+	pollCtx, stopPoll := context.WithCancel(ctx)
+	go r.pollProgress(pollCtx, id, p)
 
 	switch mode {
 	case ModeCloneOnly:
@@ -381,9 +801,204 @@ func (r *Runner) run(ctx context.Context, id string, mode Mode, p *pipeline.Pipe
 	default:
 		err = fmt.Errorf("unknown mode %q", mode)
 	}
+
+	// A streaming migration on a failover slot resumes on the promoted
+	// standby instead of failing.
+	for attempt := 1; err != nil && attempt <= maxFailovers && canFailover(ctx, mode, p, err); attempt++ {
+		next, lsn, ferr := r.failover(ctx, id, p, err)
+		if ferr != nil {
+			err = fmt.Errorf("failover after %v: %w", err, ferr)
+			break
+		}
+
+		stopPoll()
+		p.Close()
+		p = next
+		r.mu.Lock()
+		if job, ok := r.running[id]; ok {
+			job.pipeline = p
+		}
+		r.mu.Unlock()
+
+		pollCtx, stopPoll = context.WithCancel(ctx)
+		go r.pollProgress(pollCtx, id, p)
+		err = p.RunFollow(ctx, lsn)
+	}
+	stopPoll()
+}
+
+// runGroup runs a consolidating migration. Its sources have no failover.
+func (r *Runner) runGroup(ctx context.Context, id string, mode Mode, g *pipeline.Group) {
+	var err error
+	defer func() {
+		g.Close()
+		r.finish(ctx, id, err)
+	}()
+
+	pollCtx, stopPoll := context.WithCancel(ctx)
+	defer stopPoll()
+	go r.pollProgress(pollCtx, id, g)
+
+	switch mode {
+	case ModeCloneOnly:
+		err = g.RunClone(ctx)
+	case ModeCloneAndFollow, ModeCloneFollowSwitch:
+		err = g.RunCloneAndFollow(ctx)
+	default:
+		err = fmt.Errorf("unknown mode %q", mode)
+	}
+}
+
+// finish records the outcome of a run, unless a switchover ended it, and
+// forgets the job.
+func (r *Runner) finish(ctx context.Context, id string, err error) {
+	r.mu.Lock()
+	job, exists := r.running[id]
+	wasSwitchover := exists && job.switchedOver
+	if exists {
+		close(job.done)
+	}
+	r.mu.Unlock()
+
+	if wasSwitchover {
+		return
+	}
+
+	bgCtx := context.Background()
+	if err != nil && ctx.Err() != nil {
+		r.logger.Info().Str("migration", id).Msg("migration stopped by cancellation")
+		r.store.UpdateStatus(bgCtx, id, StatusStopped, "stopped", "")
+	} else if err != nil {
+		r.logger.Err(err).Str("migration", id).Msg("migration failed")
+		r.store.UpdateStatus(bgCtx, id, StatusFailed, failurePhase(err), err.Error())
+	} else {
+		r.logger.Info().Str("migration", id).Msg("migration completed")
+		r.store.UpdateStatus(bgCtx, id, StatusCompleted, "done", "")
+	}
+	r.cleanup(id)
 }
 
-func (r *Runner) pollProgress(ctx context.Context, id string, p *pipeline.Pipeline) {
+const (
+	maxFailovers = 3
+	failoverWait = 2 * time.Minute
+)
+
+// canFailover reports whether a failed pipeline may resume on a new primary:
+// it was streaming from a slot synchronized to standbys, and the slot
+// itself is still usable.
+func canFailover(ctx context.Context, mode Mode, p *pipeline.Pipeline, err error) bool {
+	if ctx.Err() != nil || mode == ModeCloneOnly || !p.FailoverSlot() {
+		return false
+	}
+	if errors.Is(err, slotguard.ErrSlotLost) || errors.Is(err, slotguard.ErrSlotLimit) {
+		return false
+	}
+	return p.Status().Phase == "streaming"
+}
+
+// failover finds the new primary among the source cluster's nodes and
+// returns a pipeline for it with the LSN to resume streaming from: the last
+// LSN applied to the destination, or the synchronized slot's position if
+// that is further ahead.
+func (r *Runner) failover(ctx context.Context, id string, p *pipeline.Pipeline, cause error) (*pipeline.Pipeline, pglogrepl.LSN, error) {
+	m, ok, err := r.store.Get(ctx, id)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !ok {
+		return nil, 0, fmt.Errorf("migration %q not found", id)
+	}
+	srcCluster, ok, err := r.clusters.Get(ctx, m.SourceClusterID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("get source cluster: %w", err)
+	}
+	if !ok {
+		return nil, 0, fmt.Errorf("source cluster %q not found", m.SourceClusterID)
+	}
+
+	r.logger.Warn().Err(cause).Str("migration", id).Msg("source failed, looking for a new primary")
+	r.store.UpdateStatus(ctx, id, StatusRunning, "failover", "")
+
+	node, err := failover.FindPrimary(ctx, srcCluster.Nodes, failoverWait, r.logger)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	cfg := *p.Config()
+	cfg.Source = config.DatabaseConfig{}
+	if err := cfg.Source.ParseURI(node.DSN()); err != nil {
+		return nil, 0, err
+	}
+	slotLSN, err := failover.SlotPosition(ctx, cfg.Source.DSN(), cfg.Replication.SlotName)
+	if err != nil {
+		return nil, 0, err
+	}
+	lsn := max(p.Status().LastLSN, slotLSN)
+
+	if err := r.store.UpdateSourceNode(ctx, id, node.ID); err != nil {
+		return nil, 0, err
+	}
+	r.logger.Info().
+		Str("migration", id).
+		Str("node", node.ID).
+		Stringer("resume_lsn", lsn).
+		Msg("resuming replication on new source primary")
+
+	return pipeline.New(&cfg, r.logger.With().Str("migration", id).Logger()), lsn, nil
+}
+
+// FailoverReadiness reports whether a migration's replication slot would
+// survive a failover of the source primary.
+func (r *Runner) FailoverReadiness(ctx context.Context, migrationID string) (*failover.Readiness, error) {
+	m, ok, err := r.store.Get(ctx, migrationID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("migration %q not found", migrationID)
+	}
+	srcCluster, ok, err := r.clusters.Get(ctx, m.SourceClusterID)
+	if err != nil {
+		return nil, fmt.Errorf("get source cluster: %w", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("source cluster %q not found", m.SourceClusterID)
+	}
+	cfg, err := r.buildConfig(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+	if err := singleSource(cfg); err != nil {
+		return nil, err
+	}
+	pool, err := openPool(ctx, cfg.Primary(), "source")
+	if err != nil {
+		return nil, err
+	}
+	defer pool.Close()
+
+	return failover.Check(ctx, pool, m.SourceNodeID, srcCluster.Nodes)
+}
+
+// checkFailover logs why the migration would not survive a source
+// failover. It is best effort and never blocks the run.
+func (r *Runner) checkFailover(ctx context.Context, id string) {
+	readiness, err := r.FailoverReadiness(ctx, id)
+	if err != nil {
+		r.logger.Warn().Err(err).Str("migration", id).Msg("failover readiness unavailable")
+		return
+	}
+	for _, w := range readiness.Warnings {
+		r.logger.Warn().Str("migration", id).Msg(w)
+	}
+}
+
+// progressSource is a running Pipeline or Group.
+type progressSource interface {
+	Status() pipeline.Progress
+}
+
+func (r *Runner) pollProgress(ctx context.Context, id string, p progressSource) {
 	ticker := time.NewTicker(2 * time.Second)
 	defer ticker.Stop()
 	for {
@@ -402,6 +1017,16 @@ func (r *Runner) pollProgress(ctx context.Context, id string, p *pipeline.Pipeli
 	}
 }
 
+// failurePhase returns the phase recorded for a failed migration. A lost
+// replication slot cannot be resumed and gets its own phase, so operators
+// know a fresh clone is needed.
+func failurePhase(err error) string {
+	if errors.Is(err, slotguard.ErrSlotLost) {
+		return "reclone_required"
+	}
+	return "error"
+}
+
 func (r *Runner) cleanup(id string) {
 	r.mu.Lock()
 	delete(r.running, id)
@@ -8,6 +8,8 @@ import (
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/jfoltran/pgmanager/internal/migration/upgrade"
 )
 
 type Mode string
@@ -31,27 +33,53 @@ const (
 )
 
 type Migration struct {
-	ID              string     `json:"id"`
-	Name            string     `json:"name"`
-	SourceClusterID string     `json:"source_cluster_id"`
-	DestClusterID   string     `json:"dest_cluster_id"`
-	SourceNodeID    string     `json:"source_node_id"`
-	DestNodeID      string     `json:"dest_node_id"`
-	Mode            Mode       `json:"mode"`
-	Fallback        bool       `json:"fallback"`
-	Status          Status     `json:"status"`
-	Phase           string     `json:"phase"`
-	ErrorMessage    string     `json:"error_message,omitempty"`
-	SlotName        string     `json:"slot_name"`
-	Publication     string     `json:"publication"`
-	CopyWorkers     int        `json:"copy_workers"`
-	ConfirmedLSN    string     `json:"confirmed_lsn,omitempty"`
-	TablesTotal     int        `json:"tables_total"`
-	TablesCopied    int        `json:"tables_copied"`
-	StartedAt       *time.Time `json:"started_at,omitempty"`
-	FinishedAt      *time.Time `json:"finished_at,omitempty"`
-	CreatedAt       time.Time  `json:"created_at"`
-	UpdatedAt       time.Time  `json:"updated_at"`
+	ID                   string          `json:"id"`
+	Name                 string          `json:"name"`
+	SourceClusterID      string          `json:"source_cluster_id"`
+	DestClusterID        string          `json:"dest_cluster_id"`
+	SourceNodeID         string          `json:"source_node_id"`
+	DestNodeID           string          `json:"dest_node_id"`
+	ReadNodeID           string          `json:"read_node_id,omitempty"`
+	Mode                 Mode            `json:"mode"`
+	Fallback             bool            `json:"fallback"`
+	Status               Status          `json:"status"`
+	Phase                string          `json:"phase"`
+	ErrorMessage         string          `json:"error_message,omitempty"`
+	SlotName             string          `json:"slot_name"`
+	Publication          string          `json:"publication"`
+	OutputPlugin         string          `json:"output_plugin"`
+	CopyWorkers          int             `json:"copy_workers"`
+	MigrateRoles         bool            `json:"migrate_roles"`
+	AllowMissingIdentity bool            `json:"allow_missing_identity"`
+	ReindexCollations    bool            `json:"reindex_collations"`
+	Feed                 bool            `json:"feed"`
+	SlotWarnBytes        int64           `json:"slot_warn_bytes"`
+	SlotWarnSafeBytes    int64           `json:"slot_warn_safe_bytes"`
+	SlotMaxBytes         int64           `json:"slot_max_bytes"`
+	SlotDropOnLimit      bool            `json:"slot_drop_on_limit"`
+	UpgradeAdvice        *upgrade.Advice `json:"upgrade_advice,omitempty"`
+	ConfirmedLSN         string          `json:"confirmed_lsn,omitempty"`
+	TablesTotal          int             `json:"tables_total"`
+	TablesCopied         int             `json:"tables_copied"`
+	StartedAt            *time.Time      `json:"started_at,omitempty"`
+	FinishedAt           *time.Time      `json:"finished_at,omitempty"`
+	CreatedAt            time.Time       `json:"created_at"`
+	UpdatedAt            time.Time       `json:"updated_at"`
+
+	// Sources, when set, consolidates several source nodes into the
+	// destination; SourceClusterID and SourceNodeID then mirror the first.
+	Sources           []MigrationSource `json:"sources,omitempty"`
+	ConsolidateColumn string            `json:"consolidate_column,omitempty"`
+}
+
+// MigrationSource is one source of a consolidating migration.
+type MigrationSource struct {
+	Name      string `json:"name"`
+	ClusterID string `json:"cluster_id"`
+	NodeID    string `json:"node_id"`
+	// Schema is the destination schema of the source's public schema;
+	// it defaults to Name.
+	Schema string `json:"schema,omitempty"`
 }
 
 type Store struct {
@@ -64,9 +92,11 @@ func NewStore(pool *pgxpool.Pool) *Store {
 
 func (s *Store) List(ctx context.Context) ([]Migration, error) {
 	rows, err := s.pool.Query(ctx, `
-		SELECT id, name, source_cluster_id, dest_cluster_id, source_node_id, dest_node_id,
-		       mode, fallback, status, phase, error_message, slot_name, publication, copy_workers,
-		       confirmed_lsn, tables_total, tables_copied,
+		SELECT id, name, source_cluster_id, dest_cluster_id, source_node_id, dest_node_id, read_node_id,
+		       mode, fallback, status, phase, error_message, slot_name, publication, output_plugin, copy_workers,
+		       migrate_roles, allow_missing_identity, reindex_collations, feed,
+		       slot_warn_bytes, slot_warn_safe_bytes, slot_max_bytes, slot_drop_on_limit,
+		       upgrade_advice, sources, consolidate_column, confirmed_lsn, tables_total, tables_copied,
 		       started_at, finished_at, created_at, updated_at
 		FROM migrations ORDER BY created_at DESC
 	`)
@@ -91,9 +121,11 @@ func (s *Store) List(ctx context.Context) ([]Migration, error) {
 
 func (s *Store) Get(ctx context.Context, id string) (Migration, bool, error) {
 	rows, err := s.pool.Query(ctx, `
-		SELECT id, name, source_cluster_id, dest_cluster_id, source_node_id, dest_node_id,
-		       mode, fallback, status, phase, error_message, slot_name, publication, copy_workers,
-		       confirmed_lsn, tables_total, tables_copied,
+		SELECT id, name, source_cluster_id, dest_cluster_id, source_node_id, dest_node_id, read_node_id,
+		       mode, fallback, status, phase, error_message, slot_name, publication, output_plugin, copy_workers,
+		       migrate_roles, allow_missing_identity, reindex_collations, feed,
+		       slot_warn_bytes, slot_warn_safe_bytes, slot_max_bytes, slot_drop_on_limit,
+		       upgrade_advice, sources, consolidate_column, confirmed_lsn, tables_total, tables_copied,
 		       started_at, finished_at, created_at, updated_at
 		FROM migrations WHERE id = $1
 	`, id)
@@ -114,11 +146,17 @@ func (s *Store) Get(ctx context.Context, id string) (Migration, bool, error) {
 
 func (s *Store) Create(ctx context.Context, m Migration) error {
 	_, err := s.pool.Exec(ctx, `
-		INSERT INTO migrations (id, name, source_cluster_id, dest_cluster_id, source_node_id, dest_node_id,
-		                        mode, fallback, status, slot_name, publication, copy_workers)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
-	`, m.ID, m.Name, m.SourceClusterID, m.DestClusterID, m.SourceNodeID, m.DestNodeID,
-		m.Mode, m.Fallback, StatusCreated, m.SlotName, m.Publication, m.CopyWorkers)
+		INSERT INTO migrations (id, name, source_cluster_id, dest_cluster_id, source_node_id, dest_node_id, read_node_id,
+		                        mode, fallback, status, slot_name, publication, output_plugin, copy_workers, migrate_roles,
+		                        allow_missing_identity, reindex_collations, feed,
+		                        slot_warn_bytes, slot_warn_safe_bytes, slot_max_bytes, slot_drop_on_limit,
+		                        sources, consolidate_column)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24)
+	`, m.ID, m.Name, m.SourceClusterID, m.DestClusterID, m.SourceNodeID, m.DestNodeID, m.ReadNodeID,
+		m.Mode, m.Fallback, StatusCreated, m.SlotName, m.Publication, m.OutputPlugin, m.CopyWorkers, m.MigrateRoles,
+		m.AllowMissingIdentity, m.ReindexCollations, m.Feed,
+		m.SlotWarnBytes, m.SlotWarnSafeBytes, m.SlotMaxBytes, m.SlotDropOnLimit,
+		m.Sources, m.ConsolidateColumn)
 	if err != nil {
 		return fmt.Errorf("create migration: %w", err)
 	}
@@ -167,6 +205,35 @@ func (s *Store) UpdateProgress(ctx context.Context, id string, phase string, lsn
 	return nil
 }
 
+// UpdateSourceNode points a migration at a new source node, after the
+// source primary failed over.
+func (s *Store) UpdateSourceNode(ctx context.Context, id, nodeID string) error {
+	tag, err := s.pool.Exec(ctx, `
+		UPDATE migrations SET source_node_id = $2, updated_at = now() WHERE id = $1
+	`, id, nodeID)
+	if err != nil {
+		return fmt.Errorf("update source node: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return errors.New("migration not found")
+	}
+	return nil
+}
+
+// UpdateUpgradeAdvice records the latest upgrade advice for a migration.
+func (s *Store) UpdateUpgradeAdvice(ctx context.Context, id string, advice *upgrade.Advice) error {
+	tag, err := s.pool.Exec(ctx, `
+		UPDATE migrations SET upgrade_advice = $2, updated_at = now() WHERE id = $1
+	`, id, advice)
+	if err != nil {
+		return fmt.Errorf("update upgrade advice: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return errors.New("migration not found")
+	}
+	return nil
+}
+
 func (s *Store) Delete(ctx context.Context, id string) error {
 	tag, err := s.pool.Exec(ctx, `DELETE FROM migrations WHERE id = $1`, id)
 	if err != nil {
@@ -181,9 +248,11 @@ func (s *Store) Delete(ctx context.Context, id string) error {
 func scanMigration(rows pgx.Rows) (Migration, error) {
 	var m Migration
 	err := rows.Scan(
-		&m.ID, &m.Name, &m.SourceClusterID, &m.DestClusterID, &m.SourceNodeID, &m.DestNodeID,
-		&m.Mode, &m.Fallback, &m.Status, &m.Phase, &m.ErrorMessage, &m.SlotName, &m.Publication, &m.CopyWorkers,
-		&m.ConfirmedLSN, &m.TablesTotal, &m.TablesCopied,
+		&m.ID, &m.Name, &m.SourceClusterID, &m.DestClusterID, &m.SourceNodeID, &m.DestNodeID, &m.ReadNodeID,
+		&m.Mode, &m.Fallback, &m.Status, &m.Phase, &m.ErrorMessage, &m.SlotName, &m.Publication, &m.OutputPlugin, &m.CopyWorkers,
+		&m.MigrateRoles, &m.AllowMissingIdentity, &m.ReindexCollations, &m.Feed,
+		&m.SlotWarnBytes, &m.SlotWarnSafeBytes, &m.SlotMaxBytes, &m.SlotDropOnLimit,
+		&m.UpgradeAdvice, &m.Sources, &m.ConsolidateColumn, &m.ConfirmedLSN, &m.TablesTotal, &m.TablesCopied,
 		&m.StartedAt, &m.FinishedAt, &m.CreatedAt, &m.UpdatedAt,
 	)
 	if err != nil {
@@ -215,10 +284,55 @@ func ValidateMigration(m Migration) error {
 	if m.DestNodeID == "" {
 		errs = append(errs, errors.New("destination node is required"))
 	}
+	if m.ReadNodeID != "" && m.ReadNodeID == m.SourceNodeID {
+		errs = append(errs, errors.New("read node must be a standby, not the source node"))
+	}
 	switch m.Mode {
 	case ModeCloneOnly, ModeCloneAndFollow, ModeCloneFollowSwitch:
 	default:
 		errs = append(errs, fmt.Errorf("invalid mode %q", m.Mode))
 	}
+	if m.SlotDropOnLimit && m.SlotMaxBytes <= 0 {
+		errs = append(errs, errors.New("slot drop on limit requires slot max bytes"))
+	}
+	switch m.OutputPlugin {
+	case "", "pgoutput", "wal2json", "test_decoding":
+	default:
+		errs = append(errs, fmt.Errorf("unsupported output plugin %q", m.OutputPlugin))
+	}
+	if len(m.Sources) > 0 {
+		errs = append(errs, validateSources(m)...)
+	}
 	return errors.Join(errs...)
 }
+
+func validateSources(m Migration) []error {
+	var errs []error
+	if len(m.Sources) < 2 {
+		errs = append(errs, errors.New("a consolidating migration needs at least two sources"))
+	}
+	if m.Fallback || m.ReadNodeID != "" {
+		errs = append(errs, errors.New("fallback and a read node are not supported with several sources"))
+	}
+	if m.ReindexCollations {
+		errs = append(errs, errors.New("collation reindexing is not supported with several sources"))
+	}
+	if m.SourceClusterID != m.Sources[0].ClusterID || m.SourceNodeID != m.Sources[0].NodeID {
+		errs = append(errs, errors.New("source cluster and node must match the first source"))
+	}
+	names := make(map[string]bool)
+	for _, s := range m.Sources {
+		if s.Name == "" || s.ClusterID == "" || s.NodeID == "" {
+			errs = append(errs, errors.New("every source requires a name, cluster and node"))
+			continue
+		}
+		if names[s.Name] {
+			errs = append(errs, fmt.Errorf("duplicate source %q", s.Name))
+		}
+		names[s.Name] = true
+		if s.ClusterID == m.DestClusterID && s.NodeID == m.DestNodeID {
+			errs = append(errs, fmt.Errorf("source %q cannot be the destination node", s.Name))
+		}
+	}
+	return errs
+}
@@ -137,6 +137,54 @@ func TestValidateMigration(t *testing.T) {
 		}
 	})
 
+	t.Run("read node same as source node", func(t *testing.T) {
+		m := valid
+		m.ReadNodeID = m.SourceNodeID
+		err := ValidateMigration(m)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if !strings.Contains(err.Error(), "read node must be a standby") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("slot drop without limit", func(t *testing.T) {
+		m := valid
+		m.SlotDropOnLimit = true
+		err := ValidateMigration(m)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if !strings.Contains(err.Error(), "slot drop on limit requires slot max bytes") {
+			t.Errorf("unexpected error: %v", err)
+		}
+		m.SlotMaxBytes = 1 << 30
+		if err := ValidateMigration(m); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("unsupported output plugin", func(t *testing.T) {
+		m := valid
+		m.OutputPlugin = "decoderbufs"
+		err := ValidateMigration(m)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if !strings.Contains(err.Error(), "unsupported output plugin") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("wal2json plugin", func(t *testing.T) {
+		m := valid
+		m.OutputPlugin = "wal2json"
+		if err := ValidateMigration(m); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
 	t.Run("invalid mode", func(t *testing.T) {
 		m := valid
 		m.Mode = "invalid_mode"
@@ -182,6 +230,40 @@ func TestValidateMigration(t *testing.T) {
 			}
 		}
 	})
+
+	t.Run("valid sources", func(t *testing.T) {
+		m := valid
+		m.Sources = []MigrationSource{
+			{Name: "acme", ClusterID: "src-cluster", NodeID: "src-node"},
+			{Name: "globex", ClusterID: "other-cluster", NodeID: "other-node"},
+		}
+		if err := ValidateMigration(m); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("invalid sources", func(t *testing.T) {
+		m := valid
+		m.Fallback = true
+		m.Sources = []MigrationSource{
+			{Name: "acme", ClusterID: "other-cluster", NodeID: "other-node"},
+			{Name: "acme", ClusterID: "dst-cluster", NodeID: "dst-node"},
+		}
+		err := ValidateMigration(m)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		for _, want := range []string{
+			"fallback and a read node",
+			"must match the first source",
+			"duplicate source",
+			"cannot be the destination node",
+		} {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("error %q missing expected message: %q", err, want)
+			}
+		}
+	})
 }
 
 func TestModeConstants(t *testing.T) {
@@ -5,10 +5,12 @@ import (
 	"net/http"
 
 	"github.com/jfoltran/pgmanager/internal/cluster"
+	ms "github.com/jfoltran/pgmanager/internal/migrationstore"
 )
 
 type clusterHandlers struct {
-	store *cluster.Store
+	store      *cluster.Store
+	migrations *ms.Store
 }
 
 func (ch *clusterHandlers) list(w http.ResponseWriter, r *http.Request) {
@@ -192,3 +194,114 @@ func (ch *clusterHandlers) introspect(w http.ResponseWriter, r *http.Request) {
 	}
 	writeJSON(w, info)
 }
+
+// inventory lists the replication objects of a cluster and attributes them
+// to the registered migrations.
+func (ch *clusterHandlers) inventory(r *http.Request, c cluster.Cluster) (cluster.ReplicationInventory, error) {
+	inv := cluster.Replication(r.Context(), c)
+	var migrations []ms.Migration
+	if ch.migrations != nil {
+		var err error
+		migrations, err = ch.migrations.List(r.Context())
+		if err != nil {
+			return inv, err
+		}
+	}
+	ms.AttributeReplication(&inv, migrations)
+	return inv, nil
+}
+
+func (ch *clusterHandlers) replication(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	c, ok, err := ch.store.Get(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "cluster not found", http.StatusNotFound)
+		return
+	}
+
+	inv, err := ch.inventory(r, c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, inv)
+}
+
+type dropOrphansRequest struct {
+	Confirm bool     `json:"confirm"`
+	Node    string   `json:"node"`
+	Slots   []string `json:"slots"`
+}
+
+func (ch *clusterHandlers) dropOrphans(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	var req dropOrphansRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !req.Confirm {
+		http.Error(w, "dropping slots is irreversible; resend with \"confirm\": true", http.StatusBadRequest)
+		return
+	}
+	if req.Node == "" || len(req.Slots) == 0 {
+		http.Error(w, "node and slots are required", http.StatusBadRequest)
+		return
+	}
+
+	c, ok, err := ch.store.Get(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "cluster not found", http.StatusNotFound)
+		return
+	}
+	var node *cluster.Node
+	for i := range c.Nodes {
+		if c.Nodes[i].ID == req.Node {
+			node = &c.Nodes[i]
+			break
+		}
+	}
+	if node == nil {
+		http.Error(w, "node not found", http.StatusNotFound)
+		return
+	}
+
+	// Re-check against a fresh inventory so a slot that became active or
+	// was claimed by a migration since it was listed is left alone.
+	inv, err := ch.inventory(r, cluster.Cluster{ID: c.ID, Nodes: []cluster.Node{*node}})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if inv.Nodes[0].Error != "" {
+		http.Error(w, inv.Nodes[0].Error, http.StatusBadGateway)
+		return
+	}
+	orphans := ms.Orphans(inv, node.ID)
+
+	dropped := []string{}
+	failed := map[string]string{}
+	for _, slot := range req.Slots {
+		if !orphans[slot] {
+			failed[slot] = "not an orphaned slot"
+			continue
+		}
+		if err := cluster.DropInactiveSlot(r.Context(), *node, slot); err != nil {
+			failed[slot] = err.Error()
+			continue
+		}
+		dropped = append(dropped, slot)
+	}
+
+	writeJSON(w, map[string]any{"ok": len(failed) == 0, "dropped": dropped, "errors": failed})
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/jackc/pglogrepl"
+	"github.com/rs/zerolog"
+
+	"github.com/jfoltran/pgmanager/internal/migration/feed"
+)
+
+const feedHeartbeat = 15 * time.Second
+
+// feedHandlers serves the live change feed of the running pipeline over
+// Server-Sent Events and WebSocket.
+type feedHandlers struct {
+	// feed returns the feed the request asks for, or nil if it is not
+	// running.
+	feed   func(*http.Request) *feed.Feed
+	tokens []string
+	logger zerolog.Logger
+}
+
+// authorize checks the bearer token, from the Authorization header or the
+// token query parameter for clients that cannot set headers.
+func (fh *feedHandlers) authorize(w http.ResponseWriter, r *http.Request) bool {
+	if len(fh.tokens) == 0 {
+		http.Error(w, "change feed is disabled: no tokens configured", http.StatusForbidden)
+		return false
+	}
+	token := r.URL.Query().Get("token")
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		token = strings.TrimPrefix(h, "Bearer ")
+	}
+	for _, t := range fh.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			return true
+		}
+	}
+	w.Header().Set("WWW-Authenticate", `Bearer realm="pgmanager"`)
+	http.Error(w, "invalid or missing token", http.StatusUnauthorized)
+	return false
+}
+
+// subscribe authorizes the request and subscribes with its from, tables
+// and ops parameters. lastEventID, if set, overrides from.
+func (fh *feedHandlers) subscribe(w http.ResponseWriter, r *http.Request, lastEventID string) (*feed.Subscription, bool) {
+	if !fh.authorize(w, r) {
+		return nil, false
+	}
+	q := r.URL.Query()
+	filter, err := feed.ParseFilter(q.Get("tables"), q.Get("ops"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	var from pglogrepl.LSN
+	if s := q.Get("from"); lastEventID != "" || s != "" {
+		if lastEventID != "" {
+			s = lastEventID
+		}
+		if from, err = pglogrepl.ParseLSN(s); err != nil {
+			http.Error(w, fmt.Sprintf("invalid LSN %q", s), http.StatusBadRequest)
+			return nil, false
+		}
+	}
+
+	f := fh.feed(r)
+	if f == nil {
+		http.Error(w, "no running migration with a change feed", http.StatusNotFound)
+		return nil, false
+	}
+	sub, err := f.Subscribe(from, filter)
+	switch {
+	case errors.Is(err, feed.ErrExpired):
+		http.Error(w, err.Error(), http.StatusGone)
+		return nil, false
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return nil, false
+	}
+	return sub, true
+}
+
+// sse streams transactions as Server-Sent Events. Each event's id is the
+// commit LSN, so a reconnecting EventSource resumes where it left off.
+func (fh *feedHandlers) sse(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	sub, ok := fh.subscribe(w, r, r.Header.Get("Last-Event-ID"))
+	if !ok {
+		return
+	}
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		txn, err := fh.next(r.Context(), sub, func() error {
+			_, err := fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+			return err
+		})
+		if err != nil {
+			if r.Context().Err() == nil {
+				fmt.Fprintf(w, "event: error\ndata: %s\n\n", err) //nolint:errcheck
+				flusher.Flush()
+			}
+			return
+		}
+		data, err := json.Marshal(txn)
+		if err != nil {
+			fh.logger.Err(err).Msg("marshal feed transaction")
+			return
+		}
+		if _, err := fmt.Fprintf(w, "id: %s\nevent: transaction\ndata: %s\n\n", txn.CommitLSN, data); err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// ws streams transactions as WebSocket text messages. A failed
+// subscription closes the connection with the error as the reason.
+func (fh *feedHandlers) ws(w http.ResponseWriter, r *http.Request) {
+	sub, ok := fh.subscribe(w, r, "")
+	if !ok {
+		return
+	}
+	defer sub.Close()
+
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		fh.logger.Err(err).Msg("feed ws accept")
+		return
+	}
+	defer conn.CloseNow() //nolint:errcheck
+	// Incoming messages are ignored; CloseRead ends ctx when the client
+	// goes away.
+	ctx := conn.CloseRead(r.Context())
+
+	for {
+		txn, err := fh.next(ctx, sub, func() error {
+			pctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+			defer cancel()
+			return conn.Ping(pctx)
+		})
+		if err != nil {
+			if ctx.Err() == nil {
+				conn.Close(websocket.StatusTryAgainLater, err.Error()) //nolint:errcheck
+			}
+			return
+		}
+		data, err := json.Marshal(txn)
+		if err != nil {
+			fh.logger.Err(err).Msg("marshal feed transaction")
+			return
+		}
+		wctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		err = conn.Write(wctx, websocket.MessageText, data)
+		cancel()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// next waits for the next transaction, calling heartbeat whenever the
+// subscription stays idle for feedHeartbeat.
+func (fh *feedHandlers) next(ctx context.Context, sub *feed.Subscription, heartbeat func() error) (*feed.Transaction, error) {
+	for {
+		nctx, cancel := context.WithTimeout(ctx, feedHeartbeat)
+		txn, err := sub.Next(nctx)
+		cancel()
+		if err == nil || !errors.Is(err, context.DeadlineExceeded) || ctx.Err() != nil {
+			return txn, err
+		}
+		if err := heartbeat(); err != nil {
+			return nil, err
+		}
+	}
+}
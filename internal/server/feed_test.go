@@ -0,0 +1,150 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/jackc/pglogrepl"
+	"github.com/rs/zerolog"
+
+	"github.com/jfoltran/pgmanager/internal/migration/feed"
+	"github.com/jfoltran/pgmanager/internal/migration/stream"
+	ms "github.com/jfoltran/pgmanager/internal/migrationstore"
+)
+
+func feedTxn(lsn pglogrepl.LSN) *feed.Transaction {
+	return &feed.Transaction{XID: uint32(lsn), CommitLSN: lsn, Changes: []*stream.ChangeMessage{{
+		Op: stream.OpInsert, Namespace: "public", Table: "users",
+		NewTuple: &stream.TupleData{Columns: []stream.Column{{Name: "id", Value: []byte("1")}}},
+	}}}
+}
+
+func newFeedServer(t *testing.T, f *feed.Feed, tokens ...string) *httptest.Server {
+	t.Helper()
+	fh := &feedHandlers{feed: func(*http.Request) *feed.Feed { return f }, tokens: tokens, logger: zerolog.Nop()}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/feed", fh.sse)
+	mux.HandleFunc("GET /api/v1/feed/ws", fh.ws)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestFeedAuthAndErrors(t *testing.T) {
+	f := feed.New(feed.Options{MaxTransactions: 1}, zerolog.Nop())
+	defer f.Close()
+	f.Publish(feedTxn(10))
+	f.Publish(feedTxn(20))
+
+	tests := []struct {
+		name   string
+		tokens []string
+		feed   *feed.Feed
+		query  string
+		want   int
+	}{
+		{"no tokens configured", nil, f, "?token=x", http.StatusForbidden},
+		{"bad token", []string{"secret"}, f, "?token=x", http.StatusUnauthorized},
+		{"no feed", []string{"secret"}, nil, "?token=secret", http.StatusNotFound},
+		{"bad op", []string{"secret"}, f, "?token=secret&ops=upsert", http.StatusBadRequest},
+		{"expired", []string{"secret"}, f, "?token=secret&from=0/1", http.StatusGone},
+	}
+	for _, tt := range tests {
+		srv := newFeedServer(t, tt.feed, tt.tokens...)
+		resp, err := http.Get(srv.URL + "/api/v1/feed" + tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, resp.StatusCode, tt.want)
+		}
+	}
+}
+
+func TestMigrationFeedNotRunning(t *testing.T) {
+	s := &Server{migRunner: ms.NewRunner(context.Background(), nil, nil, zerolog.Nop())}
+	fh := &feedHandlers{feed: s.migrationFeed, tokens: []string{"secret"}, logger: zerolog.Nop()}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/migrations/{id}/feed", fh.sse)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/api/v1/migrations/m1/feed?token=secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("status = %d, want 404 for a migration that is not running", resp.StatusCode)
+	}
+}
+
+func TestFeedSSE(t *testing.T) {
+	f := feed.New(feed.Options{}, zerolog.Nop())
+	defer f.Close()
+	f.Publish(feedTxn(10))
+	f.Publish(feedTxn(20))
+	srv := newFeedServer(t, f, "secret")
+
+	req, _ := http.NewRequest("GET", srv.URL+"/api/v1/feed?from=0/1&tables=users", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Last-Event-ID", "0/A")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	// Last-Event-ID resumes after 0/A, so the first event is 0/14.
+	sc := bufio.NewScanner(resp.Body)
+	var lines []string
+	for sc.Scan() && sc.Text() != "" {
+		lines = append(lines, sc.Text())
+	}
+	if len(lines) != 3 || lines[0] != "id: 0/14" || lines[1] != "event: transaction" ||
+		!strings.Contains(lines[2], `"commit_lsn":"0/14"`) {
+		t.Errorf("event = %q", lines)
+	}
+}
+
+func TestFeedWebSocket(t *testing.T) {
+	f := feed.New(feed.Options{}, zerolog.Nop())
+	defer f.Close()
+	srv := newFeedServer(t, f, "secret")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, _, err := websocket.Dial(ctx, srv.URL+"/api/v1/feed/ws?token=secret&ops=insert", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.CloseNow() //nolint:errcheck
+
+	// The subscription exists once the handshake completes.
+	f.Publish(feedTxn(30))
+	_, data, err := conn.Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"commit_lsn":"0/1E"`) {
+		t.Errorf("message = %s", data)
+	}
+
+	f.Close()
+	if _, _, err := conn.Read(ctx); websocket.CloseStatus(err) != websocket.StatusTryAgainLater {
+		t.Errorf("after Close: %v, want StatusTryAgainLater", err)
+	}
+}
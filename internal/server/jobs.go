@@ -1,16 +1,57 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/jackc/pglogrepl"
+
 	"github.com/jfoltran/pgmanager/internal/config"
 	"github.com/jfoltran/pgmanager/internal/daemon"
+	"github.com/jfoltran/pgmanager/internal/migration/capture"
 )
 
 type jobHandlers struct {
-	jobs *daemon.JobManager
+	jobs        *daemon.JobManager
+	captureRoot string
+}
+
+// capturePath resolves a capture directory or segment path from a request
+// against the capture root. Relative paths are taken from the root, and
+// paths that leave it are rejected.
+func (jh *jobHandlers) capturePath(path string) (string, error) {
+	if jh.captureRoot == "" {
+		return "", fmt.Errorf("no capture root is configured")
+	}
+	root := filepath.Clean(jh.captureRoot)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	path = filepath.Clean(path)
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside the capture root %s", path, root)
+	}
+	return path, nil
+}
+
+// setCaptureDir sets cfg.Capture.Dir from a request, keeping it under the
+// capture root. An empty dir leaves capture off.
+func (jh *jobHandlers) setCaptureDir(cfg *config.Config, dir string) error {
+	if dir == "" {
+		return nil
+	}
+	path, err := jh.capturePath(dir)
+	if err != nil {
+		return fmt.Errorf("capture_dir: %w", err)
+	}
+	cfg.Capture.Dir = path
+	return nil
 }
 
 func (jh *jobHandlers) submitClone(w http.ResponseWriter, r *http.Request) {
@@ -23,6 +64,54 @@ func (jh *jobHandlers) submitClone(w http.ResponseWriter, r *http.Request) {
 	}
 
 	cfg := buildConfig(payload.SourceURI, payload.DestURI, payload.SlotName, payload.Publication, payload.Workers)
+	cfg.Roles.Enabled = payload.Roles
+	cfg.Replication.AllowMissingIdentity = payload.AllowMissingIdentity
+	cfg.Snapshot.ReindexCollations = payload.ReindexCollations
+	cfg.Replication.SlotWarnBytes = payload.SlotWarnBytes
+	cfg.Replication.SlotWarnSafeBytes = payload.SlotWarnSafeBytes
+	cfg.Replication.SlotMaxBytes = payload.SlotMaxBytes
+	cfg.Replication.SlotDropOnLimit = payload.SlotDropOnLimit
+	setSourcePrimary(cfg, payload.SourcePrimaryURI)
+	if payload.OutputPlugin != "" {
+		cfg.Replication.OutputPlugin = payload.OutputPlugin
+	}
+	cfg.Capture = config.CaptureConfig{
+		Dir:          payload.CaptureDir,
+		SegmentBytes: payload.CaptureSegmentBytes,
+		MaxSegments:  payload.CaptureMaxSegments,
+	}
+	cfg.Spool = config.SpoolConfig{
+		Dir:          payload.SpoolDir,
+		MaxBytes:     payload.SpoolMaxBytes,
+		SegmentBytes: payload.SpoolSegmentBytes,
+	}
+	cfg.Memory.BudgetBytes = payload.MemoryBudgetBytes
+	cfg.Sink = config.SinkConfig{
+		Type:         payload.SinkType,
+		Dir:          payload.SinkDir,
+		SegmentBytes: payload.SinkSegmentBytes,
+		MaxSegments:  payload.SinkMaxSegments,
+		Path:         payload.SinkPath,
+		ServerName:   payload.SinkServerName,
+		URL:          payload.SinkURL,
+		BatchSize:    payload.SinkBatchSize,
+		Linger:       time.Duration(payload.SinkLingerMs) * time.Millisecond,
+		MaxRetries:   payload.SinkMaxRetries,
+	}
+	cfg.Feed = config.FeedConfig{
+		Enabled:         payload.Feed,
+		MaxTransactions: payload.FeedMaxTransactions,
+		MaxBytes:        payload.FeedMaxBytes,
+	}
+	setFanout(cfg, payload.Destinations, payload.FanoutPolicy)
+	setSharding(cfg, payload.ShardTables)
+	setRemap(cfg, payload.Remap)
+	if err := jh.setCaptureDir(cfg, payload.CaptureDir); err != nil {
+		writeJobResponse(w, http.StatusBadRequest, daemon.JobResponse{
+			Error: err.Error(),
+		})
+		return
+	}
 	if err := cfg.Validate(); err != nil {
 		writeJobResponse(w, http.StatusBadRequest, daemon.JobResponse{
 			Error: "invalid config: " + err.Error(),
@@ -59,6 +148,52 @@ func (jh *jobHandlers) submitFollow(w http.ResponseWriter, r *http.Request) {
 	}
 
 	cfg := buildConfig(payload.SourceURI, payload.DestURI, payload.SlotName, payload.Publication, 0)
+	cfg.Replication.AllowMissingIdentity = payload.AllowMissingIdentity
+	cfg.Replication.SlotWarnBytes = payload.SlotWarnBytes
+	cfg.Replication.SlotWarnSafeBytes = payload.SlotWarnSafeBytes
+	cfg.Replication.SlotMaxBytes = payload.SlotMaxBytes
+	cfg.Replication.SlotDropOnLimit = payload.SlotDropOnLimit
+	setSourcePrimary(cfg, payload.SourcePrimaryURI)
+	if payload.OutputPlugin != "" {
+		cfg.Replication.OutputPlugin = payload.OutputPlugin
+	}
+	cfg.Capture = config.CaptureConfig{
+		Dir:          payload.CaptureDir,
+		SegmentBytes: payload.CaptureSegmentBytes,
+		MaxSegments:  payload.CaptureMaxSegments,
+	}
+	cfg.Spool = config.SpoolConfig{
+		Dir:          payload.SpoolDir,
+		MaxBytes:     payload.SpoolMaxBytes,
+		SegmentBytes: payload.SpoolSegmentBytes,
+	}
+	cfg.Memory.BudgetBytes = payload.MemoryBudgetBytes
+	cfg.Sink = config.SinkConfig{
+		Type:         payload.SinkType,
+		Dir:          payload.SinkDir,
+		SegmentBytes: payload.SinkSegmentBytes,
+		MaxSegments:  payload.SinkMaxSegments,
+		Path:         payload.SinkPath,
+		ServerName:   payload.SinkServerName,
+		URL:          payload.SinkURL,
+		BatchSize:    payload.SinkBatchSize,
+		Linger:       time.Duration(payload.SinkLingerMs) * time.Millisecond,
+		MaxRetries:   payload.SinkMaxRetries,
+	}
+	cfg.Feed = config.FeedConfig{
+		Enabled:         payload.Feed,
+		MaxTransactions: payload.FeedMaxTransactions,
+		MaxBytes:        payload.FeedMaxBytes,
+	}
+	setFanout(cfg, payload.Destinations, payload.FanoutPolicy)
+	setSharding(cfg, payload.ShardTables)
+	setRemap(cfg, payload.Remap)
+	if err := jh.setCaptureDir(cfg, payload.CaptureDir); err != nil {
+		writeJobResponse(w, http.StatusBadRequest, daemon.JobResponse{
+			Error: err.Error(),
+		})
+		return
+	}
 	if err := cfg.Validate(); err != nil {
 		writeJobResponse(w, http.StatusBadRequest, daemon.JobResponse{
 			Error: "invalid config: " + err.Error(),
@@ -114,6 +249,70 @@ func (jh *jobHandlers) submitSwitchover(w http.ResponseWriter, r *http.Request)
 	})
 }
 
+func (jh *jobHandlers) submitReplay(w http.ResponseWriter, r *http.Request) {
+	var payload daemon.ReplayPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeJobResponse(w, http.StatusBadRequest, daemon.JobResponse{
+			Error: "invalid request body: " + err.Error(),
+		})
+		return
+	}
+	if payload.CapturePath == "" {
+		writeJobResponse(w, http.StatusBadRequest, daemon.JobResponse{
+			Error: "capture_path is required",
+		})
+		return
+	}
+	if payload.DestURI == "" {
+		writeJobResponse(w, http.StatusBadRequest, daemon.JobResponse{
+			Error: "dest_uri is required",
+		})
+		return
+	}
+	path, err := jh.capturePath(payload.CapturePath)
+	if err != nil {
+		writeJobResponse(w, http.StatusBadRequest, daemon.JobResponse{
+			Error: "capture_path: " + err.Error(),
+		})
+		return
+	}
+
+	var opts capture.ReplayOptions
+	if opts.FromLSN, err = parseOptionalLSN(payload.FromLSN); err != nil {
+		writeJobResponse(w, http.StatusBadRequest, daemon.JobResponse{
+			Error: "invalid from_lsn: " + err.Error(),
+		})
+		return
+	}
+	if opts.ToLSN, err = parseOptionalLSN(payload.ToLSN); err != nil {
+		writeJobResponse(w, http.StatusBadRequest, daemon.JobResponse{
+			Error: "invalid to_lsn: " + err.Error(),
+		})
+		return
+	}
+	if opts.ToLSN != 0 && opts.ToLSN < opts.FromLSN {
+		writeJobResponse(w, http.StatusBadRequest, daemon.JobResponse{
+			Error: "to_lsn is before from_lsn",
+		})
+		return
+	}
+
+	cfg := buildConfig("", payload.DestURI, "", "", 0)
+
+	// The job outlives this request, so it must not inherit its cancellation.
+	if err := jh.jobs.RunReplay(context.WithoutCancel(r.Context()), cfg.Dest, path, opts); err != nil {
+		writeJobResponse(w, http.StatusConflict, daemon.JobResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	writeJobResponse(w, http.StatusAccepted, daemon.JobResponse{
+		OK:      true,
+		Message: "replay started",
+	})
+}
+
 func (jh *jobHandlers) stopJob(w http.ResponseWriter, r *http.Request) {
 	if err := jh.jobs.StopJob(); err != nil {
 		writeJobResponse(w, http.StatusConflict, daemon.JobResponse{
@@ -136,10 +335,21 @@ func (jh *jobHandlers) jobStatus(w http.ResponseWriter, r *http.Request) {
 		if err := jh.jobs.LastError(); err != nil {
 			resp["last_error"] = err.Error()
 		}
+		if res := jh.jobs.LastReplay(); res != nil {
+			resp["replay"] = res
+		}
 	}
 	writeJSON(w, resp)
 }
 
+// parseOptionalLSN parses an LSN such as "0/16B3748"; empty means 0.
+func parseOptionalLSN(s string) (pglogrepl.LSN, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return pglogrepl.ParseLSN(s)
+}
+
 func writeJobResponse(w http.ResponseWriter, status int, resp daemon.JobResponse) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -147,6 +357,52 @@ func writeJobResponse(w http.ResponseWriter, status int, resp daemon.JobResponse
 	json.NewEncoder(w).Encode(resp) //nolint:errcheck
 }
 
+// setSourcePrimary configures a standby read source with its primary.
+func setSourcePrimary(cfg *config.Config, uri string) {
+	if uri == "" {
+		return
+	}
+	cfg.SourcePrimary.ParseURI(uri) //nolint:errcheck
+	applyConfigDefaults(&cfg.SourcePrimary)
+}
+
+// setFanout configures the extra fan-out destinations.
+func setFanout(cfg *config.Config, dests []daemon.DestinationPayload, policy string) {
+	cfg.Fanout.Policy = policy
+	for _, d := range dests {
+		dc := config.DestinationConfig{Name: d.Name}
+		dc.DB.ParseURI(d.URI) //nolint:errcheck
+		applyConfigDefaults(&dc.DB)
+		cfg.Fanout.Destinations = append(cfg.Fanout.Destinations, dc)
+	}
+}
+
+func setSharding(cfg *config.Config, tables []daemon.ShardTablePayload) {
+	for _, t := range tables {
+		cfg.Sharding.Tables = append(cfg.Sharding.Tables, config.ShardTableConfig{
+			Table:  t.Table,
+			Key:    t.Key,
+			Method: t.Method,
+			Bounds: t.Bounds,
+		})
+	}
+}
+
+func setRemap(cfg *config.Config, r *daemon.RemapPayload) {
+	if r == nil {
+		return
+	}
+	for _, m := range r.Schemas {
+		cfg.Remap.Schemas = append(cfg.Remap.Schemas, config.SchemaMapConfig{From: m.From, To: m.To})
+	}
+	for _, m := range r.Tables {
+		cfg.Remap.Tables = append(cfg.Remap.Tables, config.TableMapConfig{From: m.From, To: m.To})
+	}
+	for _, m := range r.Columns {
+		cfg.Remap.Columns = append(cfg.Remap.Columns, config.ColumnMapConfig{Table: m.Table, From: m.From, To: m.To})
+	}
+}
+
 func buildConfig(sourceURI, destURI, slotName, publication string, workers int) *config.Config {
 	cfg := &config.Config{}
 
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/jfoltran/pgmanager/internal/config"
@@ -189,3 +190,86 @@ func TestSPAHandler(t *testing.T) {
 		handler.ServeHTTP(rec, req)
 	})
 }
+
+func TestSubmitReplayValidation(t *testing.T) {
+	const dest = `"dest_uri":"postgres://localhost/db"`
+	cases := []struct {
+		name string
+		root string
+		body string
+		want string
+	}{
+		{"missing path", "/cap", `{` + dest + `}`, "capture_path is required"},
+		{"missing dest", "/cap", `{"capture_path":"orders"}`, "dest_uri is required"},
+		{"outside root", "/cap", `{"capture_path":"/tmp/cap",` + dest + `}`, "outside the capture root"},
+		{"escapes root", "/cap", `{"capture_path":"../etc",` + dest + `}`, "outside the capture root"},
+		{"no capture root", "", `{"capture_path":"orders",` + dest + `}`, "no capture root"},
+		{"bad from_lsn", "/cap", `{"capture_path":"orders",` + dest + `,"from_lsn":"nope"}`, "invalid from_lsn"},
+		{"to before from", "/cap", `{"capture_path":"orders",` + dest + `,"from_lsn":"0/20","to_lsn":"0/10"}`, "to_lsn is before from_lsn"},
+		{"bad body", "/cap", `{`, "invalid request body"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			jh := &jobHandlers{captureRoot: c.root}
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/jobs/replay", strings.NewReader(c.body))
+			rec := httptest.NewRecorder()
+			jh.submitReplay(rec, req)
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want 400", rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), c.want) {
+				t.Errorf("body = %s, want %q", rec.Body.String(), c.want)
+			}
+		})
+	}
+}
+
+func TestCapturePath(t *testing.T) {
+	jh := &jobHandlers{captureRoot: "/var/lib/pgmanager/capture/"}
+	cases := []struct {
+		path string
+		want string
+		ok   bool
+	}{
+		{"orders", "/var/lib/pgmanager/capture/orders", true},
+		{"orders/00000001-0000000000000000.seg", "/var/lib/pgmanager/capture/orders/00000001-0000000000000000.seg", true},
+		{"/var/lib/pgmanager/capture", "/var/lib/pgmanager/capture", true},
+		{"/var/lib/pgmanager/capture/a/../b", "/var/lib/pgmanager/capture/b", true},
+		{"..dots", "/var/lib/pgmanager/capture/..dots", true},
+		{"../other", "", false},
+		{"a/../../other", "", false},
+		{"/var/lib/pgmanager/capture-other", "", false},
+		{"/etc/passwd", "", false},
+	}
+	for _, c := range cases {
+		got, err := jh.capturePath(c.path)
+		if (err == nil) != c.ok || got != c.want {
+			t.Errorf("capturePath(%q) = %q, %v; want %q, ok=%v", c.path, got, err, c.want, c.ok)
+		}
+	}
+
+	if _, err := (&jobHandlers{}).capturePath("orders"); err == nil {
+		t.Error("expected an error without a capture root")
+	}
+}
+
+func TestSubmitCaptureDirOutsideRoot(t *testing.T) {
+	jh := &jobHandlers{captureRoot: "/var/lib/pgmanager/capture"}
+	body := `{"source_uri":"postgres://localhost/src","dest_uri":"postgres://localhost/dst","capture_dir":"/tmp/cap"}`
+	for name, submit := range map[string]http.HandlerFunc{
+		"clone":  jh.submitClone,
+		"follow": jh.submitFollow,
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/jobs/"+name, strings.NewReader(body))
+			rec := httptest.NewRecorder()
+			submit(rec, req)
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want 400", rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), "capture root") {
+				t.Errorf("body = %s", rec.Body.String())
+			}
+		})
+	}
+}
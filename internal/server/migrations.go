@@ -2,6 +2,7 @@ package server
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"strings"
 
@@ -53,6 +54,7 @@ func (mh *migrationHandlers) get(w http.ResponseWriter, r *http.Request) {
 				LiveBytesPerSec float64               `json:"live_bytes_per_sec,omitempty"`
 				LiveTotalRows  int64                  `json:"live_total_rows,omitempty"`
 				LiveTotalBytes int64                  `json:"live_total_bytes,omitempty"`
+				LiveSources    []metrics.SourceStatus `json:"live_sources,omitempty"`
 			}{
 				Migration:       m,
 				LivePhase:       snap.Phase,
@@ -64,6 +66,7 @@ func (mh *migrationHandlers) get(w http.ResponseWriter, r *http.Request) {
 				LiveBytesPerSec: snap.BytesPerSec,
 				LiveTotalRows:   snap.TotalRows,
 				LiveTotalBytes:  snap.TotalBytes,
+				LiveSources:     snap.Sources,
 			}
 			writeJSON(w, resp)
 			return
@@ -80,11 +83,27 @@ type createMigrationRequest struct {
 	DestClusterID   string  `json:"dest_cluster_id"`
 	SourceNodeID    string  `json:"source_node_id"`
 	DestNodeID      string  `json:"dest_node_id"`
+	ReadNodeID      string  `json:"read_node_id,omitempty"`
 	Mode            ms.Mode `json:"mode"`
 	Fallback        bool    `json:"fallback"`
 	SlotName        string  `json:"slot_name,omitempty"`
 	Publication     string  `json:"publication,omitempty"`
+	OutputPlugin    string  `json:"output_plugin,omitempty"`
 	CopyWorkers     int     `json:"copy_workers,omitempty"`
+	MigrateRoles    bool    `json:"migrate_roles"`
+
+	AllowMissingIdentity bool `json:"allow_missing_identity"`
+	ReindexCollations    bool `json:"reindex_collations"`
+	Feed                 bool `json:"feed"`
+
+	SlotWarnBytes     int64 `json:"slot_warn_bytes,omitempty"`
+	SlotWarnSafeBytes int64 `json:"slot_warn_safe_bytes,omitempty"`
+	SlotMaxBytes      int64 `json:"slot_max_bytes,omitempty"`
+	SlotDropOnLimit   bool  `json:"slot_drop_on_limit"`
+
+	// Sources consolidates several source nodes instead of one.
+	Sources           []ms.MigrationSource `json:"sources,omitempty"`
+	ConsolidateColumn string               `json:"consolidate_column,omitempty"`
 }
 
 func (mh *migrationHandlers) create(w http.ResponseWriter, r *http.Request) {
@@ -101,11 +120,29 @@ func (mh *migrationHandlers) create(w http.ResponseWriter, r *http.Request) {
 		DestClusterID:   req.DestClusterID,
 		SourceNodeID:    req.SourceNodeID,
 		DestNodeID:      req.DestNodeID,
+		ReadNodeID:      req.ReadNodeID,
 		Mode:            req.Mode,
 		Fallback:        req.Fallback,
 		SlotName:        req.SlotName,
 		Publication:     req.Publication,
+		OutputPlugin:    req.OutputPlugin,
 		CopyWorkers:     req.CopyWorkers,
+		MigrateRoles:    req.MigrateRoles,
+
+		AllowMissingIdentity: req.AllowMissingIdentity,
+		ReindexCollations:    req.ReindexCollations,
+		Feed:                 req.Feed,
+
+		SlotWarnBytes:     req.SlotWarnBytes,
+		SlotWarnSafeBytes: req.SlotWarnSafeBytes,
+		SlotMaxBytes:      req.SlotMaxBytes,
+		SlotDropOnLimit:   req.SlotDropOnLimit,
+
+		Sources:           req.Sources,
+		ConsolidateColumn: req.ConsolidateColumn,
+	}
+	if len(m.Sources) > 0 && m.SourceClusterID == "" && m.SourceNodeID == "" {
+		m.SourceClusterID, m.SourceNodeID = m.Sources[0].ClusterID, m.Sources[0].NodeID
 	}
 
 	if m.SlotName == "" {
@@ -114,6 +151,9 @@ func (mh *migrationHandlers) create(w http.ResponseWriter, r *http.Request) {
 	if m.Publication == "" {
 		m.Publication = "pgmanager_pub_" + strings.ReplaceAll(m.ID, "-", "_")
 	}
+	if m.OutputPlugin == "" {
+		m.OutputPlugin = "pgoutput"
+	}
 	if m.CopyWorkers <= 0 {
 		m.CopyWorkers = 4
 	}
@@ -141,13 +181,39 @@ func (mh *migrationHandlers) remove(w http.ResponseWriter, r *http.Request) {
 	}
 
 	force := r.URL.Query().Get("force") == "true"
+	withCleanup := r.URL.Query().Get("cleanup") == "true"
 
 	if mh.runner != nil && mh.runner.IsRunning(id) {
 		if !force {
 			http.Error(w, "cannot delete a running migration (use ?force=true)", http.StatusConflict)
 			return
 		}
-		mh.runner.Stop(r.Context(), id)
+		if withCleanup {
+			if err := mh.runner.StopAndWait(r.Context(), id); err != nil {
+				http.Error(w, "stop migration: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		} else {
+			mh.runner.Stop(r.Context(), id)
+		}
+	}
+
+	if withCleanup {
+		if mh.runner == nil {
+			http.Error(w, "migration runner not configured", http.StatusServiceUnavailable)
+			return
+		}
+		artifacts, err := mh.runner.CleanupArtifacts(r.Context(), id, force)
+		if err != nil {
+			http.Error(w, "cleanup: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		for _, a := range artifacts {
+			if a.Error != "" {
+				http.Error(w, fmt.Sprintf("cleanup: %s %s on %s: %s", a.Kind, a.Name, a.Side, a.Error), http.StatusConflict)
+				return
+			}
+		}
 	}
 
 	if err := mh.store.Delete(r.Context(), id); err != nil {
@@ -201,3 +267,188 @@ func (mh *migrationHandlers) switchover(w http.ResponseWriter, r *http.Request)
 
 	writeJSON(w, map[string]any{"ok": true, "message": "switchover started"})
 }
+
+func (mh *migrationHandlers) rolesDryRun(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if mh.runner == nil {
+		http.Error(w, "migration runner not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	report, err := mh.runner.RolesDryRun(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	writeJSON(w, report)
+}
+
+func (mh *migrationHandlers) preflight(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if mh.runner == nil {
+		http.Error(w, "migration runner not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	report, err := mh.runner.Preflight(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	writeJSON(w, report)
+}
+
+func (mh *migrationHandlers) replicaIdentity(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if mh.runner == nil {
+		http.Error(w, "migration runner not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	findings, err := mh.runner.ReplicaIdentityAudit(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	writeJSON(w, findings)
+}
+
+type fixReplicaIdentityRequest struct {
+	// Confirm must be true: the fix runs ALTER TABLE on the source.
+	Confirm bool     `json:"confirm"`
+	Tables  []string `json:"tables,omitempty"`
+}
+
+func (mh *migrationHandlers) fixReplicaIdentity(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if mh.runner == nil {
+		http.Error(w, "migration runner not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req fixReplicaIdentityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !req.Confirm {
+		http.Error(w, "fixing replica identity alters tables on the source; resend with \"confirm\": true", http.StatusBadRequest)
+		return
+	}
+
+	applied, err := mh.runner.FixReplicaIdentity(r.Context(), id, req.Tables)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	writeJSON(w, map[string]any{"ok": true, "applied": applied})
+}
+
+func (mh *migrationHandlers) collation(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if mh.runner == nil {
+		http.Error(w, "migration runner not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	report, err := mh.runner.CollationReport(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	writeJSON(w, report)
+}
+
+func (mh *migrationHandlers) upgrade(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if mh.runner == nil {
+		http.Error(w, "migration runner not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	advice, err := mh.runner.UpgradeAdvice(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	writeJSON(w, advice)
+}
+
+func (mh *migrationHandlers) failover(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if mh.runner == nil {
+		http.Error(w, "migration runner not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	readiness, err := mh.runner.FailoverReadiness(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	writeJSON(w, readiness)
+}
+
+type cleanupRequest struct {
+	Confirm   bool `json:"confirm"`
+	Terminate bool `json:"terminate"`
+}
+
+func (mh *migrationHandlers) artifacts(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if mh.runner == nil {
+		http.Error(w, "migration runner not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	artifacts, err := mh.runner.Artifacts(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	writeJSON(w, artifacts)
+}
+
+func (mh *migrationHandlers) cleanup(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if mh.runner == nil {
+		http.Error(w, "migration runner not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req cleanupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !req.Confirm {
+		http.Error(w, "cleanup drops replication slots, publications and origins; resend with \"confirm\": true", http.StatusBadRequest)
+		return
+	}
+	if mh.runner.IsRunning(id) {
+		http.Error(w, "cannot clean up a running migration; stop it first", http.StatusConflict)
+		return
+	}
+
+	artifacts, err := mh.runner.CleanupArtifacts(r.Context(), id, req.Terminate)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	ok := true
+	for _, a := range artifacts {
+		if !a.Dropped {
+			ok = false
+		}
+	}
+	writeJSON(w, map[string]any{"ok": ok, "artifacts": artifacts})
+}
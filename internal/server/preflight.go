@@ -0,0 +1,42 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/rs/zerolog"
+
+	"github.com/jfoltran/pgmanager/internal/daemon"
+	ms "github.com/jfoltran/pgmanager/internal/migrationstore"
+)
+
+type preflightHandlers struct {
+	logger zerolog.Logger
+}
+
+func (ph *preflightHandlers) run(w http.ResponseWriter, r *http.Request) {
+	var payload daemon.PreflightPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if payload.SourceURI == "" || payload.DestURI == "" {
+		http.Error(w, "source_uri and dest_uri are required", http.StatusBadRequest)
+		return
+	}
+
+	cfg := buildConfig(payload.SourceURI, payload.DestURI, "", "", 0)
+	if payload.Remap != nil {
+		setRemap(cfg, payload.Remap)
+		if err := cfg.Validate(); err != nil {
+			http.Error(w, "invalid config: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	report, err := ms.RunPreflight(r.Context(), cfg, ph.logger)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, report)
+}
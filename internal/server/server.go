@@ -14,21 +14,24 @@ import (
 	"github.com/jfoltran/pgmanager/internal/config"
 	"github.com/jfoltran/pgmanager/internal/daemon"
 	"github.com/jfoltran/pgmanager/internal/metrics"
+	"github.com/jfoltran/pgmanager/internal/migration/feed"
 	ms "github.com/jfoltran/pgmanager/internal/migrationstore"
 )
 
 // Server is the HTTP server that serves the REST API, WebSocket endpoint,
 // and embedded frontend static files.
 type Server struct {
-	collector *metrics.Collector
-	cfg       *config.Config
-	logger    zerolog.Logger
-	hub       *Hub
-	jobs       *daemon.JobManager
-	clusters   *cluster.Store
-	migStore   *ms.Store
-	migRunner  *ms.Runner
-	srv        *http.Server
+	collector   *metrics.Collector
+	cfg         *config.Config
+	logger      zerolog.Logger
+	hub         *Hub
+	jobs        *daemon.JobManager
+	clusters    *cluster.Store
+	migStore    *ms.Store
+	migRunner   *ms.Runner
+	feedTokens  []string
+	captureRoot string
+	srv         *http.Server
 }
 
 // New creates a new Server. Collector and cfg may be nil for lightweight (serve) mode.
@@ -45,6 +48,32 @@ func (s *Server) SetJobManager(jm *daemon.JobManager) {
 	s.jobs = jm
 }
 
+// SetFeedTokens sets the bearer tokens accepted by the change feed
+// endpoints. Without tokens the feed is disabled.
+func (s *Server) SetFeedTokens(tokens []string) {
+	s.feedTokens = tokens
+}
+
+// SetCaptureRoot sets the directory that capture and replay paths given to
+// the job routes must stay within. Without a root they are rejected.
+func (s *Server) SetCaptureRoot(root string) {
+	s.captureRoot = root
+}
+
+// runningFeed returns the change feed of the daemon's running pipeline.
+func (s *Server) runningFeed(*http.Request) *feed.Feed {
+	if p := s.jobs.Pipeline(); p != nil {
+		return p.Feed()
+	}
+	return nil
+}
+
+// migrationFeed returns the change feed of the running migration named by
+// the request's id.
+func (s *Server) migrationFeed(r *http.Request) *feed.Feed {
+	return s.migRunner.Feed(r.PathValue("id"))
+}
+
 // SetClusterStore attaches a cluster store for multi-cluster management.
 func (s *Server) SetClusterStore(cs *cluster.Store) {
 	s.clusters = cs
@@ -73,17 +102,22 @@ func (s *Server) Start(ctx context.Context, listen string, port int) error {
 
 	// Job control routes (requires job manager).
 	if s.jobs != nil {
-		jh := &jobHandlers{jobs: s.jobs}
+		jh := &jobHandlers{jobs: s.jobs, captureRoot: s.captureRoot}
 		mux.HandleFunc("POST /api/v1/jobs/clone", jh.submitClone)
 		mux.HandleFunc("POST /api/v1/jobs/follow", jh.submitFollow)
 		mux.HandleFunc("POST /api/v1/jobs/switchover", jh.submitSwitchover)
+		mux.HandleFunc("POST /api/v1/jobs/replay", jh.submitReplay)
 		mux.HandleFunc("POST /api/v1/jobs/stop", jh.stopJob)
 		mux.HandleFunc("GET /api/v1/jobs/status", jh.jobStatus)
+
+		fh := &feedHandlers{feed: s.runningFeed, tokens: s.feedTokens, logger: s.logger}
+		mux.HandleFunc("GET /api/v1/feed", fh.sse)
+		mux.HandleFunc("GET /api/v1/feed/ws", fh.ws)
 	}
 
 	// Cluster management routes (always available).
 	if s.clusters != nil {
-		ch := &clusterHandlers{store: s.clusters}
+		ch := &clusterHandlers{store: s.clusters, migrations: s.migStore}
 		mux.HandleFunc("GET /api/v1/clusters", ch.list)
 		mux.HandleFunc("POST /api/v1/clusters", ch.add)
 		mux.HandleFunc("GET /api/v1/clusters/{id}", ch.get)
@@ -91,6 +125,8 @@ func (s *Server) Start(ctx context.Context, listen string, port int) error {
 		mux.HandleFunc("DELETE /api/v1/clusters/{id}", ch.remove)
 		mux.HandleFunc("POST /api/v1/clusters/test-connection", ch.testConnection)
 		mux.HandleFunc("GET /api/v1/clusters/{id}/introspect", ch.introspect)
+		mux.HandleFunc("GET /api/v1/clusters/{id}/replication", ch.replication)
+		mux.HandleFunc("POST /api/v1/clusters/{id}/replication/drop-orphans", ch.dropOrphans)
 	}
 
 	// Migration routes.
@@ -103,8 +139,26 @@ func (s *Server) Start(ctx context.Context, listen string, port int) error {
 		mux.HandleFunc("POST /api/v1/migrations/{id}/start", mh.start)
 		mux.HandleFunc("POST /api/v1/migrations/{id}/stop", mh.stop)
 		mux.HandleFunc("POST /api/v1/migrations/{id}/switchover", mh.switchover)
+		mux.HandleFunc("GET /api/v1/migrations/{id}/roles", mh.rolesDryRun)
+		mux.HandleFunc("GET /api/v1/migrations/{id}/preflight", mh.preflight)
+		mux.HandleFunc("GET /api/v1/migrations/{id}/replica-identity", mh.replicaIdentity)
+		mux.HandleFunc("GET /api/v1/migrations/{id}/collation", mh.collation)
+		mux.HandleFunc("GET /api/v1/migrations/{id}/upgrade", mh.upgrade)
+		mux.HandleFunc("GET /api/v1/migrations/{id}/failover", mh.failover)
+		mux.HandleFunc("POST /api/v1/migrations/{id}/replica-identity/fix", mh.fixReplicaIdentity)
+		mux.HandleFunc("GET /api/v1/migrations/{id}/cleanup", mh.artifacts)
+		mux.HandleFunc("POST /api/v1/migrations/{id}/cleanup", mh.cleanup)
+		if s.migRunner != nil {
+			fh := &feedHandlers{feed: s.migrationFeed, tokens: s.feedTokens, logger: s.logger}
+			mux.HandleFunc("GET /api/v1/migrations/{id}/feed", fh.sse)
+			mux.HandleFunc("GET /api/v1/migrations/{id}/feed/ws", fh.ws)
+		}
 	}
 
+	// Preflight checks for an ad-hoc source/destination pair.
+	ph := &preflightHandlers{logger: s.logger}
+	mux.HandleFunc("POST /api/v1/preflight", ph.run)
+
 	// Serve embedded frontend with SPA fallback.
 	sub, err := fs.Sub(distFS, "dist")
 	if err != nil {
@@ -1,6 +1,12 @@
 import type { Snapshot, LogEntry } from "../types/metrics";
-import type { Cluster, ConnTestResult, ClusterInfo } from "../types/cluster";
-import type { Migration, CreateMigrationRequest } from "../types/migration";
+import type {
+  Cluster,
+  ConnTestResult,
+  ClusterInfo,
+  ReplicationInventory,
+  DropOrphansResult,
+} from "../types/cluster";
+import type { Migration, CreateMigrationRequest, ReplicationArtifact, CleanupResult } from "../types/migration";
 
 const BASE = "";
 
@@ -52,6 +58,23 @@ export async function submitFollow(payload?: {
   }
 }
 
+export async function submitReplay(payload: {
+  capture_path: string;
+  dest_uri?: string;
+  from_lsn?: string;
+  to_lsn?: string;
+}): Promise<void> {
+  const res = await fetch(`${BASE}/api/v1/jobs/replay`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify(payload),
+  });
+  if (!res.ok) {
+    const body = await res.json().catch(() => ({ error: `HTTP ${res.status}` }));
+    throw new Error(body.error || `HTTP ${res.status}`);
+  }
+}
+
 export async function stopJob(): Promise<void> {
   const res = await fetch(`${BASE}/api/v1/jobs/stop`, { method: "POST" });
   if (!res.ok) {
@@ -139,6 +162,35 @@ export async function introspectCluster(
   return res.json();
 }
 
+export async function fetchReplicationInventory(id: string): Promise<ReplicationInventory> {
+  const res = await fetch(`${BASE}/api/v1/clusters/${encodeURIComponent(id)}/replication`);
+  if (!res.ok) {
+    const body = await res.text();
+    throw new Error(body || `HTTP ${res.status}`);
+  }
+  return res.json();
+}
+
+export async function dropOrphanSlots(
+  id: string,
+  node: string,
+  slots: string[]
+): Promise<DropOrphansResult> {
+  const res = await fetch(
+    `${BASE}/api/v1/clusters/${encodeURIComponent(id)}/replication/drop-orphans`,
+    {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ confirm: true, node, slots }),
+    }
+  );
+  if (!res.ok) {
+    const body = await res.text();
+    throw new Error(body || `HTTP ${res.status}`);
+  }
+  return res.json();
+}
+
 // --- Migrations ---
 
 export async function fetchMigrations(): Promise<Migration[]> {
@@ -165,8 +217,11 @@ export async function createMigration(req: CreateMigrationRequest): Promise<Migr
   return res.json();
 }
 
-export async function removeMigration(id: string, force?: boolean): Promise<void> {
-  const params = force ? "?force=true" : "";
+export async function removeMigration(id: string, force?: boolean, cleanup?: boolean): Promise<void> {
+  const query = new URLSearchParams();
+  if (force) query.set("force", "true");
+  if (cleanup) query.set("cleanup", "true");
+  const params = query.toString() ? `?${query}` : "";
   const res = await fetch(`${BASE}/api/v1/migrations/${encodeURIComponent(id)}${params}`, {
     method: "DELETE",
   });
@@ -176,6 +231,28 @@ export async function removeMigration(id: string, force?: boolean): Promise<void
   }
 }
 
+export async function fetchMigrationArtifacts(id: string): Promise<ReplicationArtifact[]> {
+  const res = await fetch(`${BASE}/api/v1/migrations/${encodeURIComponent(id)}/cleanup`);
+  if (!res.ok) {
+    const body = await res.text();
+    throw new Error(body || `HTTP ${res.status}`);
+  }
+  return res.json();
+}
+
+export async function cleanupMigration(id: string, terminate?: boolean): Promise<CleanupResult> {
+  const res = await fetch(`${BASE}/api/v1/migrations/${encodeURIComponent(id)}/cleanup`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ confirm: true, terminate: !!terminate }),
+  });
+  if (!res.ok) {
+    const body = await res.text();
+    throw new Error(body || `HTTP ${res.status}`);
+  }
+  return res.json();
+}
+
 export async function startMigration(id: string): Promise<void> {
   const res = await fetch(`${BASE}/api/v1/migrations/${encodeURIComponent(id)}/start`, {
     method: "POST",
@@ -76,3 +76,62 @@ export interface ParameterInfo {
   unit?: string;
   source: string;
 }
+
+export interface ReplicationSlot {
+  name: string;
+  type: "logical" | "physical";
+  plugin?: string;
+  database?: string;
+  temporary: boolean;
+  active: boolean;
+  active_pid?: number;
+  wal_status?: string;
+  retained_bytes: number;
+  retained_size: string;
+  owner?: string;
+  orphan: boolean;
+}
+
+export interface Publication {
+  name: string;
+  database: string;
+  all_tables: boolean;
+  tables: number;
+  owner?: string;
+}
+
+export interface Subscription {
+  name: string;
+  database: string;
+  enabled: boolean;
+  slot_name?: string;
+}
+
+export interface ReplicationOrigin {
+  name: string;
+  remote_lsn?: string;
+  owner?: string;
+}
+
+export interface NodeReplication {
+  node_id: string;
+  node_name: string;
+  role: NodeRole;
+  in_recovery: boolean;
+  slots: ReplicationSlot[];
+  publications: Publication[];
+  subscriptions: Subscription[];
+  origins: ReplicationOrigin[];
+  error?: string;
+}
+
+export interface ReplicationInventory {
+  cluster_id: string;
+  nodes: NodeReplication[];
+}
+
+export interface DropOrphansResult {
+  ok: boolean;
+  dropped: string[];
+  errors: Record<string, string>;
+}
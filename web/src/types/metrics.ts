@@ -31,6 +31,37 @@ export interface Snapshot {
 
   error_count: number;
   last_error?: string;
+
+  slot?: SlotHealth;
+  spool?: SpoolDepth;
+  buffer?: BufferUsage;
+}
+
+export interface BufferUsage {
+  bytes: number;
+  limit_bytes: number;
+  blocked: boolean;
+}
+
+export interface SpoolDepth {
+  bytes: number;
+  max_bytes: number;
+  transactions: number;
+  segments: number;
+  spooled_lsn: string;
+  applied_lsn: string;
+  blocked: boolean;
+}
+
+export interface SlotHealth {
+  name: string;
+  active: boolean;
+  wal_status?: "reserved" | "extended" | "unreserved" | "lost";
+  safe_wal_size?: number;
+  retained_bytes: number;
+  state: "ok" | "warn" | "limit" | "lost";
+  message?: string;
+  checked_at: string;
 }
 
 export interface LogEntry {
@@ -11,6 +11,61 @@ export type MigrationStatus =
   | "failed"
   | "stopped";
 
+export type UpgradeSeverity = "info" | "warn" | "fail";
+
+export interface UpgradeFinding {
+  severity: UpgradeSeverity;
+  category: string;
+  title: string;
+  version: number;
+  hint?: string;
+  objects?: string[];
+}
+
+export interface UpgradeAdvice {
+  source_version: number;
+  dest_version: number;
+  findings: UpgradeFinding[];
+  post_steps: string[];
+  checked_at: string;
+}
+
+export interface FailoverStandby {
+  node_id: string;
+  host: string;
+  reachable: boolean;
+  in_recovery: boolean;
+  sync_replication_slots: boolean;
+  hot_standby_feedback: boolean;
+  primary_slot_name?: string;
+  error?: string;
+}
+
+export interface FailoverReadiness {
+  server_version: number;
+  supported: boolean;
+  synchronized_standby_slots?: string;
+  standbys?: FailoverStandby[];
+  warnings?: string[];
+}
+
+export interface ReplicationArtifact {
+  side: "source" | "source_standby" | "dest" | `dest:${string}`;
+  kind: "slot" | "publication" | "origin";
+  name: string;
+  active: boolean;
+  active_pid?: number;
+  dropped?: boolean;
+  error?: string;
+}
+
+export interface CleanupResult {
+  ok: boolean;
+  artifacts: ReplicationArtifact[];
+}
+
+export type OutputPlugin = "pgoutput" | "wal2json" | "test_decoding";
+
 export interface Migration {
   id: string;
   name: string;
@@ -18,6 +73,7 @@ export interface Migration {
   dest_cluster_id: string;
   source_node_id: string;
   dest_node_id: string;
+  read_node_id?: string;
   mode: MigrationMode;
   fallback: boolean;
   status: MigrationStatus;
@@ -25,7 +81,16 @@ export interface Migration {
   error_message?: string;
   slot_name: string;
   publication: string;
+  output_plugin: OutputPlugin;
   copy_workers: number;
+  migrate_roles: boolean;
+  allow_missing_identity: boolean;
+  reindex_collations: boolean;
+  slot_warn_bytes: number;
+  slot_warn_safe_bytes: number;
+  slot_max_bytes: number;
+  slot_drop_on_limit: boolean;
+  upgrade_advice?: UpgradeAdvice;
   confirmed_lsn?: string;
   tables_total: number;
   tables_copied: number;
@@ -51,9 +116,18 @@ export interface CreateMigrationRequest {
   dest_cluster_id: string;
   source_node_id: string;
   dest_node_id: string;
+  read_node_id?: string;
   mode: MigrationMode;
   fallback: boolean;
   slot_name?: string;
   publication?: string;
+  output_plugin?: OutputPlugin;
   copy_workers?: number;
+  migrate_roles?: boolean;
+  allow_missing_identity?: boolean;
+  reindex_collations?: boolean;
+  slot_warn_bytes?: number;
+  slot_warn_safe_bytes?: number;
+  slot_max_bytes?: number;
+  slot_drop_on_limit?: boolean;
 }